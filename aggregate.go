@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	influxdb "github.com/influxdata/influxdb1-client/v2"
+)
+
+// windowAggregate accumulates the intervals of one window of period for
+// the <measurement>_<suffix> measurement, for sinks without server-side
+// downsampling. -hourlyAggregates is the window of an hour with the suffix
+// hourly.
+type windowAggregate struct {
+	period      time.Duration
+	suffix      string
+	start       time.Time // of the window
+	seconds     float64   // observed time
+	counts      float64   // cpm weighted by minutes
+	maxCPM      int
+	maxDoseRate float64
+	dose        float64 // µSv
+}
+
+// add records an interval of length d starting at start. Intervals belong to
+// the window they start in. When start is in a later window, the finished
+// aggregate is returned and a new one is begun.
+func (w *windowAggregate) add(start time.Time, d time.Duration, cpm int, doseRate float64) *windowAggregate {
+	var done *windowAggregate
+	window := start.Truncate(w.period)
+	if !window.Equal(w.start) {
+		if w.seconds > 0 {
+			prev := *w
+			done = &prev
+		}
+		*w = windowAggregate{period: w.period, suffix: w.suffix, start: window}
+	}
+	w.seconds += d.Seconds()
+	w.counts += float64(cpm) * d.Minutes()
+	w.maxCPM = max(w.maxCPM, cpm)
+	w.maxDoseRate = max(w.maxDoseRate, doseRate)
+	w.dose += doseRate * d.Hours()
+	return done
+}
+
+// parseAggregates parses the comma-separated periods of -aggregates, like
+// "10s,1m,15m,1h". The periods must divide a day so that the windows start
+// at the same times every day.
+func parseAggregates(s string) ([]*windowAggregate, error) {
+	var windows []*windowAggregate
+	seen := map[time.Duration]bool{}
+	for _, p := range strings.Split(s, ",") {
+		if p = strings.TrimSpace(p); p == "" {
+			continue
+		}
+		d, err := time.ParseDuration(p)
+		if err != nil {
+			return nil, err
+		}
+		if d < time.Second || d%time.Second != 0 || (24*time.Hour)%d != 0 {
+			return nil, fmt.Errorf("period %s must be whole seconds dividing a day", p)
+		}
+		if seen[d] {
+			return nil, fmt.Errorf("period %s given twice", p)
+		}
+		seen[d] = true
+		windows = append(windows, &windowAggregate{period: d, suffix: periodSuffix(d)})
+	}
+	return windows, nil
+}
+
+// periodSuffix names a period in its largest whole unit, e.g. 15m for
+// 15 minutes and 90s for a minute and a half.
+func periodSuffix(d time.Duration) string {
+	switch {
+	case d%time.Hour == 0:
+		return fmt.Sprintf("%dh", d/time.Hour)
+	case d%time.Minute == 0:
+		return fmt.Sprintf("%dm", d/time.Minute)
+	}
+	return fmt.Sprintf("%ds", d/time.Second)
+}
+
+// countStats summarizes the per-second counts of an interval, to show spikes
+// the rate of the interval averages away.
+type countStats struct {
+	n          int
+	min, max   int
+	sum, sumSq float64
+}
+
+func (s *countStats) add(counts int) {
+	if s.n == 0 || counts < s.min {
+		s.min = counts
+	}
+	s.max = max(s.max, counts)
+	s.n++
+	s.sum += float64(counts)
+	s.sumSq += float64(counts) * float64(counts)
+}
+
+// addFields adds the statistics as geiger_counter_cps_* fields.
+func (s *countStats) addFields(fields map[string]interface{}) {
+	if s.n == 0 {
+		return
+	}
+	mean := s.sum / float64(s.n)
+	fields["geiger_counter_cps_min"] = s.min
+	fields["geiger_counter_cps_max"] = s.max
+	fields["geiger_counter_cps_mean"] = mean
+	// population standard deviation, clamped against rounding errors
+	fields["geiger_counter_cps_stddev"] = math.Sqrt(math.Max(0, s.sumSq/float64(s.n)-mean*mean))
+}
+
+// writeAggregates writes finished windows in one batch, each stamped with
+// its start.
+func writeAggregates(influxClient influxdb.Client, database, measurement string, tags map[string]string, done []*windowAggregate) error {
+	bp, err := influxdb.NewBatchPoints(influxdb.BatchPointsConfig{Database: database, Precision: "s"})
+	if err != nil {
+		return err
+	}
+	for _, w := range done {
+		minutes := w.seconds / 60
+		fields := map[string]interface{}{
+			"cpm_mean":       w.counts / minutes,
+			"cpm_max":        w.maxCPM,
+			"dose_rate_mean": w.dose / (w.seconds / 3600),
+			"dose_rate_max":  w.maxDoseRate,
+			"dose":           w.dose,
+			"coverage":       w.seconds / w.period.Seconds(), // fraction of the window observed
+		}
+		pt, err := influxdb.NewPoint(measurement+"_"+w.suffix, tags, fields, w.start)
+		if err != nil {
+			return err
+		}
+		bp.AddPoint(pt)
+	}
+	return influxClient.Write(bp)
+}
+
+// writeCPS writes the heartbeat samples of an interval as geiger_counter_cps
+// points, stamped at receipt with millisecond precision.
+func writeCPS(influxClient influxdb.Client, database, measurement string, tags map[string]string, samples []countSample) error {
+	bp, err := influxdb.NewBatchPoints(influxdb.BatchPointsConfig{Database: database, Precision: "ms"})
+	if err != nil {
+		return err
+	}
+	for _, s := range samples {
+		pt, err := influxdb.NewPoint(measurement, tags, map[string]interface{}{"geiger_counter_cps": s.CPS}, s.Time)
+		if err != nil {
+			return err
+		}
+		bp.AddPoint(pt)
+	}
+	return influxClient.Write(bp)
+}
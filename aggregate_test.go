@@ -0,0 +1,101 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWindowAggregate(t *testing.T) {
+	day := time.Date(2024, 6, 3, 0, 0, 0, 0, time.UTC)
+	at := func(hour, min, sec int) time.Time {
+		return day.Add(time.Duration(hour)*time.Hour + time.Duration(min)*time.Minute + time.Duration(sec)*time.Second)
+	}
+	type interval struct {
+		start time.Time
+		cpm   int
+	}
+	tests := []struct {
+		name      string
+		intervals []interval  // of a minute each
+		want      []time.Time // starts of the finished windows
+		wantLast  time.Time   // start of the window in progress
+	}{
+		{"first interval", []interval{{at(10, 0, 0), 10}}, nil, at(10, 0, 0)},
+		{"same window", []interval{{at(10, 0, 0), 10}, {at(10, 59, 59), 20}}, nil, at(10, 0, 0)},
+		// an interval belongs to the window it starts in, even if it ends
+		// in the next
+		{"crossing the boundary", []interval{{at(10, 59, 30), 10}}, nil, at(10, 0, 0)},
+		{"at the boundary", []interval{{at(10, 59, 0), 10}, {at(11, 0, 0), 20}}, []time.Time{at(10, 0, 0)}, at(11, 0, 0)},
+		{"skipped window", []interval{{at(10, 30, 0), 10}, {at(12, 5, 0), 20}}, []time.Time{at(10, 0, 0)}, at(12, 0, 0)},
+		{"midnight", []interval{{at(23, 59, 0), 10}, {at(24, 0, 0), 20}}, []time.Time{at(23, 0, 0)}, at(24, 0, 0)},
+	}
+	for _, tt := range tests {
+		w := &windowAggregate{period: time.Hour, suffix: "1h"}
+		var got []time.Time
+		for _, iv := range tt.intervals {
+			if done := w.add(iv.start, time.Minute, iv.cpm, float64(iv.cpm)/100); done != nil {
+				got = append(got, done.start)
+			}
+		}
+		if len(got) != len(tt.want) || len(got) > 0 && !got[0].Equal(tt.want[0]) || !w.start.Equal(tt.wantLast) {
+			t.Errorf("%s: finished %v, in progress %v; want %v, %v", tt.name, got, w.start, tt.want, tt.wantLast)
+		}
+	}
+}
+
+func TestWindowAggregateValues(t *testing.T) {
+	start := time.Date(2024, 6, 3, 10, 0, 0, 0, time.UTC)
+	w := &windowAggregate{period: time.Hour, suffix: "1h"}
+	w.add(start, time.Minute, 10, 0.1)
+	w.add(start.Add(time.Minute), 2*time.Minute, 40, 0.4)
+	done := w.add(start.Add(time.Hour), time.Minute, 30, 0.3)
+	if done == nil {
+		t.Fatal("window not finished")
+	}
+	if done.seconds != 180 || done.counts != 90 || done.maxCPM != 40 || done.maxDoseRate != 0.4 {
+		t.Errorf("finished window = %+v", done)
+	}
+	// the next window starts with the interval that finished this one only
+	if w.seconds != 60 || w.counts != 30 || w.maxCPM != 30 {
+		t.Errorf("window in progress = %+v", w)
+	}
+
+	f := &fakeInflux{}
+	if err := writeAggregates(f, "db", "m", nil, []*windowAggregate{done}); err != nil {
+		t.Fatal(err)
+	}
+	// 90 counts in 3 minutes, observed for 3 of 60 minutes
+	line := f.batches[0][0]
+	if !strings.HasPrefix(line, "m_1h coverage=0.05,cpm_max=40i,cpm_mean=30,") || !strings.HasSuffix(line, " 1717408800") {
+		t.Errorf("wrote %q", line)
+	}
+}
+
+func TestParseAggregates(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    []string // suffixes
+		wantErr bool
+	}{
+		{"", nil, false},
+		{"10s, 1m,15m,1h", []string{"10s", "1m", "15m", "1h"}, false},
+		{"90s,24h", []string{"90s", "24h"}, false},
+		{"7s", nil, true},   // does not divide a day
+		{"48h", nil, true},  // longer than a day
+		{"1.5s", nil, true}, // not whole seconds
+		{"500ms", nil, true},
+		{"1m,60s", nil, true},
+		{"hourly", nil, true},
+	}
+	for _, tt := range tests {
+		windows, err := parseAggregates(tt.in)
+		var got []string
+		for _, w := range windows {
+			got = append(got, w.suffix)
+		}
+		if (err != nil) != tt.wantErr || strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("parseAggregates(%q) = %v, %v, want %v, error %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,655 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// alertStage is one escalation level of a rule, e.g. warning or critical.
+type alertStage struct {
+	severity  string
+	threshold float64
+	sustain   time.Duration // the threshold must be exceeded this long before the stage fires
+	intervals int           // and in this many consecutive evaluations
+	repeat    time.Duration // notify again at this interval while active, 0 notifies once
+	notifiers []string      // names of the notifiers, empty means all
+}
+
+// alertRule fires its stages when a metric crosses their thresholds. A stage
+// resolves once the metric is back on the safe side by more than
+// hysteresis, which prevents flapping on noisy values like the battery
+// voltage.
+type alertRule struct {
+	name       string
+	metric     string
+	below      bool // fire when the value drops below the threshold instead of exceeding it
+	hysteresis float64
+	stages     []alertStage // ordered by increasing severity
+}
+
+func (r *alertRule) fires(s *alertStage, v float64) bool {
+	if r.below {
+		return v < s.threshold
+	}
+	return v > s.threshold
+}
+
+func (r *alertRule) resolves(s *alertStage, v float64) bool {
+	if r.below {
+		return v >= s.threshold+r.hysteresis
+	}
+	return v <= s.threshold-r.hysteresis
+}
+
+// alertEvent is sent to the notifiers when a stage fires or resolves.
+type alertEvent struct {
+	Rule      string    `json:"rule"`
+	Severity  string    `json:"severity"`
+	State     string    `json:"state"` // "firing" or "resolved"
+	Repeat    bool      `json:"repeat,omitempty"`
+	Metric    string    `json:"metric"`
+	Value     float64   `json:"value"`
+	Threshold float64   `json:"threshold"`
+	Time      time.Time `json:"time"`
+	Silenced  bool      `json:"silenced,omitempty"`
+}
+
+func (e alertEvent) String() string {
+	s := fmt.Sprintf("alert %s %s %s: %s=%g (threshold %g)", e.Rule, e.Severity, e.State, e.Metric, e.Value, e.Threshold)
+	if e.Silenced {
+		s += " (silenced)"
+	}
+	return s
+}
+
+type notifier interface {
+	notify(ev alertEvent) error
+}
+
+// stateNotifier is implemented by notifiers that mirror which alerts are
+// active. They receive resolved events even while notifications are
+// silenced, otherwise the alert would remain active for them.
+type stateNotifier interface {
+	notifier
+	// restore is called once at startup, before any event, with the alerts
+	// restored from the state file that are active and not silenced.
+	restore(active []alertEvent) error
+}
+
+// stageState tracks a stage of a rule between evaluations. It is persisted
+// in the state file.
+type stageState struct {
+	ExceededSince time.Time `json:"exceeded_since"`     // zero while the threshold is not exceeded
+	Exceeded      int       `json:"exceeded,omitempty"` // consecutive evaluations exceeding the threshold
+	Active        bool      `json:"active"`
+	Since         time.Time `json:"since"` // when the stage fired
+	Notified      time.Time `json:"notified"`
+}
+
+// maintenanceWindow is a period during which notifications are suppressed.
+// It is either a one-off period from from to until, or recurs every day
+// listed in days (every day if empty) between the times of day start and
+// end. An end before start spans midnight.
+type maintenanceWindow struct {
+	from, until time.Time
+	days        []time.Weekday
+	start, end  time.Duration
+}
+
+func (w *maintenanceWindow) contains(t time.Time) bool {
+	if !w.from.IsZero() || !w.until.IsZero() {
+		return !t.Before(w.from) && t.Before(w.until)
+	}
+	// time of day on the wall clock, so the window does not move on DST changes
+	tod := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+	day := t.Weekday()
+	switch {
+	case w.start <= w.end:
+		if tod < w.start || tod >= w.end {
+			return false
+		}
+	case tod < w.end:
+		// the part after midnight belongs to the window started the day before
+		day = (day + 6) % 7
+	case tod < w.start:
+		return false
+	}
+	if len(w.days) == 0 {
+		return true
+	}
+	for _, d := range w.days {
+		if d == day {
+			return true
+		}
+	}
+	return false
+}
+
+// alertHistorySize is the number of events kept for "gq-gmc ctl alerts".
+const alertHistorySize = 100
+
+// The events of the weekly report are kept apart from the history, for a
+// day more than the report covers. alertReportSize bounds them when an
+// alert flaps; the reports then state that events were dropped.
+const (
+	alertReportAge  = 8 * 24 * time.Hour
+	alertReportSize = 10000
+)
+
+// alertQueueSize is the number of events of a rule that may wait for the
+// notifiers, see alertEngine.queue. Further events are dropped.
+const alertQueueSize = 64
+
+// alertNotice is an event on its way to the notifiers.
+type alertNotice struct {
+	ev      alertEvent
+	targets map[string]notifier
+}
+
+// alertEngine evaluates the rules against incoming metric values and
+// notifies on state changes.
+type alertEngine struct {
+	rules     []*alertRule
+	notifiers map[string]notifier
+	queues    map[string]chan alertNotice // by rule name
+	states    map[string][]stageState
+	silences  map[string]time.Time // expiry by rule name, "" silences all rules
+	windows   []maintenanceWindow
+	history   []alertEvent
+	reports   []alertEvent // the events of the last alertReportAge
+	dropped   time.Time    // of the newest event dropped from reports
+	sent      int          // number of events sent so far
+	onChange  func()       // called after an evaluation that sent events
+}
+
+func newAlertEngine(rules []*alertRule, notifiers map[string]notifier) *alertEngine {
+	return &alertEngine{
+		rules:     rules,
+		notifiers: notifiers,
+		queues:    map[string]chan alertNotice{},
+		states:    map[string][]stageState{},
+		silences:  map[string]time.Time{},
+	}
+}
+
+// setRule replaces the rule with the same name, or removes it if r is nil.
+func (e *alertEngine) setRule(name string, r *alertRule) {
+	rules := e.rules[:0]
+	for _, old := range e.rules {
+		if old.name != name {
+			rules = append(rules, old)
+		}
+	}
+	if r != nil {
+		rules = append(rules, r)
+	}
+	if r == nil || len(e.states[name]) != len(r.stages) {
+		delete(e.states, name)
+	}
+	e.rules = rules
+}
+
+// restore replaces the state of the rules with previously saved state and
+// passes the active alerts to the stateNotifiers. State of unknown rules, or
+// rules whose stages changed, is dropped. It must be called once at startup,
+// also without saved state.
+func (e *alertEngine) restore(states map[string][]stageState) {
+	for _, r := range e.rules {
+		if st, ok := states[r.name]; ok && len(st) == len(r.stages) {
+			e.states[r.name] = st
+		}
+	}
+	now := time.Now()
+	active := map[string][]alertEvent{}
+	for _, r := range e.rules {
+		if e.silenced(r.name, now) {
+			continue
+		}
+		for i, st := range e.states[r.name] {
+			if !st.Active {
+				continue
+			}
+			s := &r.stages[i]
+			// the value that fired the stage is not saved
+			ev := alertEvent{Rule: r.name, Severity: s.severity, State: "firing", Repeat: true, Metric: r.metric, Value: math.NaN(), Threshold: s.threshold, Time: st.Since}
+			for _, name := range e.targets(s) {
+				active[name] = append(active[name], ev)
+			}
+		}
+	}
+	for name, n := range e.notifiers {
+		if sn, ok := n.(stateNotifier); ok {
+			if err := sn.restore(active[name]); err != nil {
+				slog.Error("notify", "notifier", name, "err", err)
+			}
+		}
+	}
+}
+
+// targets returns the names of the notifiers of s.
+func (e *alertEngine) targets(s *alertStage) []string {
+	if len(s.notifiers) > 0 {
+		return s.notifiers
+	}
+	var targets []string
+	for name := range e.notifiers {
+		targets = append(targets, name)
+	}
+	return targets
+}
+
+// evaluate checks all rules whose metric is contained in metrics.
+func (e *alertEngine) evaluate(metrics map[string]float64) {
+	now := time.Now()
+	sent := e.sent
+	defer func() {
+		if e.sent != sent && e.onChange != nil {
+			e.onChange()
+		}
+	}()
+	for _, r := range e.rules {
+		v, ok := metrics[r.metric]
+		if !ok {
+			continue
+		}
+		states := e.states[r.name]
+		if states == nil {
+			states = make([]stageState, len(r.stages))
+			e.states[r.name] = states
+		}
+		top := -1
+		for i := range r.stages {
+			s, st := &r.stages[i], &states[i]
+			if r.fires(s, v) {
+				if st.ExceededSince.IsZero() {
+					st.ExceededSince = now
+				}
+				st.Exceeded++
+			} else {
+				st.ExceededSince = time.Time{}
+				st.Exceeded = 0
+			}
+			switch {
+			case !st.Active && !st.ExceededSince.IsZero() && now.Sub(st.ExceededSince) >= s.sustain && st.Exceeded >= s.intervals:
+				st.Active = true
+				st.Since, st.Notified = now, now
+				e.send(r, s, "firing", false, v, now)
+			case st.Active && r.resolves(s, v):
+				st.Active = false
+				e.send(r, s, "resolved", false, v, now)
+			}
+			if st.Active {
+				top = i
+			}
+		}
+		// Only the most severe active stage repeats its notification.
+		if top >= 0 {
+			s, st := &r.stages[top], &states[top]
+			if s.repeat > 0 && now.Sub(st.Notified) >= s.repeat {
+				st.Notified = now
+				e.send(r, s, "firing", true, v, now)
+			}
+		}
+	}
+}
+
+// silence suppresses notifications of rule, or of all rules if rule is
+// empty, for d. State changes are still evaluated and recorded. A duration of
+// 0 lifts the silence.
+func (e *alertEngine) silence(rule string, d time.Duration) {
+	if d <= 0 {
+		delete(e.silences, rule)
+		return
+	}
+	e.silences[rule] = time.Now().Add(d)
+}
+
+// silenced reports whether notifications of rule are suppressed at t.
+func (e *alertEngine) silenced(rule string, t time.Time) bool {
+	if t.Before(e.silences[""]) || t.Before(e.silences[rule]) {
+		return true
+	}
+	for i := range e.windows {
+		if e.windows[i].contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// eventsSince returns the recorded events from t on, for the reports.
+// truncated tells that some of them were dropped, see alertReportSize.
+func (e *alertEngine) eventsSince(t time.Time) (events []alertEvent, truncated bool) {
+	for _, ev := range e.reports {
+		if !ev.Time.Before(t) {
+			events = append(events, ev)
+		}
+	}
+	return events, !e.dropped.IsZero() && !e.dropped.Before(t)
+}
+
+// status describes the active alerts, silences and recent events.
+func (e *alertEngine) status() string {
+	var b strings.Builder
+	now := time.Now()
+	for _, r := range e.rules {
+		for i, st := range e.states[r.name] {
+			if st.Active {
+				fmt.Fprintf(&b, "active: %s %s since %s\n", r.name, r.stages[i].severity, st.Since.Format(time.RFC3339))
+			}
+		}
+	}
+	for rule, until := range e.silences {
+		if until.After(now) {
+			if rule == "" {
+				rule = "all rules"
+			}
+			fmt.Fprintf(&b, "silenced: %s until %s\n", rule, until.Format(time.RFC3339))
+		}
+	}
+	for i := range e.windows {
+		if e.windows[i].contains(now) {
+			b.WriteString("silenced: maintenance window\n")
+			break
+		}
+	}
+	for _, ev := range e.history {
+		fmt.Fprintf(&b, "%s %s\n", ev.Time.Format(time.RFC3339), ev)
+	}
+	return b.String()
+}
+
+func (e *alertEngine) send(r *alertRule, s *alertStage, state string, repeat bool, v float64, t time.Time) {
+	ev := alertEvent{
+		Rule:      r.name,
+		Severity:  s.severity,
+		State:     state,
+		Repeat:    repeat,
+		Metric:    r.metric,
+		Value:     v,
+		Threshold: s.threshold,
+		Time:      t,
+		Silenced:  e.silenced(r.name, t),
+	}
+	// firing alerts stand out in the log even without notifiers, e.g. a
+	// low battery of an unattended station
+	if state == "firing" {
+		slog.Warn(ev.String())
+	} else {
+		slog.Info(ev.String())
+	}
+	e.sent++
+	if len(e.history) == alertHistorySize {
+		e.history = e.history[1:]
+	}
+	e.history = append(e.history, ev)
+	for len(e.reports) > 0 && t.Sub(e.reports[0].Time) > alertReportAge {
+		e.reports = e.reports[1:]
+	}
+	if len(e.reports) == alertReportSize {
+		e.dropped = e.reports[0].Time
+		e.reports = e.reports[1:]
+	}
+	e.reports = append(e.reports, ev)
+	targets := map[string]notifier{}
+	for _, name := range e.targets(s) {
+		if _, ok := e.notifiers[name].(stateNotifier); ev.Silenced && !(ok && ev.State == "resolved") {
+			continue
+		}
+		targets[name] = e.notifiers[name]
+	}
+	if len(targets) == 0 {
+		return
+	}
+	select {
+	case e.queue(r.name) <- alertNotice{ev: ev, targets: targets}:
+	default:
+		slog.Error("notify: queue full, event dropped", "rule", ev.Rule)
+	}
+}
+
+// queue returns the notification queue of a rule, starting its goroutine
+// on first use. The events of a rule reach the notifiers in order, so that
+// a slow notifier cannot have a resolve overtake the event it resolves.
+func (e *alertEngine) queue(rule string) chan<- alertNotice {
+	q, ok := e.queues[rule]
+	if !ok {
+		q = make(chan alertNotice, alertQueueSize)
+		e.queues[rule] = q
+		go deliver(q)
+	}
+	return q
+}
+
+// deliver passes each event of q to its notifiers, which are notified
+// concurrently, before taking the next one.
+func deliver(q <-chan alertNotice) {
+	for n := range q {
+		var wg sync.WaitGroup
+		for name, nt := range n.targets {
+			wg.Add(1)
+			go func(name string, nt notifier) {
+				defer wg.Done()
+				if err := nt.notify(n.ev); err != nil {
+					slog.Error("notify", "rule", n.ev.Rule, "notifier", name, "err", err)
+				}
+			}(name, nt)
+		}
+		wg.Wait()
+	}
+}
+
+// webhookNotifier POSTs the event as JSON to a URL.
+type webhookNotifier struct {
+	url    string
+	schema payloadSchema
+}
+
+var notifyClient = &http.Client{Timeout: 10 * time.Second}
+
+func (w *webhookNotifier) notify(ev alertEvent) error {
+	body, err := w.schema.alertPayload(ev)
+	if err != nil {
+		return err
+	}
+	resp, err := notifyClient.Post(w.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook: %s", resp.Status)
+	}
+	return nil
+}
+
+// commandNotifier runs a shell command for every event. The event and the
+// latest reading are passed in GQGMC_* environment variables.
+type commandNotifier struct {
+	command string
+	state   *liveState
+}
+
+func (c *commandNotifier) notify(ev alertEvent) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", c.command)
+	snap := c.state.get()
+	cmd.Env = append(os.Environ(),
+		"GQGMC_RULE="+ev.Rule,
+		"GQGMC_SEVERITY="+ev.Severity,
+		"GQGMC_STATE="+ev.State,
+		"GQGMC_REPEAT="+strconv.FormatBool(ev.Repeat),
+		"GQGMC_METRIC="+ev.Metric,
+		"GQGMC_VALUE="+strconv.FormatFloat(ev.Value, 'g', -1, 64),
+		"GQGMC_THRESHOLD="+strconv.FormatFloat(ev.Threshold, 'g', -1, 64),
+		"GQGMC_TIME="+ev.Time.Format(time.RFC3339),
+		// garbage from an unresponsive device may contain NUL bytes
+		"GQGMC_DEVICE="+strings.ReplaceAll(snap.Version, "\x00", ""),
+		"GQGMC_CPM="+strconv.Itoa(snap.CPM),
+		"GQGMC_DOSE_RATE="+strconv.FormatFloat(snap.DoseRate, 'g', -1, 64),
+	)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s: %w: %s", c.command, err, bytes.TrimSpace(out))
+	}
+	return nil
+}
+
+// flagAlerts are the alerts and notifiers of the command line, which come
+// on top of those of the config file.
+type flagAlerts struct {
+	webhook, command               string
+	gpio                           int
+	noData, sinkFailure            time.Duration
+	cpm, doseRate                  float64
+	intervals                      int
+	rise                           float64
+	riseWindow                     time.Duration
+	anomalySigma                   float64
+	anomalyWindow, anomalyBaseline time.Duration
+}
+
+func alertFlags(fs *flag.FlagSet) *flagAlerts {
+	a := &flagAlerts{}
+	fs.StringVar(&a.webhook, "alertWebhook", "", "URL receiving alert notifications as JSON POST requests")
+	fs.IntVar(&a.gpio, "alertGPIO", -1, "GPIO pin switched on while an alert is firing, -1 disables")
+	fs.StringVar(&a.command, "alertCommand", "", "Shell command run on alert events, details are passed in GQGMC_* environment variables")
+	fs.DurationVar(&a.noData, "noDataAlert", 0, "Alert when no heartbeat sample has arrived for this long, 0 disables")
+	fs.DurationVar(&a.sinkFailure, "sinkFailureAlert", 0, "Alert when writes to InfluxDB have been failing for this long, 0 disables")
+	fs.Float64Var(&a.cpm, "cpmAlert", 0, "Alert when the CPM exceeds this value, 0 disables")
+	fs.Float64Var(&a.doseRate, "doseRateAlert", 0, "Alert when the dose rate exceeds this many µSv/h, 0 disables")
+	fs.IntVar(&a.intervals, "alertIntervals", 1, "Consecutive intervals -cpmAlert and -doseRateAlert must be exceeded before the alert fires")
+	fs.Float64Var(&a.rise, "riseAlert", 0, "Alert when the smoothed count rate rises faster than this many percent per minute, 0 disables")
+	fs.DurationVar(&a.riseWindow, "riseWindow", 10*time.Minute, "Window over which the rate of rise is computed")
+	fs.Float64Var(&a.anomalySigma, "anomalySigma", 0, "Alert when the count rate of the last -anomalyWindow exceeds the mean of the -anomalyBaseline before it by this many standard deviations, 0 disables")
+	fs.DurationVar(&a.anomalyWindow, "anomalyWindow", 10*time.Minute, "Window whose count rate is compared with the baseline")
+	fs.DurationVar(&a.anomalyBaseline, "anomalyBaseline", 24*time.Hour, "Period before the window the mean and variation of the background are taken from")
+	return a
+}
+
+// override applies the notifiers of the alerts section of the config file,
+// except for those given as flags.
+func (a *flagAlerts) override(cfg *fileConfig, set map[string]bool) {
+	if cfg.Alerts.Webhook != "" && !set["alertWebhook"] {
+		a.webhook = cfg.Alerts.Webhook
+	}
+	if cfg.Alerts.Command != "" && !set["alertCommand"] {
+		a.command = cfg.Alerts.Command
+	}
+}
+
+// notifiers returns the notifiers of the flags and of the config file by
+// name.
+func (a *flagAlerts) notifiers(cfg *fileConfig, schema payloadSchema, state *liveState) (map[string]notifier, error) {
+	notifiers := map[string]notifier{}
+	if a.webhook != "" {
+		notifiers["webhook"] = &webhookNotifier{url: a.webhook, schema: schema}
+	}
+	if a.command != "" {
+		notifiers["command"] = &commandNotifier{command: a.command, state: state}
+	}
+	if a.gpio >= 0 {
+		g, err := newGPIONotifier(a.gpio, false)
+		if err != nil {
+			return nil, fmt.Errorf("alert gpio: %w", err)
+		}
+		notifiers["gpio"] = g
+	}
+	for name, nc := range cfg.Alerts.Notifiers {
+		if err := nc.validate(); err != nil {
+			return nil, fmt.Errorf("config: notifier %s: %w", name, err)
+		}
+		var n notifier
+		switch {
+		case nc.Webhook != "":
+			n = &webhookNotifier{url: nc.Webhook, schema: schema}
+		case nc.Command != "":
+			n = &commandNotifier{command: nc.Command, state: state}
+		case nc.GPIO != nil:
+			g, err := newGPIONotifier(*nc.GPIO, nc.ActiveLow)
+			if err != nil {
+				return nil, fmt.Errorf("notifier %s: %w", name, err)
+			}
+			n = g
+		case nc.Email != nil:
+			e := nc.Email
+			n = &emailNotifier{addr: e.SMTP, from: e.From, to: e.To, user: e.User, password: e.Password}
+		case nc.Alertmanager != nil:
+			n = newAlertmanagerNotifier(nc.Alertmanager.URL, nc.Alertmanager.Labels)
+		case nc.Telegram != nil:
+			n = &telegramNotifier{token: nc.Telegram.Token, chatID: nc.Telegram.ChatID}
+		case nc.Pushover != nil:
+			n = &pushoverNotifier{token: nc.Pushover.Token, user: nc.Pushover.User}
+		}
+		notifiers[name] = n
+	}
+	return notifiers, nil
+}
+
+// setRules adds the rules of the flags to e.
+func (a *flagAlerts) setRules(e *alertEngine) error {
+	if a.intervals < 1 {
+		return errors.New("-alertIntervals must be at least 1")
+	}
+	if a.riseWindow <= 0 {
+		return errors.New("-riseWindow must be positive")
+	}
+	if a.anomalyWindow <= 0 || a.anomalyBaseline < 2*a.anomalyWindow {
+		return errors.New("-anomalyWindow must be positive and -anomalyBaseline at least twice as long")
+	}
+	if a.noData > 0 {
+		e.setRule("no_data", &alertRule{
+			name:   "no_data",
+			metric: "sample_age_seconds",
+			stages: []alertStage{{severity: "critical", threshold: a.noData.Seconds()}},
+		})
+	}
+	for _, t := range []struct {
+		metric    string
+		threshold float64
+	}{{"cpm", a.cpm}, {"dose_rate", a.doseRate}} {
+		if t.threshold > 0 {
+			e.setRule(t.metric+"_threshold", &alertRule{
+				name:   t.metric + "_threshold",
+				metric: t.metric,
+				stages: []alertStage{{severity: "warning", threshold: t.threshold, intervals: a.intervals}},
+			})
+		}
+	}
+	if a.rise > 0 {
+		e.setRule("rate_of_rise", &alertRule{
+			name:   "rate_of_rise",
+			metric: "cpm_rise",
+			stages: []alertStage{{severity: "warning", threshold: a.rise}},
+		})
+	}
+	if a.anomalySigma > 0 {
+		e.setRule("anomaly", &alertRule{
+			name:       "anomaly",
+			metric:     "cpm_sigma",
+			hysteresis: 1,
+			stages:     []alertStage{{severity: "warning", threshold: a.anomalySigma}},
+		})
+	}
+	if a.sinkFailure > 0 {
+		e.setRule("sink_failing", &alertRule{
+			name:   "sink_failing",
+			metric: "sink_failure_seconds",
+			stages: []alertStage{{severity: "critical", threshold: a.sinkFailure.Seconds()}},
+		})
+	}
+	return nil
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakePin records the states of a gpioNotifier pin.
+type fakePin struct {
+	mu     sync.Mutex
+	states []bool
+}
+
+func (p *fakePin) set(on bool) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.states = append(p.states, on)
+	return nil
+}
+
+// wait returns the state of the pin once it was set n times.
+func (p *fakePin) wait(t *testing.T, n int) bool {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		p.mu.Lock()
+		states := p.states
+		p.mu.Unlock()
+		if len(states) >= n {
+			return states[len(states)-1]
+		}
+	}
+	t.Fatalf("pin set fewer than %d times", n)
+	return false
+}
+
+func newPinEngine() (*alertEngine, *fakePin) {
+	pin := &fakePin{}
+	rule := &alertRule{name: "cpm", metric: "cpm", stages: []alertStage{{severity: "warning", threshold: 100}}}
+	g := &gpioNotifier{set: pin.set, firing: map[string]bool{}}
+	return newAlertEngine([]*alertRule{rule}, map[string]notifier{"pin": g}), pin
+}
+
+func TestGPIORestore(t *testing.T) {
+	tests := []struct {
+		name    string
+		states  map[string][]stageState
+		silence bool
+		want    bool
+	}{
+		{"no state", nil, false, false},
+		{"resolved", map[string][]stageState{"cpm": {{}}}, false, false},
+		{"firing", map[string][]stageState{"cpm": {{Active: true, Since: time.Now()}}}, false, true},
+		{"firing while silenced", map[string][]stageState{"cpm": {{Active: true, Since: time.Now()}}}, true, false},
+		{"other stages", map[string][]stageState{"cpm": {{Active: true}, {Active: true}}}, false, false},
+	}
+	for _, tt := range tests {
+		e, pin := newPinEngine()
+		if tt.silence {
+			e.silence("", time.Hour)
+		}
+		e.restore(tt.states)
+		if got := pin.wait(t, 1); got != tt.want {
+			t.Errorf("%s: pin = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// TestGPIOResolveWhileSilenced resolves a restored alert during a silence,
+// which must still switch the pin off.
+func TestGPIOResolveWhileSilenced(t *testing.T) {
+	e, pin := newPinEngine()
+	e.restore(map[string][]stageState{"cpm": {{Active: true, Since: time.Now()}}})
+	if !pin.wait(t, 1) {
+		t.Fatal("pin off with a restored alert")
+	}
+	e.silence("", time.Hour)
+	e.evaluate(map[string]float64{"cpm": 20})
+	if pin.wait(t, 2) {
+		t.Error("pin on after the alert resolved")
+	}
+}
+
+func TestAlertReportEvents(t *testing.T) {
+	rule := &alertRule{name: "cpm", metric: "cpm", stages: []alertStage{{severity: "warning", threshold: 100}}}
+	e := newAlertEngine([]*alertRule{rule}, nil)
+	start := time.Date(2024, 6, 3, 0, 0, 0, 0, time.UTC)
+	e.send(rule, &rule.stages[0], "firing", false, 200, start.Add(-9*24*time.Hour))
+	for i := 0; i < alertReportSize+5; i++ {
+		e.send(rule, &rule.stages[0], "firing", false, 200, start.Add(time.Duration(i)*time.Second))
+	}
+	if len(e.history) != alertHistorySize {
+		t.Errorf("history holds %d events", len(e.history))
+	}
+	tests := []struct {
+		since         time.Time
+		want          int
+		wantTruncated bool
+	}{
+		{start.Add(-10 * 24 * time.Hour), alertReportSize, true},
+		{start, alertReportSize, true},
+		{start.Add(4 * time.Second), alertReportSize, true},
+		{start.Add(5 * time.Second), alertReportSize, false},
+		{start.Add(alertReportSize * time.Second), 5, false},
+	}
+	for _, tt := range tests {
+		events, truncated := e.eventsSince(tt.since)
+		if len(events) != tt.want || truncated != tt.wantTruncated {
+			t.Errorf("eventsSince(%v) = %d events, truncated %v, want %d, %v", tt.since, len(events), truncated, tt.want, tt.wantTruncated)
+		}
+	}
+
+	// the event older than alertReportAge was pruned, not dropped
+	e = newAlertEngine([]*alertRule{rule}, nil)
+	e.send(rule, &rule.stages[0], "firing", false, 200, start.Add(-9*24*time.Hour))
+	e.send(rule, &rule.stages[0], "resolved", false, 20, start)
+	if events, truncated := e.eventsSince(start.Add(-10 * 24 * time.Hour)); len(events) != 1 || truncated {
+		t.Errorf("eventsSince() after pruning = %d events, truncated %v", len(events), truncated)
+	}
+}
+
+// recordingNotifier records the events it is notified of, taking delay for
+// those that fire.
+type recordingNotifier struct {
+	mu     sync.Mutex
+	delay  time.Duration
+	events []alertEvent
+}
+
+func (n *recordingNotifier) notify(ev alertEvent) error {
+	if ev.State == "firing" {
+		time.Sleep(n.delay)
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.events = append(n.events, ev)
+	return nil
+}
+
+// wait returns the events once there are count of them.
+func (n *recordingNotifier) wait(t *testing.T, count int) []alertEvent {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		n.mu.Lock()
+		events := append([]alertEvent(nil), n.events...)
+		n.mu.Unlock()
+		if len(events) >= count {
+			return events
+		}
+	}
+	t.Fatalf("fewer than %d events notified", count)
+	return nil
+}
+
+// eventNames returns the events as "severity state" for comparing.
+func eventNames(events []alertEvent) []string {
+	var names []string
+	for _, ev := range events {
+		names = append(names, ev.Severity+" "+ev.State)
+	}
+	return names
+}
+
+func TestAlertStages(t *testing.T) {
+	tests := []struct {
+		name       string
+		hysteresis float64
+		below      bool
+		values     []float64
+		want       []string
+	}{
+		{"quiet", 0, false, []float64{50, 90, 100}, nil},
+		{"escalation", 0, false, []float64{150, 250, 300}, []string{"warning firing", "critical firing"}},
+		{"straight to critical", 0, false, []float64{250}, []string{"warning firing", "critical firing"}},
+		{"de-escalation", 0, false, []float64{250, 150, 50}, []string{"warning firing", "critical firing", "critical resolved", "warning resolved"}},
+		{"within the hysteresis", 10, false, []float64{250, 195, 195, 91}, []string{"warning firing", "critical firing", "critical resolved"}},
+		{"beyond the hysteresis", 10, false, []float64{250, 190, 95, 90}, []string{"warning firing", "critical firing", "critical resolved", "warning resolved"}},
+		{"flapping at the threshold", 10, false, []float64{101, 99, 101, 99}, []string{"warning firing"}},
+		{"below", 10, true, []float64{99, 105, 110}, []string{"warning firing", "warning resolved"}},
+	}
+	for _, tt := range tests {
+		rule := &alertRule{name: "cpm", metric: "cpm", below: tt.below, hysteresis: tt.hysteresis, stages: []alertStage{
+			{severity: "warning", threshold: 100},
+			{severity: "critical", threshold: 200},
+		}}
+		if tt.below {
+			rule.stages = rule.stages[:1]
+		}
+		e := newAlertEngine([]*alertRule{rule}, nil)
+		for _, v := range tt.values {
+			e.evaluate(map[string]float64{"cpm": v})
+		}
+		if got := eventNames(e.history); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: events %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// TestAlertOrder resolves an alert while its slow notifier is still busy
+// with the firing, which the resolve must not overtake.
+func TestAlertOrder(t *testing.T) {
+	rule := &alertRule{name: "cpm", metric: "cpm", stages: []alertStage{{severity: "warning", threshold: 100}}}
+	n := &recordingNotifier{delay: 50 * time.Millisecond}
+	e := newAlertEngine([]*alertRule{rule}, map[string]notifier{"slow": n})
+	for _, v := range []float64{150, 50, 150, 50} {
+		e.evaluate(map[string]float64{"cpm": v})
+	}
+	want := []string{"warning firing", "warning resolved", "warning firing", "warning resolved"}
+	if got := eventNames(n.wait(t, 4)); !reflect.DeepEqual(got, want) {
+		t.Errorf("notified %v, want %v", got, want)
+	}
+}
+
+func TestAlertSilenced(t *testing.T) {
+	now := time.Date(2024, 6, 3, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		silences map[string]time.Time
+		rule     string
+		at       time.Time
+		want     bool
+	}{
+		{"none", nil, "cpm", now, false},
+		{"the rule", map[string]time.Time{"cpm": now.Add(time.Hour)}, "cpm", now, true},
+		{"another rule", map[string]time.Time{"dose": now.Add(time.Hour)}, "cpm", now, false},
+		{"all rules", map[string]time.Time{"": now.Add(time.Hour)}, "cpm", now, true},
+		{"just before expiry", map[string]time.Time{"cpm": now.Add(time.Hour)}, "cpm", now.Add(time.Hour - time.Nanosecond), true},
+		{"at expiry", map[string]time.Time{"cpm": now.Add(time.Hour)}, "cpm", now.Add(time.Hour), false},
+		{"expired", map[string]time.Time{"": now.Add(-time.Minute)}, "cpm", now, false},
+	}
+	for _, tt := range tests {
+		e := newAlertEngine(nil, nil)
+		for rule, until := range tt.silences {
+			e.silences[rule] = until
+		}
+		if got := e.silenced(tt.rule, tt.at); got != tt.want {
+			t.Errorf("%s: silenced(%q, %s) = %v, want %v", tt.name, tt.rule, tt.at.Format(time.RFC3339Nano), got, tt.want)
+		}
+	}
+
+	// silence and unsilence
+	e := newAlertEngine(nil, nil)
+	e.silence("cpm", time.Hour)
+	if !e.silenced("cpm", time.Now()) {
+		t.Error("silence(cpm, 1h) did not silence cpm")
+	}
+	e.silence("cpm", 0)
+	if e.silenced("cpm", time.Now()) {
+		t.Error("silence(cpm, 0) did not lift the silence")
+	}
+}
+
+func TestMaintenanceWindow(t *testing.T) {
+	at := func(day, hour, min int) time.Time {
+		// 2024-06-03 is a Monday
+		return time.Date(2024, 6, 3+day, hour, min, 0, 0, time.UTC)
+	}
+	monday := []time.Weekday{time.Monday}
+	overnight := maintenanceWindow{days: monday, start: 22 * time.Hour, end: 2 * time.Hour}
+	office := maintenanceWindow{start: 9 * time.Hour, end: 17 * time.Hour}
+	oneOff := maintenanceWindow{from: at(0, 10, 0), until: at(0, 11, 0)}
+	tests := []struct {
+		name string
+		w    maintenanceWindow
+		t    time.Time
+		want bool
+	}{
+		{"daily at start", office, at(0, 9, 0), true},
+		{"daily before start", office, at(0, 8, 59), false},
+		{"daily before end", office, at(3, 16, 59), true},
+		{"daily at end", office, at(3, 17, 0), false},
+		{"overnight before start", overnight, at(0, 21, 59), false},
+		{"overnight at start", overnight, at(0, 22, 0), true},
+		{"overnight before midnight", overnight, at(0, 23, 59), true},
+		{"overnight after midnight", overnight, at(1, 0, 0), true},
+		{"overnight before end", overnight, at(1, 1, 59), true},
+		{"overnight at end", overnight, at(1, 2, 0), false},
+		{"overnight started the day before", overnight, at(0, 1, 0), false},
+		{"overnight on another day", overnight, at(1, 23, 0), false},
+		{"one-off at from", oneOff, at(0, 10, 0), true},
+		{"one-off before until", oneOff, at(0, 10, 59), true},
+		{"one-off at until", oneOff, at(0, 11, 0), false},
+		{"one-off at the time on another day", oneOff, at(1, 10, 30), false},
+	}
+	for _, tt := range tests {
+		if got := tt.w.contains(tt.t); got != tt.want {
+			t.Errorf("%s: contains(%s) = %v, want %v", tt.name, tt.t.Format("Mon 15:04"), got, tt.want)
+		}
+	}
+}
+
+// restoreNotifier records the alerts passed to restore.
+type restoreNotifier struct {
+	recordingNotifier
+	restored []alertEvent
+}
+
+func (n *restoreNotifier) restore(active []alertEvent) error {
+	n.restored = active
+	return nil
+}
+
+func TestAlertRestore(t *testing.T) {
+	since := time.Now().Add(-time.Hour)
+	rule := &alertRule{name: "cpm", metric: "cpm", stages: []alertStage{
+		{severity: "warning", threshold: 100},
+		{severity: "critical", threshold: 200},
+	}}
+	n := &restoreNotifier{}
+	e := newAlertEngine([]*alertRule{rule}, map[string]notifier{"state": n})
+	e.restore(map[string][]stageState{"cpm": {{Active: true, Since: since}, {}}})
+	if len(n.restored) != 1 {
+		t.Fatalf("restored %d alerts, want 1", len(n.restored))
+	}
+	ev := n.restored[0]
+	// the value that fired the stage is not saved
+	if ev.Severity != "warning" || ev.State != "firing" || !ev.Repeat || !math.IsNaN(ev.Value) || ev.Threshold != 100 || !ev.Time.Equal(since) {
+		t.Errorf("restored %+v", ev)
+	}
+	if !strings.Contains(ev.String(), "cpm=NaN") {
+		t.Errorf("restored alert reads %q", ev)
+	}
+
+	// the restored stage resolves like one that fired in this run
+	e.evaluate(map[string]float64{"cpm": 50})
+	if got := eventNames(n.wait(t, 1)); !reflect.DeepEqual(got, []string{"warning resolved"}) {
+		t.Errorf("notified %v after restoring, want the resolve", got)
+	}
+}
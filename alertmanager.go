@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// alertmanagerResend is how often active alerts are posted again. Alertmanager
+// resolves alerts that are not renewed before their endsAt, which is set to
+// a few resend intervals ahead so that alerts of a crashed daemon resolve.
+const alertmanagerResend = time.Minute
+
+// alertmanagerAlert is an alert in the format of the Alertmanager v2 API.
+type alertmanagerAlert struct {
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+	StartsAt    time.Time         `json:"startsAt"`
+	EndsAt      time.Time         `json:"endsAt"`
+}
+
+// alertmanagerNotifier posts alerts to a Prometheus Alertmanager, which
+// takes over routing, grouping and silencing.
+type alertmanagerNotifier struct {
+	url    string
+	labels map[string]string
+
+	mu     sync.Mutex
+	active map[string]alertmanagerAlert // by rule and severity
+}
+
+// newAlertmanagerNotifier returns a notifier posting to the Alertmanager at
+// url. labels are added to every alert; instance defaults to the host name.
+func newAlertmanagerNotifier(url string, labels map[string]string) *alertmanagerNotifier {
+	a := &alertmanagerNotifier{
+		url:    strings.TrimSuffix(url, "/") + "/api/v2/alerts",
+		labels: map[string]string{},
+		active: map[string]alertmanagerAlert{},
+	}
+	if host, err := os.Hostname(); err == nil {
+		a.labels["instance"] = host
+	}
+	for k, v := range labels {
+		a.labels[k] = v
+	}
+	go a.resend()
+	return a
+}
+
+func (a *alertmanagerNotifier) notify(ev alertEvent) error {
+	key := ev.Rule + "/" + ev.Severity
+	a.mu.Lock()
+	prev, ok := a.active[key]
+	alert := a.alert(ev)
+	if ok {
+		alert.StartsAt = prev.StartsAt
+	}
+	if ev.State == "firing" {
+		alert.EndsAt = time.Now().Add(5 * alertmanagerResend)
+		a.active[key] = alert
+	} else {
+		alert.EndsAt = ev.Time
+		delete(a.active, key)
+	}
+	a.mu.Unlock()
+	return a.post([]alertmanagerAlert{alert})
+}
+
+// alert converts ev to the format of Alertmanager.
+func (a *alertmanagerNotifier) alert(ev alertEvent) alertmanagerAlert {
+	alert := alertmanagerAlert{
+		Labels: map[string]string{
+			"alertname": ev.Rule,
+			"severity":  ev.Severity,
+			"metric":    ev.Metric,
+		},
+		Annotations: map[string]string{
+			"summary":   ev.String(),
+			"value":     strconv.FormatFloat(ev.Value, 'g', -1, 64),
+			"threshold": strconv.FormatFloat(ev.Threshold, 'g', -1, 64),
+		},
+		StartsAt: ev.Time,
+	}
+	for k, v := range a.labels {
+		alert.Labels[k] = v
+	}
+	return alert
+}
+
+// restore makes alertmanagerNotifier a stateNotifier: an alert that is never
+// resolved would stay active in Alertmanager as it is renewed, and restored
+// active alerts are renewed from the start, so that they do not expire.
+func (a *alertmanagerNotifier) restore(active []alertEvent) error {
+	if len(active) == 0 {
+		return nil
+	}
+	a.mu.Lock()
+	var alerts []alertmanagerAlert
+	for _, ev := range active {
+		alert := a.alert(ev)
+		delete(alert.Annotations, "value") // not saved
+		alert.EndsAt = time.Now().Add(5 * alertmanagerResend)
+		a.active[ev.Rule+"/"+ev.Severity] = alert
+		alerts = append(alerts, alert)
+	}
+	a.mu.Unlock()
+	return a.post(alerts)
+}
+
+// resend renews the active alerts.
+func (a *alertmanagerNotifier) resend() {
+	for range time.Tick(alertmanagerResend) {
+		a.mu.Lock()
+		var alerts []alertmanagerAlert
+		for key, alert := range a.active {
+			alert.EndsAt = time.Now().Add(5 * alertmanagerResend)
+			a.active[key] = alert
+			alerts = append(alerts, alert)
+		}
+		a.mu.Unlock()
+		if len(alerts) == 0 {
+			continue
+		}
+		if err := a.post(alerts); err != nil {
+			slog.Error("alertmanager", "err", err)
+		}
+	}
+}
+
+func (a *alertmanagerNotifier) post(alerts []alertmanagerAlert) error {
+	body, err := json.Marshal(alerts)
+	if err != nil {
+		return err
+	}
+	resp, err := notifyClient.Post(a.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("alertmanager: %s", resp.Status)
+	}
+	return nil
+}
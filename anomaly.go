@@ -0,0 +1,77 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+// anomalyMinIntervals is the number of intervals the baseline needs before
+// jumps are scored, fewer do not give a usable estimate of its variation.
+const anomalyMinIntervals = 10
+
+// anomalyTracker scores how far the count rate of the last window lies above
+// the mean of the baseline before it, in standard deviations. The deviation
+// combines the Poisson noise of the window with the variation of the
+// background beyond Poisson noise seen in the baseline, like the daily
+// cycle of radon, so a naturally varying background needs a larger jump
+// than a steady one.
+type anomalyTracker struct {
+	window   time.Duration
+	baseline time.Duration
+	history  []anomalySample // oldest first
+}
+
+type anomalySample struct {
+	time    time.Time // end of the interval
+	counts  int
+	seconds int
+}
+
+// add records the counts of an interval of seconds ending at t and returns
+// the score of the window ending with it. It returns 0 until the baseline
+// spans a window and anomalyMinIntervals intervals.
+func (a *anomalyTracker) add(t time.Time, counts, seconds int) float64 {
+	if seconds <= 0 {
+		return 0
+	}
+	a.history = append(a.history, anomalySample{t, counts, seconds})
+	for len(a.history) > 0 && t.Sub(a.history[0].time) > a.window+a.baseline {
+		a.history = a.history[1:]
+	}
+
+	var base, win []anomalySample
+	for i, s := range a.history {
+		if t.Sub(s.time) < a.window {
+			base, win = a.history[:i], a.history[i:]
+			break
+		}
+	}
+	if len(base) < anomalyMinIntervals || t.Sub(base[0].time) < 2*a.window {
+		return 0
+	}
+	baseCPM := meanCPM(base)
+	winCPM := meanCPM(win)
+	var winSeconds int
+	for _, s := range win {
+		winSeconds += s.seconds
+	}
+
+	// variance of the interval rates beyond their Poisson variance
+	var excess float64
+	for _, s := range base {
+		d := float64(s.counts)*60/float64(s.seconds) - baseCPM
+		excess += d*d - math.Max(baseCPM, 1)*60/float64(s.seconds)
+	}
+	excess = math.Max(excess/float64(len(base)), 0)
+	sigma := math.Sqrt(math.Max(baseCPM, 1)*60/float64(winSeconds) + excess)
+	return (winCPM - baseCPM) / sigma
+}
+
+func meanCPM(samples []anomalySample) float64 {
+	var counts, seconds int
+	for _, s := range samples {
+		counts += s.counts
+		seconds += s.seconds
+	}
+	return float64(counts) * 60 / float64(seconds)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	influxdb "github.com/influxdata/influxdb1-client/v2"
+)
+
+// asyncQueue is the number of writes asyncClient holds while the server is
+// slow, after that they are dropped.
+const asyncQueue = 256
+
+// asyncCloseTimeout bounds how long Close waits for the queued batches, so
+// that a hung server does not keep the collector from shutting down.
+const asyncCloseTimeout = 10 * time.Second
+
+var errWriteQueueFull = errors.New("write queue full, points dropped")
+
+// asyncClient writes in its own goroutine, so that a stalled server does not
+// hold up the main loop. Write queues the batch and returns the error of the
+// last write that completed, so that failures are still counted and
+// alerted on, an interval late.
+type asyncClient struct {
+	influxdb.Client
+	queue   chan influxdb.BatchPoints
+	flushed func(error) // with the result of each write, may be nil
+	stopped chan struct{}
+
+	mu     sync.Mutex
+	err    error // of the last write
+	closed bool
+}
+
+func newAsyncClient(c influxdb.Client, flushed func(error)) *asyncClient {
+	a := &asyncClient{Client: c, queue: make(chan influxdb.BatchPoints, asyncQueue), flushed: flushed, stopped: make(chan struct{})}
+	go a.run()
+	return a
+}
+
+func (a *asyncClient) Write(bp influxdb.BatchPoints) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.closed {
+		return errors.New("write after close")
+	}
+	select {
+	case a.queue <- bp:
+	default:
+		return errWriteQueueFull
+	}
+	if a.err != nil {
+		return fmt.Errorf("previous write: %w", a.err)
+	}
+	return nil
+}
+
+func (a *asyncClient) run() {
+	defer close(a.stopped)
+	for bp := range a.queue {
+		err := a.Client.Write(bp)
+		if a.flushed != nil {
+			a.flushed(err)
+		}
+		a.mu.Lock()
+		a.err = err
+		a.mu.Unlock()
+	}
+}
+
+// Close writes the queued batches and closes the client below. Batches not
+// written within asyncCloseTimeout are given up.
+func (a *asyncClient) Close() error {
+	a.mu.Lock()
+	a.closed = true
+	close(a.queue)
+	a.mu.Unlock()
+	select {
+	case <-a.stopped:
+	case <-time.After(asyncCloseTimeout):
+		slog.Error("influx: giving up on queued writes", "batches", len(a.queue)+1)
+	}
+	return a.Client.Close()
+}
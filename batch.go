@@ -0,0 +1,125 @@
+package main
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+
+	influxdb "github.com/influxdata/influxdb1-client/v2"
+)
+
+// batchingClient collects the points of several writes and writes them in
+// one request once maxPoints are pending or the oldest is maxAge old,
+// to save requests with short intervals or many counters. Write returns
+// before the points reach the server, so failures are only logged and
+// counted by the clients below, which keep buffering them.
+type batchingClient struct {
+	influxdb.Client
+	maxPoints int
+	maxAge    time.Duration
+	flushed   func(error) // with the result of each request, may be nil
+
+	mu      sync.Mutex
+	pending map[influxdb.BatchPointsConfig][]*influxdb.Point
+	n       int       // pending points
+	oldest  time.Time // of the first pending write
+	wake    chan struct{}
+	done    chan struct{}
+	stopped sync.WaitGroup
+}
+
+func newBatchingClient(c influxdb.Client, maxPoints int, maxAge time.Duration, flushed func(error)) *batchingClient {
+	b := &batchingClient{
+		Client:    c,
+		maxPoints: maxPoints,
+		maxAge:    maxAge,
+		flushed:   flushed,
+		pending:   map[influxdb.BatchPointsConfig][]*influxdb.Point{},
+		wake:      make(chan struct{}, 1),
+		done:      make(chan struct{}),
+	}
+	b.stopped.Add(1)
+	go b.run()
+	return b
+}
+
+func (b *batchingClient) Write(bp influxdb.BatchPoints) error {
+	cfg := influxdb.BatchPointsConfig{
+		Database:         bp.Database(),
+		Precision:        bp.Precision(),
+		RetentionPolicy:  bp.RetentionPolicy(),
+		WriteConsistency: bp.WriteConsistency(),
+	}
+	b.mu.Lock()
+	if b.n == 0 {
+		b.oldest = time.Now()
+	}
+	b.pending[cfg] = append(b.pending[cfg], bp.Points()...)
+	b.n += len(bp.Points())
+	full := b.maxPoints > 0 && b.n >= b.maxPoints
+	b.mu.Unlock()
+	if full {
+		select {
+		case b.wake <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// run flushes when the batch is full or old enough, and a last time on
+// Close.
+func (b *batchingClient) run() {
+	defer b.stopped.Done()
+	tick := time.NewTicker(b.maxAge / 4)
+	defer tick.Stop()
+	for {
+		select {
+		case <-b.done:
+			b.flush()
+			return
+		case <-b.wake:
+			b.flush()
+		case <-tick.C:
+			b.mu.Lock()
+			due := b.n > 0 && time.Since(b.oldest) >= b.maxAge
+			b.mu.Unlock()
+			if due {
+				b.flush()
+			}
+		}
+	}
+}
+
+// flush writes the pending points, one request per database and precision.
+func (b *batchingClient) flush() {
+	b.mu.Lock()
+	pending, n := b.pending, b.n
+	b.pending, b.n = map[influxdb.BatchPointsConfig][]*influxdb.Point{}, 0
+	b.mu.Unlock()
+	for cfg, pts := range pending {
+		bp, err := influxdb.NewBatchPoints(cfg)
+		if err != nil {
+			slog.Error("influx batch", "err", err)
+			continue
+		}
+		bp.AddPoints(pts)
+		err = b.Client.Write(bp)
+		if b.flushed != nil {
+			b.flushed(err)
+		}
+		if err != nil {
+			slog.Error("influx batch: write", "database", cfg.Database, "points", len(pts), "err", err)
+		}
+	}
+	if n > 0 {
+		slog.Debug("influx batch: wrote", "points", n)
+	}
+}
+
+// Close writes the pending points and closes the client below.
+func (b *batchingClient) Close() error {
+	close(b.done)
+	b.stopped.Wait()
+	return b.Client.Close()
+}
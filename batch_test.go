@@ -0,0 +1,81 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// waitFlush waits for the next request of a batchingClient, which must
+// succeed within timeout.
+func waitFlush(t *testing.T, flushes <-chan error, timeout time.Duration) {
+	t.Helper()
+	select {
+	case err := <-flushes:
+		if err != nil {
+			t.Fatalf("flush failed: %v", err)
+		}
+	case <-time.After(timeout):
+		t.Fatal("no flush")
+	}
+}
+
+func TestBatchFlushWhenFull(t *testing.T) {
+	f := &fakeInflux{}
+	flushes := make(chan error, 10)
+	b := newBatchingClient(f, 5, time.Hour, func(err error) { flushes <- err })
+	defer b.Close()
+	b.Write(testBatch(t, "db", 0, 2))
+	b.Write(testBatch(t, "db", 2, 2))
+	select {
+	case <-flushes:
+		t.Fatal("flushed 4 of 5 points")
+	case <-time.After(50 * time.Millisecond):
+	}
+	b.Write(testBatch(t, "db", 4, 2))
+	waitFlush(t, flushes, time.Second)
+	if want := [][]string{testLines(0, 6)}; !reflect.DeepEqual(f.batches, want) {
+		t.Errorf("wrote %v, want %v", f.batches, want)
+	}
+}
+
+func TestBatchFlushWhenOld(t *testing.T) {
+	f := &fakeInflux{}
+	flushes := make(chan error, 10)
+	const maxAge = 40 * time.Millisecond
+	b := newBatchingClient(f, 0, maxAge, func(err error) { flushes <- err })
+	defer b.Close()
+	start := time.Now()
+	b.Write(testBatch(t, "db", 0, 1))
+	b.Write(testBatch(t, "db", 1, 1))
+	waitFlush(t, flushes, time.Second)
+	if age := time.Since(start); age < maxAge {
+		t.Errorf("flushed after %s, want at least %s", age, maxAge)
+	}
+	if want := [][]string{testLines(0, 2)}; !reflect.DeepEqual(f.batches, want) {
+		t.Errorf("wrote %v, want %v", f.batches, want)
+	}
+}
+
+func TestBatchFlushOnClose(t *testing.T) {
+	f := &fakeInflux{}
+	b := newBatchingClient(f, 100, time.Hour, nil)
+	b.Write(testBatch(t, "a", 0, 2))
+	b.Write(testBatch(t, "b", 2, 1))
+	b.Write(testBatch(t, "a", 3, 1))
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !f.closed {
+		t.Error("Close() left the client below open")
+	}
+	// one request per database, in no particular order
+	got := map[string][]string{}
+	for i, db := range f.dbs {
+		got[db] = f.batches[i]
+	}
+	want := map[string][]string{"a": append(testLines(0, 2), testLines(3, 1)...), "b": testLines(2, 1)}
+	if len(f.dbs) != 2 || !reflect.DeepEqual(got, want) {
+		t.Errorf("Close() wrote %d batches: %v, want %v", len(f.dbs), got, want)
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/mwuertinger/gq-gmc/pkg/gqgmc"
+)
+
+// benchCommand implements "gq-gmc bench", which measures how well the serial
+// link to the counter performs.
+func benchCommand(args []string) {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	port := portFlags(fs)
+	rounds := fs.Int("n", 50, "Number of command round trips to time")
+	heartbeat := fs.Duration("heartbeat", 30*time.Second, "How long to record heartbeat frames, 0 skips the jitter test")
+	history := fs.Int("history", 0x10000, "Number of history bytes to download, 0 skips the throughput test")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s bench [flags]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	if fs.NArg() != 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	dev, closer := port.openDevice()
+	defer closer.Close()
+
+	version, err := dev.Version()
+	if err != nil {
+		log.Fatalf("version: %v", err)
+	}
+	fmt.Printf("Device:     %s\n", version)
+
+	if *rounds > 0 {
+		lat, err := benchLatency(dev, *rounds)
+		if err != nil {
+			log.Fatalf("latency: %v", err)
+		}
+		fmt.Printf("Round trip: %s (%d commands)\n", lat, *rounds)
+	}
+	if *heartbeat > 0 {
+		jit, err := benchHeartbeat(dev, *heartbeat)
+		if err != nil {
+			log.Fatalf("heartbeat: %v", err)
+		}
+		fmt.Printf("Heartbeat:  %s (%d intervals)\n", jit, jit.n)
+	}
+	if *history > 0 {
+		n, d, err := benchHistory(dev, *history)
+		if err != nil {
+			log.Fatalf("history: %v", err)
+		}
+		fmt.Printf("History:    %d bytes in %s, %.0f bytes/s\n", n, d.Round(time.Millisecond), float64(n)/d.Seconds())
+	}
+}
+
+// durationStats summarizes a set of measured durations.
+type durationStats struct {
+	n                 int
+	min, median, p95  time.Duration
+	max, mean, stddev time.Duration
+}
+
+func newDurationStats(ds []time.Duration) durationStats {
+	sorted := append([]time.Duration(nil), ds...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	var sum float64
+	for _, d := range sorted {
+		sum += float64(d)
+	}
+	mean := sum / float64(len(sorted))
+	var sq float64
+	for _, d := range sorted {
+		sq += (float64(d) - mean) * (float64(d) - mean)
+	}
+	return durationStats{
+		n:      len(sorted),
+		min:    sorted[0],
+		median: sorted[len(sorted)/2],
+		p95:    sorted[(len(sorted)*95)/100],
+		max:    sorted[len(sorted)-1],
+		mean:   time.Duration(mean),
+		stddev: time.Duration(math.Sqrt(sq / float64(len(sorted)))),
+	}
+}
+
+func (s durationStats) String() string {
+	r := func(d time.Duration) time.Duration { return d.Round(10 * time.Microsecond) }
+	return fmt.Sprintf("min %s, median %s, p95 %s, max %s", r(s.min), r(s.median), r(s.p95), r(s.max))
+}
+
+// jitterStats describes how far the heartbeat intervals deviate from the
+// nominal second.
+type jitterStats struct {
+	durationStats
+}
+
+func (s jitterStats) String() string {
+	r := func(d time.Duration) time.Duration { return d.Round(10 * time.Microsecond) }
+	return fmt.Sprintf("interval mean %s, stddev %s, min %s, max %s", r(s.mean), r(s.stddev), r(s.min), r(s.max))
+}
+
+// benchLatency times n <GETVOLT>> round trips. The version is cached by the
+// device, so it cannot be used for this.
+func benchLatency(dev *gqgmc.Device, n int) (durationStats, error) {
+	ds := make([]time.Duration, 0, n)
+	for i := 0; i < n; i++ {
+		start := time.Now()
+		if _, err := dev.Voltage(); err != nil {
+			return durationStats{}, err
+		}
+		ds = append(ds, time.Since(start))
+	}
+	return newDurationStats(ds), nil
+}
+
+// benchHeartbeat records the arrival times of heartbeat frames for d.
+func benchHeartbeat(dev *gqgmc.Device, d time.Duration) (jitterStats, error) {
+	if err := dev.EnableHeartbeat(); err != nil {
+		return jitterStats{}, err
+	}
+	defer dev.DisableHeartbeat()
+
+	var intervals []time.Duration
+	var last time.Time
+	for deadline := time.Now().Add(d); time.Now().Before(deadline); {
+		if _, err := dev.ReadCounts(); err != nil {
+			return jitterStats{}, err
+		}
+		now := time.Now()
+		if !last.IsZero() {
+			intervals = append(intervals, now.Sub(last))
+		}
+		last = now
+	}
+	if len(intervals) == 0 {
+		return jitterStats{}, fmt.Errorf("no frames within %s", d)
+	}
+	return jitterStats{newDurationStats(intervals)}, nil
+}
+
+// benchHistory downloads up to n bytes of history in chunks as large as the
+// protocol allows.
+func benchHistory(dev *gqgmc.Device, n int) (int, time.Duration, error) {
+	q, err := dev.Quirks()
+	if err != nil {
+		return 0, 0, err
+	}
+	if n > q.HistorySize {
+		n = q.HistorySize
+	}
+	start := time.Now()
+	for addr := 0; addr < n; addr += gqgmc.MaxHistoryChunk {
+		if _, err := dev.ReadHistory(addr, min(gqgmc.MaxHistoryChunk, n-addr)); err != nil {
+			return addr, time.Since(start), err
+		}
+	}
+	return n, time.Since(start), nil
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/influxdata/influxdb1-client/models"
+	influxdb "github.com/influxdata/influxdb1-client/v2"
+)
+
+// Bounds of the exponential backoff between attempts to flush the buffer.
+const (
+	bufferMinDelay = 5 * time.Second
+	bufferMaxDelay = 5 * time.Minute
+)
+
+// bufferFlushPoints limits the points written per request when the buffer
+// is flushed.
+const bufferFlushPoints = 5000
+
+// bufferedBatch holds the points of a failed write as line protocol, which
+// keeps their timestamps.
+type bufferedBatch struct {
+	seq       uint64   // order of the batches in the buffer
+	Database  string   `json:"database"`
+	Precision string   `json:"precision"`
+	Lines     []string `json:"lines"`
+}
+
+// bufferedClient keeps the points of failed writes and writes them again
+// with backoff until the server takes them. Write still returns the error,
+// so that failures are counted and alerted on as before. At most size
+// points are kept, the oldest are dropped first. With a spool file the
+// buffer survives restarts.
+type bufferedClient struct {
+	influxdb.Client
+	size    int
+	spool   string
+	flushed func(error) // with the result of each write to c, may be nil
+
+	mu      sync.Mutex
+	queue   []bufferedBatch // oldest first
+	points  int
+	seq     uint64 // of the last batch
+	wake    chan struct{}
+	done    chan struct{}
+	stopped sync.WaitGroup
+}
+
+// newBufferedClient returns a client buffering the failed writes to c,
+// loading the points left in the spool file by the previous run. flushed,
+// if not nil, is called with the result of the writes and of the retries.
+func newBufferedClient(c influxdb.Client, size int, spool string, flushed func(error)) (*bufferedClient, error) {
+	b := &bufferedClient{Client: c, size: size, spool: spool, flushed: flushed, wake: make(chan struct{}, 1), done: make(chan struct{})}
+	if spool != "" {
+		if err := b.load(); err != nil {
+			return nil, err
+		}
+		if b.points > 0 {
+			slog.Info("influx buffer: points left", "points", b.points, "spool", spool)
+		}
+	}
+	b.stopped.Add(1)
+	go b.retry()
+	return b, nil
+}
+
+// backlog returns the number of points waiting to be written again.
+func (b *bufferedClient) backlog() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.points
+}
+
+func (b *bufferedClient) Write(bp influxdb.BatchPoints) error {
+	err := b.Client.Write(bp)
+	b.report(err)
+	if err != nil {
+		batch := bufferedBatch{Database: bp.Database(), Precision: bp.Precision()}
+		for _, pt := range bp.Points() {
+			batch.Lines = append(batch.Lines, pt.PrecisionString(bp.Precision()))
+		}
+		b.mu.Lock()
+		b.append(batch)
+		b.trim()
+		b.save()
+		b.mu.Unlock()
+		return err
+	}
+	b.mu.Lock()
+	backlog := b.points > 0
+	b.mu.Unlock()
+	if backlog {
+		// the server is back, flush right away
+		select {
+		case b.wake <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// report passes the result of a write to flushed, if set.
+func (b *bufferedClient) report(err error) {
+	if b.flushed != nil {
+		b.flushed(err)
+	}
+}
+
+// append adds a batch to the buffer. b.mu must be held.
+func (b *bufferedClient) append(batch bufferedBatch) {
+	b.seq++
+	batch.seq = b.seq
+	b.queue = append(b.queue, batch)
+	b.points += len(batch.Lines)
+}
+
+// trim drops the oldest points beyond the size. b.mu must be held.
+func (b *bufferedClient) trim() {
+	dropped := 0
+	for b.points > b.size && len(b.queue) > 0 {
+		n := min(b.points-b.size, len(b.queue[0].Lines))
+		b.queue[0].Lines = b.queue[0].Lines[n:]
+		if len(b.queue[0].Lines) == 0 {
+			b.queue = b.queue[1:]
+		}
+		b.points -= n
+		dropped += n
+	}
+	if dropped > 0 {
+		slog.Warn("influx buffer: full, dropped the oldest points", "points", dropped)
+	}
+}
+
+// retry flushes the buffer, backing off while the server fails, until
+// Close.
+func (b *bufferedClient) retry() {
+	defer b.stopped.Done()
+	delay := bufferMinDelay
+	for {
+		select {
+		case <-time.After(delay):
+		case <-b.wake:
+		case <-b.done:
+			return
+		}
+		if err := b.flush(); err != nil {
+			delay = min(2*delay, bufferMaxDelay)
+			slog.Warn("influx buffer: flush failed", "err", err, "retry_in", delay)
+			continue
+		}
+		delay = bufferMinDelay
+	}
+}
+
+// flush writes the buffered points, oldest first, up to bufferFlushPoints
+// at a time, stopping early on Close. Points that were written are removed
+// even if a later write fails.
+func (b *bufferedClient) flush() error {
+	for {
+		select {
+		case <-b.done:
+			return nil
+		default:
+		}
+		b.mu.Lock()
+		if len(b.queue) == 0 {
+			b.mu.Unlock()
+			return nil
+		}
+		// merge batches of the same database and precision
+		first := b.queue[0]
+		var lines []string
+		var last uint64
+		for _, q := range b.queue {
+			if q.Database != first.Database || q.Precision != first.Precision || len(lines) > 0 && len(lines)+len(q.Lines) > bufferFlushPoints {
+				break
+			}
+			lines = append(lines, q.Lines...)
+			last = q.seq
+		}
+		b.mu.Unlock()
+
+		bp, err := influxdb.NewBatchPoints(influxdb.BatchPointsConfig{Database: first.Database, Precision: first.Precision})
+		if err != nil {
+			return err
+		}
+		pts, err := models.ParsePointsWithPrecision([]byte(strings.Join(lines, "\n")), time.Now(), first.Precision)
+		if err != nil {
+			// not going to get better, drop them
+			slog.Error("influx buffer: dropping unparsable points", "points", len(lines), "err", err)
+		} else {
+			for _, pt := range pts {
+				bp.AddPoint(influxdb.NewPointFrom(pt))
+			}
+			err := b.Client.Write(bp)
+			b.report(err)
+			if err != nil {
+				return err
+			}
+			slog.Info("influx buffer: wrote buffered points", "points", len(lines))
+		}
+
+		// Meanwhile failed writes may have been appended and the oldest
+		// points dropped, so the written batches are found by their seq.
+		b.mu.Lock()
+		for len(b.queue) > 0 && b.queue[0].seq <= last {
+			b.points -= len(b.queue[0].Lines)
+			b.queue = b.queue[1:]
+		}
+		b.save()
+		b.mu.Unlock()
+	}
+}
+
+// Close stops the retries, waiting for the request of a flush under way,
+// and closes the client below. The points left stay in the spool file for the next run.
+func (b *bufferedClient) Close() error {
+	close(b.done)
+	b.stopped.Wait()
+	return b.Client.Close()
+}
+
+// load reads the spool file, one JSON batch per line. A missing file is an
+// empty buffer.
+func (b *bufferedClient) load() error {
+	f, err := os.Open(b.spool)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	sc.Buffer(nil, 64<<20)
+	for sc.Scan() {
+		var batch bufferedBatch
+		if err := json.Unmarshal(sc.Bytes(), &batch); err != nil {
+			slog.Warn("influx buffer: skipping a corrupt batch", "spool", b.spool, "err", err)
+			continue
+		}
+		b.append(batch)
+	}
+	b.trim()
+	return sc.Err()
+}
+
+// save writes the buffer to the spool file, if any. b.mu must be held.
+func (b *bufferedClient) save() {
+	if b.spool == "" {
+		return
+	}
+	var out bytes.Buffer
+	enc := json.NewEncoder(&out)
+	for _, batch := range b.queue {
+		enc.Encode(batch)
+	}
+	if err := writeFileAtomic(b.spool, out.Bytes(), 0600); err != nil {
+		slog.Error("influx buffer: save spool", "err", err)
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/influxdata/influxdb1-client/models"
+	influxdb "github.com/influxdata/influxdb1-client/v2"
+)
+
+// fakeInflux records the batches written to it and fails while down.
+// Queries are answered with rows.
+type fakeInflux struct {
+	influxdb.Client
+	down    bool
+	batches [][]string // lines per written batch
+	dbs     []string
+	queries []string
+	rows    [][]interface{}
+	closed  bool
+}
+
+func (f *fakeInflux) Query(q influxdb.Query) (*influxdb.Response, error) {
+	if f.down {
+		return nil, errors.New("down")
+	}
+	f.queries = append(f.queries, q.Command)
+	return &influxdb.Response{Results: []influxdb.Result{{Series: []models.Row{{Values: f.rows}}}}}, nil
+}
+
+func (f *fakeInflux) Close() error {
+	f.closed = true
+	return nil
+}
+
+func (f *fakeInflux) Write(bp influxdb.BatchPoints) error {
+	if f.down {
+		return errors.New("down")
+	}
+	var lines []string
+	for _, pt := range bp.Points() {
+		lines = append(lines, pt.PrecisionString(bp.Precision()))
+	}
+	f.batches = append(f.batches, lines)
+	f.dbs = append(f.dbs, bp.Database())
+	return nil
+}
+
+// testBatch returns a batch of n points with the values from..from+n-1.
+func testBatch(t *testing.T, db string, from, n int) influxdb.BatchPoints {
+	t.Helper()
+	bp, err := influxdb.NewBatchPoints(influxdb.BatchPointsConfig{Database: db, Precision: "s"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := from; i < from+n; i++ {
+		pt, err := influxdb.NewPoint("m", nil, map[string]interface{}{"v": i}, time.Unix(int64(i), 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		bp.AddPoint(pt)
+	}
+	return bp
+}
+
+func testLines(from, n int) []string {
+	var lines []string
+	for i := from; i < from+n; i++ {
+		lines = append(lines, fmt.Sprintf("m v=%di %d", i, i))
+	}
+	return lines
+}
+
+func TestBufferTrim(t *testing.T) {
+	tests := []struct {
+		name    string
+		size    int
+		batches []int // points per failed write
+		want    [][]string
+	}{
+		{"fits", 10, []int{3, 4}, [][]string{testLines(0, 3), testLines(3, 4)}},
+		{"exactly full", 7, []int{3, 4}, [][]string{testLines(0, 3), testLines(3, 4)}},
+		{"drops part of the oldest", 5, []int{3, 4}, [][]string{testLines(2, 1), testLines(3, 4)}},
+		{"drops the oldest batch", 4, []int{3, 4}, [][]string{testLines(3, 4)}},
+		{"drops into the second batch", 3, []int{3, 4, 2}, [][]string{testLines(6, 1), testLines(7, 2)}},
+		{"batch larger than the buffer", 2, []int{5}, [][]string{testLines(3, 2)}},
+	}
+	for _, tt := range tests {
+		f := &fakeInflux{down: true}
+		b := &bufferedClient{Client: f, size: tt.size, wake: make(chan struct{}, 1)}
+		from := 0
+		for _, n := range tt.batches {
+			if err := b.Write(testBatch(t, "db", from, n)); err == nil {
+				t.Fatalf("%s: Write() to a server that is down succeeded", tt.name)
+			}
+			from += n
+		}
+		var got [][]string
+		points := 0
+		for _, q := range b.queue {
+			got = append(got, q.Lines)
+			points += len(q.Lines)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: buffer = %v, want %v", tt.name, got, tt.want)
+		}
+		if b.backlog() != points {
+			t.Errorf("%s: backlog() = %d, buffer holds %d points", tt.name, b.backlog(), points)
+		}
+	}
+}
+
+func TestBufferFlush(t *testing.T) {
+	f := &fakeInflux{down: true}
+	spool := filepath.Join(t.TempDir(), "spool")
+	b := &bufferedClient{Client: f, size: 2 * bufferFlushPoints, spool: spool, wake: make(chan struct{}, 1)}
+	b.Write(testBatch(t, "a", 0, 2))
+	b.Write(testBatch(t, "a", 2, 3))
+	b.Write(testBatch(t, "b", 5, 1))
+	b.Write(testBatch(t, "b", 6, bufferFlushPoints))
+	if err := b.flush(); err == nil {
+		t.Fatal("flush() to a server that is down succeeded")
+	}
+	if n := b.backlog(); n != 6+bufferFlushPoints {
+		t.Fatalf("backlog() after a failed flush = %d", n)
+	}
+
+	// a new client picks up the spool file
+	f = &fakeInflux{}
+	b = &bufferedClient{Client: f, size: 2 * bufferFlushPoints, spool: spool, wake: make(chan struct{}, 1)}
+	if err := b.load(); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.flush(); err != nil {
+		t.Fatal(err)
+	}
+	// batches of the same database are merged, up to bufferFlushPoints
+	wantDBs := []string{"a", "b", "b"}
+	wantBatches := [][]string{testLines(0, 5), testLines(5, 1), testLines(6, bufferFlushPoints)}
+	if !reflect.DeepEqual(f.dbs, wantDBs) || !reflect.DeepEqual(f.batches, wantBatches) {
+		t.Errorf("flush() wrote %d batches to %v, want %v", len(f.batches), f.dbs, wantDBs)
+	}
+	if n := b.backlog(); n != 0 {
+		t.Errorf("backlog() after flushing = %d", n)
+	}
+
+	b = &bufferedClient{Client: f, size: 2 * bufferFlushPoints, spool: spool, wake: make(chan struct{}, 1)}
+	if err := b.load(); err != nil || b.backlog() != 0 {
+		t.Errorf("spool after flushing holds %d points, %v", b.backlog(), err)
+	}
+}
+
+func TestBufferReportsFlushes(t *testing.T) {
+	f := &fakeInflux{down: true}
+	h := newCollectorHealth(time.Minute)
+	h.connected = func() bool { return true }
+	b := &bufferedClient{Client: f, size: 10, flushed: h.flushed, wake: make(chan struct{}, 1)}
+	b.Write(testBatch(t, "db", 0, 2))
+	if r := h.report(); r.LastWrite != nil || r.WriteError != "down" {
+		t.Errorf("after a failed write: last write %v, error %q", r.LastWrite, r.WriteError)
+	}
+	f.down = false
+	if err := b.flush(); err != nil {
+		t.Fatal(err)
+	}
+	if r := h.report(); r.LastWrite == nil || r.WriteError != "" {
+		t.Errorf("after flushing the buffer: last write %v, error %q", r.LastWrite, r.WriteError)
+	}
+}
+
+func TestBufferClose(t *testing.T) {
+	f := &fakeInflux{down: true}
+	spool := filepath.Join(t.TempDir(), "spool")
+	b, err := newBufferedClient(f, 10, spool, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Write(testBatch(t, "db", 0, 3))
+	done := make(chan error)
+	go func() { done <- b.Close() }()
+	select {
+	case err := <-done:
+		if err != nil || !f.closed {
+			t.Errorf("Close() = %v, closed the client below: %t", err, f.closed)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Close() did not stop the retries")
+	}
+
+	// the points stay for the next run
+	b = &bufferedClient{Client: f, size: 10, spool: spool, wake: make(chan struct{}, 1)}
+	if err := b.load(); err != nil || b.backlog() != 3 {
+		t.Errorf("spool after Close holds %d points, %v", b.backlog(), err)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"time"
+
+	influxdb "github.com/influxdata/influxdb1-client/v2"
+)
+
+// burstMaxLength ends a capture that keeps being triggered, e.g. by a
+// source left next to the tube, which would otherwise grow without bound. A
+// new capture starts with the next sample over the trigger.
+const burstMaxLength = 10 * time.Minute
+
+// burstRecorder captures every heartbeat sample around short bursts of
+// counts that the readings of an interval average away. A sample of at least
+// trigger counts starts a capture holding the samples of the window before
+// it, and the capture ends once no sample reached the trigger for a window.
+type burstRecorder struct {
+	trigger int
+	window  time.Duration
+	recent  []countSample // of the last window, oldest first
+	capture *burst
+	until   time.Time // end of the capture unless triggered again
+}
+
+// burst is a captured burst, Samples include the window before and after.
+type burst struct {
+	Start   time.Time // of the first sample over the trigger
+	Peak    int       // counts of the highest sample
+	Samples []countSample
+}
+
+// add records a sample and returns the capture it completed, if any.
+func (b *burstRecorder) add(s countSample) *burst {
+	var done *burst
+	if b.capture != nil && (s.Time.After(b.until) || s.Time.Sub(b.capture.Start) > burstMaxLength) {
+		done = b.finish()
+	}
+	if b.capture != nil {
+		b.capture.Samples = append(b.capture.Samples, s)
+		b.capture.Peak = max(b.capture.Peak, s.CPS)
+		if s.CPS >= b.trigger {
+			b.until = s.Time.Add(b.window)
+		}
+		return done
+	}
+
+	b.recent = append(b.recent, s)
+	for len(b.recent) > 0 && s.Time.Sub(b.recent[0].Time) > b.window {
+		b.recent = b.recent[1:]
+	}
+	if s.CPS >= b.trigger {
+		b.capture = &burst{Start: s.Time, Peak: s.CPS, Samples: append([]countSample(nil), b.recent...)}
+		b.until = s.Time.Add(b.window)
+		b.recent = b.recent[:0]
+	}
+	return done
+}
+
+// finish ends the capture in progress, returning nil if there is none, e.g.
+// to keep a burst the collector is shut down during.
+func (b *burstRecorder) finish() *burst {
+	done := b.capture
+	b.capture = nil
+	return done
+}
+
+// writeBurst writes the samples of a burst as geiger_counter_cps points of
+// the measurement, stamped at receipt with millisecond precision. The burst
+// tag holds the start of the burst, so that its samples can be selected
+// together.
+func writeBurst(influxClient influxdb.Client, database, measurement string, tags map[string]string, b *burst) error {
+	tags = mergeTags(tags, map[string]string{"burst": b.Start.UTC().Format(time.RFC3339)})
+	return writeCPS(influxClient, database, measurement, tags, b.Samples)
+}
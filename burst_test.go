@@ -0,0 +1,86 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBurstRecorder(t *testing.T) {
+	t0 := time.Date(2024, 6, 3, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		counts []int // one sample per second from t0
+		want   []burst
+	}{
+		{"no burst", []int{1, 2, 9, 3}, nil},
+		{
+			// the capture starts with the window before the trigger and ends
+			// with the first sample more than a window after it
+			name:   "start and end",
+			counts: []int{1, 2, 3, 4, 12, 1, 1, 1, 1},
+			want:   []burst{{Start: t0.Add(4 * time.Second), Peak: 12, Samples: samples(t0.Add(time.Second), 2, 3, 4, 12, 1, 1, 1)}},
+		},
+		{"at the trigger", []int{10, 0, 0, 0, 0}, []burst{{Start: t0, Peak: 10, Samples: samples(t0, 10, 0, 0, 0)}}},
+		{
+			name:   "triggered again",
+			counts: []int{0, 12, 0, 15, 0, 0, 0, 0},
+			want:   []burst{{Start: t0.Add(time.Second), Peak: 15, Samples: samples(t0, 0, 12, 0, 15, 0, 0, 0)}},
+		},
+		{
+			name:   "two bursts",
+			counts: []int{11, 0, 0, 0, 0, 13, 0, 0, 0, 0},
+			want: []burst{
+				{Start: t0, Peak: 11, Samples: samples(t0, 11, 0, 0, 0)},
+				{Start: t0.Add(5 * time.Second), Peak: 13, Samples: samples(t0.Add(4*time.Second), 0, 13, 0, 0, 0)},
+			},
+		},
+	}
+	for _, tt := range tests {
+		b := &burstRecorder{trigger: 10, window: 3 * time.Second}
+		var got []burst
+		for i, c := range tt.counts {
+			if done := b.add(countSample{Time: t0.Add(time.Duration(i) * time.Second), CPS: c}); done != nil {
+				got = append(got, *done)
+			}
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: bursts %+v, want %+v", tt.name, got, tt.want)
+		}
+		if b.finish() != nil {
+			t.Errorf("%s: capture left in progress", tt.name)
+		}
+	}
+}
+
+// samples returns samples of the counts, one per second from start.
+func samples(start time.Time, counts ...int) []countSample {
+	var s []countSample
+	for i, c := range counts {
+		s = append(s, countSample{Time: start.Add(time.Duration(i) * time.Second), CPS: c})
+	}
+	return s
+}
+
+func TestBurstMaxLength(t *testing.T) {
+	t0 := time.Date(2024, 6, 3, 12, 0, 0, 0, time.UTC)
+	b := &burstRecorder{trigger: 10, window: 3 * time.Second}
+	var done *burst
+	var i int
+	for i = 0; done == nil && i < 1000; i++ {
+		done = b.add(countSample{Time: t0.Add(time.Duration(i) * time.Second), CPS: 20})
+	}
+	if done == nil {
+		t.Fatal("a source left next to the tube is captured without end")
+	}
+	if end := done.Samples[len(done.Samples)-1].Time; end.Sub(done.Start) != burstMaxLength {
+		t.Errorf("capture of %s, want %s", end.Sub(done.Start), burstMaxLength)
+	}
+	// the sample ending it starts the next capture
+	if b.capture == nil || !b.capture.Start.Equal(t0.Add(time.Duration(i-1)*time.Second)) {
+		t.Errorf("next capture %+v", b.capture)
+	}
+	if c := b.finish(); c == nil || len(c.Samples) != 1 {
+		t.Errorf("finish() = %+v, want the capture in progress", c)
+	}
+}
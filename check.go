@@ -0,0 +1,424 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// checkTimeout bounds every reachability check.
+const checkTimeout = 5 * time.Second
+
+// configChecker collects the findings of "gq-gmc config check".
+type configChecker struct {
+	errors, warnings []string
+}
+
+func (c *configChecker) errorf(format string, args ...interface{}) {
+	c.errors = append(c.errors, fmt.Sprintf(format, args...))
+}
+
+func (c *configChecker) warnf(format string, args ...interface{}) {
+	c.warnings = append(c.warnings, fmt.Sprintf(format, args...))
+}
+
+// configCheckCommand implements "gq-gmc config check", which validates a
+// config file before the daemon is started with it.
+func configCheckCommand(args []string) {
+	fs := flag.NewFlagSet("config check", flag.ExitOnError)
+	influx := influxFlags(fs)
+	offline := fs.Bool("offline", false, "Skip the reachability checks")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s config check [flags] <file>\n\nNotifiers named webhook, command and gpio may also be given as daemon flags and are assumed to exist.\n\nFlags:\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	var c configChecker
+	cfg, err := loadConfig(fs.Arg(0))
+	if err != nil {
+		c.errorf("%v", err)
+	} else {
+		// secrets are often mounted only where the daemon runs
+		if err := cfg.resolveSecrets(); err != nil {
+			c.warnf("%v", err)
+		}
+		c.check(cfg)
+		set := map[string]bool{}
+		fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+		influx.override(cfg, set)
+		if !*offline {
+			c.reach(cfg, influx)
+		}
+	}
+	for _, w := range c.warnings {
+		fmt.Printf("warning: %s\n", w)
+	}
+	for _, e := range c.errors {
+		fmt.Printf("error: %s\n", e)
+	}
+	if len(c.errors) > 0 {
+		os.Exit(1)
+	}
+	fmt.Printf("%s: ok\n", fs.Arg(0))
+}
+
+// check validates the config without touching the network or devices.
+func (c *configChecker) check(cfg *fileConfig) {
+	if cfg.Interval != 0 && time.Duration(cfg.Interval) < time.Second {
+		c.errorf("interval must be at least 1s")
+	}
+	if cfg.DeviceTimezone != "" && cfg.DeviceTimezone != autoTimezone {
+		if _, err := time.LoadLocation(cfg.DeviceTimezone); err != nil {
+			c.errorf("device_timezone: %v", err)
+		}
+	}
+	if cfg.Serial.Baud < 0 {
+		c.errorf("serial: baud must not be negative")
+	}
+	if err := checkCounters(cfg, cfg.Serial.Device); err != nil {
+		c.errorf("%v", err)
+	}
+	switch api := cfg.Influx.API; api {
+	case "", "v1":
+	case "v2", "v3":
+		if cfg.Influx.Token == "" && cfg.Influx.TokenFile == "" {
+			c.errorf("influx: api %s requires a token", api)
+		}
+		if api == "v2" && cfg.Influx.Org == "" {
+			c.errorf("influx: api v2 requires an org")
+		}
+	default:
+		c.errorf("influx: unknown api %q", api)
+	}
+	if m := cfg.Mode; m != "" && m != "heartbeat" && m != "poll" {
+		c.errorf("mode: unknown mode %q", m)
+	}
+	if cfg.PollInterval != 0 && time.Duration(cfg.PollInterval) < time.Second {
+		c.errorf("poll_interval must be at least 1s")
+	}
+	if cfg.StallTimeout != nil && *cfg.StallTimeout < 0 {
+		c.errorf("stall_timeout must not be negative")
+	}
+	if cfg.BufferSize != nil && *cfg.BufferSize < 0 {
+		c.errorf("buffer_size must not be negative")
+	}
+	if cfg.Smoothing < 0 {
+		c.errorf("smoothing must not be negative")
+	}
+	if cfg.BatchAge < 0 {
+		c.errorf("batch_age must not be negative")
+	}
+	if cfg.BatchPoints != nil && *cfg.BatchPoints < 0 {
+		c.errorf("batch_points must not be negative")
+	}
+	if f := cfg.LogFileFormat; f != "" && f != "csv" && f != "ndjson" {
+		c.errorf("log_file_format: unknown format %q", f)
+	}
+	outputs := make([]string, 0, len(cfg.Outputs))
+	for name := range cfg.Outputs {
+		outputs = append(outputs, name)
+	}
+	sort.Strings(outputs)
+	for _, name := range outputs {
+		oc := cfg.Outputs[name]
+		if err := oc.validate(); err != nil {
+			c.errorf("output %s: %v", name, err)
+		}
+	}
+	if f := cfg.LogFormat; f != "" && f != "text" && f != "json" {
+		c.errorf("log_format: unknown format %q", f)
+	}
+	if l := cfg.LogLevel; l != "" {
+		var level slog.Level
+		if err := level.UnmarshalText([]byte(l)); err != nil {
+			c.errorf("log_level: %v", err)
+		}
+	}
+	if cfg.PayloadSchema != "" {
+		if _, err := parsePayloadSchema(cfg.PayloadSchema); err != nil {
+			c.errorf("payload_schema: %v", err)
+		}
+	}
+	if b := cfg.MQTT.Broker; b != "" {
+		if u, err := url.Parse(b); err != nil || u.Host == "" {
+			c.errorf("mqtt: broker %q is not a URL like tcp://host:1883", b)
+		}
+	}
+	for _, b := range cfg.Kafka.Brokers {
+		if _, _, err := net.SplitHostPort(b); err != nil {
+			c.errorf("kafka: broker %q is not an address like host:9092", b)
+		}
+	}
+	if o := cfg.Output; o != "" && o != "influx" && o != "lineprotocol" && o != "json" {
+		c.errorf("output: unknown output %q", o)
+	}
+	if g := cfg.GMCMap; (g.UserID == "") != (g.CounterID == "") {
+		c.errorf("gmcmap: user_id and counter_id are both required")
+	} else if g.Interval != 0 && time.Duration(g.Interval) < time.Minute {
+		c.errorf("gmcmap: interval must be at least 1m")
+	}
+	if r := cfg.Radmon; r.User != "" {
+		if r.Password == "" && r.PasswordFile == "" {
+			c.errorf("radmon: password is required")
+		}
+		if r.Interval != 0 && time.Duration(r.Interval) < radmonMinInterval {
+			c.errorf("radmon: interval must be at least %v", radmonMinInterval)
+		}
+	}
+	if sc := cfg.Safecast; sc.APIKey != "" || sc.APIKeyFile != "" {
+		if sc.Latitude < -90 || sc.Latitude > 90 || sc.Longitude < -180 || sc.Longitude > 180 || sc.Latitude == 0 && sc.Longitude == 0 {
+			c.errorf("safecast: latitude and longitude of the counter are required")
+		}
+		if sc.Interval != 0 && time.Duration(sc.Interval) < time.Minute {
+			c.errorf("safecast: interval must be at least 1m")
+		}
+	}
+	for _, l := range []struct{ name, addr string }{
+		{"http", cfg.Listen.HTTP},
+		{"prometheus", cfg.Listen.Prometheus},
+		{"snmp", cfg.Listen.SNMP},
+		{"modbus", cfg.Listen.Modbus},
+		{"console", cfg.Listen.Console},
+		{"grpc", cfg.Listen.GRPC},
+	} {
+		if l.addr == "" {
+			continue
+		}
+		if _, _, err := net.SplitHostPort(l.addr); err != nil {
+			c.errorf("listen: %s: %v", l.name, err)
+		}
+	}
+	if cfg.Listen.ModbusRegisters != "" {
+		if _, err := parseModbusRegisters(cfg.Listen.ModbusRegisters); err != nil {
+			c.errorf("listen: modbus_registers: %v", err)
+		}
+	}
+	if d := cfg.Listen.DBus; d != "" && d != "session" && d != "system" {
+		c.errorf("listen: dbus must be session or system, not %q", d)
+	}
+	if (cfg.Listen.GRPCTLSCert == "") != (cfg.Listen.GRPCTLSKey == "") {
+		c.errorf("listen: grpc_tls_cert and grpc_tls_key must be given together")
+	}
+	if cfg.Tube != "" {
+		if _, err := parseTube(cfg.Tube); err != nil {
+			c.errorf("tube: %v", err)
+		}
+	}
+	if cfg.CalibrationFactor < 0 {
+		c.errorf("calibration_factor must not be negative")
+	}
+	if cfg.CalibrationCurve != "" {
+		if _, err := parseCalibrationCurve(cfg.CalibrationCurve); err != nil {
+			c.errorf("calibration_curve: %v", err)
+		}
+	}
+	if _, err := parseDoseUnits(cfg.DoseUnits); err != nil {
+		c.errorf("dose_units: %v", err)
+	}
+	if _, err := parseAggregates(cfg.Aggregates); err != nil {
+		c.errorf("aggregates: %v", err)
+	}
+	if cfg.Alerts.LowBatteryVolt < 0 {
+		c.errorf("alerts: low_battery_volt must not be negative")
+	}
+	if cfg.Alerts.Webhook != "" {
+		if err := checkURL(cfg.Alerts.Webhook); err != nil {
+			c.errorf("alerts: webhook: %v", err)
+		}
+	}
+
+	// Stand-ins let the rules and reports be checked without opening GPIO
+	// pins or starting senders.
+	notifiers := map[string]notifier{
+		"webhook": &webhookNotifier{},
+		"command": &commandNotifier{},
+		"gpio":    &gpioNotifier{},
+	}
+	for _, name := range notifierNames(cfg) {
+		nc := cfg.Alerts.Notifiers[name]
+		if err := nc.validate(); err != nil {
+			c.errorf("notifier %s: %v", name, err)
+			continue
+		}
+		switch {
+		case nc.Webhook != "":
+			if err := checkURL(nc.Webhook); err != nil {
+				c.errorf("notifier %s: webhook: %v", name, err)
+			}
+			notifiers[name] = &webhookNotifier{}
+		case nc.Command != "":
+			if out, err := exec.Command("/bin/sh", "-n", "-c", nc.Command).CombinedOutput(); err != nil {
+				c.errorf("notifier %s: command: %s", name, strings.TrimSpace(string(out)))
+			}
+			notifiers[name] = &commandNotifier{}
+		case nc.GPIO != nil:
+			if *nc.GPIO < 0 {
+				c.errorf("notifier %s: gpio must not be negative", name)
+			}
+			notifiers[name] = &gpioNotifier{}
+		case nc.Email != nil:
+			if _, _, err := net.SplitHostPort(nc.Email.SMTP); err != nil {
+				c.errorf("notifier %s: email: smtp: %v", name, err)
+			}
+			notifiers[name] = &emailNotifier{}
+		case nc.Alertmanager != nil:
+			if err := checkURL(nc.Alertmanager.URL); err != nil {
+				c.errorf("notifier %s: alertmanager: %v", name, err)
+			}
+			notifiers[name] = &alertmanagerNotifier{}
+		case nc.Telegram != nil:
+			notifiers[name] = &telegramNotifier{}
+		case nc.Pushover != nil:
+			notifiers[name] = &pushoverNotifier{}
+		}
+	}
+
+	if _, err := cfg.alertRules(notifiers); err != nil {
+		c.errorf("%v", err)
+	}
+	if _, err := cfg.maintenanceWindows(); err != nil {
+		c.errorf("%v", err)
+	}
+	if _, err := cfg.scheduledTasks(); err != nil {
+		c.errorf("%v", err)
+	}
+	for i, r := range cfg.Routes {
+		if _, err := filepath.Match(r.Device, ""); err != nil || r.Device == "" {
+			c.errorf("route %d: bad device pattern %q", i+1, r.Device)
+		}
+	}
+
+	if d := cfg.Reports.Daily; d.Time != "" {
+		if _, err := parseTimeOfDay(d.Time); err != nil {
+			c.errorf("daily report: %v", err)
+		}
+		if _, err := d.reporters(notifiers); err != nil {
+			c.errorf("daily report: %v", err)
+		}
+	}
+	if w := cfg.Reports.Weekly; w.Time != "" {
+		if _, ok := weekdays[strings.ToLower(w.Day)]; !ok {
+			c.errorf("weekly report: unknown day %q", w.Day)
+		}
+		if _, err := parseTimeOfDay(w.Time); err != nil {
+			c.errorf("weekly report: %v", err)
+		}
+		if _, err := w.reporters(notifiers); err != nil {
+			c.errorf("weekly report: %v", err)
+		}
+		if w.Dir == "" && len(w.Notify) == 0 {
+			c.errorf("weekly report needs dir or notify")
+		}
+		if w.Dir != "" {
+			if fi, err := os.Stat(w.Dir); err != nil {
+				c.errorf("weekly report: %v", err)
+			} else if !fi.IsDir() {
+				c.errorf("weekly report: %s is not a directory", w.Dir)
+			}
+		}
+	}
+}
+
+// reach checks that the servers the config refers to accept connections.
+// Nothing is sent to them, except for a health query to Alertmanager and a
+// ping to InfluxDB.
+func (c *configChecker) reach(cfg *fileConfig, influx *influxConfig) {
+	client, err := influx.client()
+	if err != nil {
+		c.errorf("influx: %v", err)
+	} else if _, _, err := client.Ping(checkTimeout); err != nil {
+		c.warnf("influx %s: %v", influx.addr, err)
+	}
+
+	for _, name := range notifierNames(cfg) {
+		nc := cfg.Alerts.Notifiers[name]
+		switch {
+		case nc.Webhook != "":
+			if err := checkDial(nc.Webhook); err != nil {
+				c.warnf("notifier %s: %v", name, err)
+			}
+		case nc.Email != nil && nc.Email.SMTP != "":
+			conn, err := net.DialTimeout("tcp", nc.Email.SMTP, checkTimeout)
+			if err != nil {
+				c.warnf("notifier %s: %v", name, err)
+				continue
+			}
+			conn.Close()
+		case nc.Alertmanager != nil && nc.Alertmanager.URL != "":
+			hc := &http.Client{Timeout: checkTimeout}
+			resp, err := hc.Get(strings.TrimSuffix(nc.Alertmanager.URL, "/") + "/-/healthy")
+			if err != nil {
+				c.warnf("notifier %s: %v", name, err)
+				continue
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				c.warnf("notifier %s: alertmanager health: %s", name, resp.Status)
+			}
+		case nc.Telegram != nil:
+			if err := checkDial("https://api.telegram.org"); err != nil {
+				c.warnf("notifier %s: %v", name, err)
+			}
+		case nc.Pushover != nil:
+			if err := checkDial("https://api.pushover.net"); err != nil {
+				c.warnf("notifier %s: %v", name, err)
+			}
+		}
+	}
+}
+
+// notifierNames returns the names of the configured notifiers in order, so
+// that the findings are reported in a stable order.
+func notifierNames(cfg *fileConfig) []string {
+	names := make([]string, 0, len(cfg.Alerts.Notifiers))
+	for name := range cfg.Alerts.Notifiers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func checkURL(s string) error {
+	u, err := url.Parse(s)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("%q is not an http or https URL", s)
+	}
+	return nil
+}
+
+// checkDial opens and closes a TCP connection to the host of a URL.
+func checkDial(s string) error {
+	u, err := url.Parse(s)
+	if err != nil {
+		return err
+	}
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(u.Hostname(), port), checkTimeout)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/mwuertinger/gq-gmc/pkg/gqgmc"
+)
+
+// autoTimezone as the device timezone infers it from the device clock, see
+// inferClockLocation.
+const autoTimezone = "auto"
+
+// inferClockLocation infers the time zone of the device clock, which has
+// none, from its difference to the host clock, rounded to the 15 minutes
+// the offsets of time zones are multiples of. The clock must be less than
+// 7.5 minutes off. The host time zone is taken if it has that offset now,
+// so that records from before its last DST change are converted right;
+// otherwise the offset is fixed, like a clock that was never synchronized.
+func inferClockLocation(dev *gqgmc.Device) (*time.Location, error) {
+	saved := dev.ClockLocation()
+	dev.SetClockLocation(time.UTC)
+	defer dev.SetClockLocation(saved)
+	t, err := dev.DateTime()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	offset := t.Sub(now).Round(15 * time.Minute)
+	if offset < -12*time.Hour || offset > 14*time.Hour {
+		return nil, fmt.Errorf("device clock %s is too far off the host clock for a time zone", t.Format("2006-01-02 15:04:05"))
+	}
+	if _, host := now.Zone(); time.Duration(host)*time.Second == offset {
+		return time.Local, nil
+	}
+	sign, abs := '+', offset
+	if offset < 0 {
+		sign, abs = '-', -offset
+	}
+	name := fmt.Sprintf("UTC%c%02d:%02d", sign, int(abs.Hours()), int(abs.Minutes())%60)
+	return time.FixedZone(name, int(offset.Seconds())), nil
+}
+
+// clockCommand implements "gq-gmc clock", which shows the clock of the
+// counter and how far it is off, or sets it to the host time. The daemon
+// does the latter with -syncClock.
+func clockCommand(args []string) {
+	fs := flag.NewFlagSet("clock", flag.ExitOnError)
+	port := portFlags(fs)
+	deviceTimezone := fs.String("deviceTimezone", "Local", "Time zone of the device clock, e.g. UTC or Europe/Berlin")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s clock [flags] show|sync\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	if fs.NArg() != 1 || fs.Arg(0) != "show" && fs.Arg(0) != "sync" {
+		fs.Usage()
+		os.Exit(2)
+	}
+	loc, err := time.LoadLocation(*deviceTimezone)
+	if err != nil {
+		log.Fatalf("device timezone: %v", err)
+	}
+
+	dev, closer := port.openDevice()
+	defer closer.Close()
+	dev.SetClockLocation(loc)
+
+	t, err := dev.DateTime()
+	if err != nil {
+		log.Fatalf("clock: %v", err)
+	}
+	fmt.Printf("%s, %+v off the host clock\n", t.Format(time.RFC3339), time.Until(t).Round(time.Second))
+	if fs.Arg(0) == "show" {
+		return
+	}
+
+	if err := dev.SetDateTime(time.Now()); err != nil {
+		log.Fatalf("clock: %v", err)
+	}
+	if t, err = dev.DateTime(); err != nil {
+		log.Fatalf("clock: %v", err)
+	}
+	fmt.Printf("%s after synchronizing, %+v off the host clock\n", t.Format(time.RFC3339), time.Until(t).Round(time.Second))
+}
@@ -0,0 +1,655 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// duration is a time.Duration written as a string like "30s" in config files
+// and JSON.
+type duration time.Duration
+
+func (d duration) String() string { return time.Duration(d).String() }
+
+func (d *duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = duration(v)
+	return nil
+}
+
+func (d duration) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+// fileConfig is the content of the -config file. Flags given on the command
+// line take precedence over it.
+type fileConfig struct {
+	Interval duration          `yaml:"interval"`
+	Tags     map[string]string `yaml:"tags"`
+	APIToken string            `yaml:"api_token"`
+	// Secrets may also be read from a *_file, or be given as ${NAME} to
+	// take them from the environment.
+	APITokenFile string `yaml:"api_token_file"`
+	// DeviceTimezone is the IANA time zone the device clock is kept in, or
+	// auto to infer it from the clock, see inferClockLocation.
+	DeviceTimezone string `yaml:"device_timezone"`
+	// HostTag adds host=<host name> to the tags, true by default.
+	HostTag *bool `yaml:"host_tag"`
+	// Serial and Influx select the device and the database like the flags
+	// of the same names.
+	Serial struct {
+		Device string `yaml:"device"`
+		Baud   int    `yaml:"baud"`
+	} `yaml:"serial"`
+	// Counters are read in addition to the one selected by Serial.
+	Counters []counterConfig `yaml:"counters"`
+	Influx   struct {
+		Addr        string `yaml:"addr"`
+		API         string `yaml:"api"`
+		Token       string `yaml:"token"`
+		TokenFile   string `yaml:"token_file"`
+		Org         string `yaml:"org"`
+		Bucket      string `yaml:"bucket"`
+		Database    string `yaml:"database"`
+		Measurement string `yaml:"measurement"`
+		User        string `yaml:"user"`
+		Password    string `yaml:"password"`
+		// PasswordFile is read instead of Password, see resolveSecret.
+		PasswordFile       string `yaml:"password_file"`
+		CAFile             string `yaml:"ca_file"`
+		InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
+	} `yaml:"influx"`
+	// Tube selects the tube like -tube, e.g. J305.
+	Tube string `yaml:"tube"`
+	// CalibrationFactor converts CPM to µSv/h instead of the factor of
+	// the tube.
+	CalibrationFactor float64 `yaml:"calibration_factor"`
+	// CalibrationCurve converts CPM to µSv/h piecewise linearly between
+	// CPM:µSv/h points like "60:0.39,240:1.56", unless there is a factor.
+	CalibrationCurve string `yaml:"calibration_curve"`
+	// DoseUnits are further units the dose rate is written in, like
+	// "mrh,ngyh".
+	DoseUnits string `yaml:"dose_units"`
+	// Aggregates are the periods of further aggregate measurements, like
+	// "10s,1m,15m,1h".
+	Aggregates string `yaml:"aggregates"`
+	// Outputs are further outputs of the readings by name, written in
+	// addition to those of the flags, see outputConfig.
+	Outputs map[string]outputConfig `yaml:"outputs"`
+	// The following settings correspond to the flags of similar names.
+	Mode                 string   `yaml:"mode"` // heartbeat or poll
+	PollInterval         duration `yaml:"poll_interval"`
+	MaxCPS               *int     `yaml:"max_cps"`
+	PowerInterval        duration `yaml:"power_interval"`
+	HousekeepingInterval duration `yaml:"housekeeping_interval"`
+	Smoothing            duration `yaml:"smoothing"`
+	HourlyAggregates     bool     `yaml:"hourly_aggregates"`
+	CPSPoints            bool     `yaml:"cps_points"`
+	GapMarkers           bool     `yaml:"gap_markers"`
+	StatsFields          bool     `yaml:"stats_fields"`
+	CollectorMetrics     bool     `yaml:"collector_metrics"`
+	GPSD                 string   `yaml:"gpsd"`
+	GPSTags              bool     `yaml:"gps_tags"`
+	EnvironmentFields    bool     `yaml:"environment_fields"`
+	DryRun               bool     `yaml:"dry_run"`
+	SyncClock            bool     `yaml:"sync_clock"`
+	StateFile            string   `yaml:"state_file"`
+	Output               string   `yaml:"output"` // influx, lineprotocol or json
+	PayloadSchema        string   `yaml:"payload_schema"`
+	BufferSize           *int     `yaml:"buffer_size"`
+	BufferSpool          string   `yaml:"buffer_spool"`
+	BatchAge             duration `yaml:"batch_age"`
+	BatchPoints          *int     `yaml:"batch_points"`
+	LogFile              string   `yaml:"log_file"`
+	LogFileFormat        string   `yaml:"log_file_format"` // csv or ndjson
+	LogFileMaxSize       *int64   `yaml:"log_file_max_size"`
+	SQLite               string   `yaml:"sqlite"`
+	SQLiteRetention      duration `yaml:"sqlite_retention"`
+	Postgres             string   `yaml:"postgres"`
+	PostgresTable        string   `yaml:"postgres_table"`
+	LogLevel             string   `yaml:"log_level"`
+	LogFormat            string   `yaml:"log_format"` // text or json
+	MQTT                 struct {
+		Broker       string `yaml:"broker"`
+		ClientID     string `yaml:"client_id"`
+		User         string `yaml:"user"`
+		Password     string `yaml:"password"`
+		PasswordFile string `yaml:"password_file"`
+		Topic        string `yaml:"topic"`
+		CommandTopic string `yaml:"command_topic"`
+		Discovery    string `yaml:"discovery"`
+	} `yaml:"mqtt"`
+	Kafka struct {
+		Brokers []string `yaml:"brokers"`
+		Topic   string   `yaml:"topic"`
+	} `yaml:"kafka"`
+	OTLP struct {
+		Endpoint string `yaml:"endpoint"`
+		Headers  string `yaml:"headers"`
+	} `yaml:"otlp"`
+	Graphite      string `yaml:"graphite"`
+	StatsD        string `yaml:"statsd"`
+	MetricsPrefix string `yaml:"metrics_prefix"`
+	// StallTimeout corresponds to -stallTimeout, zero disables the watchdog.
+	StallTimeout *duration `yaml:"stall_timeout"`
+	// Listen holds the addresses of the servers, which are off by default,
+	// and their settings.
+	Listen struct {
+		HTTP            string `yaml:"http"`
+		MDNS            bool   `yaml:"mdns"`
+		Prometheus      string `yaml:"prometheus"`
+		SNMP            string `yaml:"snmp"`
+		SNMPCommunity   string `yaml:"snmp_community"`
+		Modbus          string `yaml:"modbus"`
+		ModbusRegisters string `yaml:"modbus_registers"` // in the format of -modbusRegisters
+		DBus            string `yaml:"dbus"`             // session or system
+		Console         string `yaml:"console"`
+		Ctl             string `yaml:"ctl"`
+		GRPC            string `yaml:"grpc"`
+		GRPCTLSCert     string `yaml:"grpc_tls_cert"`
+		GRPCTLSKey      string `yaml:"grpc_tls_key"`
+		Debug           string `yaml:"debug"`
+	} `yaml:"listen"`
+	GMCMap struct {
+		UserID    string   `yaml:"user_id"`
+		CounterID string   `yaml:"counter_id"`
+		Interval  duration `yaml:"interval"`
+	} `yaml:"gmcmap"`
+	Radmon struct {
+		User         string   `yaml:"user"`
+		Password     string   `yaml:"password"`
+		PasswordFile string   `yaml:"password_file"`
+		Interval     duration `yaml:"interval"`
+	} `yaml:"radmon"`
+	Safecast struct {
+		APIKey     string   `yaml:"api_key"`
+		APIKeyFile string   `yaml:"api_key_file"`
+		DeviceID   int      `yaml:"device_id"`
+		Latitude   float64  `yaml:"latitude"`
+		Longitude  float64  `yaml:"longitude"`
+		Interval   duration `yaml:"interval"`
+	} `yaml:"safecast"`
+	Alerts struct {
+		LowBatteryVolt float64                   `yaml:"low_battery_volt"`
+		Webhook        string                    `yaml:"webhook"`
+		Command        string                    `yaml:"command"`
+		Notifiers      map[string]notifierConfig `yaml:"notifiers"`
+		Rules          []ruleConfig              `yaml:"rules"`
+		Maintenance    []maintenanceConfig       `yaml:"maintenance"`
+		// Health thresholds are checked against the housekeeping
+		// telemetry. Low voltage is covered by low_battery_volt.
+		Health struct {
+			MinTemperature *float64 `yaml:"min_temperature"`
+			MaxTemperature *float64 `yaml:"max_temperature"`
+			MaxClockDrift  duration `yaml:"max_clock_drift"`
+		} `yaml:"health"`
+	} `yaml:"alerts"`
+	Reports struct {
+		Daily  reportConfig       `yaml:"daily"`
+		Weekly weeklyReportConfig `yaml:"weekly"`
+	} `yaml:"reports"`
+	Schedule []scheduleConfig `yaml:"schedule"`
+	Routes   []routeConfig    `yaml:"routes"`
+}
+
+// scheduleConfig runs a maintenance task on a cron schedule, e.g.
+//
+//	schedule:
+//	  - {task: sync-clock, cron: "0 3 * * *"}
+//	  - {task: power, cron: "@every 10m"}
+type scheduleConfig struct {
+	Task string `yaml:"task"`
+	Cron string `yaml:"cron"`
+}
+
+// reportConfig schedules a report, delivered through the named notifiers,
+// which must support reports.
+type reportConfig struct {
+	Time   string   `yaml:"time"` // time of day, e.g. "07:00"
+	Notify []string `yaml:"notify"`
+}
+
+// weeklyReportConfig schedules the HTML report of the past week, which is
+// written to Dir and/or sent to the notifiers.
+type weeklyReportConfig struct {
+	reportConfig `yaml:",inline"`
+	Day          string `yaml:"day"` // e.g. "mon"
+	Dir          string `yaml:"dir"`
+}
+
+// emailConfig is an SMTP account for sending mails.
+type emailConfig struct {
+	SMTP     string   `yaml:"smtp"` // host:port
+	From     string   `yaml:"from"`
+	To       []string `yaml:"to"`
+	User     string   `yaml:"user"`
+	Password string   `yaml:"password"`
+	// PasswordFile is read instead of Password, see resolveSecret.
+	PasswordFile string `yaml:"password_file"`
+}
+
+// maintenanceConfig is either a one-off window given by from and until, or a
+// weekly recurring one, e.g.
+//
+//	maintenance:
+//	  - {from: 2024-05-01T10:00:00Z, until: 2024-05-01T12:00:00Z}
+//	  - {days: [sat, sun], start: "22:00", end: "02:00"}
+type maintenanceConfig struct {
+	From  time.Time `yaml:"from"`
+	Until time.Time `yaml:"until"`
+	Days  []string  `yaml:"days"`
+	Start string    `yaml:"start"`
+	End   string    `yaml:"end"`
+}
+
+// notifierConfig is a named notification channel the alert stages refer to.
+// Exactly one of the fields must be set.
+type notifierConfig struct {
+	Webhook string `yaml:"webhook"`
+	Command string `yaml:"command"` // run by /bin/sh, see commandNotifier
+	// GPIO is a pin switched on while one of the alerts using this
+	// notifier is firing.
+	GPIO         *int                `yaml:"gpio"`
+	ActiveLow    bool                `yaml:"active_low"`
+	Email        *emailConfig        `yaml:"email"`
+	Alertmanager *alertmanagerConfig `yaml:"alertmanager"`
+	Telegram     *telegramConfig     `yaml:"telegram"`
+	Pushover     *pushoverConfig     `yaml:"pushover"`
+}
+
+// validate checks that exactly one kind of notifier is set and that it is
+// complete.
+func (nc *notifierConfig) validate() error {
+	kinds := 0
+	for _, set := range []bool{nc.Webhook != "", nc.Command != "", nc.GPIO != nil, nc.Email != nil, nc.Alertmanager != nil, nc.Telegram != nil, nc.Pushover != nil} {
+		if set {
+			kinds++
+		}
+	}
+	if kinds != 1 {
+		return fmt.Errorf("needs exactly one of webhook, command, gpio, email, alertmanager, telegram or pushover")
+	}
+	if e := nc.Email; e != nil && (e.SMTP == "" || e.From == "" || len(e.To) == 0) {
+		return fmt.Errorf("email needs smtp, from and to")
+	}
+	if am := nc.Alertmanager; am != nil && am.URL == "" {
+		return fmt.Errorf("alertmanager needs url")
+	}
+	if t := nc.Telegram; t != nil && (t.Token == "" && t.TokenFile == "" || t.ChatID == "") {
+		return fmt.Errorf("telegram needs token and chat_id")
+	}
+	if p := nc.Pushover; p != nil && (p.Token == "" && p.TokenFile == "" || p.User == "") {
+		return fmt.Errorf("pushover needs token and user")
+	}
+	return nil
+}
+
+// telegramConfig is a Telegram bot sending to a chat, e.g.
+//
+//	telegram:
+//	  token_file: /run/secrets/telegram
+//	  chat_id: "-1001234567890"
+type telegramConfig struct {
+	Token string `yaml:"token"`
+	// TokenFile is read instead of Token, see resolveSecret.
+	TokenFile string `yaml:"token_file"`
+	ChatID    string `yaml:"chat_id"`
+}
+
+// pushoverConfig is a Pushover application sending to a user or group, e.g.
+//
+//	pushover:
+//	  token: azGDORePK8gMaC0QOYAMyEEuzJnyUi
+//	  user: uQiRzpo4DXghDmr9QzzfQu27cmVRsG
+type pushoverConfig struct {
+	Token string `yaml:"token"` // of the application
+	// TokenFile is read instead of Token, see resolveSecret.
+	TokenFile string `yaml:"token_file"`
+	User      string `yaml:"user"` // user or group key
+}
+
+// alertmanagerConfig points to a Prometheus Alertmanager, e.g.
+//
+//	alertmanager:
+//	  url: http://alertmanager:9093
+//	  labels: {site: attic}
+type alertmanagerConfig struct {
+	URL    string            `yaml:"url"`
+	Labels map[string]string `yaml:"labels"`
+}
+
+// ruleConfig is an alert rule with one or more escalation stages, e.g.
+//
+//	rules:
+//	  - name: dose_rate
+//	    metric: dose_rate
+//	    hysteresis: 0.05
+//	    stages:
+//	      - {severity: warning, threshold: 0.3, notify: [chat], repeat: 6h}
+//	      - {severity: critical, threshold: 1, for: 5m, notify: [oncall], repeat: 15m}
+//	  - name: cpm
+//	    metric: cpm
+//	    stages:
+//	      - {severity: warning, threshold: 100, intervals: 3, notify: [webhook]}
+type ruleConfig struct {
+	Name       string        `yaml:"name"`
+	Metric     string        `yaml:"metric"`
+	Below      bool          `yaml:"below"`
+	Hysteresis float64       `yaml:"hysteresis"`
+	Stages     []stageConfig `yaml:"stages"`
+}
+
+type stageConfig struct {
+	Severity  string   `yaml:"severity"`
+	Threshold float64  `yaml:"threshold"`
+	For       duration `yaml:"for"`
+	Intervals int      `yaml:"intervals"` // consecutive intervals above the threshold
+	Repeat    duration `yaml:"repeat"`
+	Notify    []string `yaml:"notify"`
+}
+
+// alertRules converts the configured rules. Stages may only refer to the
+// given notifiers.
+func (c *fileConfig) alertRules(notifiers map[string]notifier) ([]*alertRule, error) {
+	var rules []*alertRule
+	for _, rc := range c.Alerts.Rules {
+		if rc.Name == "" {
+			return nil, fmt.Errorf("alert rule without name")
+		}
+		if !knownMetric(rc.Metric) {
+			return nil, fmt.Errorf("alert rule %s: unknown metric %q", rc.Name, rc.Metric)
+		}
+		if len(rc.Stages) == 0 {
+			return nil, fmt.Errorf("alert rule %s: no stages", rc.Name)
+		}
+		r := &alertRule{name: rc.Name, metric: rc.Metric, below: rc.Below, hysteresis: rc.Hysteresis}
+		for i, sc := range rc.Stages {
+			for _, n := range sc.Notify {
+				if _, ok := notifiers[n]; !ok {
+					return nil, fmt.Errorf("alert rule %s: unknown notifier %q", rc.Name, n)
+				}
+			}
+			if sc.Intervals < 0 {
+				return nil, fmt.Errorf("alert rule %s: intervals must not be negative", rc.Name)
+			}
+			if sc.Severity == "" {
+				sc.Severity = fmt.Sprintf("stage%d", i+1)
+			}
+			r.stages = append(r.stages, alertStage{
+				severity:  sc.Severity,
+				threshold: sc.Threshold,
+				sustain:   time.Duration(sc.For),
+				intervals: sc.Intervals,
+				repeat:    time.Duration(sc.Repeat),
+				notifiers: sc.Notify,
+			})
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+func loadConfig(path string) (*fileConfig, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfg fileConfig
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	if err := dec.Decode(&cfg); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &cfg, nil
+}
+
+// runtimeSettings are the settings that can be changed while the daemon is
+// running.
+type runtimeSettings struct {
+	Interval       duration          `json:"interval"`
+	Tags           map[string]string `json:"tags"`
+	LowBatteryVolt float64           `json:"low_battery_volt"`
+}
+
+// merge applies the fields present in the JSON document patch. Tags are
+// replaced as a whole.
+func (s *runtimeSettings) merge(patch []byte) error {
+	var p struct {
+		Interval       *duration         `json:"interval"`
+		Tags           map[string]string `json:"tags"`
+		LowBatteryVolt *float64          `json:"low_battery_volt"`
+	}
+	dec := json.NewDecoder(bytes.NewReader(patch))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&p); err != nil {
+		return err
+	}
+	if p.Interval != nil {
+		if time.Duration(*p.Interval) < time.Second {
+			return fmt.Errorf("interval must be at least 1s")
+		}
+		s.Interval = *p.Interval
+	}
+	if p.Tags != nil {
+		s.Tags = p.Tags
+	}
+	if p.LowBatteryVolt != nil {
+		if *p.LowBatteryVolt < 0 {
+			return fmt.Errorf("low_battery_volt must not be negative")
+		}
+		s.LowBatteryVolt = *p.LowBatteryVolt
+	}
+	return nil
+}
+
+// saveSettings writes s to the config file at path. The file is edited
+// rather than regenerated so comments and unrelated settings survive, and it
+// is replaced atomically.
+func saveSettings(path string, s runtimeSettings) error {
+	var doc yaml.Node
+	b, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		return err
+	}
+	if doc.Kind == 0 {
+		doc = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode}}}
+	}
+	root := doc.Content[0]
+	if err := setYAML(root, s.Interval.String(), "interval"); err != nil {
+		return err
+	}
+	if err := setYAML(root, s.Tags, "tags"); err != nil {
+		return err
+	}
+	if err := setYAML(root, s.LowBatteryVolt, "alerts", "low_battery_volt"); err != nil {
+		return err
+	}
+
+	var out bytes.Buffer
+	enc := yaml.NewEncoder(&out)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return err
+	}
+	perm := os.FileMode(0600)
+	if fi, err := os.Stat(path); err == nil {
+		perm = fi.Mode().Perm()
+	}
+	return writeFileAtomic(path, out.Bytes(), perm)
+}
+
+// setYAML sets the value at the key path below the mapping node m, creating
+// intermediate mappings as needed.
+func setYAML(m *yaml.Node, value interface{}, keys ...string) error {
+	if m.Kind != yaml.MappingNode {
+		return fmt.Errorf("config: expected mapping at %q", keys[0])
+	}
+	var v *yaml.Node
+	for i := 0; i < len(m.Content); i += 2 {
+		if m.Content[i].Value == keys[0] {
+			v = m.Content[i+1]
+		}
+	}
+	if v == nil {
+		v = &yaml.Node{Kind: yaml.MappingNode}
+		m.Content = append(m.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: keys[0]}, v)
+	}
+	if len(keys) > 1 {
+		return setYAML(v, value, keys[1:]...)
+	}
+	var n yaml.Node
+	if err := n.Encode(value); err != nil {
+		return err
+	}
+	n.HeadComment, n.LineComment, n.FootComment = v.HeadComment, v.LineComment, v.FootComment
+	*v = n
+	return nil
+}
+
+// writeFileAtomic replaces the file at path with data using a temporary file
+// in the same directory.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Chmod(perm); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// healthRules returns the rules for the configured device health thresholds.
+func (c *fileConfig) healthRules() []*alertRule {
+	h := &c.Alerts.Health
+	var rules []*alertRule
+	if h.MinTemperature != nil {
+		rules = append(rules, &alertRule{
+			name:       "low_temperature",
+			metric:     "temperature",
+			below:      true,
+			hysteresis: 1,
+			stages:     []alertStage{{severity: "warning", threshold: *h.MinTemperature}},
+		})
+	}
+	if h.MaxTemperature != nil {
+		rules = append(rules, &alertRule{
+			name:       "high_temperature",
+			metric:     "temperature",
+			hysteresis: 1,
+			stages:     []alertStage{{severity: "warning", threshold: *h.MaxTemperature}},
+		})
+	}
+	if h.MaxClockDrift > 0 {
+		rules = append(rules, &alertRule{
+			name:   "clock_drift",
+			metric: "clock_drift_seconds",
+			stages: []alertStage{{severity: "warning", threshold: time.Duration(h.MaxClockDrift).Seconds()}},
+		})
+	}
+	return rules
+}
+
+// reporters resolves the notifiers of a report.
+func (r *reportConfig) reporters(notifiers map[string]notifier) ([]reporter, error) {
+	var reporters []reporter
+	for _, name := range r.Notify {
+		rep, ok := notifiers[name].(reporter)
+		if !ok {
+			return nil, fmt.Errorf("notifier %q does not exist or cannot send reports", name)
+		}
+		reporters = append(reporters, rep)
+	}
+	return reporters, nil
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// scheduledTasks converts the configured schedule.
+func (c *fileConfig) scheduledTasks() ([]scheduledTask, error) {
+	var tasks []scheduledTask
+	for _, sc := range c.Schedule {
+		if !scheduleTasks[sc.Task] {
+			return nil, fmt.Errorf("schedule: unknown task %q", sc.Task)
+		}
+		spec, err := parseCron(sc.Cron)
+		if err != nil {
+			return nil, fmt.Errorf("schedule: %s: %w", sc.Task, err)
+		}
+		if spec.next(time.Now()).IsZero() {
+			return nil, fmt.Errorf("schedule: %s: %q never fires", sc.Task, sc.Cron)
+		}
+		tasks = append(tasks, scheduledTask{task: sc.Task, spec: spec})
+	}
+	return tasks, nil
+}
+
+// maintenanceWindows converts the configured maintenance windows.
+func (c *fileConfig) maintenanceWindows() ([]maintenanceWindow, error) {
+	var windows []maintenanceWindow
+	for _, mc := range c.Alerts.Maintenance {
+		var w maintenanceWindow
+		if !mc.From.IsZero() || !mc.Until.IsZero() {
+			if !mc.Until.After(mc.From) || mc.Start != "" || mc.End != "" || len(mc.Days) > 0 {
+				return nil, fmt.Errorf("maintenance window: need from before until and no days, start or end")
+			}
+			w.from, w.until = mc.From, mc.Until
+			windows = append(windows, w)
+			continue
+		}
+		var err error
+		if w.start, err = parseTimeOfDay(mc.Start); err != nil {
+			return nil, fmt.Errorf("maintenance window start: %w", err)
+		}
+		if w.end, err = parseTimeOfDay(mc.End); err != nil {
+			return nil, fmt.Errorf("maintenance window end: %w", err)
+		}
+		for _, d := range mc.Days {
+			wd, ok := weekdays[strings.ToLower(d)]
+			if !ok {
+				return nil, fmt.Errorf("maintenance window: unknown day %q", d)
+			}
+			w.days = append(w.days, wd)
+		}
+		windows = append(windows, w)
+	}
+	return windows, nil
+}
+
+// parseTimeOfDay parses "15:04" into the time since midnight.
+func parseTimeOfDay(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
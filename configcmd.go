@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/mwuertinger/gq-gmc/pkg/gqgmc"
+)
+
+// configBackup is the file format of "gq-gmc config backup". The model
+// guards against restoring a block laid out for another model.
+type configBackup struct {
+	Firmware string    `json:"firmware"`
+	Model    string    `json:"model"`
+	Time     time.Time `json:"time"`
+	Config   []byte    `json:"config"`
+}
+
+// configCommand implements "gq-gmc config", which reads and changes the
+// device configuration by field name, or saves and restores all of it.
+func configCommand(args []string) {
+	fs := flag.NewFlagSet("config", flag.ExitOnError)
+	port := portFlags(fs)
+	force := fs.Bool("force", false, "Restore a backup of another model")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s config [flags] get [name]\n", os.Args[0])
+		fmt.Fprintf(fs.Output(), "       %s config [flags] set name value\n", os.Args[0])
+		fmt.Fprintf(fs.Output(), "       %s config [flags] backup|restore <file>\n", os.Args[0])
+		fmt.Fprintf(fs.Output(), "       %s config check [flags] <file>\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	if fs.Arg(0) == "check" {
+		configCheckCommand(fs.Args()[1:])
+		return
+	}
+
+	switch {
+	case fs.NArg() >= 1 && fs.NArg() <= 2 && fs.Arg(0) == "get":
+	case fs.NArg() == 3 && fs.Arg(0) == "set":
+	case fs.NArg() == 2 && (fs.Arg(0) == "backup" || fs.Arg(0) == "restore"):
+	default:
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	dev, closer := port.openDevice()
+	defer closer.Close()
+
+	switch fs.Arg(0) {
+	case "backup":
+		if err := backupConfig(dev, fs.Arg(1)); err != nil {
+			log.Fatalf("config backup: %v", err)
+		}
+		return
+	case "restore":
+		if err := restoreConfig(dev, fs.Arg(1), *force); err != nil {
+			log.Fatalf("config restore: %v", err)
+		}
+		return
+	}
+
+	if fs.Arg(0) == "set" {
+		err := dev.UpdateConfig(func(c *gqgmc.Config) error {
+			return c.Set(fs.Arg(1), fs.Arg(2))
+		})
+		if err != nil {
+			log.Fatalf("config set: %v", err)
+		}
+		return
+	}
+
+	cfg, err := dev.Config()
+	if err != nil {
+		log.Fatalf("config get: %v", err)
+	}
+	names := cfg.Names()
+	if fs.NArg() == 2 {
+		names = []string{fs.Arg(1)}
+	}
+	for _, name := range names {
+		v, err := cfg.Get(name)
+		if err != nil {
+			log.Fatalf("config get: %v", err)
+		}
+		fmt.Printf("%s=%s\n", name, v)
+	}
+}
+
+// backupConfig saves the config block of the device to path.
+func backupConfig(dev *gqgmc.Device, path string) error {
+	b := configBackup{Time: time.Now()}
+	var err error
+	if b.Firmware, err = dev.Version(); err != nil {
+		return err
+	}
+	if b.Model, err = dev.Model(); err != nil {
+		return err
+	}
+	if b.Config, err = dev.RawConfig(); err != nil {
+		return err
+	}
+	out, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(path, append(out, '\n'), 0644); err != nil {
+		return err
+	}
+	fmt.Printf("Saved the %d byte config of the %s to %s\n", len(b.Config), b.Firmware, path)
+	return nil
+}
+
+// restoreConfig writes a backup to the device and reads it back to verify
+// the write.
+func restoreConfig(dev *gqgmc.Device, path string, force bool) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var b configBackup
+	if err := json.Unmarshal(data, &b); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	model, err := dev.Model()
+	if err != nil {
+		return err
+	}
+	if model != b.Model && !force {
+		return fmt.Errorf("%s holds the config of a %s, not a %s; use -force to restore it anyway", path, b.Model, model)
+	}
+	if err := dev.WriteRawConfig(b.Config); err != nil {
+		return err
+	}
+	got, err := dev.RawConfig()
+	if err != nil {
+		return fmt.Errorf("verify: %w", err)
+	}
+	if !bytes.Equal(got, b.Config) {
+		return fmt.Errorf("verify: the device config differs from %s", path)
+	}
+	fmt.Printf("Restored the config saved from the %s on %s\n", b.Firmware, b.Time.Format("2006-01-02"))
+	return nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+)
+
+const consoleHelp = `Console commands:
+  trace on|off      log the raw communication with the device
+  dump [n]          show the last n transfers with the device (default 32)
+  help              show this help
+  quit              close the connection
+All commands of "gq-gmc ctl" are accepted as well, e.g. status.
+`
+
+// listenConsole listens on addr, which must be a loopback address because the
+// console has no authentication.
+func listenConsole(addr string) (net.Listener, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if host != "localhost" {
+		if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+			return nil, fmt.Errorf("%s is not a loopback address", addr)
+		}
+	}
+	return net.Listen("tcp", addr)
+}
+
+// serveConsole accepts interactive console sessions. Commands not handled by
+// the console itself are passed on as control requests.
+func serveConsole(l net.Listener, requests chan<- ctlRequest, trace *traceReadWriter) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				slog.Error("console", "err", err)
+			}
+			return
+		}
+		go func(conn net.Conn) {
+			defer conn.Close()
+			consoleSession(conn, requests, trace)
+		}(conn)
+	}
+}
+
+func consoleSession(rw io.ReadWriter, requests chan<- ctlRequest, trace *traceReadWriter) {
+	io.WriteString(rw, "gq-gmc console, type help for a list of commands\n> ")
+	scanner := bufio.NewScanner(rw)
+	for scanner.Scan() {
+		args := strings.Fields(scanner.Text())
+		switch {
+		case len(args) == 0:
+		case args[0] == "quit" || args[0] == "exit":
+			return
+		case args[0] == "help":
+			io.WriteString(rw, consoleHelp)
+		case args[0] == "trace":
+			if len(args) != 2 || (args[1] != "on" && args[1] != "off") {
+				io.WriteString(rw, "error: usage: trace on|off\n")
+				break
+			}
+			trace.logging.Store(args[1] == "on")
+			fmt.Fprintf(rw, "protocol tracing %s\n", args[1])
+		case args[0] == "dump":
+			n := 32
+			if len(args) == 2 {
+				var err error
+				if n, err = strconv.Atoi(args[1]); err != nil || n < 1 {
+					io.WriteString(rw, "error: usage: dump [n]\n")
+					break
+				}
+			}
+			io.WriteString(rw, trace.dump(n))
+		default:
+			req := ctlRequest{args: args, origin: fromConsole, reply: make(chan string, 1)}
+			requests <- req
+			io.WriteString(rw, <-req.reply)
+		}
+		io.WriteString(rw, "> ")
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"path/filepath"
+
+	influxdb "github.com/influxdata/influxdb1-client/v2"
+)
+
+// counterConfig is a further counter read by the same process, e.g.
+//
+//	counters:
+//	  - serial: {device: /dev/ttyUSB1}
+//	    tags: {location: Basement}
+//	    calibration_factor: 0.0065
+//
+// or calibration_curve: "60:0.39,240:1.56" in the format of -calibrationCurve,
+// or tube: J305 like -tube. Each is read like the first counter and its
+// readings go to all outputs, with its own tags; the live state behind the
+// APIs, the alerts and the state file follow the first counter.
+type counterConfig struct {
+	Serial struct {
+		Device string `yaml:"device"`
+		Baud   int    `yaml:"baud"` // defaults to the baud of the first counter
+	} `yaml:"serial"`
+	// Tags are added to the global tags, replacing those of the same
+	// names.
+	Tags              map[string]string `yaml:"tags"`
+	Tube              string            `yaml:"tube"`
+	CalibrationFactor float64           `yaml:"calibration_factor"`
+	CalibrationCurve  string            `yaml:"calibration_curve"`
+}
+
+// openCounter opens the port of a further counter and identifies it. base
+// supplies the baud and the timeouts not given in c. Its pipeline shares
+// the options and the outputs of the first counter's pipeline first, starts
+// with its global tags, which follow the settings through update, and
+// writes with influxClient, routed by its port.
+func openCounter(c counterConfig, base portConfig, cfg *fileConfig, first *pipeline, influxClient influxdb.Client, logRaw bool) (*pipeline, error) {
+	pc := base
+	pc.device = c.Serial.Device
+	if c.Serial.Baud != 0 {
+		pc.baud = c.Serial.Baud
+	}
+	s, err := newReconnectingPort(pc.open)
+	if err != nil {
+		return nil, err
+	}
+	logger := slog.With("device", pc.device)
+	p := &pipeline{
+		name:     filepath.Base(pc.device),
+		port:     s,
+		dev:      pc.newDevice(newTraceReadWriter(s, logRaw, logger)),
+		logger:   logger,
+		opts:     first.opts,
+		metrics:  &selfMetrics{}, // the collector metrics are those of the first counter
+		influx:   influxClient,
+		target:   first.target,
+		outputs:  first.outputs,
+		feed:     first.feed,
+		gps:      first.gps,
+		global:   first.global,
+		settings: make(chan runtimeSettings),
+		done:     make(chan struct{}),
+	}
+	if p.version, p.profile, p.dual, err = identify(p.dev, p.logger, c.Tube, c.CalibrationFactor, c.CalibrationCurve); err != nil {
+		s.Close()
+		return nil, err
+	}
+	route := cfg.route(pc.device)
+	if route.Database != "" {
+		p.influx = &routedClient{Client: influxClient, database: route.Database}
+	}
+	p.topic = route.MQTTTopic
+	p.own = mergeTags(c.Tags, route.Tags, deviceIDTags(p.dev))
+	p.mode = readDeviceSettings(p.dev).tags()
+	return p, nil
+}
+
+// Close leaves heartbeat mode and closes the port of a further counter,
+// which ends run. It returns once run sent the last reading.
+func (p *pipeline) Close() error {
+	if err := p.dev.DisableHeartbeat(); err != nil {
+		p.logger.Error("disable heartbeat", "err", err)
+	}
+	err := p.port.Close()
+	<-p.done
+	return err
+}
+
+// update hands changed settings to run, unless it returned.
+func (p *pipeline) update(s runtimeSettings) {
+	select {
+	case p.settings <- s:
+	case <-p.done:
+	}
+}
+
+// checkCounters validates the further counters of the config, whose ports
+// must differ from each other and from the first counter at primary.
+func checkCounters(cfg *fileConfig, primary string) error {
+	seen := map[string]bool{primary: primary != ""}
+	for i, c := range cfg.Counters {
+		switch {
+		case c.Serial.Device == "":
+			return fmt.Errorf("counter %d: serial device is required", i+1)
+		case seen[c.Serial.Device]:
+			return fmt.Errorf("counter %d: %s is used twice", i+1, c.Serial.Device)
+		case c.Serial.Baud < 0:
+			return fmt.Errorf("counter %d: baud must not be negative", i+1)
+		case c.CalibrationFactor < 0:
+			return fmt.Errorf("counter %d: calibration_factor must not be negative", i+1)
+		}
+		if c.Tube != "" {
+			if _, err := parseTube(c.Tube); err != nil {
+				return fmt.Errorf("counter %d: tube: %v", i+1, err)
+			}
+		}
+		if c.CalibrationCurve != "" {
+			if _, err := parseCalibrationCurve(c.CalibrationCurve); err != nil {
+				return fmt.Errorf("counter %d: calibration_curve: %v", i+1, err)
+			}
+		}
+		seen[c.Serial.Device] = true
+	}
+	return nil
+}
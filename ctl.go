@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"net"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// ctlRequest is a command received on the control socket. The main loop
+// executes it and sends the text to return to the client on reply.
+type ctlRequest struct {
+	args   []string
+	origin ctlOrigin
+	reply  chan string
+}
+
+// ctlOrigin tells where a ctlRequest came from.
+type ctlOrigin int
+
+const (
+	fromCtl     ctlOrigin = iota // the control socket
+	fromConsole                  // the diagnostic console, see -consoleListen
+	fromMQTT                     // the command topic
+	fromAPI                      // the REST and gRPC APIs
+)
+
+// local tells whether r came from the host, through the control socket or
+// the console. Only those may send raw device commands.
+func (r ctlRequest) local() bool {
+	return r.origin == fromCtl || r.origin == fromConsole
+}
+
+// serveCtl accepts control connections. Every connection carries a single
+// command line and receives the reply before it is closed.
+func serveCtl(l net.Listener, requests chan<- ctlRequest) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				slog.Error("ctl", "err", err)
+			}
+			return
+		}
+		go func(conn net.Conn) {
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(30 * time.Second))
+			line, err := bufio.NewReader(conn).ReadString('\n')
+			if err != nil && line == "" {
+				return
+			}
+			req := ctlRequest{args: strings.Fields(line), origin: fromCtl, reply: make(chan string, 1)}
+			if len(req.args) == 0 {
+				fmt.Fprintln(conn, "error: empty command")
+				return
+			}
+			requests <- req
+			io.WriteString(conn, <-req.reply)
+		}(conn)
+	}
+}
+
+// listenCtl creates the control socket, replacing a stale one left behind by
+// a previous run.
+func listenCtl(path string) (net.Listener, error) {
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return nil, fmt.Errorf("%s is in use by another instance", path)
+	}
+	os.Remove(path)
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	// on Windows the ACL of the directory controls the access instead
+	if runtime.GOOS == "windows" {
+		return l, nil
+	}
+	if err := os.Chmod(path, 0660); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// ctlCommand implements "gq-gmc ctl", the client for the control socket of a
+// running daemon.
+func ctlCommand(args []string) {
+	fs := flag.NewFlagSet("ctl", flag.ExitOnError)
+	socket := fs.String("socket", defaultCtlSocket, "Control socket of the running daemon")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s ctl [flags] <command>\n\nCommands:\n  status\n  pause\n  resume\n  heartbeat on|off\n  flush\n  sync-clock\n  history\n  interval <duration>\n  silence <duration> [rule]\n  unsilence [rule]\n  alerts\n  loglevel <level>\n  key <n>\n  send <command> <response length> [timeout]\n\nFlags:\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	conn, err := net.Dial("unix", *socket)
+	if err != nil {
+		log.Fatalf("ctl: %v", err)
+	}
+	defer conn.Close()
+	if _, err := fmt.Fprintln(conn, strings.Join(fs.Args(), " ")); err != nil {
+		log.Fatalf("ctl: %v", err)
+	}
+	reply, err := io.ReadAll(conn)
+	if err != nil {
+		log.Fatalf("ctl: %v", err)
+	}
+	os.Stdout.Write(reply)
+	if strings.HasPrefix(string(reply), "error:") {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,4 @@
+package main
+
+// Windows has Unix domain sockets since Windows 10, but no /run.
+const defaultCtlSocket = `C:\ProgramData\gq-gmc\gq-gmc.sock`
@@ -0,0 +1,26 @@
+package main
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+// dashboardPage is a live chart of the readings of /api/v1/history and
+// /api/v1/current with the counts of /ws, for a look at the counter
+// without Grafana. It loads nothing from elsewhere, so it works offline.
+//
+//go:embed dashboard.html
+var dashboardPage []byte
+
+func serveDashboard(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(dashboardPage)
+}
@@ -0,0 +1,158 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/godbus/dbus/v5/introspect"
+	"github.com/godbus/dbus/v5/prop"
+	"github.com/mwuertinger/gq-gmc/pkg/gqgmc"
+)
+
+const (
+	dbusName      = "io.github.mwuertinger.GqGmc"
+	dbusPath      = "/io/github/mwuertinger/GqGmc"
+	dbusInterface = "io.github.mwuertinger.GqGmc1"
+)
+
+// dbusService publishes the readings as properties and a Reading signal and
+// offers a few device controls as methods.
+type dbusService struct {
+	dev *gqgmc.Device
+}
+
+func (s *dbusService) SyncClock() *dbus.Error {
+	if err := s.dev.SetDateTime(time.Now()); err != nil {
+		return dbus.MakeFailedError(err)
+	}
+	return nil
+}
+
+func (s *dbusService) SetSpeaker(on bool) *dbus.Error {
+	if err := s.dev.SetSpeaker(on); err != nil {
+		return dbus.MakeFailedError(err)
+	}
+	return nil
+}
+
+func (s *dbusService) SetVibration(on bool) *dbus.Error {
+	if err := s.dev.SetVibration(on); err != nil {
+		return dbus.MakeFailedError(err)
+	}
+	return nil
+}
+
+// startDBus connects to the "session" or "system" bus and keeps the exported
+// properties in sync with state until the connection is closed.
+func startDBus(bus string, dev *gqgmc.Device, state *liveState) (func(), error) {
+	var conn *dbus.Conn
+	var err error
+	switch bus {
+	case "session":
+		conn, err = dbus.ConnectSessionBus()
+	case "system":
+		conn, err = dbus.ConnectSystemBus()
+	default:
+		return nil, fmt.Errorf("unknown bus %q", bus)
+	}
+	if err != nil {
+		return nil, err
+	}
+	reply, err := conn.RequestName(dbusName, dbus.NameFlagDoNotQueue)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if reply != dbus.RequestNameReplyPrimaryOwner {
+		conn.Close()
+		return nil, fmt.Errorf("name %s already taken", dbusName)
+	}
+
+	svc := &dbusService{dev: dev}
+	if err := conn.Export(svc, dbusPath, dbusInterface); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	snap := state.get()
+	props, err := prop.Export(conn, dbusPath, prop.Map{dbusInterface: dbusProps(snap)})
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	node := &introspect.Node{
+		Name: dbusPath,
+		Interfaces: []introspect.Interface{
+			introspect.IntrospectData,
+			prop.IntrospectData,
+			{
+				Name:       dbusInterface,
+				Methods:    introspect.Methods(svc),
+				Properties: props.Introspection(dbusInterface),
+				Signals: []introspect.Signal{{
+					Name: "Reading",
+					Args: []introspect.Arg{
+						{Name: "cpm", Type: "i"},
+						{Name: "dose_rate", Type: "d"},
+					},
+				}},
+			},
+		},
+	}
+	if err := conn.Export(introspect.NewIntrospectable(node), dbusPath, "org.freedesktop.DBus.Introspectable"); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	updates, cancel := state.subscribe()
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case snap, ok := <-updates:
+				if !ok {
+					return
+				}
+				for name, p := range dbusProps(snap) {
+					props.SetMust(dbusInterface, name, p.Value)
+				}
+				if err := conn.Emit(dbusPath, dbusInterface+".Reading", int32(snap.CPM), snap.DoseRate); err != nil {
+					slog.Error("dbus", "err", err)
+				}
+			}
+		}
+	}()
+	return func() {
+		cancel()
+		close(done)
+		conn.Close()
+	}, nil
+}
+
+func dbusProps(snap snapshot) map[string]*prop.Prop {
+	voltage, source := 0.0, "unknown"
+	if snap.Power != nil {
+		voltage, source = snap.Power.Voltage, snap.Power.Source()
+	}
+	var ts int64
+	if !snap.Time.IsZero() {
+		ts = snap.Time.Unix()
+	}
+	p := func(v interface{}) *prop.Prop {
+		return &prop.Prop{Value: v, Emit: prop.EmitTrue}
+	}
+	return map[string]*prop.Prop{
+		"CPM":            p(int32(snap.CPM)),
+		"DoseRate":       p(snap.DoseRate),
+		"CumulativeDose": p(snap.CumulativeDose),
+		"Version":        p(snap.Version),
+		"BatteryVoltage": p(voltage),
+		"PowerSource":    p(source),
+		"Timestamp":      p(ts),
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+
+	"github.com/mwuertinger/gq-gmc/pkg/gqgmc"
+)
+
+func startDBus(bus string, dev *gqgmc.Device, state *liveState) (func(), error) {
+	return nil, errors.New("D-Bus is only supported on Linux")
+}
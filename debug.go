@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"strings"
+)
+
+// debugTransfers is the number of transfers with the device shown on
+// /debug/state.
+const debugTransfers = 32
+
+// debugState is the internal state of the collector shown on /debug/state.
+type debugState struct {
+	Goroutines    int      `json:"goroutines"`
+	HeapBytes     uint64   `json:"heap_bytes"`
+	PortConnected bool     `json:"port_connected"`
+	CountBacklog  int      `json:"count_backlog"` // counts read but not yet taken by the main loop
+	CountCapacity int      `json:"count_capacity"`
+	InfluxBacklog int      `json:"influx_backlog"` // points of failed writes kept by -bufferSize
+	MissedFrames  int64    `json:"missed_frames"`
+	Transfers     []string `json:"transfers"` // the last with the device, oldest first
+}
+
+// newDebugHandler serves the profiles of net/http/pprof below /debug/pprof/
+// and the internal state returned by state on /debug/state. It is meant for
+// troubleshooting and must not be reachable from untrusted networks.
+func newDebugHandler(state func() debugState) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.HandleFunc("/debug/state", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		s := state()
+		var m runtime.MemStats
+		runtime.ReadMemStats(&m)
+		s.Goroutines, s.HeapBytes = runtime.NumGoroutine(), m.HeapAlloc
+		writeJSON(w, s)
+	})
+	return mux
+}
+
+// transferLines splits a dump of traceReadWriter into its lines.
+func transferLines(dump string) []string {
+	lines := strings.Split(strings.TrimSuffix(dump, "\n"), "\n")
+	if len(lines) == 1 && lines[0] == "" {
+		return []string{}
+	}
+	return lines
+}
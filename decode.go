@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/mwuertinger/gq-gmc/pkg/gqgmc"
+)
+
+// decodedEntry is the NDJSON form of a history entry. Time and the rates
+// are omitted for entries that cannot be placed in time.
+type decodedEntry struct {
+	Offset   int        `json:"offset"`
+	Kind     string     `json:"kind"`
+	Time     *time.Time `json:"time,omitempty"`
+	Period   float64    `json:"period_seconds,omitempty"`
+	Mode     string     `json:"mode,omitempty"`
+	Clock    string     `json:"clock,omitempty"`
+	Counts   *int       `json:"counts,omitempty"`
+	CPM      *float64   `json:"cpm,omitempty"`
+	DoseRate *float64   `json:"dose_rate,omitempty"`
+	Note     string     `json:"note,omitempty"`
+}
+
+func newDecodedEntry(e gqgmc.HistoryEntry, tube gqgmc.Tube) decodedEntry {
+	d := decodedEntry{Offset: e.Offset, Kind: e.Kind.String(), Mode: e.Mode, Note: e.Note}
+	if !e.Time.IsZero() {
+		d.Time = &e.Time
+		d.Clock = e.Status.String()
+	}
+	d.Period = e.Period.Seconds()
+	if e.Kind == gqgmc.HistoryCount {
+		d.Counts = &e.Counts
+		if e.Period > 0 {
+			cpm := gqgmc.HistoryRecord{Counts: e.Counts, Period: e.Period}.CPM()
+			doseRate := tube.DoseRate(cpm)
+			d.CPM, d.DoseRate = &cpm, &doseRate
+		}
+	}
+	return d
+}
+
+// writeDecodedCSV writes the entries as CSV with a header, leaving the
+// fields an entry does not have empty.
+func writeDecodedCSV(w io.Writer, entries []decodedEntry) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"offset", "kind", "time", "period_seconds", "mode", "clock", "counts", "cpm", "dose_rate_usv_h", "note"})
+	for _, d := range entries {
+		row := []string{strconv.Itoa(d.Offset), d.Kind, "", "", d.Mode, d.Clock, "", "", "", d.Note}
+		if d.Time != nil {
+			row[2] = d.Time.Format(time.RFC3339)
+		}
+		if d.Period > 0 {
+			row[3] = strconv.FormatFloat(d.Period, 'g', -1, 64)
+		}
+		if d.Counts != nil {
+			row[6] = strconv.Itoa(*d.Counts)
+		}
+		if d.CPM != nil {
+			row[7] = strconv.FormatFloat(*d.CPM, 'g', -1, 64)
+			row[8] = strconv.FormatFloat(*d.DoseRate, 'g', -1, 64)
+		}
+		cw.Write(row)
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func writeDecodedNDJSON(w io.Writer, entries []decodedEntry) error {
+	b := bufio.NewWriter(w)
+	enc := json.NewEncoder(b)
+	for _, d := range entries {
+		if err := enc.Encode(d); err != nil {
+			return err
+		}
+	}
+	return b.Flush()
+}
+
+// decodeCommand implements "gq-gmc decode", which decodes a raw history
+// flash, e.g. saved by "gq-gmc history -dump" or another tool, without a
+// counter or a database.
+func decodeCommand(args []string) {
+	fs := flag.NewFlagSet("decode", flag.ExitOnError)
+	format := fs.String("format", "csv", "Output format: csv or ndjson")
+	out := fs.String("out", "", "Output file, default stdout")
+	deviceTimezone := fs.String("deviceTimezone", "Local", "Time zone of the device clock")
+	model := fs.String("model", "", "Device model selecting the tube for the dose rate, e.g. GMC-320+")
+	records := fs.Bool("records", false, "Only write the counts that can be placed in time, in order of time, as the history command stores them")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s decode [flags] dump.bin\n\nDecodes a raw history flash into its timestamps, counts and location notes.\n\nFlags:\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	write := writeDecodedCSV
+	switch *format {
+	case "csv":
+	case "ndjson":
+		write = writeDecodedNDJSON
+	default:
+		log.Fatalf("decode: unknown format %q", *format)
+	}
+	loc, err := time.LoadLocation(*deviceTimezone)
+	if err != nil {
+		log.Fatalf("device timezone: %v", err)
+	}
+	raw, err := os.ReadFile(fs.Arg(0))
+	if err != nil {
+		log.Fatalf("decode: %v", err)
+	}
+
+	history := gqgmc.DecodeHistory(raw, loc)
+	if *records {
+		placed := history[:0]
+		for _, e := range history {
+			if e.Kind == gqgmc.HistoryCount && e.Period > 0 {
+				placed = append(placed, e)
+			}
+		}
+		history = placed
+		sort.SliceStable(history, func(i, j int) bool { return history[i].Time.Before(history[j].Time) })
+	}
+	tube := gqgmc.LookupProfile(*model).Tube
+	entries := make([]decodedEntry, len(history))
+	for i, e := range history {
+		entries[i] = newDecodedEntry(e, tube)
+	}
+
+	if *out == "" {
+		if err := write(os.Stdout, entries); err != nil {
+			log.Fatalf("decode: %v", err)
+		}
+		return
+	}
+	f, err := os.Create(*out)
+	if err != nil {
+		log.Fatalf("decode: %v", err)
+	}
+	if err := write(f, entries); err != nil {
+		log.Fatalf("decode: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		log.Fatalf("decode: %v", err)
+	}
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mwuertinger/gq-gmc/pkg/gqgmc"
+	"github.com/tarm/serial"
+)
+
+// deviceCommand implements "gq-gmc device", which changes settings on the
+// counter instead of collecting data.
+func deviceCommand(args []string) {
+	fs := flag.NewFlagSet("device", flag.ExitOnError)
+	port := portFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %[1]s device [flags] speaker|vibration on|off\n       %[1]s device [flags] backlight off|<setting>\n       %[1]s device [flags] quiet on|off\n\n"+
+			"The backlight setting is numbered as in the backlight menu of the device,\nfrom 0 for off. quiet on switches the speaker and the backlight off for\nthe night, quiet off the speaker on again.\n\nFlags:\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+
+	if fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	var set func(*gqgmc.Device) error
+	if fs.Arg(0) == "backlight" {
+		setting, err := strconv.Atoi(fs.Arg(1))
+		if fs.Arg(1) == "off" {
+			setting, err = 0, nil
+		}
+		if err != nil {
+			fs.Usage()
+			os.Exit(2)
+		}
+		set = func(dev *gqgmc.Device) error { return dev.SetBacklight(setting) }
+	} else {
+		on, err := parseOnOff(fs.Arg(1))
+		if err != nil {
+			fs.Usage()
+			os.Exit(2)
+		}
+		switch fs.Arg(0) {
+		case "speaker":
+			set = func(dev *gqgmc.Device) error { return dev.SetSpeaker(on) }
+		case "vibration":
+			set = func(dev *gqgmc.Device) error { return dev.SetVibration(on) }
+		case "quiet":
+			set = func(dev *gqgmc.Device) error { return dev.SetQuiet(on) }
+		default:
+			fs.Usage()
+			os.Exit(2)
+		}
+	}
+
+	dev, closer := port.openDevice()
+	defer closer.Close()
+	if err := set(dev); err != nil {
+		log.Fatalf("%s: %v", fs.Arg(0), err)
+	}
+}
+
+// powerCommand implements "gq-gmc power", which switches the counter on or
+// off or reboots it, e.g. to power-cycle a remote station.
+func powerCommand(args []string) {
+	fs := flag.NewFlagSet("power", flag.ExitOnError)
+	port := portFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s power [flags] on|off|reboot\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	var cmd func(*gqgmc.Device) error
+	switch fs.Arg(0) {
+	case "on":
+		cmd = (*gqgmc.Device).PowerOn
+	case "off":
+		cmd = (*gqgmc.Device).PowerOff
+	case "reboot":
+		cmd = (*gqgmc.Device).Reboot
+	default:
+		fs.Usage()
+		os.Exit(2)
+	}
+	// a counter that is off cannot be probed, so it is switched on at
+	// every baud
+	bauds := []int{port.baud}
+	if port.baud == 0 && fs.Arg(0) == "on" {
+		bauds = probeBauds
+	}
+	for _, baud := range bauds {
+		p := *port
+		p.baud = baud
+		dev, closer := p.openDevice()
+		err := cmd(dev)
+		closer.Close()
+		if err != nil {
+			log.Fatalf("power %s: %v", fs.Arg(0), err)
+		}
+	}
+}
+
+// keyCommand implements "gq-gmc key", which presses a key of the counter.
+func keyCommand(args []string) {
+	fs := flag.NewFlagSet("key", flag.ExitOnError)
+	port := portFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s key [flags] <n>\n\nPresses key n from 0 to %d, the keys S1 to S4 from left to right.\n\nFlags:\n", os.Args[0], gqgmc.Keys-1)
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	n, err := strconv.Atoi(fs.Arg(0))
+	if err != nil || n < 0 || n >= gqgmc.Keys {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	dev, closer := port.openDevice()
+	defer closer.Close()
+	if err := dev.PressKey(n); err != nil {
+		log.Fatalf("key %d: %v", n, err)
+	}
+}
+
+func parseOnOff(s string) (bool, error) {
+	switch s {
+	case "on":
+		return true, nil
+	case "off":
+		return false, nil
+	}
+	return false, fmt.Errorf("expected on or off, got %q", s)
+}
+
+// portPollInterval is the read timeout of the serial port. The response
+// timeouts are enforced by the Device, which polls the port this often.
+const portPollInterval = 100 * time.Millisecond
+
+// portConfig selects the serial port and its timeouts.
+type portConfig struct {
+	device           string
+	baud             int
+	readTimeout      time.Duration
+	interByteTimeout time.Duration
+}
+
+// portFlags registers the flags selecting the serial port on fs.
+func portFlags(fs *flag.FlagSet) *portConfig {
+	p := &portConfig{}
+	fs.StringVar(&p.device, "dev", "", "Serial port device for sensor communication, or tcp://host:port of a serial bridge; rfc2217://host:port also sets the baud of the bridge")
+	fs.IntVar(&p.baud, "baud", 0, "Serial port baud for sensor communication, 0 tries 115200 and 57600")
+	fs.DurationVar(&p.readTimeout, "readTimeout", 2*time.Second, "How long to wait for the device to start responding")
+	fs.DurationVar(&p.interByteTimeout, "interByteTimeout", 0, "How long to wait for each further byte of a multi-byte response, 0 uses -readTimeout")
+	return p
+}
+
+// probeBauds are tried in order; newer models default to 115200.
+var probeBauds = []int{115200, 57600}
+
+// probeTimeout is how long the counter gets to answer <GETVER>> at each of
+// probeBauds.
+const probeTimeout = time.Second
+
+// open opens the serial port, or connects to the serial bridge at a
+// tcp:// or rfc2217:// device. Without a baud it is probed, and the baud
+// found is kept for reopening the port. A tcp:// bridge has the baud
+// configured on its side.
+func (p *portConfig) open() (io.ReadWriteCloser, error) {
+	if p.baud == 0 && !strings.HasPrefix(p.device, "tcp://") {
+		s, _, err := p.probe()
+		if err != nil {
+			return nil, err
+		}
+		slog.Info("baud detected", "dev", p.device, "baud", p.baud)
+		return s, nil
+	}
+	return p.openAt(p.baud)
+}
+
+// probe opens the port at each of probeBauds in turn and locks onto the
+// first one at which the counter answers <GETVER>>, returning the version.
+func (p *portConfig) probe() (io.ReadWriteCloser, string, error) {
+	var err error
+	for _, baud := range probeBauds {
+		var s io.ReadWriteCloser
+		if s, err = p.openAt(baud); err != nil {
+			return nil, "", err
+		}
+		dev := gqgmc.New(s)
+		dev.SetTimeouts(probeTimeout, 0)
+		var v string
+		if v, err = dev.Version(); err == nil {
+			p.baud = baud
+			return s, v, nil
+		}
+		s.Close()
+	}
+	return nil, "", fmt.Errorf("no response at %v baud: %w", probeBauds, err)
+}
+
+func (p *portConfig) openAt(baud int) (io.ReadWriteCloser, error) {
+	if isBridge(p.device) {
+		return openBridge(p.device, baud)
+	}
+	unlock, err := lockPort(p.device)
+	if err != nil {
+		return nil, err
+	}
+	c := &serial.Config{Name: p.device, Baud: baud, ReadTimeout: portPollInterval}
+	s, err := serial.OpenPort(c)
+	if err != nil {
+		unlock()
+		return nil, err
+	}
+	return &serialPort{Port: s, name: p.device, unlock: unlock}, nil
+}
+
+// serialPort tells read timeouts from a vanished device. On Linux a read
+// returns io.EOF both when the read timeout expires and once the device is
+// unplugged, so io.EOF is passed on only if the device node is gone.
+type serialPort struct {
+	*serial.Port
+	name   string
+	unlock func() // see lockPort
+}
+
+func (s *serialPort) Read(p []byte) (int, error) {
+	n, err := s.Port.Read(p)
+	if err == io.EOF {
+		if _, statErr := os.Stat(s.name); statErr == nil {
+			return n, nil
+		}
+	}
+	return n, err
+}
+
+// Close closes the port and releases its lock.
+func (s *serialPort) Close() error {
+	err := s.Port.Close()
+	s.unlock()
+	return err
+}
+
+// newDevice returns a Device on rw using the configured timeouts.
+func (p *portConfig) newDevice(rw io.ReadWriter) *gqgmc.Device {
+	dev := gqgmc.New(rw)
+	dev.SetTimeouts(p.readTimeout, p.interByteTimeout)
+	return dev
+}
+
+// openDevice opens the serial port for a device command or exits.
+func (p *portConfig) openDevice() (*gqgmc.Device, io.Closer) {
+	if p.device == "" {
+		name, ok := discoverPort()
+		if !ok {
+			log.Fatal("No counter found, select the serial port with -dev")
+		}
+		p.device = name
+	}
+	s, err := p.open()
+	if err != nil {
+		log.Fatal("open port: ", err)
+	}
+	return p.newDevice(s), s
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/mwuertinger/gq-gmc/pkg/gqgmc"
+)
+
+// deviceSettings are the settings of the device configuration that decide
+// how a station measures and alerts on site. They are served on
+// /api/v1/device and written with the housekeeping telemetry, so that the
+// configuration of remote stations can be audited without visiting them.
+type deviceSettings struct {
+	ReadAt        time.Time `json:"read_at"`
+	Speaker       bool      `json:"speaker"`
+	Vibration     *bool     `json:"vibration,omitempty"` // GMC-500 and GMC-600 only
+	Alarm         bool      `json:"alarm"`
+	AlarmOn       string    `json:"alarm_on"` // cpm or dose_rate
+	AlarmCPM      int       `json:"alarm_cpm"`
+	AlarmDoseRate float64   `json:"alarm_dose_rate"` // µSv/h
+	HistoryMode   string    `json:"history_mode"`    // see gqgmc.Config.HistoryMode
+	DisplayUnit   string    `json:"display_unit"`
+	PowerSaving   int       `json:"power_saving"`
+	Backlight     int       `json:"backlight"` // setting as numbered in the device menu
+}
+
+// readDeviceSettings reads the settings from the device configuration. It
+// returns nil if the configuration cannot be read.
+func readDeviceSettings(dev *gqgmc.Device) *deviceSettings {
+	c, err := dev.Config()
+	if err != nil {
+		slog.Warn("device config", "err", err)
+		return nil
+	}
+	s := &deviceSettings{
+		ReadAt:        time.Now(),
+		Speaker:       c.Speaker,
+		Alarm:         c.Alarm,
+		AlarmOn:       "cpm",
+		AlarmCPM:      c.AlarmCPM,
+		AlarmDoseRate: c.AlarmDoseRate,
+		HistoryMode:   c.HistoryMode(),
+		DisplayUnit:   c.DisplayUnit(),
+		PowerSaving:   c.PowerSaving,
+		Backlight:     c.Backlight,
+	}
+	if c.AlarmType == 1 {
+		s.AlarmOn = "dose_rate"
+	}
+	if c.Has("vibration") {
+		s.Vibration = &c.Vibration
+	}
+	return s
+}
+
+// tags returns the display unit and history mode, which are attached to the
+// readings so that the unit of the on-device history is known later. It
+// returns nil for nil settings.
+func (s *deviceSettings) tags() map[string]string {
+	if s == nil {
+		return nil
+	}
+	return map[string]string{"display_unit": s.DisplayUnit, "history_mode": s.HistoryMode}
+}
+
+// addFields adds the settings as device_* fields.
+func (s *deviceSettings) addFields(fields map[string]interface{}) {
+	if s == nil {
+		return
+	}
+	fields["device_speaker"] = s.Speaker
+	if s.Vibration != nil {
+		fields["device_vibration"] = *s.Vibration
+	}
+	fields["device_alarm"] = s.Alarm
+	fields["device_alarm_on"] = s.AlarmOn
+	fields["device_alarm_cpm"] = s.AlarmCPM
+	fields["device_alarm_dose_rate"] = s.AlarmDoseRate
+	fields["device_history_mode"] = s.HistoryMode
+	fields["device_power_saving"] = s.PowerSaving
+	fields["device_backlight"] = s.Backlight
+}
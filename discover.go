@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+)
+
+// usbID is the USB vendor and product ID of a serial adapter.
+type usbID struct {
+	vendor, product uint16
+}
+
+func (id usbID) String() string {
+	return fmt.Sprintf("%04x:%04x", id.vendor, id.product)
+}
+
+// counterUSBIDs are the USB serial chips built into GMC counters: the
+// WCH CH340 of most models and its CH341 sibling.
+var counterUSBIDs = []usbID{
+	{0x1a86, 0x7523},
+	{0x1a86, 0x5523},
+}
+
+func isCounterUSBID(id usbID) bool {
+	for _, c := range counterUSBIDs {
+		if c == id {
+			return true
+		}
+	}
+	return false
+}
+
+// discoverPort returns the serial port of an attached counter, found by the
+// USB IDs of its serial chip. With several candidates the first in name
+// order is taken.
+func discoverPort() (string, bool) {
+	ports := usbSerialPorts()
+	var found []string
+	for port, id := range ports {
+		if isCounterUSBID(id) {
+			found = append(found, port)
+		}
+	}
+	if len(found) == 0 {
+		return "", false
+	}
+	sort.Strings(found)
+	if len(found) > 1 {
+		slog.Warn("found several counters, select one with -dev", "found", found, "using", found[0])
+	}
+	return found[0], true
+}
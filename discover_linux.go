@@ -0,0 +1,51 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// usbSerialPorts returns the USB serial ports with the IDs of their
+// adapters, read from sysfs.
+func usbSerialPorts() map[string]usbID {
+	ports := map[string]usbID{}
+	for _, pattern := range []string{"/sys/class/tty/ttyUSB*", "/sys/class/tty/ttyACM*"} {
+		ttys, _ := filepath.Glob(pattern)
+		for _, tty := range ttys {
+			dir, err := filepath.EvalSymlinks(filepath.Join(tty, "device"))
+			if err != nil {
+				continue
+			}
+			// The device is the USB interface or, for usb-serial
+			// drivers, a child of it; the IDs are on the USB device
+			// above.
+			for i := 0; i < 3; i++ {
+				if id, ok := readUSBID(dir); ok {
+					ports["/dev/"+filepath.Base(tty)] = id
+					break
+				}
+				dir = filepath.Dir(dir)
+			}
+		}
+	}
+	return ports
+}
+
+func readUSBID(dir string) (usbID, bool) {
+	read := func(name string) (uint16, bool) {
+		b, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return 0, false
+		}
+		v, err := strconv.ParseUint(strings.TrimSpace(string(b)), 16, 16)
+		return uint16(v), err == nil
+	}
+	vendor, ok := read("idVendor")
+	if !ok {
+		return usbID{}, false
+	}
+	product, ok := read("idProduct")
+	return usbID{vendor, product}, ok
+}
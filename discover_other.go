@@ -0,0 +1,8 @@
+//go:build !linux && !windows
+
+package main
+
+// usbSerialPorts is not implemented on this platform, -dev must be given.
+func usbSerialPorts() map[string]usbID {
+	return nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/windows/registry"
+)
+
+// usbSerialPorts returns the COM ports of the known counter adapters, read
+// from the device instances Windows registered for their USB IDs. Instances
+// of adapters seen before but no longer attached are left out.
+func usbSerialPorts() map[string]usbID {
+	ports := map[string]usbID{}
+	present := map[string]bool{}
+	if k, err := registry.OpenKey(registry.LOCAL_MACHINE, `HARDWARE\DEVICEMAP\SERIALCOMM`, registry.QUERY_VALUE); err == nil {
+		names, _ := k.ReadValueNames(-1)
+		for _, n := range names {
+			if port, _, err := k.GetStringValue(n); err == nil {
+				present[port] = true
+			}
+		}
+		k.Close()
+	}
+	for _, id := range counterUSBIDs {
+		path := fmt.Sprintf(`SYSTEM\CurrentControlSet\Enum\USB\VID_%04X&PID_%04X`, id.vendor, id.product)
+		k, err := registry.OpenKey(registry.LOCAL_MACHINE, path, registry.ENUMERATE_SUB_KEYS)
+		if err != nil {
+			continue
+		}
+		instances, _ := k.ReadSubKeyNames(-1)
+		k.Close()
+		for _, inst := range instances {
+			p, err := registry.OpenKey(registry.LOCAL_MACHINE, path+`\`+inst+`\Device Parameters`, registry.QUERY_VALUE)
+			if err != nil {
+				continue
+			}
+			if name, _, err := p.GetStringValue("PortName"); err == nil && present[name] {
+				ports[name] = id
+			}
+			p.Close()
+		}
+	}
+	return ports
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"log/slog"
+
+	influxdb "github.com/influxdata/influxdb1-client/v2"
+)
+
+// dryRunClient stands in for InfluxDB with -dryRun and logs the points in
+// line protocol instead of sending them.
+type dryRunClient struct {
+	discardClient
+}
+
+func (dryRunClient) Write(bp influxdb.BatchPoints) error {
+	for _, pt := range bp.Points() {
+		slog.Info("dry run: would write", "output", "influx", "database", bp.Database(), "point", pt.String())
+	}
+	return nil
+}
+
+// dryRunOutput stands in for an output with -dryRun.
+type dryRunOutput struct {
+	name string
+}
+
+func (o dryRunOutput) write(r reading) error {
+	slog.Info("dry run: would write", "output", o.name, "counter", r.Counter, "cpm", r.CPM, "dose_rate", r.DoseRate)
+	return nil
+}
+
+func (dryRunOutput) Close() error { return nil }
+
+// dryRunUploader logs what would be submitted to a radiation map.
+type dryRunUploader struct {
+	service string
+}
+
+func (u dryRunUploader) upload(up upload) error {
+	slog.Info("dry run: would upload", "service", u.service, "cpm", up.snap.CPM, "mean_cpm", up.meanCPM, "dose_rate", up.snap.DoseRate)
+	return nil
+}
+
+// dryRunNotifier logs the alerts instead of sending them.
+type dryRunNotifier struct {
+	name string
+}
+
+func (n dryRunNotifier) notify(ev alertEvent) error {
+	slog.Info("dry run: would notify", "notifier", n.name, "rule", ev.Rule, "state", ev.State, "value", ev.Value)
+	return nil
+}
+
+// dryRunReporter stands in for a notifier that can also send reports.
+type dryRunReporter struct {
+	dryRunNotifier
+}
+
+func (r dryRunReporter) report(subject, contentType, body string) error {
+	slog.Info("dry run: would send report", "notifier", r.name, "subject", subject, "bytes", len(body))
+	return nil
+}
+
+// dryRunNotifiers replaces the notifiers by stand-ins logging the alerts
+// and reports they would have sent.
+func dryRunNotifiers(notifiers map[string]notifier) {
+	for name, n := range notifiers {
+		if _, ok := n.(reporter); ok {
+			notifiers[name] = dryRunReporter{dryRunNotifier{name}}
+		} else {
+			notifiers[name] = dryRunNotifier{name}
+		}
+	}
+}
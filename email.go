@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// emailNotifier sends alert events and reports as mails through an SMTP
+// server. The connection is upgraded with STARTTLS if the server offers it.
+type emailNotifier struct {
+	addr     string // host:port
+	from     string
+	to       []string
+	user     string
+	password string
+}
+
+func (m *emailNotifier) notify(ev alertEvent) error {
+	subject := fmt.Sprintf("[gq-gmc] %s %s %s", ev.Rule, ev.Severity, ev.State)
+	body := fmt.Sprintf("%s\n\nTime: %s\n", ev, ev.Time.Format(time.RFC1123Z))
+	return m.send(subject, "text/plain", body)
+}
+
+// report sends a report with the given subject.
+func (m *emailNotifier) report(subject, contentType, body string) error {
+	return m.send(subject, contentType, body)
+}
+
+func (m *emailNotifier) send(subject, contentType, body string) error {
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", m.from)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(m.to, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", subject)
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "MIME-Version: 1.0\r\nContent-Type: %s; charset=utf-8\r\n\r\n", contentType)
+	msg.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+
+	var auth smtp.Auth
+	if m.user != "" {
+		host, _, err := net.SplitHostPort(m.addr)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", m.user, m.password, host)
+	}
+	return smtp.SendMail(m.addr, auth, m.from, m.to, msg.Bytes())
+}
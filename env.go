@@ -0,0 +1,59 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// envPrefix starts the names of the environment variables standing in for
+// flags.
+const envPrefix = "GQGMC_"
+
+// envName returns the environment variable of a flag: GQGMC_INFLUX_ADDR for
+// -influxAddr, GQGMC_SAFECAST_API_KEY for -safecastAPIKey.
+func envName(flagName string) string {
+	var b strings.Builder
+	b.WriteString(envPrefix)
+	r := []rune(flagName)
+	for i, c := range r {
+		if i > 0 && unicode.IsUpper(c) {
+			prev := r[i-1]
+			// a word starts after a lower case letter, and an acronym ends
+			// before an upper case letter followed by a lower case one
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || i+1 < len(r) && unicode.IsUpper(prev) && unicode.IsLower(r[i+1]) {
+				b.WriteByte('_')
+			}
+		}
+		b.WriteRune(unicode.ToUpper(c))
+	}
+	return b.String()
+}
+
+// parseFlags parses the command line and then sets the flags not given on
+// it from their environment variables, for containers. Both take precedence
+// over the config file, which only applies to flags not set either way. The
+// variable of -tag holds the key=value pairs separated by commas.
+func parseFlags(fs *flag.FlagSet, args []string) {
+	fs.Parse(args)
+	given := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { given[f.Name] = true })
+	fs.VisitAll(func(f *flag.Flag) {
+		name := envName(f.Name)
+		v, ok := os.LookupEnv(name)
+		if !ok || given[f.Name] {
+			return
+		}
+		values := []string{v}
+		if _, ok := f.Value.(tagFlags); ok {
+			values = strings.Split(v, ",")
+		}
+		for _, v := range values {
+			if err := fs.Set(f.Name, v); err != nil {
+				log.Fatalf("%s: %v", name, err)
+			}
+		}
+	})
+}
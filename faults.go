@@ -0,0 +1,114 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+// errInjectedDisconnect is returned by writes after an injected disconnect.
+var errInjectedDisconnect = errors.New("fault injection: device disconnected")
+
+// faultRates holds the probability of each fault per read from the port.
+type faultRates struct {
+	timeout    float64 // the data is lost and the read times out
+	partial    float64 // the last byte of the read is lost
+	garbage    float64 // random bytes are inserted before the data
+	disconnect float64 // the port fails until it is reopened
+}
+
+// parseFaultRates parses the -faults flag, e.g.
+// "timeout=0.01,partial=0.005,garbage=0.005,disconnect=0.0001".
+func parseFaultRates(s string) (faultRates, error) {
+	var r faultRates
+	for _, entry := range strings.Split(s, ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok {
+			return r, fmt.Errorf("%q: expected fault=probability", entry)
+		}
+		p, err := strconv.ParseFloat(value, 64)
+		if err != nil || !(p >= 0 && p <= 1) {
+			return r, fmt.Errorf("%q: probability must be between 0 and 1", entry)
+		}
+		switch name {
+		case "timeout":
+			r.timeout = p
+		case "partial":
+			r.partial = p
+		case "garbage":
+			r.garbage = p
+		case "disconnect":
+			r.disconnect = p
+		default:
+			return r, fmt.Errorf("unknown fault %q", name)
+		}
+	}
+	return r, nil
+}
+
+// faultReadWriter injects faults into the reads from the port. The faults
+// are drawn from a seeded source so that a run can be reproduced.
+type faultReadWriter struct {
+	rw           io.ReadWriter
+	rates        faultRates
+	rng          *rand.Rand
+	disconnected bool
+}
+
+func newFaultReadWriter(rw io.ReadWriter, rates faultRates, seed int64) *faultReadWriter {
+	return &faultReadWriter{rw: rw, rates: rates, rng: rand.New(rand.NewSource(seed))}
+}
+
+func (f *faultReadWriter) Read(p []byte) (int, error) {
+	if f.disconnected {
+		return 0, io.EOF
+	}
+	if len(p) == 0 {
+		return f.rw.Read(p)
+	}
+	switch x := f.rng.Float64(); {
+	case x < f.rates.disconnect:
+		slog.Warn("fault injection: disconnect")
+		f.disconnected = true
+		return 0, io.EOF
+	case x < f.rates.disconnect+f.rates.timeout:
+		n, err := f.rw.Read(p)
+		slog.Warn("fault injection: timeout", "dropped", n)
+		return 0, err
+	case x < f.rates.disconnect+f.rates.timeout+f.rates.partial:
+		n, err := f.rw.Read(p)
+		if n > 0 {
+			slog.Warn("fault injection: partial read", "dropped", 1)
+			n--
+		}
+		return n, err
+	case x < f.rates.disconnect+f.rates.timeout+f.rates.partial+f.rates.garbage:
+		k := 1 + f.rng.Intn(min(4, len(p)))
+		f.rng.Read(p[:k])
+		slog.Warn("fault injection: garbage", "bytes", k)
+		if k == len(p) {
+			return k, nil
+		}
+		n, err := f.rw.Read(p[k:])
+		return k + n, err
+	}
+	return f.rw.Read(p)
+}
+
+func (f *faultReadWriter) Write(p []byte) (int, error) {
+	if f.disconnected {
+		return 0, errInjectedDisconnect
+	}
+	return f.rw.Write(p)
+}
+
+func (f *faultReadWriter) Close() error {
+	if c, ok := f.rw.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+package main
+
+import "testing"
+
+func TestParseFaultRates(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    faultRates
+		wantErr bool
+	}{
+		{"timeout=0.01", faultRates{timeout: 0.01}, false},
+		{"timeout=0.01,partial=0.005,garbage=0.005,disconnect=0.0001", faultRates{0.01, 0.005, 0.005, 0.0001}, false},
+		{" garbage=1 , partial=0 ", faultRates{garbage: 1}, false},
+		{"timeout=0.1,timeout=0.2", faultRates{timeout: 0.2}, false},
+		{"", faultRates{}, true},
+		{"timeout", faultRates{}, true},
+		{"timeout=", faultRates{}, true},
+		{"timeout=often", faultRates{}, true},
+		{"timeout=-0.1", faultRates{}, true},
+		{"timeout=1.5", faultRates{}, true},
+		{"timeout=NaN", faultRates{}, true},
+		{"flood=0.1", faultRates{}, true},
+		{"timeout=0.1,", faultRates{}, true},
+	}
+	for _, tt := range tests {
+		got, err := parseFaultRates(tt.s)
+		if (err != nil) != tt.wantErr || !tt.wantErr && got != tt.want {
+			t.Errorf("parseFaultRates(%q) = %+v, %v, want %+v, error %v", tt.s, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// fileLogBackups is the number of rotated files kept by fileLogger.
+const fileLogBackups = 5
+
+// fileLogger appends the reading of each interval to a CSV or NDJSON file,
+// for collecting raw data without a database. When the file grows beyond
+// maxSize bytes it is renamed to <path>.1, shifting the older files up to
+// <path>.5; zero disables rotation. With counters the counter of each
+// reading is written too, see reading.Counter, in a last CSV column.
+type fileLogger struct {
+	path     string
+	csv      bool
+	maxSize  int64
+	counters bool
+
+	f    *os.File
+	size int64
+}
+
+func newFileLogger(path, format string, maxSize int64, counters bool) (*fileLogger, error) {
+	if format != "csv" && format != "ndjson" {
+		return nil, fmt.Errorf("unknown format %q", format)
+	}
+	l := &fileLogger{path: path, csv: format == "csv", maxSize: maxSize, counters: counters}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// open opens the file for appending, starting a new CSV file with a header.
+func (l *fileLogger) open() error {
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.f, l.size = f, fi.Size()
+	if l.csv && l.size == 0 {
+		if l.counters {
+			return l.append([]byte("time,cpm,dose_rate_usv_h,counter\n"))
+		}
+		return l.append([]byte("time,cpm,dose_rate_usv_h\n"))
+	}
+	return nil
+}
+
+func (l *fileLogger) write(r reading) error {
+	var line []byte
+	if l.csv {
+		line = []byte(r.Time.Format(time.RFC3339) + "," + strconv.Itoa(r.CPM) + "," + strconv.FormatFloat(r.DoseRate, 'g', -1, 64))
+		if l.counters {
+			line = append(line, ","+r.Counter...)
+		}
+		line = append(line, '\n')
+	} else {
+		var err error
+		line, err = json.Marshal(struct {
+			Time     time.Time `json:"time"`
+			CPM      int       `json:"cpm"`
+			DoseRate float64   `json:"dose_rate_usv_h"`
+			Counter  string    `json:"counter,omitempty"`
+		}{r.Time, r.CPM, r.DoseRate, r.Counter})
+		if err != nil {
+			return err
+		}
+		line = append(line, '\n')
+	}
+	if l.maxSize > 0 && l.size > 0 && l.size+int64(len(line)) > l.maxSize {
+		if err := l.rotate(); err != nil {
+			return err
+		}
+	}
+	return l.append(line)
+}
+
+func (l *fileLogger) append(b []byte) error {
+	n, err := l.f.Write(b)
+	l.size += int64(n)
+	return err
+}
+
+// rotate shifts the rotated files up by one, dropping the oldest, and
+// starts a new file.
+func (l *fileLogger) rotate() error {
+	if err := l.f.Close(); err != nil {
+		return err
+	}
+	for i := fileLogBackups - 1; i > 0; i-- {
+		os.Rename(l.path+"."+strconv.Itoa(i), l.path+"."+strconv.Itoa(i+1))
+	}
+	if err := os.Rename(l.path, l.path+".1"); err != nil {
+		return err
+	}
+	return l.open()
+}
+
+func (l *fileLogger) Close() error {
+	return l.f.Close()
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"time"
+
+	influxdb "github.com/influxdata/influxdb1-client/v2"
+)
+
+// gapMinDuration is the shortest interruption of the counts written as a
+// gap, shorter ones are the odd lost heartbeat frame.
+const gapMinDuration = 5 * time.Second
+
+// Reasons of gaps, ordered by precedence: a gap with timeouts that ended in
+// a lost port is a port_lost gap.
+const (
+	gapTimeout       = "timeout"
+	gapReadError     = "read_error"
+	gapPortLost      = "port_lost"
+	gapCollectorDown = "collector_down" // between the last reading of the previous run and the first of this one
+)
+
+var gapPrecedence = map[string]int{gapTimeout: 1, gapReadError: 2, gapPortLost: 3}
+
+// gap is an interruption of the counts, from the last reading before it to
+// the first one after it.
+type gap struct {
+	Start, End time.Time
+	Reason     string
+}
+
+// gapTracker follows the reads of the reader and sends the gaps to ch,
+// dropping them when the main loop is behind.
+type gapTracker struct {
+	ch     chan<- gap
+	last   time.Time // of the last reading
+	reason string    // of the failures since, empty without
+}
+
+func newGapTracker(ch chan<- gap) *gapTracker {
+	return &gapTracker{ch: ch, last: time.Now()}
+}
+
+// fail records a failed read.
+func (t *gapTracker) fail(reason string) {
+	if gapPrecedence[reason] > gapPrecedence[t.reason] {
+		t.reason = reason
+	}
+}
+
+// reading records a successful read, ending a gap if there was one.
+func (t *gapTracker) reading() {
+	now := time.Now()
+	if t.reason != "" && now.Sub(t.last) >= gapMinDuration {
+		select {
+		case t.ch <- gap{Start: t.last, End: now, Reason: t.reason}:
+		default:
+		}
+	}
+	t.last, t.reason = now, ""
+}
+
+// writeGap writes the gap to the <measurement>_gaps measurement, stamped
+// with its start and tagged with its reason, for annotations on dashboards.
+func writeGap(influxClient influxdb.Client, database, measurement string, tags map[string]string, g gap) error {
+	fields := map[string]interface{}{"duration_seconds": g.End.Sub(g.Start).Seconds()}
+	return sendToInflux(influxClient, database, measurement+"_gaps", mergeTags(tags, map[string]string{"reason": g.Reason}), fields, g.Start)
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// gmcmapURL is the logging endpoint of gmcmap.com the WiFi models use.
+const gmcmapURL = "http://www.gmcmap.com/log2.asp"
+
+// gmcmapUploader submits readings to the GQ GMC map with the account and
+// counter IDs shown on gmcmap.com.
+type gmcmapUploader struct {
+	userID, counterID string
+}
+
+func (g *gmcmapUploader) upload(u upload) error {
+	q := url.Values{
+		"AID":  {g.userID},
+		"GID":  {g.counterID},
+		"CPM":  {strconv.Itoa(u.snap.CPM)},
+		"ACPM": {strconv.FormatFloat(u.meanCPM, 'f', 2, 64)},
+		"uSV":  {strconv.FormatFloat(u.snap.DoseRate, 'f', 4, 64)},
+	}
+	resp, err := uploadClient.Get(gmcmapURL + "?" + q.Encode())
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s", resp.Status)
+	}
+	// errors come as a page with status 200
+	if i := strings.Index(string(body), "ERR"); i >= 0 {
+		return fmt.Errorf("%s", strings.TrimSpace(string(body[i:min(i+80, len(body))])))
+	}
+	return nil
+}
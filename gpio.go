@@ -0,0 +1,45 @@
+package main
+
+import "sync"
+
+// gpioNotifier drives an output pin, e.g. for an LED, buzzer or relay, while
+// at least one alert is firing.
+type gpioNotifier struct {
+	set func(on bool) error
+
+	mu     sync.Mutex
+	firing map[string]bool
+}
+
+func newGPIONotifier(pin int, activeLow bool) (*gpioNotifier, error) {
+	set, err := openGPIO(pin, activeLow)
+	if err != nil {
+		return nil, err
+	}
+	// the pin is set by restore
+	return &gpioNotifier{set: set, firing: map[string]bool{}}, nil
+}
+
+func (g *gpioNotifier) notify(ev alertEvent) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	key := ev.Rule + "/" + ev.Severity
+	if ev.State == "firing" {
+		g.firing[key] = true
+	} else {
+		delete(g.firing, key)
+	}
+	return g.set(len(g.firing) > 0)
+}
+
+// restore makes gpioNotifier a stateNotifier: the pin is switched off when
+// an alert resolves during a silence, and is on from the start while
+// restored alerts are active.
+func (g *gpioNotifier) restore(active []alertEvent) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, ev := range active {
+		g.firing[ev.Rule+"/"+ev.Severity] = true
+	}
+	return g.set(len(g.firing) > 0)
+}
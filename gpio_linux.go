@@ -0,0 +1,46 @@
+//go:build linux
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// openGPIO exports pin through the sysfs GPIO interface and configures it as
+// output. The returned function switches it.
+func openGPIO(pin int, activeLow bool) (func(on bool) error, error) {
+	dir := fmt.Sprintf("/sys/class/gpio/gpio%d", pin)
+	if _, err := os.Stat(dir); errors.Is(err, os.ErrNotExist) {
+		if err := os.WriteFile("/sys/class/gpio/export", []byte(fmt.Sprint(pin)), 0); err != nil {
+			return nil, fmt.Errorf("gpio %d: %w", pin, err)
+		}
+	}
+	// udev needs a moment to fix the permissions of a freshly exported pin
+	var err error
+	for i := 0; i < 10; i++ {
+		if err = os.WriteFile(dir+"/direction", []byte("out"), 0); err == nil {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("gpio %d: %w", pin, err)
+	}
+	low := "0"
+	if activeLow {
+		low = "1"
+	}
+	if err := os.WriteFile(dir+"/active_low", []byte(low), 0); err != nil {
+		return nil, fmt.Errorf("gpio %d: %w", pin, err)
+	}
+	return func(on bool) error {
+		v := "0"
+		if on {
+			v = "1"
+		}
+		return os.WriteFile(dir+"/value", []byte(v), 0)
+	}, nil
+}
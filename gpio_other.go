@@ -0,0 +1,9 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+func openGPIO(pin int, activeLow bool) (func(on bool) error, error) {
+	return nil, errors.New("GPIO is only supported on Linux")
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"log/slog"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// gpsMaxAge is how long a position is used after the last fix, gpsd reports
+// about once a second while it has one.
+const gpsMaxAge = 10 * time.Second
+
+// gpsFix is a position reported by gpsd.
+type gpsFix struct {
+	Time      time.Time // of the receipt
+	Latitude  float64
+	Longitude float64
+	Altitude  *float64 // above mean sea level, nil without a 3D fix
+}
+
+// gpsClient follows the position reported by gpsd, for mobile surveys. The
+// connection is reopened with backoff when it fails.
+type gpsClient struct {
+	addr string
+	tags bool // attach the position as tags instead of fields
+
+	mu  sync.Mutex
+	fix gpsFix
+}
+
+func newGPSClient(addr string, tags bool) *gpsClient {
+	return &gpsClient{addr: addr, tags: tags}
+}
+
+// run reads the reports of gpsd, it never returns.
+func (g *gpsClient) run() {
+	for delay := reconnectMinDelay; ; delay = min(2*delay, reconnectMaxDelay) {
+		if err := g.watch(&delay); err != nil {
+			slog.Warn("gpsd", "addr", g.addr, "err", err)
+		}
+		time.Sleep(delay)
+	}
+}
+
+// watch enables the JSON reports and reads them until the connection fails.
+// The backoff is reset once connected.
+func (g *gpsClient) watch(delay *time.Duration) error {
+	conn, err := net.DialTimeout("tcp", g.addr, 5*time.Second)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(`?WATCH={"enable":true,"json":true}` + "\n")); err != nil {
+		return err
+	}
+	slog.Info("gpsd: connected", "addr", g.addr)
+	*delay = reconnectMinDelay
+	sc := bufio.NewScanner(conn)
+	for sc.Scan() {
+		var tpv struct {
+			Class  string   `json:"class"`
+			Mode   int      `json:"mode"` // 2 is a 2D fix, 3 a 3D fix
+			Lat    float64  `json:"lat"`
+			Lon    float64  `json:"lon"`
+			AltMSL *float64 `json:"altMSL"`
+			Alt    *float64 `json:"alt"` // before gpsd 3.20
+		}
+		if err := json.Unmarshal(sc.Bytes(), &tpv); err != nil || tpv.Class != "TPV" || tpv.Mode < 2 {
+			continue
+		}
+		fix := gpsFix{Time: time.Now(), Latitude: tpv.Lat, Longitude: tpv.Lon}
+		if tpv.Mode == 3 {
+			fix.Altitude = tpv.AltMSL
+			if fix.Altitude == nil {
+				fix.Altitude = tpv.Alt
+			}
+		}
+		g.mu.Lock()
+		g.fix = fix
+		g.mu.Unlock()
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+	return net.ErrClosed
+}
+
+// current returns the last position, false when there was no fix within
+// gpsMaxAge.
+func (g *gpsClient) current() (gpsFix, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.fix, !g.fix.Time.IsZero() && time.Since(g.fix.Time) < gpsMaxAge
+}
+
+// addPosition attaches the current position to a point as latitude,
+// longitude and altitude fields or tags. A nil client adds nothing.
+func (g *gpsClient) addPosition(fields map[string]interface{}, tags map[string]string) {
+	if g == nil {
+		return
+	}
+	fix, ok := g.current()
+	if !ok {
+		return
+	}
+	if g.tags {
+		tags["latitude"] = strconv.FormatFloat(fix.Latitude, 'f', 6, 64)
+		tags["longitude"] = strconv.FormatFloat(fix.Longitude, 'f', 6, 64)
+		if fix.Altitude != nil {
+			tags["altitude"] = strconv.FormatFloat(*fix.Altitude, 'f', 1, 64)
+		}
+		return
+	}
+	fields["latitude"] = fix.Latitude
+	fields["longitude"] = fix.Longitude
+	if fix.Altitude != nil {
+		fields["altitude"] = *fix.Altitude
+	}
+}
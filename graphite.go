@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// graphiteTimeout bounds connecting to and writing to Graphite.
+const graphiteTimeout = 5 * time.Second
+
+// metricsSender sends the readings as <prefix>.cpm and <prefix>.dose_rate,
+// those of a further counter as <prefix>.<counter>.cpm and so on, to
+// Graphite, in the plaintext protocol over TCP or, with a udp:// address,
+// UDP, or to StatsD as gauges over UDP.
+type metricsSender struct {
+	network, addr string
+	statsd        bool
+	prefix        string
+}
+
+// newGraphiteSender returns a sender for a Graphite address like
+// localhost:2003 or udp://localhost:2003.
+func newGraphiteSender(addr, prefix string) *metricsSender {
+	network := "tcp"
+	if a, ok := strings.CutPrefix(addr, "udp://"); ok {
+		network, addr = "udp", a
+	}
+	return &metricsSender{network: network, addr: strings.TrimPrefix(addr, "tcp://"), prefix: prefix}
+}
+
+// newStatsDSender returns a sender for a StatsD address like localhost:8125.
+func newStatsDSender(addr, prefix string) *metricsSender {
+	return &metricsSender{network: "udp", addr: addr, statsd: true, prefix: prefix}
+}
+
+func (m *metricsSender) write(r reading) error {
+	prefix := m.prefix
+	if r.Counter != "" {
+		prefix += "." + r.Counter
+	}
+	var b strings.Builder
+	for _, v := range []struct {
+		name  string
+		value float64
+	}{
+		{"cpm", float64(r.CPM)},
+		{"dose_rate", r.DoseRate},
+	} {
+		if m.statsd {
+			fmt.Fprintf(&b, "%s.%s:%g|g\n", prefix, v.name, v.value)
+		} else {
+			fmt.Fprintf(&b, "%s.%s %g %d\n", prefix, v.name, v.value, r.Time.Unix())
+		}
+	}
+	// a connection per reading, which also takes care of restarts of the server
+	conn, err := net.DialTimeout(m.network, m.addr, graphiteTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetWriteDeadline(time.Now().Add(graphiteTimeout))
+	_, err = conn.Write([]byte(b.String()))
+	return err
+}
+
+func (m *metricsSender) Close() error { return nil }
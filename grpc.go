@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mwuertinger/gq-gmc/pkg/gqgmc"
+	pb "github.com/mwuertinger/gq-gmc/pkg/gqgmcpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// grpcServer serves the gRPC API of pkg/gqgmcpb. Like the REST API it reads
+// the live state and leaves the commands to the main loop, sending them as
+// control requests; the control RPCs require token and are disabled if it
+// is empty. Without TLS they are refused to clients that are not on the
+// loopback interface, which would send the token in plaintext.
+type grpcServer struct {
+	pb.UnimplementedCollectorServer
+	state  *liveState
+	idTags map[string]string // model and serial
+	token  string
+	tls    bool
+	ctl    chan<- ctlRequest
+}
+
+// newGRPCServer returns the server, serving TLS with the certificate and
+// key files if given.
+func newGRPCServer(state *liveState, idTags map[string]string, token, certFile, keyFile string, ctl chan<- ctlRequest) (*grpc.Server, error) {
+	var opts []grpc.ServerOption
+	if certFile != "" {
+		creds, err := credentials.NewServerTLSFromFile(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, grpc.Creds(creds))
+	}
+	srv := grpc.NewServer(opts...)
+	pb.RegisterCollectorServer(srv, &grpcServer{state: state, idTags: idTags, token: token, tls: certFile != "", ctl: ctl})
+	return srv, nil
+}
+
+func (g *grpcServer) WatchReadings(_ *pb.WatchReadingsRequest, stream pb.Collector_WatchReadingsServer) error {
+	ch, cancel := g.state.subscribe()
+	defer cancel()
+	snap := g.state.get()
+	var last time.Time
+	for {
+		if !snap.Time.IsZero() && !snap.Time.Equal(last) {
+			last = snap.Time
+			if err := stream.Send(newPBReading(snap)); err != nil {
+				return err
+			}
+		}
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case s, ok := <-ch:
+			if !ok {
+				return nil // the collector stops
+			}
+			snap = s
+		}
+	}
+}
+
+func newPBReading(snap snapshot) *pb.Reading {
+	r := &pb.Reading{
+		Time:                timestamppb.New(snap.Time),
+		Cpm:                 int32(snap.CPM),
+		DoseRate:            snap.DoseRate,
+		CumulativeDose:      snap.CumulativeDose,
+		CumulativeDoseSince: timestamppb.New(snap.DoseSince),
+		Device:              snap.Version,
+		CpmRise:             snap.CPMRise,
+		CpmSigma:            snap.CPMSigma,
+	}
+	if snap.Power != nil {
+		r.BatteryVoltage = &snap.Power.Voltage
+		r.PowerSource = snap.Power.Source()
+	}
+	return r
+}
+
+func (g *grpcServer) GetDeviceInfo(context.Context, *pb.GetDeviceInfoRequest) (*pb.DeviceInfo, error) {
+	snap := g.state.get()
+	info := &pb.DeviceInfo{Version: snap.Version, Model: g.idTags["model"], Serial: g.idTags["serial"]}
+	if s := snap.Settings; s != nil {
+		info.Settings = &pb.DeviceSettings{
+			ReadAt:        timestamppb.New(s.ReadAt),
+			Speaker:       s.Speaker,
+			Vibration:     s.Vibration,
+			Alarm:         s.Alarm,
+			AlarmOn:       s.AlarmOn,
+			AlarmCpm:      int32(s.AlarmCPM),
+			AlarmDoseRate: s.AlarmDoseRate,
+			HistoryMode:   s.HistoryMode,
+			DisplayUnit:   s.DisplayUnit,
+			PowerSaving:   int32(s.PowerSaving),
+			Backlight:     int32(s.Backlight),
+		}
+	}
+	return info, nil
+}
+
+func (g *grpcServer) PressKey(ctx context.Context, req *pb.PressKeyRequest) (*pb.PressKeyResponse, error) {
+	if req.Key < 0 || req.Key >= gqgmc.Keys {
+		return nil, status.Errorf(codes.InvalidArgument, "key must be 0 to %d", gqgmc.Keys-1)
+	}
+	if err := g.command(ctx, "key", strconv.Itoa(int(req.Key))); err != nil {
+		return nil, err
+	}
+	return &pb.PressKeyResponse{}, nil
+}
+
+func (g *grpcServer) SyncClock(ctx context.Context, _ *pb.SyncClockRequest) (*pb.SyncClockResponse, error) {
+	if err := g.command(ctx, "sync-clock"); err != nil {
+		return nil, err
+	}
+	return &pb.SyncClockResponse{}, nil
+}
+
+func (g *grpcServer) SetCollection(ctx context.Context, req *pb.SetCollectionRequest) (*pb.SetCollectionResponse, error) {
+	cmd := "pause"
+	if req.Enabled {
+		cmd = "resume"
+	}
+	if err := g.command(ctx, cmd); err != nil {
+		return nil, err
+	}
+	return &pb.SetCollectionResponse{}, nil
+}
+
+func (g *grpcServer) SetHeartbeat(ctx context.Context, req *pb.SetHeartbeatRequest) (*pb.SetHeartbeatResponse, error) {
+	cmd := "off"
+	if req.Enabled {
+		cmd = "on"
+	}
+	if err := g.command(ctx, "heartbeat", cmd); err != nil {
+		return nil, err
+	}
+	return &pb.SetHeartbeatResponse{}, nil
+}
+
+// command checks the token of the call and has the main loop execute a
+// control command, returning its error reply as UNAVAILABLE.
+func (g *grpcServer) command(ctx context.Context, args ...string) error {
+	if g.token == "" {
+		return status.Error(codes.PermissionDenied, "control RPCs are disabled without -apiToken")
+	}
+	if p, ok := peer.FromContext(ctx); !g.tls && (!ok || !isLoopback(p.Addr)) {
+		return status.Error(codes.PermissionDenied, "control RPCs need -grpcTLSCert for clients other than localhost")
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	var got string
+	if auth := md.Get("authorization"); len(auth) == 1 {
+		got, _ = strings.CutPrefix(auth[0], "Bearer ")
+	}
+	if subtle.ConstantTimeCompare([]byte(got), []byte(g.token)) != 1 {
+		return status.Error(codes.Unauthenticated, "invalid token")
+	}
+	req := ctlRequest{args: args, origin: fromAPI, reply: make(chan string, 1)}
+	select {
+	case g.ctl <- req:
+	case <-ctx.Done():
+		return status.FromContextError(ctx.Err()).Err()
+	}
+	if msg, ok := strings.CutPrefix(<-req.reply, "error: "); ok {
+		return status.Error(codes.Unavailable, strings.TrimSpace(msg))
+	}
+	return nil
+}
+
+// isLoopback tells whether addr is a TCP address on the loopback interface.
+func isLoopback(addr net.Addr) bool {
+	a, ok := addr.(*net.TCPAddr)
+	return ok && a.IP.IsLoopback()
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+
+	pb "github.com/mwuertinger/gq-gmc/pkg/gqgmcpb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+func TestGRPCSetCollection(t *testing.T) {
+	local := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 40000}
+	remote := &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 40000}
+	tests := []struct {
+		name    string
+		token   string
+		tls     bool
+		addr    net.Addr
+		auth    string
+		enabled bool
+		want    codes.Code
+		wantCmd string
+	}{
+		{"pause", "secret", false, local, "Bearer secret", false, codes.OK, "pause"},
+		{"resume", "secret", false, local, "Bearer secret", true, codes.OK, "resume"},
+		{"IPv6 loopback", "secret", false, &net.TCPAddr{IP: net.IPv6loopback}, "Bearer secret", false, codes.OK, "pause"},
+		{"remote over TLS", "secret", true, remote, "Bearer secret", false, codes.OK, "pause"},
+		{"remote in plaintext", "secret", false, remote, "Bearer secret", false, codes.PermissionDenied, ""},
+		{"no peer", "secret", false, nil, "Bearer secret", false, codes.PermissionDenied, ""},
+		{"wrong token", "secret", false, local, "Bearer guess", false, codes.Unauthenticated, ""},
+		{"no token given", "secret", false, local, "", false, codes.Unauthenticated, ""},
+		{"no token set", "", true, local, "Bearer ", false, codes.PermissionDenied, ""},
+		{"counter fails", "secret", false, local, "Bearer secret", false, codes.Unavailable, "pause"},
+	}
+	for _, tt := range tests {
+		ctl := make(chan ctlRequest, 1)
+		g := &grpcServer{token: tt.token, tls: tt.tls, ctl: ctl}
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", tt.auth))
+		if tt.addr != nil {
+			ctx = peer.NewContext(ctx, &peer.Peer{Addr: tt.addr})
+		}
+		reply := "paused\n"
+		if tt.want == codes.Unavailable {
+			reply = "error: no counter\n"
+		}
+		var cmd string
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			if req, ok := <-ctl; ok {
+				cmd = strings.Join(req.args, " ")
+				req.reply <- reply
+			}
+		}()
+		_, err := g.SetCollection(ctx, &pb.SetCollectionRequest{Enabled: tt.enabled})
+		close(ctl)
+		<-done
+		if got := status.Code(err); got != tt.want || cmd != tt.wantCmd {
+			t.Errorf("%s: SetCollection() = %v, sent %q, want %v, %q", tt.name, err, cmd, tt.want, tt.wantCmd)
+		}
+	}
+}
+
+func TestGRPCSetHeartbeat(t *testing.T) {
+	for _, tt := range []struct {
+		enabled bool
+		wantCmd string
+	}{
+		{false, "heartbeat off"},
+		{true, "heartbeat on"},
+	} {
+		ctl := make(chan ctlRequest, 1)
+		g := &grpcServer{token: "secret", ctl: ctl}
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer secret"))
+		ctx = peer.NewContext(ctx, &peer.Peer{Addr: &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)}})
+		var cmd string
+		go func() {
+			req := <-ctl
+			cmd = strings.Join(req.args, " ")
+			req.reply <- "heartbeat switched\n"
+		}()
+		if _, err := g.SetHeartbeat(ctx, &pb.SetHeartbeatRequest{Enabled: tt.enabled}); err != nil || cmd != tt.wantCmd {
+			t.Errorf("SetHeartbeat(%t) = %v, sent %q, want %q", tt.enabled, err, cmd, tt.wantCmd)
+		}
+	}
+}
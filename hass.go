@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// hassSensor is a reading field announced to Home Assistant.
+type hassSensor struct {
+	field       string
+	name        string
+	unit        string
+	deviceClass string
+	stateClass  string
+}
+
+var hassSensors = []hassSensor{
+	{field: "cpm", name: "Count rate", unit: "CPM", stateClass: "measurement"},
+	{field: "dose_rate", name: "Dose rate", unit: "µSv/h", stateClass: "measurement"},
+	{field: "cumulative_dose", name: "Cumulative dose", unit: "µSv", stateClass: "total"},
+	{field: "battery_voltage", name: "Battery voltage", unit: "V", deviceClass: "voltage", stateClass: "measurement"},
+}
+
+var hassInvalidID = regexp.MustCompile(`[^a-zA-Z0-9_-]+`)
+
+// hassDiscoveryMessages returns the retained config messages announcing the
+// readings published on topic as sensors to Home Assistant MQTT discovery.
+// Both payload schemas publish a JSON document on topic holding all fields.
+func hassDiscoveryMessages(prefix, topic, model, version string) ([]message, error) {
+	host, _ := os.Hostname()
+	id := hassInvalidID.ReplaceAllString(strings.Trim(host+"_"+topic, "_"), "_")
+	device := map[string]interface{}{
+		"identifiers":  []string{id},
+		"name":         "Geiger counter " + topic,
+		"manufacturer": "GQ Electronics",
+		"model":        model,
+		"sw_version":   version,
+	}
+	var msgs []message
+	for _, s := range hassSensors {
+		cfg := map[string]interface{}{
+			"name":                s.name,
+			"unique_id":           id + "_" + s.field,
+			"state_topic":         topic,
+			"value_template":      "{{ value_json." + s.field + " }}",
+			"unit_of_measurement": s.unit,
+			"state_class":         s.stateClass,
+			"availability_topic":  topic + "/status",
+			"device":              device,
+		}
+		if s.deviceClass != "" {
+			cfg["device_class"] = s.deviceClass
+		}
+		b, err := json.Marshal(cfg)
+		if err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, message{prefix + "/sensor/" + id + "/" + s.field + "/config", b})
+	}
+	return msgs, nil
+}
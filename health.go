@@ -0,0 +1,131 @@
+package main
+
+import (
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// Limits of /healthz and /readyz. A collector is alive while samples arrive
+// and ready while they arrive on time and the readings get written.
+const (
+	healthMaxSampleAge = 5 * time.Minute
+	readyMaxSampleAge  = time.Minute
+	readyMinWriteAge   = 5 * time.Minute // writes may be up to 3 intervals old, at least this
+)
+
+// collectorHealth tracks what /healthz and /readyz report. It is updated by
+// the main loop and the InfluxDB clients without going through liveState,
+// whose subscribers would otherwise be woken by every heartbeat sample.
+type collectorHealth struct {
+	started    time.Time
+	connected  func() bool            // whether the serial port is open
+	lastSample atomic.Int64           // Unix nanoseconds
+	lastWrite  atomic.Int64           // of the last successful InfluxDB write
+	writeErr   atomic.Pointer[string] // of the last write, nil if it succeeded
+	failing    atomic.Int64           // Unix nanoseconds of the first failed write in a row, 0 while writing
+	interval   atomic.Int64           // reporting interval
+}
+
+// newCollectorHealth returns the health of a collector reporting every
+// interval. connected must be set before the report is served.
+func newCollectorHealth(interval time.Duration) *collectorHealth {
+	h := &collectorHealth{started: time.Now()}
+	h.interval.Store(int64(interval))
+	return h
+}
+
+func (h *collectorHealth) sample() { h.lastSample.Store(time.Now().UnixNano()) }
+
+// flushed records the result of a write reaching the InfluxDB server, as
+// opposed to one queued by the clients above it, see sinkConfig.open.
+func (h *collectorHealth) flushed(err error) {
+	if err != nil {
+		msg := err.Error()
+		h.writeErr.Store(&msg)
+		h.failing.CompareAndSwap(0, time.Now().UnixNano())
+		return
+	}
+	h.writeErr.Store(nil)
+	h.failing.Store(0)
+	h.lastWrite.Store(time.Now().UnixNano())
+}
+
+// failingSince returns when the writes reaching the InfluxDB server started
+// failing, or the zero time while they succeed.
+func (h *collectorHealth) failingSince() time.Time {
+	if ns := h.failing.Load(); ns != 0 {
+		return time.Unix(0, ns)
+	}
+	return time.Time{}
+}
+
+// healthReport is the JSON body of /healthz and /readyz.
+type healthReport struct {
+	Status     string     `json:"status"` // ok or failing
+	SerialPort string     `json:"serial_port"`
+	LastSample *time.Time `json:"last_sample,omitempty"`
+	LastWrite  *time.Time `json:"last_write,omitempty"`
+	WriteError string     `json:"write_error,omitempty"` // of the last write, if it failed
+	Problems   []string   `json:"problems,omitempty"`
+	sampleAge  time.Duration
+	writeAge   time.Duration
+}
+
+func (h *collectorHealth) report() healthReport {
+	r := healthReport{SerialPort: "lost"}
+	if h.connected() {
+		r.SerialPort = "open"
+	}
+	// before the first sample or write the age counts from the start
+	r.sampleAge, r.writeAge = time.Since(h.started), time.Since(h.started)
+	if ns := h.lastSample.Load(); ns != 0 {
+		t := time.Unix(0, ns)
+		r.LastSample, r.sampleAge = &t, time.Since(t)
+	}
+	if ns := h.lastWrite.Load(); ns != 0 {
+		t := time.Unix(0, ns)
+		r.LastWrite, r.writeAge = &t, time.Since(t)
+	}
+	if msg := h.writeErr.Load(); msg != nil {
+		r.WriteError = *msg
+	}
+	return r
+}
+
+// serve writes the report with status 503 if there are problems.
+func (r healthReport) serve(w http.ResponseWriter) {
+	r.Status = "ok"
+	if len(r.Problems) > 0 {
+		r.Status = "failing"
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	writeJSON(w, r)
+}
+
+// healthz fails once no sample has arrived for healthMaxSampleAge, which
+// the collector does not recover from by itself.
+func (h *collectorHealth) healthz(w http.ResponseWriter, r *http.Request) {
+	rep := h.report()
+	if rep.sampleAge > healthMaxSampleAge {
+		rep.Problems = append(rep.Problems, "no sample for "+rep.sampleAge.Round(time.Second).String())
+	}
+	rep.serve(w)
+}
+
+// readyz fails while the serial port is lost, samples are late or the
+// readings have not been written for three intervals.
+func (h *collectorHealth) readyz(w http.ResponseWriter, r *http.Request) {
+	rep := h.report()
+	if rep.SerialPort != "open" {
+		rep.Problems = append(rep.Problems, "serial port lost")
+	}
+	if rep.sampleAge > readyMaxSampleAge {
+		rep.Problems = append(rep.Problems, "no sample for "+rep.sampleAge.Round(time.Second).String())
+	}
+	if maxAge := max(3*time.Duration(h.interval.Load()), readyMinWriteAge); rep.writeAge > maxAge {
+		rep.Problems = append(rep.Problems, "no InfluxDB write for "+rep.writeAge.Round(time.Second).String())
+	}
+	rep.serve(w)
+}
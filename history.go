@@ -0,0 +1,171 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"time"
+
+	influxdb "github.com/influxdata/influxdb1-client/v2"
+	"github.com/mwuertinger/gq-gmc/pkg/gqgmc"
+)
+
+// historyRecords converts the decoded history flash into records for the
+// import, normalizing the values to CPM.
+func historyRecords(history []gqgmc.HistoryRecord) []importRecord {
+	records := make([]importRecord, 0, len(history))
+	for _, h := range history {
+		records = append(records, importRecord{time: h.Time, period: h.Period, status: h.Status, cpm: h.CPM()})
+	}
+	return records
+}
+
+// backfillHistory downloads the history flash of dev and writes the records
+// not covered by the live data. With syncClock the device clock is
+// synchronized afterwards, so the timestamps of the next download are
+// accurate as well. It returns the number of records written and found.
+func backfillHistory(dev *gqgmc.Device, client influxdb.Client, database, measurement string, tags map[string]string, profile gqgmc.Profile, syncClock bool) (int, int, error) {
+	start := time.Now()
+	raw, err := dev.DownloadHistory(nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	slog.Debug("history downloaded", "bytes", len(raw), "duration", time.Since(start))
+	records := historyRecords(gqgmc.ParseHistory(raw, dev.ClockLocation()))
+	kept, err := dedupRecords(client, database, measurement, tags, records)
+	if err != nil {
+		return 0, len(records), err
+	}
+	n, err := writeImport(client, database, measurement, tags, profile, kept)
+	if err != nil {
+		return n, len(records), err
+	}
+	if syncClock {
+		syncDeviceClock(dev)
+	}
+	return n, len(records), nil
+}
+
+// historyCommand implements "gq-gmc history", which backfills InfluxDB
+// with the history the counter logged while the collector was not running.
+func historyCommand(args []string) {
+	fs := flag.NewFlagSet("history", flag.ExitOnError)
+	port := portFlags(fs)
+	influx := influxFlags(fs)
+	tagValues := tagFlag(fs)
+	configPath := fs.String("config", "", "YAML config file providing the tags and device_timezone")
+	deviceTimezone := fs.String("deviceTimezone", "", "Time zone of the device clock, default device_timezone or Local; auto infers it from the difference of the device clock to the host clock")
+	dedup := fs.Bool("dedup", true, "Skip records overlapping data already stored for the same tags")
+	syncClock := fs.Bool("syncClock", true, "Synchronize the device clock after the download")
+	dump := fs.String("dump", "", "Also save the raw history flash to this file")
+	file := fs.String("file", "", "Decode a raw history flash saved with -dump instead of reading the device")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s history [flags]\n\nDownloads the history flash of the counter and writes the records not yet stored to InfluxDB.\n\nFlags:\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	if fs.NArg() != 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	tags := readingTags(&fileConfig{}, tagValues)
+	zone := "Local"
+	if *configPath != "" {
+		cfg, err := loadConfig(*configPath)
+		if err != nil {
+			log.Fatalf("config: %v", err)
+		}
+		if err := cfg.resolveSecrets(); err != nil {
+			log.Fatalf("config: %v", err)
+		}
+		set := map[string]bool{}
+		fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+		influx.override(cfg, set)
+		if cfg.Serial.Device != "" && !set["dev"] {
+			port.device = cfg.Serial.Device
+		}
+		if cfg.Serial.Baud != 0 && !set["baud"] {
+			port.baud = cfg.Serial.Baud
+		}
+		tags = readingTags(cfg, tagValues)
+		if cfg.DeviceTimezone != "" {
+			zone = cfg.DeviceTimezone
+		}
+	}
+	if *deviceTimezone != "" {
+		zone = *deviceTimezone
+	}
+	loc := time.Local
+	if zone != autoTimezone {
+		var err error
+		if loc, err = time.LoadLocation(zone); err != nil {
+			log.Fatalf("device timezone: %v", err)
+		}
+	} else if *file != "" {
+		log.Fatal("device timezone: auto needs the counter, not -file")
+	}
+	client, err := influx.client()
+	if err != nil {
+		log.Fatalf("influx: %v", err)
+	}
+
+	var raw []byte
+	var profile gqgmc.Profile
+	if *file != "" {
+		if raw, err = os.ReadFile(*file); err != nil {
+			log.Fatalf("history: %v", err)
+		}
+		profile = gqgmc.LookupProfile("")
+	} else {
+		dev, closer := port.openDevice()
+		defer closer.Close()
+		if zone == autoTimezone {
+			if loc, err = inferClockLocation(dev); err != nil {
+				log.Fatalf("device timezone: %v", err)
+			}
+			slog.Info("device timezone inferred from the clock", "zone", loc.String())
+		}
+		dev.SetClockLocation(loc)
+		v, err := dev.Version()
+		if err != nil {
+			log.Fatalf("history: %v", err)
+		}
+		profile = gqgmc.LookupProfile(v)
+		profile.Tube = deviceCalibration(dev, profile.Tube)
+		tags = mergeTags(tags, deviceIDTags(dev))
+		last := -1
+		raw, err = dev.DownloadHistory(func(done, total int) {
+			if pct := done * 100 / total; pct/10 != last/10 {
+				slog.Info("downloading history", "percent", pct)
+				last = pct
+			}
+		})
+		if err != nil {
+			log.Fatalf("history: %v", err)
+		}
+		if *syncClock {
+			syncDeviceClock(dev)
+		}
+	}
+	if *dump != "" {
+		if err := os.WriteFile(*dump, raw, 0644); err != nil {
+			log.Fatalf("history: %v", err)
+		}
+	}
+
+	records := historyRecords(gqgmc.ParseHistory(raw, loc))
+	total := len(records)
+	if *dedup {
+		if records, err = dedupRecords(client, influx.database, influx.measurement, tags, records); err != nil {
+			log.Fatalf("history: %v", err)
+		}
+	}
+	n, err := writeImport(client, influx.database, influx.measurement, tags, profile, records)
+	if err != nil {
+		log.Fatalf("history: %v", err)
+	}
+	slog.Info("history backfilled", "records", n, "found", total, "tube", profile.Tube.Name)
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// apiHistoryRetention is how long /api/v1/history keeps readings, and
+// apiHistoryMax bounds their number for short intervals.
+const (
+	apiHistoryRetention = 24 * time.Hour
+	apiHistoryMax       = 20000
+)
+
+// currentReading is the JSON representation of the latest reading.
+type currentReading struct {
+	Time           time.Time `json:"time"`
+	CPM            int       `json:"cpm"`
+	DoseRate       float64   `json:"dose_rate"`
+	CumulativeDose float64   `json:"cumulative_dose"`
+	Device         string    `json:"device"`
+	BatteryVoltage *float64  `json:"battery_voltage,omitempty"`
+	PowerSource    string    `json:"power_source,omitempty"`
+}
+
+func newCurrentReading(snap snapshot) currentReading {
+	cur := currentReading{
+		Time:           snap.Time,
+		CPM:            snap.CPM,
+		DoseRate:       snap.DoseRate,
+		CumulativeDose: snap.CumulativeDose,
+		Device:         snap.Version,
+	}
+	if snap.Power != nil {
+		cur.BatteryVoltage = &snap.Power.Voltage
+		cur.PowerSource = snap.Power.Source()
+	}
+	return cur
+}
+
+// deviceInfo is the JSON representation of the device and its settings,
+// null if the configuration could not be read.
+type deviceInfo struct {
+	Device   string          `json:"device"`
+	Settings *deviceSettings `json:"settings"`
+}
+
+// readingHistory keeps the readings of the last apiHistoryRetention in
+// memory, oldest first.
+type readingHistory struct {
+	mu       sync.Mutex
+	readings []currentReading
+}
+
+// record appends the snapshot of every new reading.
+func (h *readingHistory) record(state *liveState) {
+	ch, cancel := state.subscribe()
+	defer cancel()
+	var last time.Time
+	for snap := range ch {
+		if snap.Time.Equal(last) {
+			continue // power or version update without a new reading
+		}
+		last = snap.Time
+		h.mu.Lock()
+		h.readings = append(h.readings, newCurrentReading(snap))
+		drop := max(len(h.readings)-apiHistoryMax, 0)
+		for drop < len(h.readings) && snap.Time.Sub(h.readings[drop].Time) > apiHistoryRetention {
+			drop++
+		}
+		h.readings = h.readings[drop:]
+		h.mu.Unlock()
+	}
+}
+
+// since returns the readings after t.
+func (h *readingHistory) since(t time.Time) []currentReading {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	i := sort.Search(len(h.readings), func(i int) bool { return h.readings[i].Time.After(t) })
+	return append([]currentReading{}, h.readings[i:]...)
+}
+
+// settingsRequest asks the main loop to apply a JSON patch to the runtime
+// settings. A nil patch just queries them.
+type settingsRequest struct {
+	patch []byte
+	reply chan settingsReply
+}
+
+type settingsReply struct {
+	settings runtimeSettings
+	err      error
+}
+
+// newAPIHandler serves the REST API, the heartbeat stream on /ws, the
+// dashboard on / and the health checks on /healthz and /readyz. Changing
+// settings, silencing alerts and pressing keys require token to be sent as
+// bearer token and are disabled if token is empty.
+func newAPIHandler(state *liveState, feed *countFeed, health *collectorHealth, token string, settings chan<- settingsRequest, ctl chan<- ctlRequest) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", serveDashboard)
+	mux.Handle("/ws", newWSHandler(feed))
+	mux.HandleFunc("/healthz", health.healthz)
+	mux.HandleFunc("/readyz", health.readyz)
+	mux.HandleFunc("/api/v1/current", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		writeJSON(w, newCurrentReading(state.get()))
+	})
+	history := &readingHistory{}
+	go history.record(state)
+	// ?minutes=N limits the readings to the last N minutes, 60 by default
+	mux.HandleFunc("/api/v1/history", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		minutes := 60
+		if m := r.URL.Query().Get("minutes"); m != "" {
+			var err error
+			if minutes, err = strconv.Atoi(m); err != nil || minutes < 1 {
+				http.Error(w, "minutes must be a positive number", http.StatusBadRequest)
+				return
+			}
+		}
+		writeJSON(w, history.since(time.Now().Add(-time.Duration(minutes)*time.Minute)))
+	})
+	mux.HandleFunc("/api/v1/device", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		snap := state.get()
+		writeJSON(w, deviceInfo{Device: snap.Version, Settings: snap.Settings})
+	})
+	mux.HandleFunc("/api/v1/version", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		writeJSON(w, currentBuild())
+	})
+	mux.HandleFunc("/api/v1/settings", func(w http.ResponseWriter, r *http.Request) {
+		req := settingsRequest{reply: make(chan settingsReply, 1)}
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPatch:
+			if !authorized(r, token) {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			body, err := io.ReadAll(io.LimitReader(r.Body, 1<<16))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			req.patch = body
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		settings <- req
+		reply := <-req.reply
+		if reply.err != nil {
+			http.Error(w, reply.err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, reply.settings)
+	})
+	// POST {"duration": "1h", "rule": "dose_rate"} silences a rule, or all
+	// rules without "rule". DELETE lifts the silence, ?rule= selects a rule.
+	mux.HandleFunc("/api/v1/silence", func(w http.ResponseWriter, r *http.Request) {
+		if !authorized(r, token) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		var args []string
+		switch r.Method {
+		case http.MethodPost:
+			var body struct {
+				Duration duration `json:"duration"`
+				Rule     string   `json:"rule"`
+			}
+			if err := json.NewDecoder(io.LimitReader(r.Body, 1<<16)).Decode(&body); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			args = []string{"silence", body.Duration.String()}
+			if body.Rule != "" {
+				args = append(args, body.Rule)
+			}
+		case http.MethodDelete:
+			args = []string{"unsilence"}
+			if rule := r.URL.Query().Get("rule"); rule != "" {
+				args = append(args, rule)
+			}
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		req := ctlRequest{args: args, origin: fromAPI, reply: make(chan string, 1)}
+		ctl <- req
+		reply := <-req.reply
+		if msg, ok := strings.CutPrefix(reply, "error: "); ok {
+			http.Error(w, strings.TrimSpace(msg), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		io.WriteString(w, reply)
+	})
+	// POST {"key": 3} presses key S4 of the counter
+	mux.HandleFunc("/api/v1/key", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !authorized(r, token) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		var body struct {
+			Key *int `json:"key"`
+		}
+		if err := json.NewDecoder(io.LimitReader(r.Body, 1<<16)).Decode(&body); err != nil || body.Key == nil {
+			http.Error(w, `expected {"key": n}`, http.StatusBadRequest)
+			return
+		}
+		req := ctlRequest{args: []string{"key", strconv.Itoa(*body.Key)}, origin: fromAPI, reply: make(chan string, 1)}
+		ctl <- req
+		reply := <-req.reply
+		if msg, ok := strings.CutPrefix(reply, "error: "); ok {
+			http.Error(w, strings.TrimSpace(msg), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		io.WriteString(w, reply)
+	})
+	return mux
+}
+
+func authorized(r *http.Request, token string) bool {
+	got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return token != "" && ok && subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("http", "err", err)
+	}
+}
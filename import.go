@@ -0,0 +1,313 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"math"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	influxdb "github.com/influxdata/influxdb1-client/v2"
+	"github.com/mwuertinger/gq-gmc/pkg/gqgmc"
+)
+
+// importRecord is a reading parsed from a CSV export.
+type importRecord struct {
+	time   time.Time
+	period time.Duration // time covered by the value
+	status gqgmc.ClockStatus
+	cpm    float64
+}
+
+// csvTimeLayouts are the timestamp formats GQ Data Viewer writes, depending
+// on version and locale.
+var csvTimeLayouts = []string{
+	"2006/01/02 15:04:05",
+	"2006-01-02 15:04:05",
+	"2006/1/2 15:04:05",
+	"01/02/2006 15:04:05",
+	"2006/01/02 15:04",
+}
+
+var modelPattern = regexp.MustCompile(`GMC-[0-9]+[A-Za-z+]*( ?Re)? ?[0-9.]*`)
+
+// parseDataViewerCSV parses a history export of GQ Data Viewer. The export
+// starts with a free form preamble naming the device, followed by a header
+// row and one row per logged value. Values are counts per second, minute or
+// hour depending on the data type column and are normalized to CPM.
+// Timestamps are device clock readings in loc. model is the device found in
+// the preamble, if any.
+func parseDataViewerCSV(r io.Reader, loc *time.Location) (records []importRecord, model string, err error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.LazyQuotes = true
+	timeCol, valueCol, typeCol := -1, -1, -1
+	line := 0
+	for {
+		row, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		line++
+		if timeCol < 0 {
+			for i, cell := range row {
+				if m := modelPattern.FindString(cell); m != "" && model == "" {
+					model = strings.TrimSpace(m)
+				}
+				cell = strings.ToLower(strings.TrimSpace(cell))
+				switch {
+				case strings.Contains(cell, "time"):
+					timeCol = i
+				case cell == "cpm" || cell == "data" || cell == "value" || cell == "count":
+					valueCol = i
+				case cell == "type" || strings.Contains(cell, "data type"):
+					typeCol = i
+				}
+			}
+			if timeCol < 0 || valueCol < 0 {
+				timeCol, valueCol, typeCol = -1, -1, -1 // still in the preamble
+			}
+			continue
+		}
+		if len(row) <= timeCol || len(row) <= valueCol {
+			continue
+		}
+		ts := strings.TrimSpace(row[timeCol])
+		var wall time.Time
+		for _, layout := range csvTimeLayouts {
+			if wall, err = time.Parse(layout, ts); err == nil {
+				break
+			}
+		}
+		if err != nil {
+			return nil, "", fmt.Errorf("line %d: invalid time %q", line, ts)
+		}
+		v, err := strconv.ParseFloat(strings.TrimSpace(row[valueCol]), 64)
+		if err != nil {
+			continue // markers like "Power On" carry no value
+		}
+		period := time.Minute
+		if typeCol >= 0 && typeCol < len(row) {
+			switch t := strings.ToLower(row[typeCol]); {
+			case strings.Contains(t, "second"):
+				v *= 60
+				period = time.Second
+			case strings.Contains(t, "hour"):
+				v /= 60
+				period = time.Hour
+			}
+		}
+		var prev time.Time
+		if len(records) > 0 {
+			prev = records[len(records)-1].time
+		}
+		t, status := gqgmc.ClockTimeAfter(wall.Year(), wall.Month(), wall.Day(), wall.Hour(), wall.Minute(), wall.Second(), loc, prev)
+		records = append(records, importRecord{time: t, period: period, status: status, cpm: v})
+	}
+	if timeCol < 0 {
+		return nil, "", errors.New("no header row with time and value columns found")
+	}
+	return records, model, nil
+}
+
+// importCommand implements "gq-gmc import", which writes CSV exports of GQ
+// Data Viewer to InfluxDB.
+func importCommand(args []string) {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	influx := influxFlags(fs)
+	tagValues := tagFlag(fs)
+	configPath := fs.String("config", "", "YAML config file providing the tags and device_timezone")
+	deviceTimezone := fs.String("deviceTimezone", "", "Time zone of the timestamps in the export, default device_timezone or Local")
+	model := fs.String("model", "", "Device model selecting the tube for the dose rate, default taken from the export")
+	dedup := fs.Bool("dedup", true, "Skip records overlapping data already stored for the same tags")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s import [flags] file.csv...\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	tags := readingTags(&fileConfig{}, tagValues)
+	zone := "Local"
+	if *configPath != "" {
+		cfg, err := loadConfig(*configPath)
+		if err != nil {
+			log.Fatalf("config: %v", err)
+		}
+		tags = readingTags(cfg, tagValues)
+		if cfg.DeviceTimezone != "" {
+			zone = cfg.DeviceTimezone
+		}
+	}
+	if *deviceTimezone != "" {
+		zone = *deviceTimezone
+	}
+	if zone == autoTimezone {
+		log.Fatal("device timezone: auto needs the counter, give the zone of the export")
+	}
+	loc, err := time.LoadLocation(zone)
+	if err != nil {
+		log.Fatalf("device timezone: %v", err)
+	}
+	client, err := influx.client()
+	if err != nil {
+		log.Fatalf("influx: %v", err)
+	}
+
+	for _, path := range fs.Args() {
+		f, err := os.Open(path)
+		if err != nil {
+			log.Fatalf("import: %v", err)
+		}
+		records, found, err := parseDataViewerCSV(f, loc)
+		f.Close()
+		if err != nil {
+			log.Fatalf("import %s: %v", path, err)
+		}
+		if *model != "" {
+			found = *model
+		}
+		profile := gqgmc.LookupProfile(found)
+		total := len(records)
+		if *dedup {
+			if records, err = dedupRecords(client, influx.database, influx.measurement, tags, records); err != nil {
+				log.Fatalf("import %s: %v", path, err)
+			}
+		}
+		n, err := writeImport(client, influx.database, influx.measurement, tags, profile, records)
+		if err != nil {
+			log.Fatalf("import %s: %v", path, err)
+		}
+		slog.Info("imported", "records", n, "found", total, "file", path, "device", found, "tube", profile.Tube.Name)
+	}
+}
+
+// importBatchSize limits the points per InfluxDB write.
+const importBatchSize = 5000
+
+// writeImport writes the records to the measurement of the live data. It
+// returns the number of records written. The live data stores the rate as
+// an integer and InfluxDB rejects a float in a field holding integers, so
+// geiger_counter_cpm is rounded and the rate of per-second and per-hour
+// records, which is fractional, is kept exactly in geiger_counter_cpm_float.
+func writeImport(client influxdb.Client, database, measurement string, tags map[string]string, profile gqgmc.Profile, records []importRecord) (int, error) {
+	written := 0
+	for start := 0; start < len(records); start += importBatchSize {
+		end := start + importBatchSize
+		if end > len(records) {
+			end = len(records)
+		}
+		bp, err := influxdb.NewBatchPoints(influxdb.BatchPointsConfig{Database: database, Precision: "s"})
+		if err != nil {
+			return written, err
+		}
+		for _, r := range records[start:end] {
+			fields := map[string]interface{}{
+				"geiger_counter_cpm":       int(math.Round(r.cpm)),
+				"geiger_counter_cpm_float": r.cpm,
+				"geiger_counter_dose_rate": profile.Tube.DoseRate(r.cpm),
+			}
+			if r.status != gqgmc.ClockExact {
+				slog.Warn("import: time in a daylight saving time transition", "time", r.time.Format(time.RFC3339), "status", r.status)
+				fields["clock_status"] = r.status.String()
+			}
+			pt, err := influxdb.NewPoint(measurement, tags, fields, r.time)
+			if err != nil {
+				return written, err
+			}
+			bp.AddPoint(pt)
+		}
+		if err := client.Write(bp); err != nil {
+			return written, err
+		}
+		written += end - start
+	}
+	return written, nil
+}
+
+// dedupRecords drops the records whose period overlaps a minute for which
+// the measurement already has points with the same tags. Backfilled data
+// therefore fills gaps in the live data instead of double counting the
+// covered time.
+func dedupRecords(client influxdb.Client, database, measurement string, tags map[string]string, records []importRecord) ([]importRecord, error) {
+	if len(records) == 0 {
+		return records, nil
+	}
+	start, end := records[0].time, records[0].time.Add(records[0].period)
+	for _, r := range records {
+		if r.time.Before(start) {
+			start = r.time
+		}
+		if e := r.time.Add(r.period); e.After(end) {
+			end = e
+		}
+	}
+	covered, err := coveredMinutes(client, database, measurement, tags, start, end)
+	if err != nil {
+		return nil, err
+	}
+	var kept []importRecord
+	for _, r := range records {
+		overlaps := false
+		for m := r.time.Truncate(time.Minute); m.Before(r.time.Add(r.period)); m = m.Add(time.Minute) {
+			if covered[m.Unix()] {
+				overlaps = true
+				break
+			}
+		}
+		if !overlaps {
+			kept = append(kept, r)
+		}
+	}
+	if n := len(records) - len(kept); n > 0 {
+		slog.Info("import: skipping records overlapping stored data", "records", n)
+	}
+	return kept, nil
+}
+
+// quoteIdent quotes a measurement or tag name for InfluxQL.
+func quoteIdent(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, `\"`) + `"`
+}
+
+// coveredMinutes returns the start times, as Unix seconds, of the minutes
+// from start to end that contain points with the given tags.
+func coveredMinutes(client influxdb.Client, database, measurement string, tags map[string]string, start, end time.Time) (map[int64]bool, error) {
+	q := fmt.Sprintf(`SELECT count("geiger_counter_cpm") FROM %s WHERE time >= %ds AND time < %ds`, quoteIdent(measurement), start.Unix(), end.Unix())
+	for k, v := range tags {
+		q += fmt.Sprintf(` AND %s = '%s'`, quoteIdent(k), strings.ReplaceAll(v, `'`, `\'`))
+	}
+	q += " GROUP BY time(1m) fill(none)"
+	resp, err := client.Query(influxdb.NewQuery(q, database, "s"))
+	if err != nil {
+		return nil, err
+	}
+	if err := resp.Error(); err != nil {
+		return nil, err
+	}
+	covered := map[int64]bool{}
+	for _, res := range resp.Results {
+		for _, row := range res.Series {
+			for _, v := range row.Values {
+				if len(v) == 2 && influxFloat(v[1]) > 0 {
+					covered[int64(influxFloat(v[0]))] = true
+				}
+			}
+		}
+	}
+	return covered, nil
+}
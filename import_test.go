@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mwuertinger/gq-gmc/pkg/gqgmc"
+)
+
+const testExport = `GQ Data Viewer history export
+Device: GMC-320Re 4.26
+Time zone of the device: local
+Date Time,CPM,Type
+2024/03/31 01:59:00,12,Every Minute
+2024/03/31 02:15:00,13,Every Minute
+2024/03/31 03:16:00,14,Every Minute
+2024/10/27 02:30:00,20,Every Minute
+2024/10/27 02:31:00,Power On,
+2024/10/27 02:59:00,21,Every Minute
+2024/10/27 02:00:00,22,Every Minute
+2024/10/27 02:30:00,23,Every Minute
+2024-10-27 03:00:00,3,Every Second
+10/27/2024 04:00:00,600,Every Hour
+2024/10/27 05:00,24,Every Minute
+`
+
+func TestParseDataViewerCSV(t *testing.T) {
+	loc, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip("no time zone database:", err)
+	}
+	utc := func(month time.Month, day, hour, min int) time.Time {
+		return time.Date(2024, month, day, hour, min, 0, 0, time.UTC)
+	}
+	records, model, err := parseDataViewerCSV(strings.NewReader(testExport), loc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if model != "GMC-320Re 4.26" {
+		t.Errorf("model = %q", model)
+	}
+	want := []importRecord{
+		{utc(time.March, 31, 0, 59), time.Minute, gqgmc.ClockExact, 12},
+		// 02:15 does not exist on March 31
+		{utc(time.March, 31, 1, 15), time.Minute, gqgmc.ClockSkipped, 13},
+		{utc(time.March, 31, 1, 16), time.Minute, gqgmc.ClockExact, 14},
+		// the first pass through 02:00-03:00 on October 27 is still CEST
+		{utc(time.October, 27, 0, 30), time.Minute, gqgmc.ClockAmbiguous, 20},
+		{utc(time.October, 27, 0, 59), time.Minute, gqgmc.ClockAmbiguous, 21},
+		// the clock went back, so this is the second pass in CET
+		{utc(time.October, 27, 1, 0), time.Minute, gqgmc.ClockRepeated, 22},
+		{utc(time.October, 27, 1, 30), time.Minute, gqgmc.ClockRepeated, 23},
+		{utc(time.October, 27, 2, 0), time.Second, gqgmc.ClockExact, 180},
+		{utc(time.October, 27, 3, 0), time.Hour, gqgmc.ClockExact, 10},
+		{utc(time.October, 27, 4, 0), time.Minute, gqgmc.ClockExact, 24},
+	}
+	if len(records) != len(want) {
+		t.Fatalf("parseDataViewerCSV() = %d records, want %d: %v", len(records), len(want), records)
+	}
+	for i, w := range want {
+		r := records[i]
+		if !r.time.Equal(w.time) || r.period != w.period || r.status != w.status || r.cpm != w.cpm {
+			t.Errorf("record %d = %v %v %v %v, want %v %v %v %v", i, r.time.UTC(), r.period, r.status, r.cpm, w.time, w.period, w.status, w.cpm)
+		}
+	}
+}
+
+func TestParseDataViewerCSVErrors(t *testing.T) {
+	tests := []struct {
+		name, csv string
+	}{
+		{"no header", "GMC-320 4.26\n2024/10/27 02:30:00,20\n"},
+		{"empty", ""},
+		{"invalid time", "Time,CPM\n27.10.2024 02:30,20\n"},
+	}
+	for _, tt := range tests {
+		if _, _, err := parseDataViewerCSV(strings.NewReader(tt.csv), time.UTC); err == nil {
+			t.Errorf("%s: parseDataViewerCSV() succeeded", tt.name)
+		}
+	}
+}
+
+func TestWriteImport(t *testing.T) {
+	f := &fakeInflux{}
+	profile := gqgmc.Profile{Tube: gqgmc.Tube{Factor: 0.01}}
+	records := []importRecord{
+		{time.Unix(60, 0), time.Hour, gqgmc.ClockExact, 10.5},
+		{time.Unix(120, 0), time.Minute, gqgmc.ClockRepeated, 20},
+	}
+	n, err := writeImport(f, "db", "m", map[string]string{"host": "a"}, profile, records)
+	if err != nil || n != 2 {
+		t.Fatalf("writeImport() = %d, %v", n, err)
+	}
+	want := []string{
+		"m,host=a geiger_counter_cpm=11i,geiger_counter_cpm_float=10.5,geiger_counter_dose_rate=0.105 60",
+		`m,host=a clock_status="repeated",geiger_counter_cpm=20i,geiger_counter_cpm_float=20,geiger_counter_dose_rate=0.2 120`,
+	}
+	if len(f.batches) != 1 || strings.Join(f.batches[0], "\n") != strings.Join(want, "\n") {
+		t.Errorf("writeImport() wrote %q, want %q", f.batches, want)
+	}
+}
+
+func TestDedupRecords(t *testing.T) {
+	minute := func(m int) time.Time { return time.Unix(int64(60*m), 0) }
+	f := &fakeInflux{rows: [][]interface{}{
+		{json.Number("120"), json.Number("1")}, // minute 2
+		{json.Number("180"), json.Number("0")}, // counted nothing
+		{json.Number("600"), json.Number("3")}, // minute 10
+	}}
+	records := []importRecord{
+		{time: minute(1), period: time.Minute, cpm: 1},
+		{time: minute(2), period: time.Minute, cpm: 2},
+		{time: minute(3), period: time.Minute, cpm: 3},
+		{time: minute(3).Add(30 * time.Second), period: time.Second, cpm: 4},
+		// an hourly value overlapping minute 10
+		{time: minute(5), period: time.Hour, cpm: 5},
+		{time: minute(65), period: time.Minute, cpm: 6},
+		{time: minute(2).Add(59 * time.Second), period: time.Second, cpm: 7},
+	}
+	kept, err := dedupRecords(f, "db", "m", map[string]string{"host": "it's"}, records)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []float64
+	for _, r := range kept {
+		got = append(got, r.cpm)
+	}
+	if want := []float64{1, 3, 4, 6}; !reflect.DeepEqual(got, want) {
+		t.Errorf("dedupRecords() kept %v, want %v", got, want)
+	}
+	want := `SELECT count("geiger_counter_cpm") FROM "m" WHERE time >= 60s AND time < 3960s AND "host" = 'it\'s' GROUP BY time(1m) fill(none)`
+	if len(f.queries) != 1 || f.queries[0] != want {
+		t.Errorf("dedupRecords() queried %q, want %q", f.queries, want)
+	}
+
+	if kept, err := dedupRecords(f, "db", "m", nil, nil); err != nil || len(kept) != 0 || len(f.queries) != 1 {
+		t.Errorf("dedupRecords() of no records = %v, %v after %d queries", kept, err, len(f.queries))
+	}
+	f.down = true
+	if _, err := dedupRecords(f, "db", "m", nil, records); err == nil {
+		t.Error("dedupRecords() succeeded while the server is down")
+	}
+}
@@ -0,0 +1,462 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+	influxdb "github.com/influxdata/influxdb1-client/v2"
+)
+
+// influxConfig selects the InfluxDB server the data goes to.
+type influxConfig struct {
+	addr      string
+	api       string
+	token     string
+	tokenFile string
+	org       string
+	bucket    string
+	// user and password authenticate with the v1 API
+	user         string
+	password     string
+	passwordFile string
+	// caFile is a PEM file of the CAs trusted besides the system ones
+	caFile             string
+	insecureSkipVerify bool
+	// database and measurement of the readings, also with the v2 and v3
+	// APIs where the database is the default bucket
+	database    string
+	measurement string
+}
+
+// Defaults of influxConfig.
+const (
+	defaultInfluxDatabase    = "sensors"
+	defaultInfluxMeasurement = "measurements"
+)
+
+// influxFlags registers the flags selecting the InfluxDB server on fs.
+func influxFlags(fs *flag.FlagSet) *influxConfig {
+	c := &influxConfig{}
+	fs.StringVar(&c.addr, "influxAddr", "http://localhost:8086", "Address of InfluxDB server, empty to run without InfluxDB")
+	fs.StringVar(&c.api, "influxAPI", "v1", "InfluxDB API: v1, v2 for InfluxDB 2.x and Influx Cloud, whose bucket needs a DBRP mapping for the queries of the history dedup and the reports, or v3 for InfluxDB 3 and Influx Cloud Serverless")
+	fs.StringVar(&c.token, "influxToken", "", "InfluxDB token, required by -influxAPI v2 and v3")
+	fs.StringVar(&c.tokenFile, "influxTokenFile", "", "File holding the InfluxDB token, instead of -influxToken")
+	fs.StringVar(&c.org, "influxOrg", "", "InfluxDB organization, required by -influxAPI v2")
+	fs.StringVar(&c.bucket, "influxBucket", "", "InfluxDB bucket with -influxAPI v2 and v3, defaults to the database name")
+	fs.StringVar(&c.user, "influxUser", "", "InfluxDB user name with -influxAPI v1")
+	fs.StringVar(&c.password, "influxPassword", "", "InfluxDB password with -influxAPI v1")
+	fs.StringVar(&c.passwordFile, "influxPasswordFile", "", "File holding the InfluxDB password, instead of -influxPassword")
+	fs.StringVar(&c.caFile, "influxCA", "", "PEM file of the CA certificates trusted for an https -influxAddr, besides the system ones")
+	fs.BoolVar(&c.insecureSkipVerify, "influxInsecureSkipVerify", false, "Do not verify the certificate of an https -influxAddr")
+	fs.StringVar(&c.database, "influxDB", defaultInfluxDatabase, "InfluxDB database of the readings")
+	fs.StringVar(&c.measurement, "influxMeasurement", defaultInfluxMeasurement, "InfluxDB measurement of the readings, aggregates go to <measurement>_hourly and <measurement>_<period>")
+	return c
+}
+
+// override applies the influx section of the config file, except for the
+// settings given as flags.
+func (c *influxConfig) override(cfg *fileConfig, set map[string]bool) {
+	in := &cfg.Influx
+	if in.Addr != "" && !set["influxAddr"] {
+		c.addr = in.Addr
+	}
+	if in.API != "" && !set["influxAPI"] {
+		c.api = in.API
+	}
+	if in.Token != "" && !set["influxToken"] && !set["influxTokenFile"] {
+		c.token = in.Token
+	}
+	if in.Org != "" && !set["influxOrg"] {
+		c.org = in.Org
+	}
+	if in.Bucket != "" && !set["influxBucket"] {
+		c.bucket = in.Bucket
+	}
+	if in.User != "" && !set["influxUser"] {
+		c.user = in.User
+	}
+	if in.Password != "" && !set["influxPassword"] && !set["influxPasswordFile"] {
+		c.password = in.Password
+	}
+	if in.CAFile != "" && !set["influxCA"] {
+		c.caFile = in.CAFile
+	}
+	if in.InsecureSkipVerify && !set["influxInsecureSkipVerify"] {
+		c.insecureSkipVerify = true
+	}
+	if in.Database != "" && !set["influxDB"] {
+		c.database = in.Database
+	}
+	if in.Measurement != "" && !set["influxMeasurement"] {
+		c.measurement = in.Measurement
+	}
+}
+
+// tagFlags collects the repeatable -tag key=value flag.
+type tagFlags map[string]string
+
+func (t tagFlags) String() string {
+	pairs := make([]string, 0, len(t))
+	for k, v := range t {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (t tagFlags) Set(s string) error {
+	k, v, ok := strings.Cut(s, "=")
+	if !ok || k == "" || v == "" {
+		return fmt.Errorf("expected key=value, got %q", s)
+	}
+	t[k] = v
+	return nil
+}
+
+// tagFlag registers the -tag flag on fs.
+func tagFlag(fs *flag.FlagSet) tagFlags {
+	t := tagFlags{}
+	fs.Var(t, "tag", "Tag key=value added to the readings besides host=<host name>, may be repeated")
+	return t
+}
+
+// readingTags returns host=<host name>, unless host_tag is off, and the tags
+// of the config file, with the -tag flags replacing tags of the same names.
+// Sites are told apart by the host name or by a tag like location.
+func readingTags(cfg *fileConfig, flags tagFlags) map[string]string {
+	var host map[string]string
+	if cfg.HostTag == nil || *cfg.HostTag {
+		if name, err := os.Hostname(); err == nil {
+			host = map[string]string{"host": name}
+		}
+	}
+	return mergeTags(host, cfg.Tags, flags)
+}
+
+// client returns a client for the configured API. All of them share the
+// InfluxQL queries of the v1 API, which InfluxDB 3 still serves and which
+// InfluxDB 2.x serves only for buckets mapped to a database: the dedup of
+// the history import and the reports need a DBRP mapping of the bucket to
+// the database there, see influxDBRPHint. Both take the token as password
+// and ignore the user name.
+func (c *influxConfig) client() (influxdb.Client, error) {
+	if c.addr == "" {
+		return discardClient{}, nil
+	}
+	tlsConfig, err := c.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	switch c.api {
+	case "v1":
+		password, err := resolveSecret(c.password, c.passwordFile)
+		if err != nil {
+			return nil, fmt.Errorf("influx password: %w", err)
+		}
+		return influxdb.NewHTTPClient(influxdb.HTTPConfig{Addr: c.addr, Username: c.user, Password: password, TLSConfig: tlsConfig})
+	case "v2", "v3":
+		token, err := resolveSecret(c.token, c.tokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("influx token: %w", err)
+		}
+		if token == "" {
+			return nil, fmt.Errorf("-influxAPI %s requires -influxToken", c.api)
+		}
+		if c.api == "v2" && c.org == "" {
+			return nil, fmt.Errorf("-influxAPI v2 requires -influxOrg")
+		}
+		v1, err := influxdb.NewHTTPClient(influxdb.HTTPConfig{Addr: c.addr, Username: "gq-gmc", Password: token, TLSConfig: tlsConfig})
+		if err != nil {
+			return nil, err
+		}
+		opts := influxdb2.DefaultOptions().SetHTTPRequestTimeout(30).SetUseGZip(true).SetTLSConfig(tlsConfig)
+		v2 := influxdb2.NewClientWithOptions(c.addr, token, opts)
+		return &influxV2Client{Client: v1, v2: v2, org: c.org, bucket: c.bucket}, nil
+	}
+	return nil, fmt.Errorf("unknown InfluxDB API %q", c.api)
+}
+
+// tlsConfig returns the TLS settings of the https connections, nil for the
+// defaults.
+func (c *influxConfig) tlsConfig() (*tls.Config, error) {
+	if c.caFile == "" && !c.insecureSkipVerify {
+		return nil, nil
+	}
+	t := &tls.Config{InsecureSkipVerify: c.insecureSkipVerify}
+	if c.caFile != "" {
+		pem, err := os.ReadFile(c.caFile)
+		if err != nil {
+			return nil, fmt.Errorf("influx CA: %w", err)
+		}
+		if t.RootCAs, err = x509.SystemCertPool(); err != nil {
+			t.RootCAs = x509.NewCertPool()
+		}
+		if !t.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("influx CA: no certificates in %s", c.caFile)
+		}
+	}
+	return t, nil
+}
+
+// discardClient stands in for InfluxDB when no address is configured, e.g.
+// as the readings go to -sqlite, -postgres or -logFile only.
+type discardClient struct{}
+
+func (discardClient) Ping(time.Duration) (time.Duration, string, error) { return 0, "", nil }
+func (discardClient) Write(influxdb.BatchPoints) error                  { return nil }
+func (discardClient) Close() error                                      { return nil }
+
+func (discardClient) Query(influxdb.Query) (*influxdb.Response, error) {
+	return nil, errors.New("no InfluxDB configured")
+}
+
+func (discardClient) QueryAsChunk(influxdb.Query) (*influxdb.ChunkedResponse, error) {
+	return nil, errors.New("no InfluxDB configured")
+}
+
+// lineProtocolClient prints the points in line protocol instead of sending
+// them, for the execd input of Telegraf. The log stays on stderr.
+type lineProtocolClient struct {
+	discardClient
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (c *lineProtocolClient) Write(bp influxdb.BatchPoints) error {
+	var b bytes.Buffer
+	for _, pt := range bp.Points() {
+		b.WriteString(pt.String())
+		b.WriteByte('\n')
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, err := c.w.Write(b.Bytes())
+	return err
+}
+
+// jsonReading is a reading as printed by jsonClient.
+type jsonReading struct {
+	Time     time.Time         `json:"time"`
+	CPM      int64             `json:"cpm"`
+	CPS      float64           `json:"cps"` // mean of the interval
+	DoseRate float64           `json:"dose_rate"`
+	Device   string            `json:"device,omitempty"` // model tag
+	Tags     map[string]string `json:"tags,omitempty"`
+}
+
+// jsonClient prints the points of the readings measurement as one JSON
+// object per line instead of sending them, for piping into jq or scripts.
+// The points of other measurements, like the aggregates, are left out.
+type jsonClient struct {
+	discardClient
+	mu          sync.Mutex
+	w           io.Writer
+	measurement string
+}
+
+func (c *jsonClient) Write(bp influxdb.BatchPoints) error {
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	for _, pt := range bp.Points() {
+		if pt.Name() != c.measurement {
+			continue
+		}
+		fields, err := pt.Fields()
+		if err != nil {
+			return err
+		}
+		cpm, _ := fields["geiger_counter_cpm"].(int64)
+		doseRate, _ := fields["geiger_counter_dose_rate"].(float64)
+		tags := pt.Tags()
+		if err := enc.Encode(jsonReading{Time: pt.Time(), CPM: cpm, CPS: float64(cpm) / 60, DoseRate: doseRate, Device: tags["model"], Tags: tags}); err != nil {
+			return err
+		}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, err := c.w.Write(b.Bytes())
+	return err
+}
+
+// influxV2Client writes through the v2 write API with the official client,
+// which InfluxDB 2.x serves natively and InfluxDB 3 for compatibility.
+// Without a bucket the database of the batch is the bucket. Writes are
+// gzipped. Queries go to the embedded v1 client, see client.
+type influxV2Client struct {
+	influxdb.Client
+	v2     influxdb2.Client
+	org    string // ignored by InfluxDB 3
+	bucket string
+}
+
+func (c *influxV2Client) Write(bp influxdb.BatchPoints) error {
+	bucket := c.bucket
+	if bucket == "" {
+		bucket = bp.Database()
+	}
+	precision := influxPrecision(bp.Precision())
+	points := make([]*write.Point, 0, len(bp.Points()))
+	for _, pt := range bp.Points() {
+		fields, err := pt.Fields()
+		if err != nil {
+			return err
+		}
+		points = append(points, write.NewPoint(pt.Name(), pt.Tags(), fields, pt.Time().Truncate(precision)))
+	}
+	if err := c.v2.WriteAPIBlocking(c.org, bucket).WritePoint(context.Background(), points...); err != nil {
+		return fmt.Errorf("influx write: %w", err)
+	}
+	return nil
+}
+
+// influxDBRPHint tells how to map a bucket of InfluxDB 2.x to the database,
+// which its v1 API needs to answer the InfluxQL queries.
+const influxDBRPHint = "InfluxDB 2.x answers InfluxQL queries only for a bucket mapped to the database: influx v1 dbrp create --db <database> --rp autogen --bucket-id <bucket id> --default"
+
+// Query adds influxDBRPHint to the errors of InfluxDB 2.x.
+func (c *influxV2Client) Query(q influxdb.Query) (*influxdb.Response, error) {
+	resp, err := c.Client.Query(q)
+	if c.org == "" {
+		return resp, err
+	}
+	if err != nil {
+		return resp, fmt.Errorf("%w; %s", err, influxDBRPHint)
+	}
+	if resp.Err != "" {
+		resp.Err += "; " + influxDBRPHint
+	}
+	return resp, nil
+}
+
+func (c *influxV2Client) Close() error {
+	c.v2.Close()
+	return c.Client.Close()
+}
+
+// influxPrecision returns the duration of a precision of the v1 API, to which
+// the v2 client, which always writes nanoseconds, truncates the timestamps.
+func influxPrecision(p string) time.Duration {
+	switch p {
+	case "h":
+		return time.Hour
+	case "m":
+		return time.Minute
+	case "s":
+		return time.Second
+	case "ms":
+		return time.Millisecond
+	case "u", "us":
+		return time.Microsecond
+	}
+	return time.Nanosecond
+}
+
+// sinkConfig selects where the points go and how failed writes are kept
+// and frequent ones collected.
+type sinkConfig struct {
+	output      string
+	bufferSize  int
+	bufferSpool string
+	batchAge    time.Duration
+	batchPoints int
+}
+
+func sinkFlags(fs *flag.FlagSet) *sinkConfig {
+	c := &sinkConfig{}
+	fs.StringVar(&c.output, "output", "influx", "Where the points go: influx, lineprotocol printing them to stdout for the execd input of Telegraf, or json printing a JSON object per reading to stdout")
+	fs.IntVar(&c.bufferSize, "bufferSize", 100000, "Points of failed InfluxDB writes kept for retrying, 0 disables")
+	fs.StringVar(&c.bufferSpool, "bufferSpool", "", "File keeping the points of failed InfluxDB writes across restarts, e.g. /var/lib/gq-gmc/spool.jsonl")
+	fs.DurationVar(&c.batchAge, "batchAge", 0, "Collect the InfluxDB points for up to this long and write them in one request, 0 writes every interval right away")
+	fs.IntVar(&c.batchPoints, "batchPoints", 5000, "Write the collected points earlier once this many are pending, see -batchAge")
+	return c
+}
+
+// override applies the settings of the config file, except for those given
+// as flags.
+func (c *sinkConfig) override(cfg *fileConfig, set map[string]bool) {
+	if cfg.Output != "" && !set["output"] {
+		c.output = cfg.Output
+	}
+	if cfg.BufferSize != nil && !set["bufferSize"] {
+		c.bufferSize = *cfg.BufferSize
+	}
+	if cfg.BufferSpool != "" && !set["bufferSpool"] {
+		c.bufferSpool = cfg.BufferSpool
+	}
+	if cfg.BatchAge != 0 && !set["batchAge"] {
+		c.batchAge = time.Duration(cfg.BatchAge)
+	}
+	if cfg.BatchPoints != nil && !set["batchPoints"] {
+		c.batchPoints = *cfg.BatchPoints
+	}
+}
+
+// open returns the client the points are written with. It does not wait
+// for the server; closing it writes the queued points and closes the
+// clients below. With dryRun the points are only logged. flushed is called
+// with the result of every write to the server, by the lowest of the
+// buffered, batching and async clients, as the ones above only queue.
+func (c *sinkConfig) open(influx *influxConfig, dryRun bool, metrics *selfMetrics, flushed func(error)) (*asyncClient, error) {
+	var client influxdb.Client
+	var err error
+	switch c.output {
+	case "influx":
+		if dryRun {
+			client = dryRunClient{}
+			break
+		}
+		if client, err = influx.client(); err != nil {
+			return nil, fmt.Errorf("influx: %w", err)
+		}
+		if influx.addr != "" {
+			client = &meteredClient{Client: client, metrics: metrics}
+		}
+	case "lineprotocol":
+		client = &lineProtocolClient{w: os.Stdout}
+	case "json":
+		client = &jsonClient{w: os.Stdout, measurement: influx.measurement}
+	default:
+		return nil, fmt.Errorf("unknown -output %q", c.output)
+	}
+	if c.bufferSize > 0 {
+		if metrics.buffer, err = newBufferedClient(client, c.bufferSize, c.bufferSpool, flushed); err != nil {
+			return nil, fmt.Errorf("influx buffer: %w", err)
+		}
+		client, flushed = metrics.buffer, nil
+	}
+	if c.batchAge > 0 {
+		client, flushed = newBatchingClient(client, c.batchPoints, c.batchAge, flushed), nil
+	}
+	return newAsyncClient(client, flushed), nil
+}
+
+// influxOutput writes the readings as points of the measurement. The
+// aggregates, gaps and bursts are written beside them with the same client.
+type influxOutput struct {
+	client      influxdb.Client
+	database    string
+	measurement string
+}
+
+// write queues the reading. Whether it reached the server is reported to
+// the collector health by the client below, see sinkConfig.open.
+func (o *influxOutput) write(r reading) error {
+	return sendToInflux(o.client, o.database, o.measurement, r.Tags, r.Fields, r.Time)
+}
+
+// Close leaves the client open for the writes beside the readings.
+func (o *influxOutput) Close() error { return nil }
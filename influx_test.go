@@ -0,0 +1,74 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	influxdb "github.com/influxdata/influxdb1-client/v2"
+)
+
+func TestInfluxV2Write(t *testing.T) {
+	var got *http.Request
+	var body string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		rd := io.Reader(r.Body)
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			zr, err := gzip.NewReader(r.Body)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			rd = zr
+		}
+		b, _ := io.ReadAll(rd)
+		body = string(b)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	c := &influxConfig{addr: srv.URL, api: "v2", token: "secret", org: "home"}
+	client, err := c.client()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	ts := time.Date(2024, 10, 27, 1, 30, 15, 500, time.UTC)
+	bp, _ := influxdb.NewBatchPoints(influxdb.BatchPointsConfig{Database: "sensors", Precision: "s"})
+	pt, _ := influxdb.NewPoint("measurements", map[string]string{"host": "a"}, map[string]interface{}{"geiger_counter_cpm": 20}, ts)
+	bp.AddPoint(pt)
+	if err := client.Write(bp); err != nil {
+		t.Fatal(err)
+	}
+
+	if got == nil {
+		t.Fatal("nothing written")
+	}
+	if got.URL.Path != "/api/v2/write" || got.URL.Query().Get("org") != "home" || got.URL.Query().Get("bucket") != "sensors" {
+		t.Errorf("wrote to %s", got.URL)
+	}
+	if a := got.Header.Get("Authorization"); a != "Token secret" {
+		t.Errorf("Authorization = %q", a)
+	}
+	want := "measurements,host=a geiger_counter_cpm=20i 1729992615000000000"
+	if strings.TrimSpace(body) != want {
+		t.Errorf("body = %q, want %q", body, want)
+	}
+
+	c = &influxConfig{addr: srv.URL, api: "v3", token: "secret", bucket: "radiation"}
+	if client, err = c.client(); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if err := client.Write(bp); err != nil {
+		t.Fatal(err)
+	}
+	if got.URL.Query().Get("bucket") != "radiation" {
+		t.Errorf("wrote to %s with -influxBucket", got.URL)
+	}
+}
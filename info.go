@@ -0,0 +1,68 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/mwuertinger/gq-gmc/pkg/gqgmc"
+)
+
+// infoCommand implements "gq-gmc info", which shows what the collector
+// knows about the counter.
+func infoCommand(args []string) {
+	fs := flag.NewFlagSet("info", flag.ExitOnError)
+	port := portFlags(fs)
+	deviceTimezone := fs.String("deviceTimezone", "Local", "Time zone of the device clock, e.g. UTC or Europe/Berlin")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s info [flags]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	if fs.NArg() != 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	loc, err := time.LoadLocation(*deviceTimezone)
+	if err != nil {
+		log.Fatalf("device timezone: %v", err)
+	}
+
+	dev, closer := port.openDevice()
+	defer closer.Close()
+	dev.SetClockLocation(loc)
+
+	t, err := dev.Telemetry()
+	if err != nil {
+		log.Fatalf("info: %v", err)
+	}
+	fmt.Printf("firmware:    %s\n", t.Version)
+	if model, err := dev.Model(); err == nil {
+		fmt.Printf("model:       %s\n", model)
+	}
+	if serial, err := dev.Serial(); err == nil {
+		fmt.Printf("serial:      %s\n", serial)
+	}
+	profile := gqgmc.LookupProfile(t.Version)
+	tube := deviceCalibration(dev, profile.Tube)
+	fmt.Printf("tube:        %s, %g µSv/h per CPM\n", tube.Name, tube.DoseRate(60)/60)
+	if high := profile.HighTube; high.Name != "" {
+		fmt.Printf("high tube:   %s, %g µSv/h per CPM\n", high.Name, high.Factor)
+	}
+	if p, err := dev.PowerStatus(); err == nil {
+		fmt.Printf("power:       %s, %.2f V\n", p.Source(), p.Voltage)
+	} else if t.Voltage != nil {
+		fmt.Printf("battery:     %.2f V\n", *t.Voltage)
+	}
+	if t.Temperature != nil {
+		fmt.Printf("temperature: %.1f °C\n", *t.Temperature)
+	}
+	if t.Gyro != nil {
+		fmt.Printf("gyro:        x=%d y=%d z=%d\n", t.Gyro.X, t.Gyro.Y, t.Gyro.Z)
+	}
+	if t.Clock != nil {
+		fmt.Printf("clock:       %s (%+v)\n", t.Clock.Format(time.RFC3339), t.ClockDrift)
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mwuertinger/gq-gmc/pkg/gqgmc"
+	"gopkg.in/yaml.v3"
+)
+
+// portCandidates are the device names USB serial adapters show up as.
+var portCandidates = []string{"/dev/serial/by-id/*", "/dev/ttyUSB*", "/dev/ttyACM*", "/dev/cu.usbserial*"}
+
+// detectedDevice is a counter found by detectDevices.
+type detectedDevice struct {
+	port    string
+	baud    int
+	version string
+}
+
+// detectDevices probes the candidate ports with <GETVER>>.
+func detectDevices() []detectedDevice {
+	var found []detectedDevice
+	seen := map[string]bool{}
+	for _, pattern := range portCandidates {
+		names, _ := filepath.Glob(pattern)
+		for _, name := range names {
+			// by-id links point to the ttyUSB devices found later
+			real, err := filepath.EvalSymlinks(name)
+			if err != nil || seen[real] {
+				continue
+			}
+			seen[real] = true
+			p := &portConfig{device: name}
+			if s, v, err := p.probe(); err == nil {
+				s.Close()
+				found = append(found, detectedDevice{port: name, baud: p.baud, version: v})
+			}
+		}
+	}
+	return found
+}
+
+// prompter asks questions on the terminal. At the end of the input the
+// defaults are taken.
+type prompter struct {
+	in  *bufio.Reader
+	out io.Writer
+}
+
+func (p *prompter) ask(question, def string) string {
+	if def != "" {
+		fmt.Fprintf(p.out, "%s [%s]: ", question, def)
+	} else {
+		fmt.Fprintf(p.out, "%s: ", question)
+	}
+	line, err := p.in.ReadString('\n')
+	line = strings.TrimSpace(line)
+	if err != nil {
+		fmt.Fprintln(p.out)
+	}
+	if line == "" {
+		return def
+	}
+	return line
+}
+
+func (p *prompter) confirm(question string, def bool) bool {
+	d := "y/N"
+	if def {
+		d = "Y/n"
+	}
+	switch strings.ToLower(p.ask(question, d)) {
+	case "y", "yes":
+		return true
+	case "n", "no":
+		return false
+	}
+	return def
+}
+
+// initCommand implements "gq-gmc init", a wizard writing a config file for
+// first-time users.
+func initCommand(args []string) {
+	fs := flag.NewFlagSet("init", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s init [config file]\n\nDetects the counter, asks for the basic settings and writes them to the config file, gq-gmc.yaml by default.\n", os.Args[0])
+	}
+	parseFlags(fs, args)
+	if fs.NArg() > 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	path := "gq-gmc.yaml"
+	if fs.NArg() == 1 {
+		path = fs.Arg(0)
+	}
+	p := &prompter{in: bufio.NewReader(os.Stdin), out: os.Stdout}
+	if _, err := os.Stat(path); err == nil && !p.confirm(path+" exists, overwrite?", false) {
+		os.Exit(1)
+	}
+
+	fmt.Println("Looking for counters...")
+	devices := detectDevices()
+	var dev detectedDevice
+	for i, d := range devices {
+		fmt.Printf("  %d: %s at %s, %d baud\n", i+1, d.version, d.port, d.baud)
+	}
+	switch {
+	case len(devices) == 0:
+		fmt.Println("No counter found. Check the cable and the permissions of the serial port.")
+		dev.port = p.ask("Serial port", "/dev/ttyUSB0")
+		dev.baud, _ = strconv.Atoi(p.ask("Baud rate", "115200"))
+	case len(devices) == 1:
+		dev = devices[0]
+	default:
+		i, _ := strconv.Atoi(p.ask("Use counter", "1"))
+		if i < 1 || i > len(devices) {
+			log.Fatalf("init: no counter %d", i)
+		}
+		dev = devices[i-1]
+	}
+
+	location := p.ask("Location tag of the readings", "Office")
+	influx := &influxConfig{addr: p.ask("InfluxDB address", "http://localhost:8086"), database: defaultInfluxDatabase}
+	switch p.ask("InfluxDB version (1, 2 for 2.x and Influx Cloud, 3 for InfluxDB 3 and Cloud Serverless)", "1") {
+	case "1":
+		influx.api = "v1"
+	case "2":
+		influx.api = "v2"
+		influx.org = p.ask("InfluxDB organization", "")
+		influx.bucket = p.ask("InfluxDB bucket", "sensors")
+		influx.token = p.ask("InfluxDB token", "")
+	case "3":
+		influx.api = "v3"
+		influx.token = p.ask("InfluxDB token", "")
+	default:
+		log.Fatal("init: the InfluxDB version must be 1, 2 or 3")
+	}
+
+	tube := gqgmc.LookupProfile(dev.version).Tube
+	fmt.Printf("The %s tube converts %g µSv/h per CPM.\n", tube.Name, tube.Factor)
+	var factor float64
+	if !p.confirm("Use this factor?", true) {
+		var err error
+		factor, err = strconv.ParseFloat(p.ask("µSv/h per CPM", ""), 64)
+		if err != nil || factor <= 0 {
+			log.Fatal("init: the factor must be a positive number")
+		}
+	}
+
+	root := &yaml.Node{Kind: yaml.MappingNode}
+	doc := &yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{root}}
+	doc.HeadComment = "Written by gq-gmc init. Run: gq-gmc serve -config " + path
+	set := func(value interface{}, keys ...string) {
+		if err := setYAML(root, value, keys...); err != nil {
+			log.Fatalf("init: %v", err)
+		}
+	}
+	set(dev.port, "serial", "device")
+	set(dev.baud, "serial", "baud")
+	set(influx.addr, "influx", "addr")
+	set(influx.api, "influx", "api")
+	if influx.org != "" {
+		set(influx.org, "influx", "org")
+		set(influx.bucket, "influx", "bucket")
+	}
+	if influx.token != "" {
+		set(influx.token, "influx", "token")
+	}
+	set(map[string]string{"location": location}, "tags")
+	if factor > 0 {
+		set(factor, "calibration_factor")
+	}
+	var out bytes.Buffer
+	enc := yaml.NewEncoder(&out)
+	enc.SetIndent(2)
+	if err := enc.Encode(doc); err != nil {
+		log.Fatalf("init: %v", err)
+	}
+	// the file may hold a token
+	if err := writeFileAtomic(path, out.Bytes(), 0600); err != nil {
+		log.Fatalf("init: %v", err)
+	}
+	fmt.Printf("Wrote %s\n", path)
+
+	cfg, err := loadConfig(path)
+	if err != nil {
+		log.Fatalf("init: %v", err)
+	}
+	var c configChecker
+	c.check(cfg)
+	for _, e := range c.errors {
+		fmt.Printf("error: %s\n", e)
+	}
+
+	fmt.Printf("Testing a write to %s...\n", influx.addr)
+	if err := initTestWrite(influx, cfg.Tags, dev.version); err != nil {
+		fmt.Printf("Test write failed: %v\nFix the InfluxDB settings in %s before starting the collector.\n", err, path)
+		os.Exit(1)
+	}
+	fmt.Printf("Test write succeeded. Start collecting with:\n  %s serve -config %s\n", os.Args[0], path)
+	if influx.api == "v2" {
+		fmt.Printf("Note: %s\n", strings.Replace(influxDBRPHint, "<database>", influx.database, 1))
+	}
+}
+
+// initTestWrite writes a housekeeping point with the firmware and collector
+// version, the same point the daemon writes during housekeeping.
+func initTestWrite(influx *influxConfig, tags map[string]string, firmware string) error {
+	client, err := influx.client()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	fields := map[string]interface{}{"collector_version": currentBuild().Version}
+	if firmware != "" {
+		fields["firmware"] = firmware
+	}
+	return sendToInflux(client, influx.database, "housekeeping", tags, fields, time.Now())
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// kafkaWriteTimeout bounds the delivery of a reading to the brokers.
+const kafkaWriteTimeout = 10 * time.Second
+
+// kafkaReading is the JSON message of a reading, keyed by the serial
+// number of the counter.
+type kafkaReading struct {
+	currentReading
+	Tags map[string]string `json:"tags,omitempty"`
+}
+
+// newKafkaWriter returns a producer for the comma-separated brokers.
+// Readings are rare, so they are sent without waiting for a batch to fill.
+func newKafkaWriter(brokers, topic string) *kafka.Writer {
+	return &kafka.Writer{
+		Addr:         kafka.TCP(strings.Split(brokers, ",")...),
+		Topic:        topic,
+		Balancer:     &kafka.Hash{},
+		RequiredAcks: kafka.RequireOne,
+		BatchTimeout: 10 * time.Millisecond,
+		WriteTimeout: kafkaWriteTimeout,
+	}
+}
+
+// kafkaOutput produces the readings as JSON messages, keyed by the serial
+// number of the counter.
+type kafkaOutput struct {
+	w *kafka.Writer
+}
+
+func (o *kafkaOutput) write(r reading) error {
+	b, err := json.Marshal(kafkaReading{newCurrentReading(r.State), r.Tags})
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), kafkaWriteTimeout)
+	defer cancel()
+	if err := o.w.WriteMessages(ctx, kafka.Message{Key: []byte(r.Tags["serial"]), Value: b, Time: r.Time}); err != nil {
+		return fmt.Errorf("topic %s: %w", o.w.Topic, err)
+	}
+	return nil
+}
+
+func (o *kafkaOutput) Close() error {
+	return o.w.Close()
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+
+	"github.com/mwuertinger/gq-gmc/pkg/gqgmc"
+)
+
+// listenConfig holds the addresses of the servers, which are off by
+// default.
+type listenConfig struct {
+	http, prometheus, debug       string
+	mdns                          bool
+	snmp, snmpCommunity           string
+	modbus, modbusRegisters       string
+	grpc, grpcTLSCert, grpcTLSKey string
+	dbus, console, ctl            string
+}
+
+func listenFlags(fs *flag.FlagSet) *listenConfig {
+	c := &listenConfig{}
+	fs.StringVar(&c.snmp, "snmpListen", "", "UDP address for the SNMP agent, e.g. :161")
+	fs.StringVar(&c.snmpCommunity, "snmpCommunity", "public", "SNMP community granting read access")
+	fs.StringVar(&c.modbus, "modbusListen", "", "TCP address for the Modbus TCP server, e.g. :502")
+	fs.StringVar(&c.modbusRegisters, "modbusRegisters", defaultModbusRegisters, "Modbus register map as comma separated addr=metric[:u16|u32|f32][*scale] entries")
+	fs.StringVar(&c.dbus, "dbus", "", "Publish readings on the \"session\" or \"system\" D-Bus")
+	fs.StringVar(&c.console, "consoleListen", "", "Loopback TCP address for the diagnostic console, e.g. localhost:4023")
+	fs.StringVar(&c.ctl, "ctlSocket", "", "Path of the control socket for \"gq-gmc ctl\", e.g. "+defaultCtlSocket)
+	fs.StringVar(&c.prometheus, "promListen", "", "TCP address serving Prometheus metrics on /metrics, e.g. :9100")
+	fs.StringVar(&c.http, "httpListen", "", "TCP address for the REST API, the dashboard and the /ws heartbeat stream, e.g. :8080")
+	fs.StringVar(&c.grpc, "grpcListen", "", "TCP address serving the gRPC API, see pkg/gqgmcpb, e.g. :9090")
+	fs.StringVar(&c.grpcTLSCert, "grpcTLSCert", "", "PEM certificate file the gRPC API is served with over TLS, which its control RPCs need for clients other than localhost")
+	fs.StringVar(&c.grpcTLSKey, "grpcTLSKey", "", "PEM private key file of -grpcTLSCert")
+	fs.StringVar(&c.debug, "debugListen", "", "TCP address for the pprof profiles and the internal state on /debug/state, e.g. localhost:6060")
+	fs.BoolVar(&c.mdns, "mdns", false, "Advertise the REST API via mDNS as "+mdnsService)
+	return c
+}
+
+// override applies the listen section of the config file, except for the
+// addresses given as flags.
+func (c *listenConfig) override(cfg *fileConfig, set map[string]bool) {
+	l := &cfg.Listen
+	if l.HTTP != "" && !set["httpListen"] {
+		c.http = l.HTTP
+	}
+	if l.MDNS && !set["mdns"] {
+		c.mdns = true
+	}
+	if l.Prometheus != "" && !set["promListen"] {
+		c.prometheus = l.Prometheus
+	}
+	if l.SNMP != "" && !set["snmpListen"] {
+		c.snmp = l.SNMP
+	}
+	if l.SNMPCommunity != "" && !set["snmpCommunity"] {
+		c.snmpCommunity = l.SNMPCommunity
+	}
+	if l.Modbus != "" && !set["modbusListen"] {
+		c.modbus = l.Modbus
+	}
+	if l.ModbusRegisters != "" && !set["modbusRegisters"] {
+		c.modbusRegisters = l.ModbusRegisters
+	}
+	if l.DBus != "" && !set["dbus"] {
+		c.dbus = l.DBus
+	}
+	if l.Console != "" && !set["consoleListen"] {
+		c.console = l.Console
+	}
+	if l.Ctl != "" && !set["ctlSocket"] {
+		c.ctl = l.Ctl
+	}
+	if l.GRPC != "" && !set["grpcListen"] {
+		c.grpc = l.GRPC
+	}
+	if l.GRPCTLSCert != "" && !set["grpcTLSCert"] {
+		c.grpcTLSCert = l.GRPCTLSCert
+	}
+	if l.GRPCTLSKey != "" && !set["grpcTLSKey"] {
+		c.grpcTLSKey = l.GRPCTLSKey
+	}
+	if l.Debug != "" && !set["debugListen"] {
+		c.debug = l.Debug
+	}
+}
+
+// servers holds what the servers answer from and where they send the
+// requests changing the collector.
+type servers struct {
+	state    *liveState
+	feed     *countFeed // the /ws stream, nil without -httpListen
+	health   *collectorHealth
+	metrics  *selfMetrics
+	dev      *gqgmc.Device
+	trace    *traceReadWriter
+	idTags   map[string]string
+	tube     string
+	token    string
+	settings chan<- settingsRequest
+	ctl      chan<- ctlRequest
+}
+
+// start starts the servers with an address. stop shuts them down, in the
+// reverse order.
+func (c *listenConfig) start(sv servers) (stop func(), err error) {
+	var stops []func()
+	stop = func() {
+		for i := len(stops) - 1; i >= 0; i-- {
+			stops[i]()
+		}
+	}
+	defer func() {
+		if err != nil {
+			stop()
+		}
+	}()
+	closer := func(f func() error) func() { return func() { f() } }
+
+	if c.snmp != "" {
+		conn, err := net.ListenPacket("udp", c.snmp)
+		if err != nil {
+			return nil, fmt.Errorf("snmp: %w", err)
+		}
+		stops = append(stops, closer(conn.Close))
+		go newSNMPAgent(c.snmpCommunity, sv.state).serve(conn)
+	}
+
+	if c.modbus != "" {
+		regs, err := parseModbusRegisters(c.modbusRegisters)
+		if err != nil {
+			return nil, fmt.Errorf("modbus: %w", err)
+		}
+		l, err := net.Listen("tcp", c.modbus)
+		if err != nil {
+			return nil, fmt.Errorf("modbus: %w", err)
+		}
+		stops = append(stops, closer(l.Close))
+		go (&modbusServer{registers: regs, state: sv.state}).serve(l)
+	}
+
+	if c.dbus != "" {
+		s, err := startDBus(c.dbus, sv.dev, sv.state)
+		if err != nil {
+			return nil, fmt.Errorf("dbus: %w", err)
+		}
+		stops = append(stops, s)
+	}
+
+	if c.http != "" {
+		l, err := net.Listen("tcp", c.http)
+		if err != nil {
+			return nil, fmt.Errorf("http: %w", err)
+		}
+		srv := &http.Server{Handler: newAPIHandler(sv.state, sv.feed, sv.health, sv.token, sv.settings, sv.ctl)}
+		stops = append(stops, closer(srv.Close))
+		go func() {
+			if err := srv.Serve(l); err != http.ErrServerClosed {
+				slog.Error("http", "err", err)
+			}
+		}()
+		if c.mdns {
+			m, err := advertiseMDNS(l.Addr().String(), sv.state.get().Version)
+			if err != nil {
+				return nil, fmt.Errorf("mdns: %w", err)
+			}
+			stops = append(stops, closer(m.Shutdown))
+		}
+	}
+
+	if c.grpc != "" {
+		if (c.grpcTLSCert == "") != (c.grpcTLSKey == "") {
+			return nil, errors.New("grpc: -grpcTLSCert and -grpcTLSKey must be given together")
+		}
+		l, err := net.Listen("tcp", c.grpc)
+		if err != nil {
+			return nil, fmt.Errorf("grpc: %w", err)
+		}
+		srv, err := newGRPCServer(sv.state, sv.idTags, sv.token, c.grpcTLSCert, c.grpcTLSKey, sv.ctl)
+		if err != nil {
+			l.Close()
+			return nil, fmt.Errorf("grpc: %w", err)
+		}
+		stops = append(stops, srv.Stop)
+		go func() {
+			if err := srv.Serve(l); err != nil {
+				slog.Error("grpc", "err", err)
+			}
+		}()
+	}
+
+	if c.prometheus != "" {
+		l, err := net.Listen("tcp", c.prometheus)
+		if err != nil {
+			return nil, fmt.Errorf("prometheus: %w", err)
+		}
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", prometheusHandler(sv.state, map[string]string{
+			"version": sv.state.get().Version,
+			"tube":    sv.tube,
+		}, sv.metrics))
+		srv := &http.Server{Handler: mux}
+		stops = append(stops, closer(srv.Close))
+		go func() {
+			if err := srv.Serve(l); err != http.ErrServerClosed {
+				slog.Error("prometheus", "err", err)
+			}
+		}()
+	}
+
+	if c.ctl != "" {
+		l, err := listenCtl(c.ctl)
+		if err != nil {
+			return nil, fmt.Errorf("ctl: %w", err)
+		}
+		stops = append(stops, closer(l.Close))
+		go serveCtl(l, sv.ctl)
+	}
+
+	if c.console != "" {
+		l, err := listenConsole(c.console)
+		if err != nil {
+			return nil, fmt.Errorf("console: %w", err)
+		}
+		stops = append(stops, closer(l.Close))
+		go serveConsole(l, sv.ctl, sv.trace)
+	}
+	return stop, nil
+}
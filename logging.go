@@ -0,0 +1,28 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+)
+
+// logLevel can be changed at runtime through the control socket.
+var logLevel = new(slog.LevelVar)
+
+// setupLogging routes the standard logger through slog so that log.Printf
+// output honours logLevel. The format is text, or json for log collectors
+// like Loki.
+func setupLogging(format string) error {
+	opts := &slog.HandlerOptions{Level: logLevel}
+	var h slog.Handler
+	switch format {
+	case "text":
+		h = slog.NewTextHandler(os.Stderr, opts)
+	case "json":
+		h = slog.NewJSONHandler(os.Stderr, opts)
+	default:
+		return fmt.Errorf("unknown format %q", format)
+	}
+	slog.SetDefault(slog.New(h))
+	return nil
+}
@@ -1,174 +1,1309 @@
 package main
 
 import (
-	"encoding/binary"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"syscall"
 	"time"
 
+	mqtt "github.com/eclipse/paho.mqtt.golang"
 	influxdb "github.com/influxdata/influxdb1-client/v2"
-	"github.com/tarm/serial"
+	"github.com/mwuertinger/gq-gmc/pkg/gqgmc"
 )
 
-const heartbeatMask = 0x3FFF
-
 func main() {
-	sensorDevice := flag.String("dev", "", "Serial port device for sensor communication")
-	sensorBaud := flag.Int("baud", 57600, "Serial port baud for sensor communication")
-	influxAddress := flag.String("influxAddr", "http://localhost:8086", "Address of InfluxDB server")
+	setupLogging("text")
+	args := os.Args[1:]
+	if isService() {
+		// installed with the flags of serve, see serviceCommand
+		runService(args)
+		return
+	}
+	if len(args) > 0 {
+		switch args[0] {
+		case "serve":
+			serveCommand(args[1:])
+			return
+		case "info":
+			infoCommand(args[1:])
+			return
+		case "clock":
+			clockCommand(args[1:])
+			return
+		case "ctl":
+			ctlCommand(args[1:])
+			return
+		case "device":
+			deviceCommand(args[1:])
+			return
+		case "power":
+			powerCommand(args[1:])
+			return
+		case "key":
+			keyCommand(args[1:])
+			return
+		case "config":
+			configCommand(args[1:])
+			return
+		case "report":
+			reportCommand(args[1:])
+			return
+		case "import":
+			importCommand(args[1:])
+			return
+		case "bench":
+			benchCommand(args[1:])
+			return
+		case "version":
+			versionCommand(args[1:])
+			return
+		case "init":
+			initCommand(args[1:])
+			return
+		case "history":
+			historyCommand(args[1:])
+			return
+		case "track":
+			trackCommand(args[1:])
+			return
+		case "service":
+			serviceCommand(args[1:])
+			return
+		case "receive":
+			receiveCommand(args[1:])
+			return
+		case "decode":
+			decodeCommand(args[1:])
+			return
+		}
+	}
+	// without a command the flags are those of serve, as before there were
+	// commands
+	serveCommand(args)
+}
+
+// commandsUsage lists the commands in the usage of serve.
+const commandsUsage = `Usage: %[1]s [serve] [flags]
+       %[1]s <command> [flags] [args]
+
+Commands:
+  serve     collect the readings of the counter, the default
+  info      show model, serial number, tube and health of the counter
+  clock     show the clock of the counter or set it to the host time
+  history   download the history flash of the counter
+  decode    decode a raw history flash to CSV or NDJSON
+  device    change settings of the counter
+  power     switch the counter on or off or reboot it
+  key       press a key of the counter
+  config    read and change the device configuration, check config files
+  init      detect the counter and write a config file
+  import    import GQ Data Viewer CSV exports
+  report    write the weekly report of past readings
+  track     export the GPS track of a survey as GPX or KML
+  receive   log WiFi models uploading in the gmcmap.com format
+  bench     measure how well the serial link performs
+  ctl       control a running collector
+  service   install the collector as a Windows service
+  version   show the version of the collector
+
+Run "%[1]s <command> -h" for the flags of a command. Every flag can also be
+set by an environment variable, e.g. GQGMC_INFLUX_ADDR for -influxAddr.
+Flags on the command line take precedence over the environment variables,
+which take precedence over the config file.
+
+Flags of serve:
+`
+
+// serveCommand implements "gq-gmc serve", the collector daemon.
+func serveCommand(args []string) {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), commandsUsage, os.Args[0])
+		flag.PrintDefaults()
+	}
+	port := portFlags(flag.CommandLine)
+	simulate := flag.String("simulate", "", "Read from a simulated counter instead of the serial port, following this scenario file or, if empty, a constant background rate. Without -dev the simulator is also used when no counter is found")
+	simulateCPM := flag.Float64("simulateCPM", defaultBackgroundCPM, "Background rate of -simulate without a scenario file, in CPM")
+	simulateVersion := flag.String("simulateVersion", defaultSimulatedVersion, "Firmware version string the simulator reports, selecting the protocol variant of the model, e.g. \"GMC-500+Re 2.24\"")
+	influx := influxFlags(flag.CommandLine)
+	sink := sinkFlags(flag.CommandLine)
+	fileOutputs := outputFlags(flag.CommandLine)
+	mqttc := mqttFlags(flag.CommandLine)
+	publishers := publisherFlags(flag.CommandLine)
+	uploaders := uploaderFlags(flag.CommandLine)
+	alertOpts := alertFlags(flag.CommandLine)
+	listen := listenFlags(flag.CommandLine)
+	readOpts := readingFlags(flag.CommandLine)
+	tagValues := tagFlag(flag.CommandLine)
+	faults := flag.String("faults", "", "Developer option injecting serial faults, e.g. timeout=0.01,partial=0.005,garbage=0.005,disconnect=0.0001")
+	faultSeed := flag.Int64("faultSeed", 1, "Seed for -faults, the same seed reproduces the same faults")
 	logRawCommunication := flag.Bool("logRawCommunication", false, "Log the raw communication with the device")
-	flag.Parse()
+	replayFile := flag.String("replay", "", "Read from a log of -logRawCommunication instead of the serial port, playing back the recorded communication")
+	replaySpeed := flag.Float64("replaySpeed", 1, "Speed-up of -replay, 0 plays back as fast as possible")
+	lowBatteryVolt := flag.Float64("lowBatteryVolt", 0, "Alert when the battery voltage drops below this value, 0 disables")
+	housekeepingInterval := flag.Duration("housekeepingInterval", 15*time.Minute, "Interval for writing and checking device health telemetry, 0 disables")
+	deviceTimezone := flag.String("deviceTimezone", "Local", "Time zone of the device clock, e.g. UTC or Europe/Berlin, or auto to infer it from the difference to the host clock")
+	tubeName := flag.String("tube", "", "Tube of the counter for converting CPM to µSv/h instead of the calibration of the device, e.g. J305 after replacing the tube; default by the model")
+	calibrationFactor := flag.Float64("calibrationFactor", 0, "µSv/h per CPM, 0 uses -calibrationCurve, the calibration points of the device or, if they are not readable, the factor of the tube")
+	calibrationCurve := flag.String("calibrationCurve", "", "CPM:µSv/h points the dose rate is interpolated between, e.g. 60:0.39,240:1.56,1000:6.8")
+	gpsdAddr := flag.String("gpsd", "", "Address of gpsd, e.g. localhost:2947, the position is attached to every measurement for mobile surveys")
+	gpsTags := flag.Bool("gpsTags", false, "Attach the position of -gpsd as tags instead of fields")
+	collectorFields := flag.Bool("collectorMetrics", false, "Also write the health of the collector, like read errors and InfluxDB write latency, to the collector measurement every interval")
+	dryRun := flag.Bool("dryRun", false, "Talk to the counter and compute the readings but only log what would be written to InfluxDB, the other outputs, the maps and the notifiers, to check a setup before it goes live")
+	syncClock := flag.Bool("syncClock", false, "Set the device clock to the host time on startup and once per day")
+	payloadSchemaName := flag.String("payloadSchema", string(schemaDefault), "Layout of MQTT and webhook payloads: default or nodered (flat JSON, one message per metric)")
+	interval := flag.Duration("interval", 60*time.Second, "Reporting interval, shorter intervals report the rate over the last minute")
+	logLevelName := flag.String("logLevel", "info", "Minimum level of the log messages: debug, info, warn or error")
+	logFormat := flag.String("logFormat", "text", "Format of the log messages on stderr: text, or json for log collectors")
+	multicastAddr := flag.String("multicastAddr", "", "Multicast group receiving every heartbeat sample, e.g. 239.255.42.99:4242")
+	multicastFormat := flag.String("multicastFormat", "json", "Format of the multicast datagrams: json or binary")
+	maxCPS := flag.Int("maxCPS", gqgmc.DefaultMaxCPS, "Heartbeat counts per second above which the stream is taken for misaligned and resynchronized, 0 disables")
+	powerInterval := flag.Duration("powerInterval", 10*time.Minute, "Interval for querying battery voltage and power source, 0 disables")
+	stateFile := flag.String("stateFile", "", "File keeping alert state and counters across restarts, e.g. /var/lib/gq-gmc/state.json")
+	configPath := flag.String("config", "", "YAML config file; flags given on the command line or as GQGMC_* environment variables take precedence")
+	apiToken := flag.String("apiToken", "", "API token for the REST and gRPC control endpoints, sent as bearer token")
+	apiTokenFile := flag.String("apiTokenFile", "", "File holding the API token for the REST and gRPC control endpoints, instead of -apiToken")
+	parseFlags(flag.CommandLine, args)
+	set := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	tags := readingTags(&fileConfig{}, tagValues)
+	cfg := &fileConfig{}
+	if *configPath != "" {
+		var err error
+		cfg, err = loadConfig(*configPath)
+		if err != nil {
+			log.Fatalf("config: %v", err)
+		}
+		if err := cfg.resolveSecrets(); err != nil {
+			log.Fatalf("config: %v", err)
+		}
+		if cfg.Interval != 0 && !set["interval"] {
+			*interval = time.Duration(cfg.Interval)
+		}
+		tags = readingTags(cfg, tagValues)
+		if cfg.DeviceTimezone != "" && !set["deviceTimezone"] {
+			*deviceTimezone = cfg.DeviceTimezone
+		}
+		if cfg.Serial.Device != "" && !set["dev"] {
+			port.device = cfg.Serial.Device
+		}
+		if cfg.Serial.Baud != 0 && !set["baud"] {
+			port.baud = cfg.Serial.Baud
+		}
+		influx.override(cfg, set)
+		sink.override(cfg, set)
+		fileOutputs.override(cfg, set)
+		mqttc.override(cfg, set)
+		publishers.override(cfg, set)
+		uploaders.override(cfg, set)
+		alertOpts.override(cfg, set)
+		listen.override(cfg, set)
+		readOpts.override(cfg, set)
+		if cfg.Tube != "" && !set["tube"] {
+			*tubeName = cfg.Tube
+		}
+		if cfg.CalibrationFactor != 0 && !set["calibrationFactor"] {
+			*calibrationFactor = cfg.CalibrationFactor
+		}
+		if cfg.CalibrationCurve != "" && !set["calibrationCurve"] {
+			*calibrationCurve = cfg.CalibrationCurve
+		}
+		if cfg.APIToken != "" && !set["apiToken"] && !set["apiTokenFile"] {
+			*apiToken = cfg.APIToken
+		}
+		if cfg.Alerts.LowBatteryVolt != 0 && !set["lowBatteryVolt"] {
+			*lowBatteryVolt = cfg.Alerts.LowBatteryVolt
+		}
+		if cfg.MaxCPS != nil && !set["maxCPS"] {
+			*maxCPS = *cfg.MaxCPS
+		}
+		if cfg.PowerInterval != 0 && !set["powerInterval"] {
+			*powerInterval = time.Duration(cfg.PowerInterval)
+		}
+		if cfg.HousekeepingInterval != 0 && !set["housekeepingInterval"] {
+			*housekeepingInterval = time.Duration(cfg.HousekeepingInterval)
+		}
+		if cfg.GPSD != "" && !set["gpsd"] {
+			*gpsdAddr = cfg.GPSD
+		}
+		if cfg.GPSTags && !set["gpsTags"] {
+			*gpsTags = true
+		}
+		if cfg.CollectorMetrics && !set["collectorMetrics"] {
+			*collectorFields = true
+		}
+		if cfg.DryRun && !set["dryRun"] {
+			*dryRun = true
+		}
+		if cfg.SyncClock && !set["syncClock"] {
+			*syncClock = true
+		}
+		if cfg.StateFile != "" && !set["stateFile"] {
+			*stateFile = cfg.StateFile
+		}
+		if cfg.LogLevel != "" && !set["logLevel"] {
+			*logLevelName = cfg.LogLevel
+		}
+		if cfg.LogFormat != "" && !set["logFormat"] {
+			*logFormat = cfg.LogFormat
+		}
+		if cfg.PayloadSchema != "" && !set["payloadSchema"] {
+			*payloadSchemaName = cfg.PayloadSchema
+		}
+	}
+	if err := setupLogging(*logFormat); err != nil {
+		log.Fatalf("-logFormat: %v", err)
+	}
+	level := logLevel.Level()
+	if err := level.UnmarshalText([]byte(*logLevelName)); err != nil {
+		log.Fatalf("-logLevel: %v", err)
+	}
+	logLevel.Set(level)
+	slog.Info("gq-gmc", "build", currentBuild())
+
+	settings := runtimeSettings{
+		Interval:       duration(*interval),
+		Tags:           tags,
+		LowBatteryVolt: *lowBatteryVolt,
+	}
+
+	schema, err := parsePayloadSchema(*payloadSchemaName)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *apiToken, err = resolveSecret(*apiToken, *apiTokenFile); err != nil {
+		log.Fatalf("api token: %v", err)
+	}
+	if mqttc.password, err = resolveSecret(mqttc.password, mqttc.passwordFile); err != nil {
+		log.Fatalf("mqtt password: %v", err)
+	}
 
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	forwardServiceStop(sigChan)
 
-	influxClient, err := influxdb.NewHTTPClient(influxdb.HTTPConfig{
-		Addr: *influxAddress,
-	})
+	metrics := &selfMetrics{}
+	health := newCollectorHealth(time.Duration(settings.Interval))
+	async, err := sink.open(influx, *dryRun, metrics, health.flushed)
 	if err != nil {
-		log.Fatalf("influx: %v", err)
+		log.Fatal(err)
 	}
+	defer async.Close()
+	var influxClient influxdb.Client = async
 
-	var s io.ReadWriteCloser
-
-	if *sensorDevice != "" && *sensorBaud > 0 {
-		c := &serial.Config{Name: *sensorDevice, Baud: *sensorBaud, ReadTimeout: 2 * time.Second}
-		s, err = serial.OpenPort(c)
-		if err != nil {
-			log.Fatal("open port: ", err)
+	discovered := false
+	if port.device == "" && !set["simulate"] && *replayFile == "" {
+		if name, ok := discoverPort(); ok {
+			slog.Info("found counter", "device", name)
+			port.device = name
+			discovered = true
 		}
-	} else {
-		log.Printf("-dev and -baud flags not set, using fakeSerial")
-		s = &fakeSerial{}
+	}
+	// the route follows the discovered port; the further counters are
+	// routed by their own ports
+	base := influxClient
+	route := cfg.route(port.device)
+	if route.Database != "" {
+		influxClient = &routedClient{Client: influxClient, database: route.Database}
+	}
+	if route.MQTTTopic != "" && !set["mqttTopic"] {
+		mqttc.topic = route.MQTTTopic
+	}
+	// without a port and without a counter to discover, the simulator
+	// stands in as it always did
+	simulated := set["simulate"] || port.device == "" && *replayFile == ""
+	var sc *scenario
+	var rp *replay
+	switch {
+	case set["simulate"] && *replayFile != "":
+		log.Fatal("-simulate and -replay are mutually exclusive")
+	case *replayFile != "":
+		if *replaySpeed < 0 {
+			log.Fatal("-replaySpeed must not be negative")
+		}
+		if rp, err = loadReplay(*replayFile, *replaySpeed); err != nil {
+			log.Fatalf("replay: %v", err)
+		}
+		slog.Info("replaying", "file", *replayFile, "frames", len(rp.frames), "speed", *replaySpeed)
+		go func() {
+			<-rp.done
+			// give the last frames time to pass the pipeline
+			time.Sleep(time.Second)
+			slog.Info("replay: end of recording")
+			select {
+			case sigChan <- syscall.SIGTERM:
+			default:
+			}
+		}()
+	case simulated:
+		if !set["simulate"] {
+			slog.Warn("no counter found, using the simulator; select the serial port with -dev")
+		}
+		if *simulateCPM < 0 {
+			log.Fatal("-simulateCPM must not be negative")
+		}
+		if err := checkSimulatedVersion(*simulateVersion); err != nil {
+			log.Fatalf("-simulateVersion: %v", err)
+		}
+		if *simulate != "" {
+			if sc, err = loadScenario(*simulate); err != nil {
+				log.Fatalf("simulate: %v", err)
+			}
+		}
+		slog.Info("using simulator")
+	}
+	devLog := slog.With("device", port.device)
+	if simulated {
+		devLog = slog.With("device", "simulator")
+	} else if rp != nil {
+		devLog = slog.With("device", "replay")
+	}
+	var rates faultRates
+	if *faults != "" {
+		if rates, err = parseFaultRates(*faults); err != nil {
+			log.Fatalf("-faults: %v", err)
+		}
+		slog.Warn("injecting serial faults", "faults", *faults, "seed", *faultSeed)
+	}
+	// openPort is called again to reopen a lost port. A discovered counter is
+	// looked up again as it may come back under another name.
+	opens := int64(0)
+	openPort := func() (io.ReadWriteCloser, error) {
+		var rwc io.ReadWriteCloser
+		switch {
+		case simulated:
+			rwc = newSimulator(sc, *simulateCPM, *simulateVersion)
+		case rp != nil:
+			// a reopen continues the replay
+			rwc = rp
+		default:
+			if discovered {
+				if name, ok := discoverPort(); ok {
+					port.device = name
+				}
+			}
+			var err error
+			if rwc, err = port.open(); err != nil {
+				return nil, err
+			}
+		}
+		if *faults != "" {
+			// a new seed for each reopen, which would fail at the same read otherwise
+			rwc = newFaultReadWriter(rwc, rates, *faultSeed+opens)
+		}
+		opens++
+		return rwc, nil
+	}
+	s, err := newReconnectingPort(openPort)
+	if err != nil {
+		log.Fatal("open port: ", err)
 	}
 	defer func() {
 		s.Close()
 	}()
 
-	var port io.ReadWriter
-	port = s
-	if *logRawCommunication {
-		port = &loggingReadWriter{s}
+	trace := newTraceReadWriter(s, *logRawCommunication, devLog)
+	dev := port.newDevice(trace)
+	clockLoc := time.Local
+	if *deviceTimezone != autoTimezone {
+		if clockLoc, err = time.LoadLocation(*deviceTimezone); err != nil {
+			log.Fatalf("device timezone: %v", err)
+		}
+	} else if loc, err := inferClockLocation(dev); err != nil {
+		devLog.Warn("device timezone not inferred, using the host time zone", "err", err)
+	} else {
+		devLog.Info("device timezone inferred from the clock", "zone", loc.String())
+		clockLoc = loc
 	}
+	dev.SetClockLocation(clockLoc)
+	dev.SetMaxCPS(*maxCPS)
 
-	// Enable heart beat mode: Geiger counter will report event count every second
-	fmt.Fprintf(port, "<HEARTBEAT1>>")
-	defer func() {
-		fmt.Fprintf(port, "<HEARTBEAT0>>")
-	}()
+	state := &liveState{}
+	defer state.close()
+	version, profile, dualTube, err := identify(dev, devLog, *tubeName, *calibrationFactor, *calibrationCurve)
+	if err != nil {
+		log.Fatal(err)
+	}
+	state.update(func(snap *snapshot) { snap.Version = version })
+	if err := readOpts.check(*interval); err != nil {
+		log.Fatal(err)
+	}
+	devSettings := readDeviceSettings(dev)
+	state.update(func(snap *snapshot) { snap.Settings = devSettings })
+	idTags := deviceIDTags(dev)
+	p := &pipeline{
+		port:    s,
+		dev:     dev,
+		logger:  devLog,
+		version: version,
+		profile: profile,
+		dual:    dualTube,
+		opts:    readOpts,
+		metrics: metrics,
+		influx:  influxClient,
+		target:  influx,
+		global:  settings.Tags,
+		own:     mergeTags(route.Tags, idTags),
+		mode:    devSettings.tags(),
+	}
 
-	// countChan is used to transmit the event counts. It uses a pointer to distinguish between 0 and a closed channel.
-	countChan := make(chan *uint16, 128)
-	go func() {
-		defer close(countChan)
-		for {
-			var buf [2]byte
-			n, err := port.Read(buf[:])
-			if err == io.EOF {
-				log.Printf("Read: EOF")
-				return
+	health.connected = s.connected
+	ctlRequests := make(chan ctlRequest)
+	settingsRequests := make(chan settingsRequest)
+	var feed *countFeed
+	if listen.http != "" {
+		feed = &countFeed{}
+	}
+	stopServers, err := listen.start(servers{
+		state:    state,
+		feed:     feed,
+		health:   health,
+		metrics:  metrics,
+		dev:      dev,
+		trace:    trace,
+		idTags:   idTags,
+		tube:     profile.Tube.Name,
+		token:    *apiToken,
+		settings: settingsRequests,
+		ctl:      ctlRequests,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer stopServers()
+
+	if *dryRun {
+		slog.Warn("dry run: nothing is written")
+	}
+	var multicast *multicastSender
+	switch {
+	case *multicastAddr == "":
+	case *dryRun:
+		slog.Warn("dry run: multicast skipped")
+	default:
+		multicast, err = newMulticastSender(*multicastAddr, *multicastFormat, state.get().Version)
+		if err != nil {
+			log.Fatalf("multicast: %v", err)
+		}
+		defer multicast.Close()
+	}
+
+	// with -dryRun the counter is not connected to the broker, whose
+	// commands would change it
+	var mqttClient mqtt.Client
+	if mqttc.broker != "" && !*dryRun {
+		mqttc.schema = schema
+		mqttc.version = state.get().Version
+		mqttc.model, _ = dev.Model()
+		if mqttClient, err = connectMQTT(*mqttc, ctlRequests); err != nil {
+			log.Fatalf("mqtt: %v", err)
+		}
+		defer disconnectMQTT(mqttClient, *mqttc)
+	}
+
+	// the outputs of the flags go with those of the config file
+	outputConfigs, err := fileOutputs.merge(cfg.Outputs)
+	if err != nil {
+		log.Fatalf("config: %v", err)
+	}
+	for name, oc := range outputConfigs {
+		oc.counters = len(cfg.Counters) > 0
+		outputConfigs[name] = oc
+	}
+	outputs, err := openOutputs(outputConfigs, *dryRun)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	influxOut := startOutput("influx", func() (output, error) {
+		return &influxOutput{client: influxClient, database: influx.database, measurement: influx.measurement}, nil
+	})
+	outputs = append(outputs, influxOut)
+	add := func(name string, open func() (output, error)) {
+		outputs = append(outputs, startOutput(name, open))
+	}
+	// addPublisher adds an output sending to a server, which -dryRun
+	// only logs
+	addPublisher := func(name string, open func() (output, error)) {
+		if *dryRun {
+			open = func() (output, error) { return dryRunOutput{name: name}, nil }
+		}
+		add(name, open)
+	}
+	if mqttc.broker != "" && mqttc.topic != "" {
+		addPublisher("mqtt", func() (output, error) { return &mqttOutput{c: mqttClient, cfg: *mqttc}, nil })
+	}
+	if err := publishers.start(addPublisher); err != nil {
+		log.Fatal(err)
+	}
+	if err := uploaders.start(add, *dryRun); err != nil {
+		log.Fatal(err)
+	}
+	// the outputs are closed before the clients they write with
+	defer closeOutputs(outputs)
+
+	if readOpts.mode == "heartbeat" {
+		// Enable heart beat mode: Geiger counter will report event count every second
+		// a lost port is reopened by the reader, which enables it again
+		// a device that does not stream yet is retried by the reader
+		if err := dev.EnableHeartbeat(); errors.Is(err, gqgmc.ErrNoHeartbeat) {
+			devLog.Error("enable heartbeat", "err", err)
+		} else if err != nil && !errors.Is(err, errPortLost) {
+			log.Fatalf("enable heartbeat: %v", err)
+		}
+		defer func() {
+			if err := dev.DisableHeartbeat(); err != nil {
+				devLog.Error("disable heartbeat", "err", err)
 			}
-			if err != nil {
-				fmt.Printf("Read error: %v\n", err)
-				continue
+		}()
+	}
+
+	// missedFrames is updated by the reader and added to the counters when
+	// they are saved.
+	missedFrames := &metrics.missed
+	var gps *gpsClient
+	if *gpsdAddr != "" {
+		gps = newGPSClient(*gpsdAddr, *gpsTags)
+		go gps.run()
+	}
+	p.outputs, p.feed, p.gps = outputs, feed, gps
+	p.startReader()
+
+	if listen.debug != "" {
+		l, err := net.Listen("tcp", listen.debug)
+		if err != nil {
+			log.Fatalf("debug: %v", err)
+		}
+		srv := &http.Server{Handler: newDebugHandler(func() debugState {
+			backlog, capacity := p.counts.backlog()
+			return debugState{
+				PortConnected: s.connected(),
+				CountBacklog:  backlog,
+				CountCapacity: capacity,
+				InfluxBacklog: metrics.backlog(),
+				MissedFrames:  metrics.dropped(),
+				Transfers:     transferLines(trace.dump(debugTransfers)),
 			}
-			// After ReadTimeout Read returns with n == 0
-			if n == 0 {
-				continue
+		})}
+		defer srv.Close()
+		go func() {
+			if err := srv.Serve(l); err != http.ErrServerClosed {
+				slog.Error("debug", "err", err)
 			}
+		}()
+		slog.Warn("debug endpoint enabled", "addr", l.Addr().String())
+	}
 
-			val := binary.BigEndian.Uint16(buf[:])
-			val &= heartbeatMask
-			countChan <- &val
+	if err := checkCounters(cfg, port.device); err != nil {
+		log.Fatalf("config: %v", err)
+	}
+	var further []*pipeline
+	for _, c := range cfg.Counters {
+		cn, err := openCounter(c, *port, cfg, p, base, *logRawCommunication)
+		if err != nil {
+			log.Fatalf("counter %s: %v", c.Serial.Device, err)
 		}
-	}()
+		defer cn.Close()
+		go cn.run(time.Duration(settings.Interval))
+		further = append(further, cn)
+	}
+
+	notifiers, err := alertOpts.notifiers(cfg, schema, state)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *dryRun {
+		dryRunNotifiers(notifiers)
+	}
+	rules, err := cfg.alertRules(notifiers)
+	if err != nil {
+		log.Fatalf("config: %v", err)
+	}
+	rules = append(rules, cfg.healthRules()...)
+	alerts := newAlertEngine(rules, notifiers)
+	alerts.windows, err = cfg.maintenanceWindows()
+	if err != nil {
+		log.Fatalf("config: %v", err)
+	}
+	if err := alertOpts.setRules(alerts); err != nil {
+		log.Fatal(err)
+	}
+	applySettings := func(s runtimeSettings) {
+		if s.LowBatteryVolt > 0 {
+			alerts.setRule("low_battery", &alertRule{
+				name:       "low_battery",
+				metric:     "battery_voltage",
+				below:      true,
+				hysteresis: 0.1,
+				stages:     []alertStage{{severity: "warning", threshold: s.LowBatteryVolt}},
+			})
+		} else {
+			alerts.setRule("low_battery", nil)
+		}
+		settings = s
+		p.global = s.Tags
+		for _, cn := range further {
+			cn.update(s)
+		}
+		health.interval.Store(int64(s.Interval))
+	}
+	applySettings(settings)
+
+	persisted := &persistentState{}
+	if *stateFile != "" {
+		persisted, err = loadState(*stateFile)
+		if err != nil {
+			log.Fatalf("state: %v", err)
+		}
+	}
+	alerts.restore(persisted.Alerts)
+	counters := persisted.Counters
+	if counters == nil {
+		counters = &counterState{Since: time.Now()}
+	}
+	state.update(func(snap *snapshot) {
+		snap.CumulativeDose = counters.CumulativeDose
+		snap.DoseSince = counters.Since
+	})
+
+	var power *gqgmc.PowerStatus
+	updatePower := func() {
+		p, err := dev.PowerStatus()
+		if err != nil {
+			devLog.Warn("power status", "err", err)
+			return
+		}
+		power = &p
+		state.update(func(snap *snapshot) { snap.Power = &p })
+		alerts.evaluate(map[string]float64{"battery_voltage": p.Voltage})
+	}
+	var powerTimer <-chan time.Time
+	if *powerInterval > 0 {
+		updatePower()
+		powerTimer = time.Tick(*powerInterval)
+	}
+
+	var clockTimer <-chan time.Time
+	if *syncClock {
+		syncDeviceClock(dev)
+		clockTimer = time.Tick(24 * time.Hour)
+	}
+
+	var dailyReporters []reporter
+	var dailyTimer <-chan time.Time
+	var dailyAt time.Duration
+	if cfg.Reports.Daily.Time != "" {
+		dailyAt, err = parseTimeOfDay(cfg.Reports.Daily.Time)
+		if err != nil {
+			log.Fatalf("config: daily report: %v", err)
+		}
+		dailyReporters, err = cfg.Reports.Daily.reporters(notifiers)
+		if err != nil {
+			log.Fatalf("config: daily report: %v", err)
+		}
+		dailyTimer = time.After(time.Until(nextTimeOfDay(time.Now(), dailyAt)))
+	}
+	daily := newDailyStats(time.Now(), counters.CumulativeDose)
+	if counters.Daily != nil {
+		daily = restoreDailyStats(counters.Daily)
+	}
+
+	var weeklyReporters []reporter
+	var weeklyTimer <-chan time.Time
+	var weeklyDay time.Weekday
+	var weeklyAt time.Duration
+	if w := cfg.Reports.Weekly; w.Time != "" {
+		var ok bool
+		if weeklyDay, ok = weekdays[strings.ToLower(w.Day)]; !ok {
+			log.Fatalf("config: weekly report: unknown day %q", w.Day)
+		}
+		if weeklyAt, err = parseTimeOfDay(w.Time); err != nil {
+			log.Fatalf("config: weekly report: %v", err)
+		}
+		if weeklyReporters, err = w.reporters(notifiers); err != nil {
+			log.Fatalf("config: weekly report: %v", err)
+		}
+		if w.Dir == "" && len(weeklyReporters) == 0 {
+			log.Fatal("config: weekly report needs dir or notify")
+		}
+		weeklyTimer = time.After(time.Until(nextTimeOfWeek(time.Now(), weeklyDay, weeklyAt)))
+	}
+
+	var housekeepingTimer <-chan time.Time
+	if *housekeepingInterval > 0 {
+		housekeepingTimer = time.Tick(*housekeepingInterval)
+	}
+
+	// lastSample feeds the collector health metrics
+	lastSample := time.Now()
+	// downSince is the last reading of the previous run, the first reading
+	// of this one ends the gap of the collector being down
+	downSince := counters.LastSample
+	healthTimer := time.Tick(10 * time.Second)
+	rise := &riseTracker{window: alertOpts.riseWindow}
+	anomaly := &anomalyTracker{window: alertOpts.anomalyWindow, baseline: alertOpts.anomalyBaseline}
+	paused := false
+	timer := time.NewTicker(time.Duration(settings.Interval))
+	defer timer.Stop()
+
+	// saveState writes the alert state and the counters to the state file.
+	saveState := func() {
+		if *stateFile == "" {
+			return
+		}
+		snap := state.get()
+		counters.CumulativeDose = snap.CumulativeDose
+		n := missedFrames.Swap(0)
+		counters.MissedFrames += n
+		metrics.saved.Add(n)
+		counters.FailedWrites += influxOut.failed.Swap(0)
+		counters.Daily = daily.state()
+		persisted.Alerts = alerts.states
+		persisted.Counters = counters
+		if err := persisted.save(*stateFile); err != nil {
+			slog.Error("save state", "file", *stateFile, "err", err)
+		}
+	}
+	alerts.onChange = saveState
+	defer saveState()
+
+	// flush sends the reading of the interval with the live state.
+	// Every sample covers one second, so cpm is computed from the number
+	// of seconds actually observed. This also takes care of the seconds
+	// lost while device queries suspend heartbeat mode.
+	flush := func() {
+		defer p.reset()
+		r, ok := p.reading(time.Duration(settings.Interval))
+		if !ok {
+			return
+		}
+		cpmSigma := anomaly.add(r.Time, p.sum, p.samples)
+		cpmRise := rise.add(r.Time, float64(r.CPM))
+		daily.add(r.DoseRate)
+		state.update(func(snap *snapshot) {
+			snap.CPMRise = cpmRise
+			snap.CPMSigma = cpmSigma
+			snap.Time = r.Time
+			snap.CPM = r.CPM
+			snap.DoseRate = r.DoseRate
+			snap.CumulativeDose += r.DoseRate * r.Time.Sub(p.start).Hours()
+		})
+		alerts.evaluate(state.get().metrics())
+		if power != nil {
+			r.Fields["geiger_counter_battery_voltage"] = power.Voltage
+			r.Fields["geiger_counter_power_source"] = power.Source()
+			r.Fields["geiger_counter_charging"] = power.Charging
+		}
+		r.State = state.get()
+		p.send(r)
+		if *collectorFields {
+			if err := sendToInflux(influxClient, influx.database, "collector", mergeTags(p.global, p.own), metrics.fields(), time.Now()); err != nil {
+				slog.Error("influx write collector", "err", err)
+			}
+		}
+		saveState()
+	}
+	// changeSettings applies the settings changed through the REST API or
+	// ctl interval and saves them to the config file. A new interval starts
+	// with the reading of the current one.
+	changeSettings := func(s runtimeSettings) {
+		if s.Interval != settings.Interval {
+			flush()
+			timer.Reset(time.Duration(s.Interval))
+		}
+		applySettings(s)
+		slog.Info("settings changed", "interval", s.Interval, "tags", s.Tags, "low_battery_volt", s.LowBatteryVolt)
+		if *configPath != "" {
+			if err := saveSettings(*configPath, s); err != nil {
+				slog.Error("save settings", "err", err)
+			}
+		}
+	}
+
+	sendDaily := func() {
+		snap := state.get()
+		events, truncated := alerts.eventsSince(daily.start)
+		sendReport(dailyReporters, "[gq-gmc] Daily summary", "text/plain", daily.format(snap, events, truncated))
+		daily = newDailyStats(time.Now(), snap.CumulativeDose)
+	}
+	sendWeekly := func() {
+		now := time.Now()
+		events, truncated := alerts.eventsSince(now.AddDate(0, 0, -7))
+		go writeWeekly(influxClient, influx.database, influx.measurement, now, state.get().Version, events, truncated, cfg.Reports.Weekly.Dir, weeklyReporters)
+	}
+	housekeeping := func() {
+		t, err := dev.Telemetry()
+		if err != nil {
+			devLog.Warn("housekeeping", "err", err)
+			return
+		}
+		alerts.evaluate(telemetryMetrics(t))
+		daily.telemetry = &t
+		if s := readDeviceSettings(dev); s != nil {
+			devSettings = s
+			p.mode = s.tags()
+			state.update(func(snap *snapshot) { snap.Settings = s })
+		}
+		if err := writeHousekeeping(influxClient, influx.database, mergeTags(p.global, p.own), t, devSettings); err != nil {
+			devLog.Warn("housekeeping", "err", err)
+		}
+	}
+	// backfill downloads the device history in the background; the
+	// download takes minutes and heartbeat mode resumes between its chunks.
+	var backfilling atomic.Bool
+	backfill := func() bool {
+		if !backfilling.CompareAndSwap(false, true) {
+			return false
+		}
+		tags := p.tags()
+		go func() {
+			defer backfilling.Store(false)
+			n, total, err := backfillHistory(dev, influxClient, influx.database, influx.measurement, tags, profile, *syncClock)
+			if err != nil {
+				devLog.Error("history", "err", err)
+				return
+			}
+			devLog.Info("history backfilled", "records", n, "found", total)
+		}()
+		return true
+	}
+	tasks, err := cfg.scheduledTasks()
+	if err != nil {
+		log.Fatalf("config: %v", err)
+	}
+	scheduled := startScheduler(tasks)
+
+	// The systemd watchdog is only fed while samples arrive, so that a
+	// stalled device stream gets the service restarted.
+	var watchdogTimer <-chan time.Time
+	watchdog := sdWatchdogInterval()
+	if watchdog > 0 {
+		watchdogTimer = time.Tick(watchdog / 2)
+	}
+	if err := sdNotify("READY=1"); err != nil {
+		slog.Warn("sd_notify", "err", err)
+	}
+	defer sdNotify("STOPPING=1")
+
+	// takeSample adds a sample of the reader to the interval.
+	takeSample := func(c countSample) {
+		lastSample = c.Time
+		health.sample()
+		if !downSince.IsZero() && lastSample.Sub(downSince) >= gapMinDuration {
+			p.recordGap(gap{Start: downSince, End: lastSample, Reason: gapCollectorDown})
+		}
+		downSince = time.Time{}
+		counters.LastSample = lastSample
+		if paused {
+			return
+		}
+		p.add(c)
+		counters.TotalCounts += int64(c.CPS)
+		if multicast != nil {
+			if err := multicast.send(c.Time, c.CPS); err != nil {
+				slog.Debug("multicast", "err", err)
+			}
+		}
+	}
 
-	cpm := 0
-	timer := time.Tick(60 * time.Second)
 	for {
 		select {
 		case sig := <-sigChan:
-			log.Printf("Received %s, shutting down", sig)
+			slog.Info("shutting down", "signal", sig.String())
+			// the counts of the partial interval are written pro rata
+			if !paused {
+				flush()
+			}
+			p.finish()
 			return
-		case count := <-countChan:
-			if count == nil {
-				log.Print("countChan is closed, exiting")
+		case <-p.counts.ready:
+			batch, open := p.drain()
+			for _, c := range batch {
+				takeSample(c)
+			}
+			if !open {
+				slog.Info("reader stopped, exiting")
 				return
 			}
-			cpm += int(*count)
-		case <-timer:
-			doseRate := float64(cpm) * 0.00625
-			log.Printf("cpm=%d, doseRate=%f", cpm, doseRate)
-			err = sendToInflux(influxClient, cpm, doseRate)
-			if err != nil {
-				log.Printf("sendToInflux: %v", err)
+		case g := <-p.gapChan:
+			p.recordGap(g)
+		case <-powerTimer:
+			updatePower()
+		case <-clockTimer:
+			syncDeviceClock(dev)
+		case <-watchdogTimer:
+			if time.Since(lastSample) < watchdog {
+				sdNotify("WATCHDOG=1")
+			} else {
+				devLog.Warn("no samples, not feeding the systemd watchdog", "last_sample", lastSample.Format(time.RFC3339))
+			}
+		case <-healthTimer:
+			m := map[string]float64{
+				"sample_age_seconds":   time.Since(lastSample).Seconds(),
+				"sink_failure_seconds": 0,
+			}
+			// the worker only sees the clients queueing the readings, the
+			// health the writes reaching the server
+			t := influxOut.failingSince()
+			if h := health.failingSince(); !h.IsZero() && (t.IsZero() || h.Before(t)) {
+				t = h
+			}
+			if !t.IsZero() {
+				m["sink_failure_seconds"] = time.Since(t).Seconds()
+			}
+			alerts.evaluate(m)
+		case <-dailyTimer:
+			sendDaily()
+			dailyTimer = time.After(time.Until(nextTimeOfDay(time.Now(), dailyAt)))
+		case <-weeklyTimer:
+			now := time.Now()
+			sendWeekly()
+			weeklyTimer = time.After(time.Until(nextTimeOfWeek(now, weeklyDay, weeklyAt)))
+		case <-housekeepingTimer:
+			housekeeping()
+		case task := <-scheduled:
+			slog.Debug("scheduled task", "task", task)
+			switch task {
+			case "sync-clock":
+				syncDeviceClock(dev)
+			case "power":
+				updatePower()
+			case "housekeeping":
+				housekeeping()
+			case "daily-report":
+				sendDaily()
+			case "weekly-report":
+				sendWeekly()
+			case "flush":
+				if !paused {
+					flush()
+				}
+			case "history":
+				if !backfill() {
+					slog.Warn("history: previous download still running")
+				}
+			}
+		case <-timer.C:
+			if !paused {
+				flush()
+			}
+		case req := <-settingsRequests:
+			if req.patch == nil {
+				req.reply <- settingsReply{settings: settings}
+				continue
+			}
+			s := settings
+			s.Tags = map[string]string{}
+			for k, v := range settings.Tags {
+				s.Tags[k] = v
+			}
+			if err := s.merge(req.patch); err != nil {
+				req.reply <- settingsReply{err: err}
+				continue
+			}
+			changeSettings(s)
+			req.reply <- settingsReply{settings: s}
+		case req := <-ctlRequests:
+			switch req.args[0] {
+			case "status":
+				snap := state.get()
+				req.reply <- fmt.Sprintf("device: %s\npaused: %t\ninterval: %s\nlog level: %s\nlast reading: %s cpm=%d doseRate=%f\ncumulative dose: %f µSv since %s\ntotal counts: %d\nmissed frames: %d\nfailed writes: %d\ncurrent interval: %d samples, %d counts\n",
+					snap.Version, paused, settings.Interval, logLevel.Level(), snap.Time.Format(time.RFC3339), snap.CPM, snap.DoseRate, snap.CumulativeDose, snap.DoseSince.Format(time.RFC3339),
+					counters.TotalCounts, counters.MissedFrames+missedFrames.Load(), counters.FailedWrites+influxOut.failed.Load(), p.samples, p.sum)
+			case "pause":
+				paused = true
+				p.reset()
+				p.recent.reset()
+				req.reply <- "collection paused\n"
+			case "resume":
+				if p.heartbeatOff.Load() {
+					req.reply <- "error: the heartbeat is off, see heartbeat on\n"
+					break
+				}
+				paused = false
+				p.reset()
+				timer.Reset(time.Duration(settings.Interval))
+				req.reply <- "collection resumed\n"
+			case "heartbeat":
+				// switches the stream of the counter, the collection is
+				// paused while it is off
+				if len(req.args) != 2 || req.args[1] != "on" && req.args[1] != "off" {
+					req.reply <- "error: usage: heartbeat on|off\n"
+				} else if readOpts.mode != "heartbeat" {
+					req.reply <- "error: the counter is polled, see -mode\n"
+				} else if req.args[1] == "off" {
+					p.heartbeatOff.Store(true)
+					paused = true
+					p.reset()
+					p.recent.reset()
+					if err := dev.DisableHeartbeat(); err != nil {
+						req.reply <- fmt.Sprintf("error: %v\n", err)
+					} else {
+						req.reply <- "heartbeat off, collection paused\n"
+					}
+				} else {
+					// a counter that does not stream yet is retried by
+					// the reader, as on startup
+					p.heartbeatOff.Store(false)
+					paused = false
+					p.reset()
+					timer.Reset(time.Duration(settings.Interval))
+					if err := dev.EnableHeartbeat(); err != nil {
+						req.reply <- fmt.Sprintf("error: %v\n", err)
+					} else {
+						req.reply <- "heartbeat on, collection resumed\n"
+					}
+				}
+			case "flush":
+				flush()
+				timer.Reset(time.Duration(settings.Interval))
+				req.reply <- "flushed\n"
+			case "history":
+				if backfill() {
+					req.reply <- "history download started\n"
+				} else {
+					req.reply <- "error: history download already running\n"
+				}
+			case "sync-clock":
+				if err := dev.SetDateTime(time.Now()); err != nil {
+					req.reply <- fmt.Sprintf("error: %v\n", err)
+				} else {
+					req.reply <- "device clock synchronized\n"
+				}
+			case "interval":
+				var d time.Duration
+				if len(req.args) == 2 {
+					d, err = time.ParseDuration(req.args[1])
+				}
+				if len(req.args) != 2 || err != nil || d < time.Second {
+					req.reply <- "error: usage: interval <duration of at least 1s>\n"
+				} else {
+					s := settings
+					s.Interval = duration(d)
+					changeSettings(s)
+					req.reply <- fmt.Sprintf("interval set to %s\n", d)
+				}
+			case "silence":
+				var d time.Duration
+				if len(req.args) == 2 || len(req.args) == 3 {
+					d, err = time.ParseDuration(req.args[1])
+				}
+				if len(req.args) < 2 || len(req.args) > 3 || err != nil || d <= 0 {
+					req.reply <- "error: usage: silence <duration> [rule]\n"
+				} else {
+					rule, what := "", "alerts"
+					if len(req.args) == 3 {
+						rule, what = req.args[2], "alert "+req.args[2]
+					}
+					alerts.silence(rule, d)
+					req.reply <- fmt.Sprintf("%s silenced until %s\n", what, time.Now().Add(d).Format(time.RFC3339))
+				}
+			case "unsilence":
+				if len(req.args) > 2 {
+					req.reply <- "error: usage: unsilence [rule]\n"
+				} else {
+					rule := ""
+					if len(req.args) == 2 {
+						rule = req.args[1]
+					}
+					alerts.silence(rule, 0)
+					req.reply <- "silence lifted\n"
+				}
+			case "alerts":
+				req.reply <- alerts.status()
+			case "loglevel":
+				var level slog.Level
+				if len(req.args) != 2 {
+					req.reply <- "error: usage: loglevel debug|info|warn|error\n"
+				} else if err := level.UnmarshalText([]byte(req.args[1])); err != nil {
+					req.reply <- fmt.Sprintf("error: %v\n", err)
+				} else {
+					logLevel.Set(level)
+					req.reply <- fmt.Sprintf("log level set to %s\n", level)
+				}
+			case "key":
+				var n int
+				if len(req.args) == 2 {
+					n, err = strconv.Atoi(req.args[1])
+				}
+				if len(req.args) != 2 || err != nil {
+					req.reply <- fmt.Sprintf("error: usage: key <0-%d>\n", gqgmc.Keys-1)
+				} else if err := dev.PressKey(n); err != nil {
+					req.reply <- fmt.Sprintf("error: %v\n", err)
+				} else {
+					req.reply <- fmt.Sprintf("key %d pressed\n", n)
+				}
+			case "send":
+				// an ad-hoc command, answered in hex, only from the host
+				var n int
+				timeout := time.Duration(0)
+				if !req.local() {
+					req.reply <- "error: send is only accepted on the control socket and the console\n"
+					break
+				}
+				if len(req.args) == 3 || len(req.args) == 4 {
+					n, err = strconv.Atoi(req.args[2])
+					if err == nil && len(req.args) == 4 {
+						timeout, err = time.ParseDuration(req.args[3])
+					}
+				}
+				if len(req.args) < 3 || len(req.args) > 4 || err != nil || n < 0 {
+					req.reply <- "error: usage: send <command> <response length> [timeout]\n"
+				} else if resp, err := dev.SendCommand(req.args[1], n, timeout); err != nil {
+					req.reply <- fmt.Sprintf("error: %v\n", err)
+				} else if n == 0 {
+					req.reply <- "sent\n"
+				} else {
+					req.reply <- fmt.Sprintf("%x\n", resp)
+				}
+			default:
+				req.reply <- fmt.Sprintf("error: unknown command %q\n", req.args[0])
 			}
-			cpm = 0
 		}
 	}
 }
 
-func sendToInflux(influxClient influxdb.Client, cpm int, doseRate float64) error {
-	// Create a new point batch
-	bp, err := influxdb.NewBatchPoints(influxdb.BatchPointsConfig{
-		Database:  "sensors",
-		Precision: "s",
-	})
-	if err != nil {
-		return err
+// deviceCalibration returns tube converting with the calibration curve
+// stored on the device, which the owner may have adjusted. tube is returned
+// unchanged if the calibration cannot be read.
+func deviceCalibration(dev *gqgmc.Device, tube gqgmc.Tube) gqgmc.Tube {
+	c, err := dev.Config()
+	if err == nil {
+		tube.Curve, err = c.CalibrationCurve()
 	}
-	tags := map[string]string{"location": "Office"}
-	fields := map[string]interface{}{}
-	fields["geiger_counter_cpm"] = cpm
-	fields["geiger_counter_dose_rate"] = doseRate
-
-	pt, err := influxdb.NewPoint("measurements", tags, fields, time.Now())
 	if err != nil {
-		return err
+		slog.Warn("device calibration unreadable, using the factor of the tube", "err", err, "factor", tube.Factor)
+		return tube
 	}
-	bp.AddPoint(pt)
+	slog.Info("using the calibration of the device", "curve", fmt.Sprint(tube.Curve))
+	return tube
+}
 
-	// Write the batch
-	if err := influxClient.Write(bp); err != nil {
-		return err
+// deviceIDTags returns the model and serial number of the device, which
+// tell multiple counters apart. Unknown values are left out.
+func deviceIDTags(dev *gqgmc.Device) map[string]string {
+	tags := map[string]string{}
+	if model, err := dev.Model(); err == nil {
+		tags["model"] = model
 	}
-	return nil
+	serial, err := dev.Serial()
+	if err != nil {
+		slog.Warn("serial number", "err", err)
+	} else {
+		tags["serial"] = serial
+	}
+	return tags
 }
 
-type loggingReadWriter struct {
-	rw io.ReadWriter
+// mergeTags returns the union of the tag sets, later sets win.
+func mergeTags(sets ...map[string]string) map[string]string {
+	tags := map[string]string{}
+	for _, set := range sets {
+		for k, v := range set {
+			tags[k] = v
+		}
+	}
+	return tags
 }
 
-func (l *loggingReadWriter) Read(p []byte) (n int, err error) {
-	n, err = l.rw.Read(p)
-	log.Printf("loggingReadWriter: Read %d bytes: %x", n, p[0:n])
-	return
+// addEnvironmentFields adds the temperature and orientation of the device to
+// the fields of a measurement. Sensors the model lacks are skipped.
+func addEnvironmentFields(dev *gqgmc.Device, fields map[string]interface{}) {
+	if t, err := dev.Temperature(); err == nil {
+		fields["geiger_counter_temperature"] = t
+	} else if !errors.Is(err, gqgmc.ErrUnsupported) {
+		slog.Warn("temperature", "err", err)
+	}
+	if g, err := dev.Gyro(); err == nil {
+		fields["geiger_counter_gyro_x"] = int(g.X)
+		fields["geiger_counter_gyro_y"] = int(g.Y)
+		fields["geiger_counter_gyro_z"] = int(g.Z)
+	} else if !errors.Is(err, gqgmc.ErrUnsupported) {
+		slog.Warn("gyro", "err", err)
+	}
 }
 
-func (l *loggingReadWriter) Write(p []byte) (n int, err error) {
-	n, err = l.rw.Write(p)
-	log.Printf("loggingReadWriter: Wrote %d bytes: %x", n, p[0:n])
-	return
+// syncDeviceClock pushes the host time to the device, keeping the timestamps
+// of offline logged history accurate.
+func syncDeviceClock(dev *gqgmc.Device) {
+	if err := dev.SetDateTime(time.Now()); err != nil {
+		slog.Error("sync clock", "err", err)
+		return
+	}
+	slog.Info("device clock synchronized")
 }
 
-type fakeSerial struct {
+// writeHousekeeping writes the device health telemetry to its own
+// measurement, separate from the radiation data.
+func writeHousekeeping(influxClient influxdb.Client, database string, tags map[string]string, t gqgmc.Telemetry, settings *deviceSettings) error {
+	build := currentBuild()
+	fields := map[string]interface{}{"firmware": t.Version, "collector_version": build.Version}
+	if build.Commit != "" {
+		fields["collector_commit"] = build.Commit
+	}
+	if t.Voltage != nil {
+		fields["voltage"] = *t.Voltage
+	}
+	if t.Temperature != nil {
+		fields["temperature"] = *t.Temperature
+	}
+	if t.Gyro != nil {
+		fields["gyro_x"] = int(t.Gyro.X)
+		fields["gyro_y"] = int(t.Gyro.Y)
+		fields["gyro_z"] = int(t.Gyro.Z)
+	}
+	if t.Clock != nil {
+		fields["clock_drift_seconds"] = t.ClockDrift.Seconds()
+	}
+	settings.addFields(fields)
+	return sendToInflux(influxClient, database, "housekeeping", tags, fields, time.Now())
 }
 
-func (l *fakeSerial) Read(p []byte) (n int, err error) {
-	p[0] = 0x80
-	p[1] = 0x00
-	return 2, nil
+// telemetryMetrics returns the values of t the alert rules can refer to.
+// The clock drift is reported as an absolute value.
+func telemetryMetrics(t gqgmc.Telemetry) map[string]float64 {
+	m := map[string]float64{}
+	if t.Voltage != nil {
+		m["battery_voltage"] = *t.Voltage
+	}
+	if t.Temperature != nil {
+		m["temperature"] = *t.Temperature
+	}
+	if t.Clock != nil {
+		m["clock_drift_seconds"] = math.Abs(t.ClockDrift.Seconds())
+	}
+	return m
 }
 
-func (l *fakeSerial) Write(p []byte) (n int, err error) {
-	return len(p), nil
-}
+func sendToInflux(influxClient influxdb.Client, database, measurement string, tags map[string]string, fields map[string]interface{}, t time.Time) error {
+	// Create a new point batch
+	bp, err := influxdb.NewBatchPoints(influxdb.BatchPointsConfig{
+		Database:  database,
+		Precision: "s",
+	})
+	if err != nil {
+		return err
+	}
+	pt, err := influxdb.NewPoint(measurement, tags, fields, t)
+	if err != nil {
+		return err
+	}
+	bp.AddPoint(pt)
 
-func (l *fakeSerial) Close() error {
+	// Write the batch
+	if err := influxClient.Write(bp); err != nil {
+		return err
+	}
 	return nil
 }
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+
+	"github.com/hashicorp/mdns"
+)
+
+// mdnsService is the DNS-SD service type under which the REST API is
+// advertised.
+const mdnsService = "_gq-gmc._tcp"
+
+// advertiseMDNS announces the HTTP server listening on addr. The TXT record
+// carries the API path and the device model so clients can pick a counter
+// without connecting first.
+func advertiseMDNS(addr, device string) (*mdns.Server, error) {
+	ipStr, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return nil, err
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+	ips, err := advertisedIPs(net.ParseIP(ipStr))
+	if err != nil {
+		return nil, err
+	}
+	txt := []string{"path=/api/v1/current", "device=" + device}
+	svc, err := mdns.NewMDNSService(fmt.Sprintf("gq-gmc on %s", host), mdnsService, "", "", port, ips, txt)
+	if err != nil {
+		return nil, err
+	}
+	return mdns.NewServer(&mdns.Config{Zone: svc})
+}
+
+// advertisedIPs returns the listen address if it is specific, otherwise the
+// addresses of all interfaces. Resolving the host name, which the mdns
+// package would do instead, fails on many small installations.
+func advertisedIPs(listen net.IP) ([]net.IP, error) {
+	if listen != nil && !listen.IsUnspecified() {
+		return []net.IP{listen}, nil
+	}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil, err
+	}
+	var ips []net.IP
+	for _, a := range addrs {
+		if n, ok := a.(*net.IPNet); ok && !n.IP.IsLoopback() && !n.IP.IsLinkLocalUnicast() {
+			ips = append(ips, n.IP)
+		}
+	}
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("no addresses to advertise")
+	}
+	return ips, nil
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// defaultModbusRegisters is the register map used unless -modbusRegisters is
+// given.
+const defaultModbusRegisters = "0=cpm:u32,2=dose_rate:u32*1000,4=cumulative_dose:u32*1000,6=battery_voltage:u16*1000,7=external_power:u16"
+
+// modbusRegister maps a metric to one or two holding registers.
+type modbusRegister struct {
+	addr   uint16
+	metric string
+	typ    string // u16, u32 or f32; 32 bit values use big endian word order
+	scale  float64
+}
+
+// parseModbusRegisters parses a comma separated list of
+// <addr>=<metric>[:<type>][*<scale>] entries.
+func parseModbusRegisters(s string) ([]modbusRegister, error) {
+	var regs []modbusRegister
+	for _, entry := range strings.Split(s, ",") {
+		addr, spec, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok {
+			return nil, fmt.Errorf("register %q: missing =", entry)
+		}
+		a, err := strconv.ParseUint(addr, 0, 16)
+		if err != nil {
+			return nil, fmt.Errorf("register %q: %w", entry, err)
+		}
+		r := modbusRegister{addr: uint16(a), metric: spec, typ: "u16", scale: 1}
+		if metric, scale, ok := strings.Cut(r.metric, "*"); ok {
+			r.scale, err = strconv.ParseFloat(scale, 64)
+			if err != nil {
+				return nil, fmt.Errorf("register %q: %w", entry, err)
+			}
+			r.metric = metric
+		}
+		if metric, typ, ok := strings.Cut(r.metric, ":"); ok {
+			r.metric, r.typ = metric, typ
+		}
+		switch r.typ {
+		case "u16", "u32", "f32":
+		default:
+			return nil, fmt.Errorf("register %q: unknown type %q", entry, r.typ)
+		}
+		regs = append(regs, r)
+	}
+	return regs, nil
+}
+
+// Modbus function and exception codes.
+const (
+	modbusReadHolding = 0x03
+	modbusReadInput   = 0x04
+
+	modbusIllegalFunction = 0x01
+	modbusIllegalValue    = 0x03
+)
+
+// modbusServer exposes the current readings as Modbus TCP holding (and
+// input) registers. Unmapped registers read as zero.
+type modbusServer struct {
+	registers []modbusRegister
+	state     *liveState
+}
+
+func (m *modbusServer) serve(l net.Listener) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				slog.Error("modbus", "err", err)
+			}
+			return
+		}
+		go m.handleConn(conn)
+	}
+}
+
+func (m *modbusServer) handleConn(conn net.Conn) {
+	defer conn.Close()
+	for {
+		// MBAP header: transaction id, protocol id, length, unit id
+		var hdr [7]byte
+		if _, err := io.ReadFull(conn, hdr[:]); err != nil {
+			return
+		}
+		length := int(binary.BigEndian.Uint16(hdr[4:]))
+		if binary.BigEndian.Uint16(hdr[2:]) != 0 || length < 2 || length > 254 {
+			return
+		}
+		pdu := make([]byte, length-1)
+		if _, err := io.ReadFull(conn, pdu); err != nil {
+			return
+		}
+		resp := m.handle(pdu)
+		out := make([]byte, 7, 7+len(resp))
+		copy(out, hdr[:])
+		binary.BigEndian.PutUint16(out[4:], uint16(len(resp)+1))
+		if _, err := conn.Write(append(out, resp...)); err != nil {
+			return
+		}
+	}
+}
+
+func (m *modbusServer) handle(pdu []byte) []byte {
+	fc := pdu[0]
+	if fc != modbusReadHolding && fc != modbusReadInput {
+		return []byte{fc | 0x80, modbusIllegalFunction}
+	}
+	if len(pdu) != 5 {
+		return []byte{fc | 0x80, modbusIllegalValue}
+	}
+	start := int(binary.BigEndian.Uint16(pdu[1:]))
+	count := int(binary.BigEndian.Uint16(pdu[3:]))
+	if count < 1 || count > 125 || start+count > 0x10000 {
+		return []byte{fc | 0x80, modbusIllegalValue}
+	}
+	words := m.image(start, count)
+	resp := []byte{fc, byte(2 * count)}
+	for _, w := range words {
+		resp = binary.BigEndian.AppendUint16(resp, w)
+	}
+	return resp
+}
+
+// image renders the registers [start, start+count).
+func (m *modbusServer) image(start, count int) []uint16 {
+	metrics := m.state.get().metrics()
+	words := make([]uint16, count)
+	for _, r := range m.registers {
+		v := metrics[r.metric] * r.scale
+		var enc []uint16
+		switch r.typ {
+		case "u16":
+			enc = []uint16{uint16(clamp(v, math.MaxUint16))}
+		case "u32":
+			u := uint32(clamp(v, math.MaxUint32))
+			enc = []uint16{uint16(u >> 16), uint16(u)}
+		case "f32":
+			u := math.Float32bits(float32(v))
+			enc = []uint16{uint16(u >> 16), uint16(u)}
+		}
+		for i, w := range enc {
+			if a := int(r.addr) + i - start; a >= 0 && a < count {
+				words[a] = w
+			}
+		}
+	}
+	return words
+}
+
+func clamp(v, max float64) float64 {
+	return math.Max(0, math.Min(math.Round(v), max))
+}
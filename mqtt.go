@@ -0,0 +1,210 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+type mqttConfig struct {
+	broker       string
+	clientID     string
+	user         string
+	password     string
+	passwordFile string
+	commandTopic string
+	topic        string // readings are published below it if set
+	schema       payloadSchema
+
+	// discovery is the Home Assistant discovery prefix. If set, the
+	// readings are announced as sensors and their availability is
+	// published on <topic>/status.
+	discovery string
+	model     string
+	version   string
+}
+
+func mqttFlags(fs *flag.FlagSet) *mqttConfig {
+	c := &mqttConfig{}
+	fs.StringVar(&c.broker, "mqttBroker", "", "MQTT broker URL, e.g. tcp://localhost:1883")
+	fs.StringVar(&c.clientID, "mqttClientID", defaultMQTTClientID(), "MQTT client ID")
+	fs.StringVar(&c.user, "mqttUser", "", "MQTT user name")
+	fs.StringVar(&c.password, "mqttPassword", "", "MQTT password")
+	fs.StringVar(&c.passwordFile, "mqttPasswordFile", "", "File holding the MQTT password, instead of -mqttPassword")
+	fs.StringVar(&c.commandTopic, "mqttCommandTopic", "", "MQTT topic accepting the remote commands sync-clock, interval, history, silence and unsilence, replies go to <topic>/result")
+	fs.StringVar(&c.topic, "mqttTopic", "", "MQTT topic the readings are published to, e.g. gq-gmc")
+	fs.StringVar(&c.discovery, "mqttDiscovery", "", "Home Assistant discovery prefix the readings are announced under, e.g. homeassistant")
+	return c
+}
+
+// override applies the mqtt section of the config file, except for the
+// settings given as flags.
+func (c *mqttConfig) override(cfg *fileConfig, set map[string]bool) {
+	m := &cfg.MQTT
+	if m.Broker != "" && !set["mqttBroker"] {
+		c.broker = m.Broker
+	}
+	if m.ClientID != "" && !set["mqttClientID"] {
+		c.clientID = m.ClientID
+	}
+	if m.User != "" && !set["mqttUser"] {
+		c.user = m.User
+	}
+	if m.Password != "" && !set["mqttPassword"] && !set["mqttPasswordFile"] {
+		c.password = m.Password
+	}
+	if m.Topic != "" && !set["mqttTopic"] {
+		c.topic = m.Topic
+	}
+	if m.CommandTopic != "" && !set["mqttCommandTopic"] {
+		c.commandTopic = m.CommandTopic
+	}
+	if m.Discovery != "" && !set["mqttDiscovery"] {
+		c.discovery = m.Discovery
+	}
+}
+
+// connectMQTT connects to the broker. Subscriptions are re-established by
+// the client after reconnects.
+func connectMQTT(cfg mqttConfig, requests chan<- ctlRequest) (mqtt.Client, error) {
+	opts := mqtt.NewClientOptions().
+		AddBroker(cfg.broker).
+		SetClientID(cfg.clientID).
+		SetUsername(cfg.user).
+		SetPassword(cfg.password).
+		SetAutoReconnect(true).
+		SetConnectRetry(true)
+	announce := cfg.discovery != "" && cfg.topic != ""
+	if announce {
+		opts.SetWill(cfg.topic+"/status", "offline", 1, true)
+	}
+	opts.SetOnConnectHandler(func(c mqtt.Client) {
+		if cfg.commandTopic != "" {
+			t := c.Subscribe(cfg.commandTopic, 1, func(c mqtt.Client, m mqtt.Message) {
+				go handleMQTTCommand(c, cfg.commandTopic, m, requests)
+			})
+			if t.Wait() && t.Error() != nil {
+				slog.Error("mqtt: subscribe", "topic", cfg.commandTopic, "err", t.Error())
+			}
+		}
+		if announce {
+			go announceHass(c, cfg)
+		}
+	})
+	c := mqtt.NewClient(opts)
+	t := c.Connect()
+	if !t.WaitTimeout(10 * time.Second) {
+		slog.Warn("mqtt: broker not reachable yet, retrying in the background", "broker", cfg.broker)
+	} else if t.Error() != nil {
+		return nil, t.Error()
+	}
+	return c, nil
+}
+
+// mqttOutput publishes the readings as retained messages below the topic,
+// those of a further counter below the topic of its route or below
+// <topic>/<counter>. The connection stays open for the commands when the
+// output is closed.
+type mqttOutput struct {
+	c   mqtt.Client
+	cfg mqttConfig
+}
+
+func (o *mqttOutput) write(r reading) error {
+	topic := o.cfg.topic
+	switch {
+	case r.Topic != "":
+		topic = r.Topic
+	case r.Counter != "":
+		topic += "/" + r.Counter
+	}
+	msgs, err := o.cfg.schema.readingMessages(topic, r.State)
+	if err != nil {
+		return err
+	}
+	for _, m := range msgs {
+		if t := o.c.Publish(m.topic, 0, true, m.payload); t.Wait() && t.Error() != nil {
+			return fmt.Errorf("publish %s: %w", m.topic, t.Error())
+		}
+	}
+	return nil
+}
+
+func (o *mqttOutput) Close() error { return nil }
+
+// announceHass publishes the Home Assistant discovery messages and marks the
+// collector online. It runs after every connect so that the announcement
+// survives restarts of Home Assistant's broker.
+func announceHass(c mqtt.Client, cfg mqttConfig) {
+	msgs, err := hassDiscoveryMessages(cfg.discovery, cfg.topic, cfg.model, cfg.version)
+	if err != nil {
+		slog.Error("mqtt", "err", err)
+		return
+	}
+	msgs = append(msgs, message{cfg.topic + "/status", []byte("online")})
+	for _, m := range msgs {
+		if t := c.Publish(m.topic, 1, true, m.payload); t.Wait() && t.Error() != nil {
+			slog.Error("mqtt: publish", "topic", m.topic, "err", t.Error())
+		}
+	}
+}
+
+// disconnectMQTT marks the collector offline if it announced itself and
+// disconnects. The will only covers connections that break.
+func disconnectMQTT(c mqtt.Client, cfg mqttConfig) {
+	if cfg.discovery != "" && cfg.topic != "" {
+		c.Publish(cfg.topic+"/status", 1, true, "offline").WaitTimeout(time.Second)
+	}
+	c.Disconnect(250)
+}
+
+// mqttCommands are the commands of the control socket accepted on the
+// command topic. Anyone who may publish to the broker can send them, so
+// they are limited to the remote management of a site; the others, which
+// control the device or the collection, need the control socket or the
+// API token.
+var mqttCommands = map[string]bool{
+	"sync-clock": true,
+	"interval":   true,
+	"history":    true,
+	"silence":    true,
+	"unsilence":  true,
+}
+
+// handleMQTTCommand executes a command line received on the command topic
+// and publishes the reply to <topic>/result.
+func handleMQTTCommand(c mqtt.Client, topic string, m mqtt.Message, requests chan<- ctlRequest) {
+	reply := mqttCommandReply(m.Payload(), requests)
+	if reply == "" {
+		return
+	}
+	if t := c.Publish(topic+"/result", 1, false, reply); t.Wait() && t.Error() != nil {
+		slog.Error("mqtt: publish result", "err", t.Error())
+	}
+}
+
+// mqttCommandReply passes a command of mqttCommands to the main loop and
+// returns its reply, an error for the other commands and nothing for an
+// empty payload.
+func mqttCommandReply(payload []byte, requests chan<- ctlRequest) string {
+	args := strings.Fields(string(payload))
+	if len(args) == 0 {
+		return ""
+	}
+	if !mqttCommands[args[0]] {
+		slog.Warn("mqtt: command refused", "command", args[0])
+		return fmt.Sprintf("error: command %q is not accepted over MQTT\n", args[0])
+	}
+	slog.Info("mqtt: command", "command", strings.Join(args, " "))
+	req := ctlRequest{args: args, origin: fromMQTT, reply: make(chan string, 1)}
+	requests <- req
+	return <-req.reply
+}
+
+func defaultMQTTClientID() string {
+	return fmt.Sprintf("gq-gmc-%d", time.Now().UnixNano()%100000)
+}
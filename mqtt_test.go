@@ -0,0 +1,66 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMQTTCommandReply(t *testing.T) {
+	tests := []struct {
+		payload string
+		want    string // the command reaching the main loop, empty if none
+		reply   string
+	}{
+		{"sync-clock", "sync-clock", "ok\n"},
+		{"interval 30s", "interval 30s", "ok\n"},
+		{"history", "history", "ok\n"},
+		{"silence 1h high_cpm", "silence 1h high_cpm", "ok\n"},
+		{"unsilence", "unsilence", "ok\n"},
+		{"pause", "", "error: command \"pause\" is not accepted over MQTT\n"},
+		{"key 1", "", "error: command \"key\" is not accepted over MQTT\n"},
+		{"loglevel debug", "", "error: command \"loglevel\" is not accepted over MQTT\n"},
+		{"  ", "", ""},
+	}
+	for _, tt := range tests {
+		requests := make(chan ctlRequest)
+		var got string
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for req := range requests {
+				got = strings.Join(req.args, " ")
+				req.reply <- "ok\n"
+			}
+		}()
+		reply := mqttCommandReply([]byte(tt.payload), requests)
+		close(requests)
+		<-done
+		if reply != tt.reply || got != tt.want {
+			t.Errorf("mqttCommandReply(%q) = %q, main loop got %q; want %q, %q", tt.payload, reply, got, tt.reply, tt.want)
+		}
+	}
+}
+
+func TestMQTTCannotSend(t *testing.T) {
+	requests := make(chan ctlRequest, 1)
+	reply := mqttCommandReply([]byte("send <GETVER>> 14"), requests)
+	if !strings.HasPrefix(reply, "error: ") {
+		t.Errorf("send over MQTT replied %q, want an error", reply)
+	}
+	select {
+	case req := <-requests:
+		t.Errorf("send over MQTT reached the main loop: %q", req.args)
+	default:
+	}
+
+	// the commands that do reach the main loop carry their origin, which
+	// the main loop refuses send for
+	go func() {
+		req := <-requests
+		if req.origin != fromMQTT || req.local() {
+			t.Errorf("request of the command topic has origin %d, local %t", req.origin, req.local())
+		}
+		req.reply <- "ok\n"
+	}()
+	mqttCommandReply([]byte("history"), requests)
+}
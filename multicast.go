@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net"
+	"time"
+)
+
+// multicastSender broadcasts every heartbeat sample to a UDP multicast group.
+// The default TTL of 1 keeps the datagrams on the local network.
+//
+// The binary format is 16 bytes: "GQ", format version 1, a reserved zero
+// byte, the receive time as big endian int64 Unix milliseconds and the
+// counts as big endian uint32.
+type multicastSender struct {
+	conn   *net.UDPConn
+	binary bool
+	device string
+}
+
+func newMulticastSender(addr, format, device string) (*multicastSender, error) {
+	if format != "json" && format != "binary" {
+		return nil, fmt.Errorf("unknown format %q", format)
+	}
+	group, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if !group.IP.IsMulticast() {
+		return nil, fmt.Errorf("%s is not a multicast address", group.IP)
+	}
+	conn, err := net.DialUDP("udp", nil, group)
+	if err != nil {
+		return nil, err
+	}
+	return &multicastSender{conn: conn, binary: format == "binary", device: device}, nil
+}
+
+func (m *multicastSender) send(t time.Time, cps int) error {
+	var msg []byte
+	if m.binary {
+		msg = make([]byte, 16)
+		copy(msg, "GQ\x01\x00")
+		binary.BigEndian.PutUint64(msg[4:], uint64(t.UnixMilli()))
+		binary.BigEndian.PutUint32(msg[12:], uint32(cps))
+	} else {
+		var err error
+		msg, err = json.Marshal(struct {
+			Time   time.Time `json:"time"`
+			CPS    int       `json:"cps"`
+			Device string    `json:"device"`
+		}{t, cps, m.device})
+		if err != nil {
+			return err
+		}
+	}
+	_, err := m.conn.Write(msg)
+	return err
+}
+
+func (m *multicastSender) Close() error {
+	return m.conn.Close()
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// otlpExporter pushes the readings as OpenTelemetry gauges to the
+// /v1/metrics endpoint of a collector, using OTLP over HTTP with the JSON
+// encoding. The tags of the readings become resource attributes.
+type otlpExporter struct {
+	url     string
+	headers map[string]string // e.g. for authentication
+}
+
+// OTLP JSON messages, limited to what the exporter sends.
+type (
+	otlpAttribute struct {
+		Key   string `json:"key"`
+		Value struct {
+			StringValue string `json:"stringValue"`
+		} `json:"value"`
+	}
+	otlpDataPoint struct {
+		TimeUnixNano string  `json:"timeUnixNano"`
+		AsDouble     float64 `json:"asDouble"`
+	}
+	otlpMetric struct {
+		Name        string `json:"name"`
+		Description string `json:"description"`
+		Unit        string `json:"unit"`
+		Gauge       struct {
+			DataPoints []otlpDataPoint `json:"dataPoints"`
+		} `json:"gauge"`
+	}
+	otlpScopeMetrics struct {
+		Scope struct {
+			Name    string `json:"name"`
+			Version string `json:"version"`
+		} `json:"scope"`
+		Metrics []otlpMetric `json:"metrics"`
+	}
+	otlpResourceMetrics struct {
+		Resource struct {
+			Attributes []otlpAttribute `json:"attributes"`
+		} `json:"resource"`
+		ScopeMetrics []otlpScopeMetrics `json:"scopeMetrics"`
+	}
+	otlpRequest struct {
+		ResourceMetrics []otlpResourceMetrics `json:"resourceMetrics"`
+	}
+)
+
+// newOTLPExporter returns an exporter for the collector at endpoint, e.g.
+// http://localhost:4318. headers are comma-separated key=value pairs.
+func newOTLPExporter(endpoint, headers string) (*otlpExporter, error) {
+	e := &otlpExporter{url: strings.TrimSuffix(endpoint, "/") + "/v1/metrics", headers: map[string]string{}}
+	for _, h := range strings.Split(headers, ",") {
+		if h = strings.TrimSpace(h); h == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(h, "=")
+		if !ok {
+			return nil, fmt.Errorf("header %q is not key=value", h)
+		}
+		e.headers[strings.TrimSpace(k)] = strings.TrimSpace(v)
+	}
+	return e, nil
+}
+
+func (e *otlpExporter) write(r reading) error {
+	attrs := []otlpAttribute{otlpString("service.name", "gq-gmc")}
+	if r.State.Version != "" {
+		attrs = append(attrs, otlpString("device", r.State.Version))
+	}
+	keys := make([]string, 0, len(r.Tags))
+	for k := range r.Tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		attrs = append(attrs, otlpString(k, r.Tags[k]))
+	}
+	ts := strconv.FormatInt(r.Time.UnixNano(), 10)
+	gauge := func(name, description, unit string, v float64) otlpMetric {
+		m := otlpMetric{Name: name, Description: description, Unit: unit}
+		m.Gauge.DataPoints = []otlpDataPoint{{TimeUnixNano: ts, AsDouble: v}}
+		return m
+	}
+
+	var sm otlpScopeMetrics
+	sm.Scope.Name, sm.Scope.Version = "github.com/mwuertinger/gq-gmc", currentBuild().Version
+	sm.Metrics = []otlpMetric{
+		gauge("geiger.cpm", "Counts per minute", "{count}/min", float64(r.CPM)),
+		gauge("geiger.dose_rate", "Dose rate", "uSv/h", r.DoseRate),
+	}
+	rm := otlpResourceMetrics{ScopeMetrics: []otlpScopeMetrics{sm}}
+	rm.Resource.Attributes = attrs
+
+	body, err := json.Marshal(otlpRequest{ResourceMetrics: []otlpResourceMetrics{rm}})
+	if err != nil {
+		return err
+	}
+	hr, err := http.NewRequest(http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	hr.Header.Set("Content-Type", "application/json")
+	for k, v := range e.headers {
+		hr.Header.Set(k, v)
+	}
+	resp, err := uploadClient.Do(hr)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+func otlpString(key, value string) otlpAttribute {
+	a := otlpAttribute{Key: key}
+	a.Value.StringValue = value
+	return a
+}
+
+func (e *otlpExporter) Close() error { return nil }
@@ -0,0 +1,379 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// outputQueue is the number of readings an output may fall behind before
+// further readings are dropped for it.
+const outputQueue = 64
+
+// reading is the result of an interval as written to the outputs.
+type reading struct {
+	Time time.Time
+	// Counter is the base name of the serial port of a further counter of
+	// the config file, empty for the first counter.
+	Counter string
+	// Topic is the MQTT topic of the route of a further counter, empty
+	// without one.
+	Topic    string
+	CPM      int
+	DoseRate float64 // µSv/h
+	Tags     map[string]string
+	// Fields are those of the InfluxDB point, the above and what the flags
+	// add, like the battery voltage or the position.
+	Fields map[string]interface{}
+	// State is the live state after the reading, which the publishers send.
+	State snapshot
+}
+
+// output receives the reading of every interval: the stores like
+// fileLogger and sqliteStore, influxOutput and the publishers like
+// mqttOutput. The servers answering from the live state, like the REST API
+// and Prometheus, are not outputs.
+type output interface {
+	write(r reading) error
+	Close() error
+}
+
+// outputConfig is an output of the config file, e.g.
+//
+//	outputs:
+//	  archive:
+//	    file: /var/lib/gq-gmc/readings.csv
+//	    max_size: 0
+//	  local:
+//	    sqlite: /var/lib/gq-gmc/readings.db
+//	    retention: 720h
+//
+// Exactly one of File, SQLite and Postgres is set, the other settings
+// belong to one of them.
+type outputConfig struct {
+	File      string   `yaml:"file"`
+	Format    string   `yaml:"format"`   // of file: csv, the default, or ndjson
+	MaxSize   *int64   `yaml:"max_size"` // of file, bytes at which it is rotated, see -logFileMaxSize
+	SQLite    string   `yaml:"sqlite"`
+	Retention duration `yaml:"retention"` // of sqlite
+	Postgres  string   `yaml:"postgres"`
+	Table     string   `yaml:"table"` // of postgres, readings by default
+
+	counters bool // the config has further counters, see fileLogger
+}
+
+func (oc *outputConfig) validate() error {
+	kinds := 0
+	for _, set := range []bool{oc.File != "", oc.SQLite != "", oc.Postgres != ""} {
+		if set {
+			kinds++
+		}
+	}
+	if kinds != 1 {
+		return fmt.Errorf("needs exactly one of file, sqlite or postgres")
+	}
+	if f := oc.Format; f != "" && f != "csv" && f != "ndjson" {
+		return fmt.Errorf("format: unknown format %q", f)
+	}
+	if oc.MaxSize != nil && *oc.MaxSize < 0 {
+		return fmt.Errorf("max_size must not be negative")
+	}
+	return nil
+}
+
+// open opens the output, creating the file or table if missing.
+func (oc *outputConfig) open() (output, error) {
+	var out output
+	var err error
+	switch {
+	case oc.File != "":
+		format, maxSize := "csv", int64(10<<20)
+		if oc.Format != "" {
+			format = oc.Format
+		}
+		if oc.MaxSize != nil {
+			maxSize = *oc.MaxSize
+		}
+		out, err = newFileLogger(oc.File, format, maxSize, oc.counters)
+	case oc.SQLite != "":
+		out, err = newSQLiteStore(oc.SQLite, time.Duration(oc.Retention))
+	default:
+		table := "readings"
+		if oc.Table != "" {
+			table = oc.Table
+		}
+		out, err = newPostgresStore(oc.Postgres, table)
+	}
+	if err != nil {
+		// not the nil pointer of the store in an interface
+		return nil, err
+	}
+	return out, nil
+}
+
+// flagOutputs are the outputs of the flags, which go with those of the
+// config file under the names of their flags.
+type flagOutputs struct {
+	logFile, sqlite, postgres outputConfig
+	logFileMaxSize            int64
+}
+
+func outputFlags(fs *flag.FlagSet) *flagOutputs {
+	o := &flagOutputs{}
+	fs.StringVar(&o.logFile.File, "logFile", "", "File the reading of each interval is appended to, e.g. /var/lib/gq-gmc/readings.csv")
+	fs.StringVar(&o.logFile.Format, "logFileFormat", "csv", "Format of -logFile: csv or ndjson")
+	fs.Int64Var(&o.logFileMaxSize, "logFileMaxSize", 10<<20, "Size in bytes at which -logFile is rotated, 0 disables rotation")
+	o.logFile.MaxSize = &o.logFileMaxSize
+	fs.StringVar(&o.sqlite.SQLite, "sqlite", "", "SQLite database the reading of each interval is written to, e.g. /var/lib/gq-gmc/readings.db")
+	fs.DurationVar((*time.Duration)(&o.sqlite.Retention), "sqliteRetention", 0, "Age at which readings are deleted from -sqlite, 0 keeps them")
+	fs.StringVar(&o.postgres.Postgres, "postgres", "", "PostgreSQL connection string the reading of each interval is written to, e.g. postgres://gqgmc@localhost/sensors, the password may come from PGPASSWORD or ~/.pgpass")
+	fs.StringVar(&o.postgres.Table, "postgresTable", "readings", "Table of -postgres, created if missing and made a hypertable if TimescaleDB is installed")
+	return o
+}
+
+// override applies the settings of these outputs in the config file,
+// except for those given as flags.
+func (o *flagOutputs) override(cfg *fileConfig, set map[string]bool) {
+	if cfg.LogFile != "" && !set["logFile"] {
+		o.logFile.File = cfg.LogFile
+	}
+	if cfg.LogFileFormat != "" && !set["logFileFormat"] {
+		o.logFile.Format = cfg.LogFileFormat
+	}
+	if cfg.LogFileMaxSize != nil && !set["logFileMaxSize"] {
+		o.logFileMaxSize = *cfg.LogFileMaxSize
+	}
+	if cfg.SQLite != "" && !set["sqlite"] {
+		o.sqlite.SQLite = cfg.SQLite
+	}
+	if cfg.SQLiteRetention != 0 && !set["sqliteRetention"] {
+		o.sqlite.Retention = cfg.SQLiteRetention
+	}
+	if cfg.Postgres != "" && !set["postgres"] {
+		o.postgres.Postgres = cfg.Postgres
+	}
+	if cfg.PostgresTable != "" && !set["postgresTable"] {
+		o.postgres.Table = cfg.PostgresTable
+	}
+}
+
+// merge returns the outputs of the config file together with those of the
+// flags that are set.
+func (o *flagOutputs) merge(configs map[string]outputConfig) (map[string]outputConfig, error) {
+	merged := map[string]outputConfig{}
+	for name, oc := range configs {
+		merged[name] = oc
+	}
+	for _, f := range []struct {
+		name string
+		oc   outputConfig
+	}{{"logFile", o.logFile}, {"sqlite", o.sqlite}, {"postgres", o.postgres}} {
+		if f.oc.File == "" && f.oc.SQLite == "" && f.oc.Postgres == "" {
+			continue
+		}
+		if _, ok := merged[f.name]; ok {
+			return nil, fmt.Errorf("output %s: name taken by -%s", f.name, f.name)
+		}
+		merged[f.name] = f.oc
+	}
+	return merged, nil
+}
+
+// publisherConfig are the publishers pushing the readings to other
+// systems, besides MQTT.
+type publisherConfig struct {
+	kafkaBrokers, kafkaTopic  string
+	otlpEndpoint, otlpHeaders string
+	graphite, statsd, prefix  string
+}
+
+func publisherFlags(fs *flag.FlagSet) *publisherConfig {
+	c := &publisherConfig{}
+	fs.StringVar(&c.kafkaBrokers, "kafkaBrokers", "", "Comma-separated Kafka brokers the readings are produced to as JSON, e.g. localhost:9092")
+	fs.StringVar(&c.kafkaTopic, "kafkaTopic", "gq-gmc", "Kafka topic of the readings")
+	fs.StringVar(&c.otlpEndpoint, "otlpEndpoint", "", "OpenTelemetry collector the readings are pushed to as gauges via OTLP/HTTP, e.g. http://localhost:4318")
+	fs.StringVar(&c.otlpHeaders, "otlpHeaders", "", "Comma-separated key=value HTTP headers of the OTLP requests, e.g. for authentication")
+	fs.StringVar(&c.graphite, "graphite", "", "Graphite server the readings are sent to in the plaintext protocol, e.g. localhost:2003 or udp://localhost:2003")
+	fs.StringVar(&c.statsd, "statsd", "", "StatsD server the readings are sent to as gauges over UDP, e.g. localhost:8125")
+	fs.StringVar(&c.prefix, "metricsPrefix", "geiger", "Prefix of the metric names for -graphite and -statsd")
+	return c
+}
+
+// override applies the publishers of the config file, except for the
+// settings given as flags.
+func (c *publisherConfig) override(cfg *fileConfig, set map[string]bool) {
+	if k := cfg.Kafka; len(k.Brokers) > 0 && !set["kafkaBrokers"] {
+		c.kafkaBrokers = strings.Join(k.Brokers, ",")
+	}
+	if cfg.Kafka.Topic != "" && !set["kafkaTopic"] {
+		c.kafkaTopic = cfg.Kafka.Topic
+	}
+	if cfg.OTLP.Endpoint != "" && !set["otlpEndpoint"] {
+		c.otlpEndpoint = cfg.OTLP.Endpoint
+	}
+	if cfg.OTLP.Headers != "" && !set["otlpHeaders"] {
+		c.otlpHeaders = cfg.OTLP.Headers
+	}
+	if cfg.Graphite != "" && !set["graphite"] {
+		c.graphite = cfg.Graphite
+	}
+	if cfg.StatsD != "" && !set["statsd"] {
+		c.statsd = cfg.StatsD
+	}
+	if cfg.MetricsPrefix != "" && !set["metricsPrefix"] {
+		c.prefix = cfg.MetricsPrefix
+	}
+}
+
+// start passes the outputs of the configured publishers to add.
+func (c *publisherConfig) start(add func(name string, open func() (output, error))) error {
+	if c.kafkaBrokers != "" {
+		add("kafka", func() (output, error) { return &kafkaOutput{newKafkaWriter(c.kafkaBrokers, c.kafkaTopic)}, nil })
+	}
+	if c.otlpEndpoint != "" {
+		e, err := newOTLPExporter(c.otlpEndpoint, c.otlpHeaders)
+		if err != nil {
+			return fmt.Errorf("otlp: %w", err)
+		}
+		add("otlp", func() (output, error) { return e, nil })
+	}
+	if c.graphite != "" {
+		m := newGraphiteSender(c.graphite, c.prefix)
+		add("graphite", func() (output, error) { return m, nil })
+	}
+	if c.statsd != "" {
+		m := newStatsDSender(c.statsd, c.prefix)
+		add("statsd", func() (output, error) { return m, nil })
+	}
+	return nil
+}
+
+// outputWorker writes to an output in its own goroutine, so that a slow or
+// failing output neither holds up the main loop nor the other outputs. An
+// output that cannot be opened, e.g. a database that is down at boot, is
+// opened again with the next reading.
+type outputWorker struct {
+	name    string
+	open    func() (output, error)
+	out     output // nil until opened
+	ch      chan reading
+	done    chan struct{}
+	dropped atomic.Int64 // readings not written as the queue was full
+	failed  atomic.Int64 // writes that failed
+	failing atomic.Int64 // Unix nanoseconds of the first failed write in a row, 0 while writing
+}
+
+func startOutput(name string, open func() (output, error)) *outputWorker {
+	w := &outputWorker{name: name, open: open, ch: make(chan reading, outputQueue), done: make(chan struct{})}
+	var err error
+	if w.out, err = open(); err != nil {
+		slog.Error("output open", "output", name, "err", err)
+	}
+	go w.run()
+	return w
+}
+
+func (w *outputWorker) run() {
+	defer close(w.done)
+	failing := w.out == nil
+	for r := range w.ch {
+		err := w.write(r)
+		if err != nil {
+			w.failed.Add(1)
+			w.failing.CompareAndSwap(0, time.Now().UnixNano())
+		} else {
+			w.failing.Store(0)
+		}
+		switch {
+		case err != nil:
+			if !failing {
+				slog.Error("output write", "output", w.name, "err", err)
+			} else {
+				slog.Debug("output write", "output", w.name, "err", err)
+			}
+			failing = true
+		case failing:
+			slog.Info("output recovered", "output", w.name)
+			failing = false
+		}
+	}
+}
+
+func (w *outputWorker) write(r reading) error {
+	if w.out == nil {
+		out, err := w.open()
+		if err != nil {
+			return err
+		}
+		w.out = out
+	}
+	return w.out.write(r)
+}
+
+// failingSince returns when the writes started failing, or the zero time
+// while they succeed.
+func (w *outputWorker) failingSince() time.Time {
+	if ns := w.failing.Load(); ns != 0 {
+		return time.Unix(0, ns)
+	}
+	return time.Time{}
+}
+
+// send queues the reading, dropping it if the output is too far behind.
+func (w *outputWorker) send(r reading) {
+	select {
+	case w.ch <- r:
+	default:
+		if w.dropped.Add(1) == 1 {
+			slog.Warn("output behind, dropping readings", "output", w.name)
+		}
+	}
+}
+
+// Close writes the queued readings and closes the output.
+func (w *outputWorker) Close() error {
+	close(w.ch)
+	<-w.done
+	if w.out == nil {
+		return nil
+	}
+	return w.out.Close()
+}
+
+// openOutputs validates the outputs of the config file and starts them in
+// the order of their names, with dryRun as stand-ins logging the readings.
+func openOutputs(configs map[string]outputConfig, dryRun bool) ([]*outputWorker, error) {
+	names := make([]string, 0, len(configs))
+	for name := range configs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		oc := configs[name]
+		if err := oc.validate(); err != nil {
+			return nil, fmt.Errorf("output %s: %w", name, err)
+		}
+	}
+	var workers []*outputWorker
+	for _, name := range names {
+		oc, name := configs[name], name
+		open := oc.open
+		if dryRun {
+			open = func() (output, error) { return dryRunOutput{name}, nil }
+		}
+		workers = append(workers, startOutput(name, open))
+	}
+	return workers, nil
+}
+
+func closeOutputs(workers []*outputWorker) {
+	for _, w := range workers {
+		if err := w.Close(); err != nil {
+			slog.Error("output close", "output", w.name, "err", err)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// payloadSchema selects how readings and alerts are encoded for MQTT and
+// webhooks.
+//
+// The default schema publishes the reading as one JSON document and posts
+// alert events as they are. The nodered schema follows the Node-RED and
+// Homie conventions: every metric is published as a plain value on its own
+// subtopic, and JSON documents are flat with a "topic" field and Unix
+// millisecond timestamps, so flows need no transformation functions.
+type payloadSchema string
+
+const (
+	schemaDefault payloadSchema = "default"
+	schemaNodeRED payloadSchema = "nodered"
+)
+
+func parsePayloadSchema(s string) (payloadSchema, error) {
+	switch p := payloadSchema(s); p {
+	case schemaDefault, schemaNodeRED:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown payload schema %q", s)
+}
+
+// message is a payload for a topic.
+type message struct {
+	topic   string
+	payload []byte
+}
+
+// readingMessages encodes snap for publishing below topic.
+func (p payloadSchema) readingMessages(topic string, snap snapshot) ([]message, error) {
+	if p == schemaDefault {
+		b, err := json.Marshal(newCurrentReading(snap))
+		if err != nil {
+			return nil, err
+		}
+		return []message{{topic, b}}, nil
+	}
+
+	flat := map[string]interface{}{
+		"topic":     topic,
+		"device":    snap.Version,
+		"timestamp": snap.Time.UnixMilli(),
+	}
+	var msgs []message
+	for name, v := range snap.metrics() {
+		if name == "age_seconds" {
+			continue
+		}
+		flat[name] = v
+		msgs = append(msgs, message{topic + "/" + name, []byte(strconv.FormatFloat(v, 'g', -1, 64))})
+	}
+	b, err := json.Marshal(flat)
+	if err != nil {
+		return nil, err
+	}
+	return append(msgs, message{topic, b}), nil
+}
+
+// alertPayload encodes ev for a webhook.
+func (p payloadSchema) alertPayload(ev alertEvent) ([]byte, error) {
+	if p == schemaDefault {
+		return json.Marshal(ev)
+	}
+	return json.Marshal(map[string]interface{}{
+		"topic":     "alert/" + ev.Rule,
+		"payload":   ev.State,
+		"rule":      ev.Rule,
+		"severity":  ev.Severity,
+		"metric":    ev.Metric,
+		"value":     ev.Value,
+		"threshold": ev.Threshold,
+		"timestamp": ev.Time.UnixMilli(),
+	})
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// persistentState is the content of the -stateFile. It keeps the state of
+// the collector across restarts.
+type persistentState struct {
+	Alerts   map[string][]stageState `json:"alerts,omitempty"`
+	Counters *counterState           `json:"counters,omitempty"`
+}
+
+// counterState holds the long-running statistics of the collector.
+type counterState struct {
+	Since          time.Time   `json:"since"`           // start of the accumulation
+	CumulativeDose float64     `json:"cumulative_dose"` // µSv
+	TotalCounts    int64       `json:"total_counts"`
+	MissedFrames   int64       `json:"missed_frames"` // heartbeat frames lost to timeouts and read errors
+	FailedWrites   int64       `json:"failed_writes"` // intervals that could not be written to InfluxDB
+	Daily          *dailyState `json:"daily,omitempty"`
+	LastSample     time.Time   `json:"last_sample,omitempty"` // for the gap to the next run
+}
+
+// dailyState is the persisted form of dailyStats.
+type dailyState struct {
+	Start     time.Time `json:"start"`
+	Intervals int       `json:"intervals"`
+	Sum       float64   `json:"sum"`
+	Min       float64   `json:"min"`
+	Max       float64   `json:"max"`
+	DoseStart float64   `json:"dose_start"`
+}
+
+// loadState reads the state file. A missing file yields an empty state.
+func loadState(path string) (*persistentState, error) {
+	var p persistentState
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return &p, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &p); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &p, nil
+}
+
+func (p *persistentState) save(path string) error {
+	b, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, append(b, '\n'), 0600)
+}
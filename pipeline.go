@@ -0,0 +1,496 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	influxdb "github.com/influxdata/influxdb1-client/v2"
+	"github.com/mwuertinger/gq-gmc/pkg/gqgmc"
+)
+
+// readingConfig holds how the counters are read and what their readings
+// carry, which is the same for all counters.
+type readingConfig struct {
+	mode               string
+	pollInterval       time.Duration
+	stallTimeout       time.Duration
+	environment, stats bool
+	smoothing          time.Duration
+	doseUnits          string
+	cps, gaps          bool
+	burstTrigger       int
+	burstWindow        time.Duration
+	hourly             bool
+	aggregates         string
+	units              []doseUnit         // of doseUnits, set by check
+	windows            []*windowAggregate // of aggregates and hourly, set by check
+}
+
+func readingFlags(fs *flag.FlagSet) *readingConfig {
+	c := &readingConfig{}
+	fs.DurationVar(&c.smoothing, "smoothing", 0, "Time constant of an exponentially smoothed dose rate written as geiger_counter_dose_rate_smoothed next to the raw value, e.g. 5m, 0 disables")
+	fs.StringVar(&c.doseUnits, "doseUnits", "", "Further units the dose rate is written in as additional fields: mrh for mR/h, ngyh for nGy/h")
+	fs.BoolVar(&c.environment, "environmentFields", false, "Add the temperature and orientation of the device to every measurement, if the model has the sensors")
+	fs.BoolVar(&c.stats, "statsFields", false, "Add the minimum, maximum, mean and standard deviation of the counts per second in the interval to every measurement")
+	fs.BoolVar(&c.cps, "cpsPoints", false, "Also write every heartbeat sample as a geiger_counter_cps point stamped at receipt, for burst analysis")
+	fs.IntVar(&c.burstTrigger, "burstTrigger", 0, "Capture every heartbeat sample around samples of at least this many counts per second to the <measurement>_burst measurement, 0 disables")
+	fs.DurationVar(&c.burstWindow, "burstWindow", time.Minute, "Samples kept before and after a burst with -burstTrigger")
+	fs.BoolVar(&c.gaps, "gapMarkers", false, "Also write interruptions of the counts and the downtime between runs to the <measurement>_gaps measurement, tagged with the reason")
+	fs.BoolVar(&c.hourly, "hourlyAggregates", false, "Also write hourly mean, maximum and dose to the <measurement>_hourly measurement, see -influxMeasurement")
+	fs.StringVar(&c.aggregates, "aggregates", "", "Comma-separated periods like 10s,1m,15m,1h to also write mean, maximum and dose per period to the <measurement>_<period> measurements")
+	fs.StringVar(&c.mode, "mode", "heartbeat", "How counts are read: heartbeat streaming, or poll for firmware that streams unreliably")
+	fs.DurationVar(&c.pollInterval, "pollInterval", 5*time.Second, "Interval of the <GETCPM>> queries with -mode poll")
+	fs.DurationVar(&c.stallTimeout, "stallTimeout", defaultStallTimeout, "Reopen the port when no heartbeat frame arrived for this long, 0 disables")
+	return c
+}
+
+// override applies the config file, except for the options given as flags.
+func (c *readingConfig) override(cfg *fileConfig, set map[string]bool) {
+	if cfg.DoseUnits != "" && !set["doseUnits"] {
+		c.doseUnits = cfg.DoseUnits
+	}
+	if cfg.Smoothing != 0 && !set["smoothing"] {
+		c.smoothing = time.Duration(cfg.Smoothing)
+	}
+	if cfg.Mode != "" && !set["mode"] {
+		c.mode = cfg.Mode
+	}
+	if cfg.PollInterval != 0 && !set["pollInterval"] {
+		c.pollInterval = time.Duration(cfg.PollInterval)
+	}
+	if cfg.StallTimeout != nil && !set["stallTimeout"] {
+		c.stallTimeout = time.Duration(*cfg.StallTimeout)
+	}
+	if cfg.EnvironmentFields && !set["environmentFields"] {
+		c.environment = true
+	}
+	if cfg.HourlyAggregates && !set["hourlyAggregates"] {
+		c.hourly = true
+	}
+	if cfg.Aggregates != "" && !set["aggregates"] {
+		c.aggregates = cfg.Aggregates
+	}
+	if cfg.StatsFields && !set["statsFields"] {
+		c.stats = true
+	}
+	if cfg.GapMarkers && !set["gapMarkers"] {
+		c.gaps = true
+	}
+	if cfg.CPSPoints && !set["cpsPoints"] {
+		c.cps = true
+	}
+}
+
+// check validates the options and parses the units and the aggregate
+// windows.
+func (c *readingConfig) check(interval time.Duration) error {
+	switch c.mode {
+	case "heartbeat":
+		if c.stallTimeout < 0 {
+			return errors.New("-stallTimeout must not be negative")
+		}
+	case "poll":
+		if c.pollInterval < time.Second {
+			return errors.New("-pollInterval must be at least 1s")
+		}
+	default:
+		return fmt.Errorf("unknown -mode %q", c.mode)
+	}
+	if c.burstTrigger > 0 && c.burstWindow <= 0 {
+		return errors.New("-burstWindow must be positive")
+	}
+	var err error
+	if c.units, err = parseDoseUnits(c.doseUnits); err != nil {
+		return fmt.Errorf("-doseUnits: %w", err)
+	}
+	if c.windows, err = parseAggregates(c.aggregates); err != nil {
+		return fmt.Errorf("-aggregates: %w", err)
+	}
+	if c.hourly {
+		c.windows = append(c.windows, &windowAggregate{period: time.Hour, suffix: "hourly"})
+	}
+	for _, w := range c.windows {
+		if w.period < interval {
+			slog.Warn("aggregate period shorter than the interval, some windows stay empty", "period", w.period, "interval", interval)
+		}
+	}
+	return nil
+}
+
+// identify reads the version of the counter and selects its tube: by name,
+// with a calibration factor or curve, or as calibrated on the device.
+func identify(dev *gqgmc.Device, logger *slog.Logger, tube string, factor float64, curve string) (version string, profile gqgmc.Profile, dual bool, err error) {
+	profile = gqgmc.LookupProfile("")
+	if v, err := dev.Version(); err == nil {
+		version = v
+		profile = gqgmc.LookupProfile(v)
+		dual = gqgmc.LookupQuirks(v).DualTube
+		logger.Info("counter", "firmware", v, "tube", profile.Tube.Name)
+	} else {
+		logger.Warn("get version", "err", err)
+	}
+	if tube != "" {
+		if profile.Tube, err = parseTube(tube); err != nil {
+			return "", profile, false, fmt.Errorf("tube: %w", err)
+		}
+		logger.Info("tube selected", "tube", profile.Tube.Name, "factor", profile.Tube.Factor)
+	}
+	switch {
+	case factor > 0:
+		profile.Tube.Factor = factor
+	case curve != "":
+		if profile.Tube.Curve, err = parseCalibrationCurve(curve); err != nil {
+			return "", profile, false, fmt.Errorf("calibration curve: %w", err)
+		}
+	case tube != "":
+	default:
+		profile.Tube = deviceCalibration(dev, profile.Tube)
+	}
+	return version, profile, dual, nil
+}
+
+// pipeline reads a counter and turns its samples into a reading every
+// interval, which it sends to the outputs. serveCommand drives the pipeline
+// of the first counter from its main loop, which adds the live state, the
+// alerts and the counters of the state file; the further counters of the
+// config file each run their own, see run.
+type pipeline struct {
+	name    string // of a further counter, see reading.Counter
+	port    *reconnectingPort
+	dev     *gqgmc.Device
+	logger  *slog.Logger
+	version string
+	profile gqgmc.Profile
+	dual    bool // read the high dose tube too, see DualCPM
+	opts    *readingConfig
+	metrics *selfMetrics
+	influx  influxdb.Client // routed by the port
+	target  *influxConfig   // database and measurement
+	topic   string          // see reading.Topic
+	outputs []*outputWorker
+	feed    *countFeed // nil without -httpListen
+	gps     *gpsClient // nil without -gpsd
+	// the tags of the readings are the global ones, replaced by those of
+	// the counter and its route, its model and serial, and its mode
+	global, own, mode map[string]string
+
+	counts   *countRing           // from the reader
+	gapChan  chan gap             // from the reader
+	settings chan runtimeSettings // to run, see update
+	done     chan struct{}
+	// heartbeatOff is set while ctl heartbeat off stopped the stream, which
+	// the reader then waits out without taking the silence for lost frames
+	heartbeatOff atomic.Bool
+
+	// the interval: the sum and number of the samples, each covering a
+	// second, from start
+	sum, samples int
+	start        time.Time
+	cps          []countSample // with -cpsPoints
+	stats        countStats    // with -statsFields
+	recent       *countWindow  // the rate of intervals shorter than a minute
+	windows      []*windowAggregate
+	smoothed     *ewma // nil without -smoothing
+	bursts       *burstRecorder
+	batch        []countSample
+}
+
+func (p *pipeline) tags() map[string]string {
+	return mergeTags(p.global, p.own, p.mode)
+}
+
+// startReader starts reading the counts in the mode of the options, until
+// the port is closed.
+func (p *pipeline) startReader() {
+	p.counts = newCountRing(countRingSize)
+	p.gapChan = make(chan gap, 16)
+	p.start = time.Now()
+	p.recent = newCountWindow(cpmWindowSeconds)
+	for _, w := range p.opts.windows {
+		p.windows = append(p.windows, &windowAggregate{period: w.period, suffix: w.suffix})
+	}
+	if p.opts.smoothing > 0 {
+		p.smoothed = &ewma{tau: p.opts.smoothing}
+	}
+	if p.opts.burstTrigger > 0 {
+		p.bursts = &burstRecorder{trigger: p.opts.burstTrigger, window: p.opts.burstWindow}
+	}
+	go p.read()
+}
+
+func (p *pipeline) read() {
+	defer p.counts.close()
+	missed := &p.metrics.missed
+	gaps := newGapTracker(p.gapChan)
+	// reconnect waits until a lost port is reopened and restores the read
+	// mode. The seconds without a port count as missed frames.
+	reconnect := func() bool {
+		start := time.Now()
+		if !p.port.reconnect() {
+			return false
+		}
+		missed.Add(int64(time.Since(start) / time.Second))
+		if p.opts.mode == "heartbeat" && !p.heartbeatOff.Load() {
+			if err := p.dev.EnableHeartbeat(); err != nil {
+				p.logger.Error("enable heartbeat", "err", err)
+			}
+		}
+		return true
+	}
+	if p.opts.mode == "poll" {
+		pollCounts(p.dev, p.opts.pollInterval, p.counts, missed, gaps, reconnect)
+		return
+	}
+	stall := newStallWatchdog(p.logger, p.port, p.opts.stallTimeout)
+	rejected := 0 // invalid counts in a row
+	for {
+		val, err := p.dev.ReadCounts()
+		if errors.Is(err, errPortLost) {
+			missed.Add(1)
+			gaps.fail(gapPortLost)
+			if !reconnect() {
+				return
+			}
+			stall.reconnected()
+			continue
+		}
+		// After ReadTimeout no frame has arrived
+		if err == gqgmc.ErrTimeout && p.heartbeatOff.Load() {
+			stall.reconnected()
+			continue
+		}
+		if err == gqgmc.ErrTimeout {
+			missed.Add(1)
+			gaps.fail(gapTimeout)
+			stall.timedOut()
+			continue
+		}
+		stall.frame()
+		// invalid counts are dropped like lost frames, but logged once
+		// per run as a wedged device sends them every second
+		if errors.Is(err, gqgmc.ErrInvalid) {
+			missed.Add(1)
+			gaps.fail(gapReadError)
+			p.metrics.rejected.Add(1)
+			if errors.Is(err, gqgmc.ErrResynchronized) {
+				p.metrics.resyncs.Add(1)
+			}
+			if rejected++; rejected == 1 {
+				p.logger.Warn("rejected heartbeat", "err", err)
+			} else {
+				p.logger.Debug("rejected heartbeat", "err", err)
+			}
+			continue
+		}
+		if err != nil {
+			missed.Add(1)
+			gaps.fail(gapReadError)
+			p.metrics.readErrors.Add(1)
+			if errors.Is(err, gqgmc.ErrResynchronized) {
+				p.metrics.resyncs.Add(1)
+			}
+			p.logger.Warn("read error", "err", err)
+			continue
+		}
+		// the count bits of a frame with the reserved bits set are used
+		if n := p.dev.TakeReserved(); n > 0 {
+			p.metrics.rejected.Add(int64(n))
+			p.logger.Debug("heartbeat with reserved bits set", "count", val)
+		}
+		if rejected > 0 {
+			p.logger.Info("heartbeat counts valid again", "rejected", rejected)
+			rejected = 0
+		}
+		gaps.reading()
+		p.counts.push(val)
+	}
+}
+
+// drain returns the samples passed by the reader since the last call, and
+// false once the reader stopped. The samples are valid until the next call.
+func (p *pipeline) drain() ([]countSample, bool) {
+	var open bool
+	p.batch, open = p.counts.drain(p.batch[:0])
+	if n := p.counts.takeDropped(); n > 0 {
+		p.metrics.missed.Add(n)
+		p.metrics.overflows.Add(n)
+		p.logger.Warn("main loop behind, dropped samples", "samples", n)
+	}
+	return p.batch, open
+}
+
+// add adds a sample of the reader to the interval.
+func (p *pipeline) add(c countSample) {
+	p.logger.Debug("heartbeat", "counts", c.CPS)
+	p.sum += c.CPS
+	p.samples++
+	p.recent.add(c.CPS)
+	if p.opts.cps {
+		p.cps = append(p.cps, c)
+	}
+	p.stats.add(c.CPS)
+	if p.bursts != nil {
+		p.recordBurst(p.bursts.add(c))
+	}
+	if p.feed != nil {
+		p.feed.publish(countSample{Time: c.Time, CPS: c.CPS, Device: p.version})
+	}
+}
+
+// reading returns the reading of the interval, false without samples, and
+// writes the aggregates of the windows it completes.
+func (p *pipeline) reading(interval time.Duration) (reading, bool) {
+	if p.samples == 0 {
+		return reading{}, false
+	}
+	t := time.Now()
+	cpm := p.sum * 60 / p.samples
+	if interval < cpmWindowSeconds*time.Second {
+		cpm = p.recent.cpm()
+	}
+	doseRate := p.profile.Tube.DoseRate(float64(cpm))
+	p.logger.Info("reading", "cpm", cpm, "dose_rate", doseRate)
+	tags := p.tags()
+	var done []*windowAggregate
+	for _, w := range p.windows {
+		if d := w.add(p.start, t.Sub(p.start), cpm, doseRate); d != nil {
+			done = append(done, d)
+		}
+	}
+	if len(done) > 0 {
+		if err := writeAggregates(p.influx, p.target.database, p.target.measurement, tags, done); err != nil {
+			p.logger.Error("aggregates", "err", err)
+		}
+	}
+	fields := map[string]interface{}{
+		"geiger_counter_cpm":       cpm,
+		"geiger_counter_dose_rate": doseRate,
+	}
+	addDoseRateFields(fields, doseRate, p.opts.units)
+	addSmoothedDoseRate(fields, p.smoothed, doseRate)
+	if p.opts.environment {
+		addEnvironmentFields(p.dev, fields)
+	}
+	if p.opts.stats {
+		p.stats.addFields(fields)
+	}
+	p.gps.addPosition(fields, tags)
+	if p.dual {
+		if low, high, err := p.dev.DualCPM(); err != nil {
+			p.logger.Warn("dual tube", "err", err)
+		} else {
+			addDualTubeFields(fields, low, high, p.profile.HighTube)
+		}
+	}
+	return reading{
+		Time:     t,
+		Counter:  p.name,
+		Topic:    p.topic,
+		CPM:      cpm,
+		DoseRate: doseRate,
+		Tags:     tags,
+		Fields:   fields,
+		State:    snapshot{Time: t, CPM: cpm, DoseRate: doseRate, Version: p.version},
+	}, true
+}
+
+// send sends r to the outputs and, with -cpsPoints, writes the samples of
+// the interval.
+func (p *pipeline) send(r reading) {
+	for _, o := range p.outputs {
+		o.send(r)
+	}
+	if len(p.cps) > 0 {
+		if err := writeCPS(p.influx, p.target.database, p.target.measurement, r.Tags, p.cps); err != nil {
+			p.logger.Error("influx write cps", "err", err)
+		}
+	}
+}
+
+// reset begins the next interval.
+func (p *pipeline) reset() {
+	p.sum, p.samples = 0, 0
+	p.start = time.Now()
+	p.cps = p.cps[:0]
+	p.stats = countStats{}
+}
+
+// recordGap logs an interruption of the counts and, with -gapMarkers,
+// writes it.
+func (p *pipeline) recordGap(g gap) {
+	p.logger.Warn("gap in the counts", "reason", g.Reason, "start", g.Start.Format(time.RFC3339), "duration", g.End.Sub(g.Start).Round(time.Second))
+	if p.opts.gaps {
+		if err := writeGap(p.influx, p.target.database, p.target.measurement, p.tags(), g); err != nil {
+			p.logger.Error("influx write gap", "err", err)
+		}
+	}
+}
+
+// recordBurst logs a captured burst and writes it beside the readings.
+func (p *pipeline) recordBurst(b *burst) {
+	if b == nil {
+		return
+	}
+	p.logger.Warn("burst", "start", b.Start.Format(time.RFC3339), "peak_cps", b.Peak, "samples", len(b.Samples))
+	if err := writeBurst(p.influx, p.target.database, p.target.measurement+"_burst", p.tags(), b); err != nil {
+		p.logger.Error("influx write burst", "err", err)
+	}
+}
+
+// finish writes the burst being captured when the collector stops.
+func (p *pipeline) finish() {
+	if p.bursts != nil {
+		p.recordBurst(p.bursts.finish())
+	}
+}
+
+// run reads a further counter and sends a reading every interval, until
+// the counter is closed. The tags and the interval follow the settings
+// passed by update. The counts of the last, partial interval are sent pro
+// rata.
+func (p *pipeline) run(interval time.Duration) {
+	defer close(p.done)
+	if p.opts.mode == "heartbeat" {
+		if err := p.dev.EnableHeartbeat(); err != nil && !errors.Is(err, errPortLost) {
+			p.logger.Error("enable heartbeat", "err", err)
+		}
+	}
+	p.startReader()
+	flush := func() {
+		if r, ok := p.reading(interval); ok {
+			p.send(r)
+		}
+		p.reset()
+	}
+	timer := time.NewTicker(interval)
+	defer timer.Stop()
+	for {
+		select {
+		case <-p.counts.ready:
+			batch, open := p.drain()
+			for _, c := range batch {
+				p.add(c)
+			}
+			if !open {
+				flush()
+				p.finish()
+				return
+			}
+		case g := <-p.gapChan:
+			p.recordGap(g)
+		case s := <-p.settings:
+			p.global = s.Tags
+			if d := time.Duration(s.Interval); d != interval {
+				flush()
+				interval = d
+				timer.Reset(d)
+			}
+		case <-timer.C:
+			flush()
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package gqgmc
+
+import "time"
+
+// ClockStatus tells how reliably a device clock reading could be converted
+// to an instant.
+type ClockStatus int
+
+const (
+	ClockExact ClockStatus = iota
+	// ClockAmbiguous readings fall into the hour that is repeated when
+	// daylight saving time ends. The earlier of the two instants is used.
+	ClockAmbiguous
+	// ClockSkipped readings fall into the hour that does not exist when
+	// daylight saving time starts. They are moved forward by the length of
+	// the gap.
+	ClockSkipped
+	// ClockRepeated readings fall into the repeated hour like ClockAmbiguous
+	// ones, but follow readings from after its first pass, see
+	// ClockTimeAfter. The later of the two instants is used.
+	ClockRepeated
+)
+
+func (s ClockStatus) String() string {
+	switch s {
+	case ClockAmbiguous:
+		return "ambiguous"
+	case ClockSkipped:
+		return "skipped"
+	case ClockRepeated:
+		return "repeated"
+	}
+	return "exact"
+}
+
+// ClockTime converts a wall clock reading of the device clock in loc to an
+// instant. Unlike time.Date it reports readings that do not map to exactly
+// one instant because of a daylight saving time transition.
+func ClockTime(year int, month time.Month, day, hour, min, sec int, loc *time.Location) (time.Time, ClockStatus) {
+	t, _, status := clockTimes(year, month, day, hour, min, sec, loc)
+	return t, status
+}
+
+// ClockTimeAfter is ClockTime for readings logged in order, prev being the
+// instant of the reading before, if any. A reading in the repeated hour maps
+// to the later instant if prev lies between the two, as it then belongs to
+// the second pass through the hour.
+func ClockTimeAfter(year int, month time.Month, day, hour, min, sec int, loc *time.Location, prev time.Time) (time.Time, ClockStatus) {
+	t, later, status := clockTimes(year, month, day, hour, min, sec, loc)
+	if status == ClockAmbiguous && t.Before(prev) && !later.Before(prev) {
+		return later, ClockRepeated
+	}
+	return t, status
+}
+
+// clockTimes returns the instant of the reading and, for ClockAmbiguous
+// readings, also the later one.
+func clockTimes(year int, month time.Month, day, hour, min, sec int, loc *time.Location) (t, later time.Time, status ClockStatus) {
+	wall := time.Date(year, month, day, hour, min, sec, 0, time.UTC)
+	// Transitions are far less than a day apart from each other, so the
+	// offsets half a day before and after cover both sides of any of them.
+	_, before := wall.Add(-12 * time.Hour).In(loc).Zone()
+	_, after := wall.Add(12 * time.Hour).In(loc).Zone()
+	var valid []time.Time
+	for _, offset := range []int{before, after} {
+		t := wall.Add(-time.Duration(offset) * time.Second).In(loc)
+		if sameWall(t, wall) && (len(valid) == 0 || !valid[0].Equal(t)) {
+			valid = append(valid, t)
+		}
+	}
+	switch len(valid) {
+	case 0:
+		return wall.Add(-time.Duration(before) * time.Second).In(loc), time.Time{}, ClockSkipped
+	case 2:
+		if valid[1].Before(valid[0]) {
+			valid[0], valid[1] = valid[1], valid[0]
+		}
+		return valid[0], valid[1], ClockAmbiguous
+	}
+	return valid[0], time.Time{}, ClockExact
+}
+
+func sameWall(t, wall time.Time) bool {
+	y, mo, d := t.Date()
+	h, mi, s := t.Clock()
+	wy, wmo, wd := wall.Date()
+	wh, wmi, ws := wall.Clock()
+	return y == wy && mo == wmo && d == wd && h == wh && mi == wmi && s == ws
+}
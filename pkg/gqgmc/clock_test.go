@@ -0,0 +1,122 @@
+package gqgmc
+
+import (
+	"testing"
+	"time"
+)
+
+func berlin(t *testing.T) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip("no time zone database:", err)
+	}
+	return loc
+}
+
+func utc(month time.Month, day, hour, min int) time.Time {
+	return time.Date(2024, month, day, hour, min, 0, 0, time.UTC)
+}
+
+// In 2024 daylight saving time in Europe/Berlin started on March 31 at 02:00
+// and ended on October 27 at 03:00.
+func TestClockTime(t *testing.T) {
+	loc := berlin(t)
+	tests := []struct {
+		month      time.Month
+		day, hour  int
+		want       time.Time
+		wantStatus ClockStatus
+	}{
+		{time.March, 31, 1, utc(time.March, 31, 0, 30), ClockExact},
+		{time.March, 31, 2, utc(time.March, 31, 1, 30), ClockSkipped},
+		{time.March, 31, 3, utc(time.March, 31, 1, 30), ClockExact},
+		{time.October, 27, 1, utc(time.October, 26, 23, 30), ClockExact},
+		{time.October, 27, 2, utc(time.October, 27, 0, 30), ClockAmbiguous},
+		{time.October, 27, 3, utc(time.October, 27, 2, 30), ClockExact},
+	}
+	for _, tt := range tests {
+		got, status := ClockTime(2024, tt.month, tt.day, tt.hour, 30, 0, loc)
+		if !got.Equal(tt.want) || status != tt.wantStatus {
+			t.Errorf("ClockTime(%v %d %02d:30) = %v, %v, want %v, %v", tt.month, tt.day, tt.hour, got.UTC(), status, tt.want, tt.wantStatus)
+		}
+	}
+}
+
+func TestClockTimeAfter(t *testing.T) {
+	loc := berlin(t)
+	tests := []struct {
+		name       string
+		month      time.Month
+		day, hour  int
+		prev       time.Time
+		want       time.Time
+		wantStatus ClockStatus
+	}{
+		{"first pass", time.October, 27, 2, time.Time{}, utc(time.October, 27, 0, 30), ClockAmbiguous},
+		{"first pass after earlier reading", time.October, 27, 2, utc(time.October, 27, 0, 10), utc(time.October, 27, 0, 30), ClockAmbiguous},
+		{"second pass", time.October, 27, 2, utc(time.October, 27, 0, 45), utc(time.October, 27, 1, 30), ClockRepeated},
+		{"second pass at its start", time.October, 27, 2, utc(time.October, 27, 1, 30), utc(time.October, 27, 1, 30), ClockRepeated},
+		{"after a ring buffer wrap", time.October, 27, 2, utc(time.November, 3, 0, 0), utc(time.October, 27, 0, 30), ClockAmbiguous},
+		{"skipped hour", time.March, 31, 2, utc(time.March, 31, 1, 45), utc(time.March, 31, 1, 30), ClockSkipped},
+		{"exact", time.October, 27, 3, utc(time.October, 27, 2, 45), utc(time.October, 27, 2, 30), ClockExact},
+	}
+	for _, tt := range tests {
+		got, status := ClockTimeAfter(2024, tt.month, tt.day, tt.hour, 30, 0, loc, tt.prev)
+		if !got.Equal(tt.want) || status != tt.wantStatus {
+			t.Errorf("%s: ClockTimeAfter() = %v, %v, want %v, %v", tt.name, got.UTC(), status, tt.want, tt.wantStatus)
+		}
+	}
+}
+
+func TestHistoryTimestamp(t *testing.T) {
+	loc := berlin(t)
+	tests := []struct {
+		b          []byte
+		dataType   byte
+		prev       time.Time
+		want       time.Time
+		wantStatus ClockStatus
+		wantPeriod time.Duration
+	}{
+		{[]byte{24, 3, 31, 2, 30, 0}, 2, time.Time{}, utc(time.March, 31, 1, 30), ClockSkipped, time.Minute},
+		{[]byte{24, 10, 27, 2, 30, 0}, 3, time.Time{}, utc(time.October, 27, 0, 30), ClockAmbiguous, time.Hour},
+		{[]byte{24, 10, 27, 2, 30, 0}, 1, utc(time.October, 27, 1, 0), utc(time.October, 27, 1, 30), ClockRepeated, time.Second},
+		{[]byte{24, 10, 27, 12, 0, 0}, 0, time.Time{}, utc(time.October, 27, 11, 0), ClockExact, 0},
+		{[]byte{24, 13, 27, 2, 30, 0}, 2, time.Time{}, time.Time{}, ClockExact, 0},
+		{[]byte{24, 10, 27, 24, 0, 0}, 2, time.Time{}, time.Time{}, ClockExact, 0},
+		{[]byte{24, 10, 27, 2, 30, 0}, 9, time.Time{}, time.Time{}, ClockExact, 0},
+	}
+	for _, tt := range tests {
+		got, status, period := historyTimestamp(tt.b, tt.dataType, loc, tt.prev)
+		if !got.Equal(tt.want) || status != tt.wantStatus || period != tt.wantPeriod {
+			t.Errorf("historyTimestamp(% x, %d) = %v, %v, %v, want %v, %v, %v", tt.b, tt.dataType, got.UTC(), status, period, tt.want, tt.wantStatus, tt.wantPeriod)
+		}
+	}
+}
+
+// TestParseHistoryRepeatedHour has the device log through the end of
+// daylight saving time and write a timestamp in the second pass through the
+// repeated hour, which must not overwrite the first.
+func TestParseHistoryRepeatedHour(t *testing.T) {
+	loc := berlin(t)
+	var data []byte
+	data = append(data, 0x55, 0xAA, 0x00, 24, 10, 27, 2, 58, 0, 0x55, 0xAA, 2)
+	data = append(data, 1, 2)
+	data = append(data, 0x55, 0xAA, 0x00, 24, 10, 27, 2, 0, 0, 0x55, 0xAA, 2)
+	data = append(data, 3)
+	got := ParseHistory(data, loc)
+	want := []HistoryRecord{
+		{Time: utc(time.October, 27, 0, 58), Period: time.Minute, Status: ClockAmbiguous, Counts: 1},
+		{Time: utc(time.October, 27, 0, 59), Period: time.Minute, Status: ClockAmbiguous, Counts: 2},
+		{Time: utc(time.October, 27, 1, 0), Period: time.Minute, Status: ClockRepeated, Counts: 3},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("ParseHistory() = %v, want %v", got, want)
+	}
+	for i := range want {
+		if !got[i].Time.Equal(want[i].Time) || got[i].Period != want[i].Period || got[i].Status != want[i].Status || got[i].Counts != want[i].Counts {
+			t.Errorf("record %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
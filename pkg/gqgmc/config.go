@@ -0,0 +1,354 @@
+package gqgmc
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// encoding describes how a configuration value is stored.
+type encoding int
+
+const (
+	encBool         encoding = iota // 0 off, 1 on
+	encBoolInverted                 // 0 on, 1 off
+	encUint8
+	encUint16 // big endian
+	encFloatLE
+	encFloatBE
+)
+
+func (e encoding) size() int {
+	switch e {
+	case encUint16:
+		return 2
+	case encFloatLE, encFloatBE:
+		return 4
+	}
+	return 1
+}
+
+type fieldLayout struct {
+	offset int
+	enc    encoding
+}
+
+// layout256 is used by the GMC-280, GMC-300 and GMC-320.
+var layout256 = map[string]fieldLayout{
+	"power":             {0, encBoolInverted},
+	"alarm":             {1, encBool},
+	"speaker":           {2, encBool},
+	"backlight":         {4, encUint8},
+	"alarm_cpm":         {6, encUint16},
+	"calibration0_cpm":  {8, encUint16},
+	"calibration0_usvh": {10, encFloatLE},
+	"calibration1_cpm":  {14, encUint16},
+	"calibration1_usvh": {16, encFloatLE},
+	"calibration2_cpm":  {20, encUint16},
+	"calibration2_usvh": {22, encFloatLE},
+	"display_mode":      {26, encUint8},
+	"alarm_usvh":        {27, encFloatLE},
+	"alarm_type":        {31, encUint8},
+	"save_data_type":    {32, encUint8},
+	"power_saving":      {44, encUint8},
+	"battery_type":      {56, encUint8},
+	"baudrate":          {57, encUint8},
+}
+
+// layout512 is used by the GMC-500 and GMC-600. The fields shared with the
+// smaller models keep their offsets but floats are big endian, and the block
+// has room for the vibration motor and WiFi settings. The tests pin these
+// offsets, which were not checked against a dump of every firmware.
+var layout512 = map[string]fieldLayout{
+	"power":             {0, encBoolInverted},
+	"alarm":             {1, encBool},
+	"speaker":           {2, encBool},
+	"backlight":         {4, encUint8},
+	"alarm_cpm":         {6, encUint16},
+	"calibration0_cpm":  {8, encUint16},
+	"calibration0_usvh": {10, encFloatBE},
+	"calibration1_cpm":  {14, encUint16},
+	"calibration1_usvh": {16, encFloatBE},
+	"calibration2_cpm":  {20, encUint16},
+	"calibration2_usvh": {22, encFloatBE},
+	"display_mode":      {26, encUint8},
+	"alarm_usvh":        {27, encFloatBE},
+	"alarm_type":        {31, encUint8},
+	"save_data_type":    {32, encUint8},
+	"power_saving":      {44, encUint8},
+	"battery_type":      {56, encUint8},
+	"baudrate":          {57, encUint8},
+	"vibration":         {58, encBool},
+	"wifi":              {230, encBool},
+}
+
+// CalibrationPoint maps a count rate to the dose rate it corresponds to.
+type CalibrationPoint struct {
+	CPM  int
+	USvH float64
+}
+
+// Config is the decoded configuration block of either layout. Bytes not
+// covered by a field are preserved when the block is encoded again.
+type Config struct {
+	Power         bool
+	Alarm         bool
+	Speaker       bool
+	Vibration     bool // 512 byte layout only
+	WiFi          bool // 512 byte layout only
+	Backlight     int  // backlight timeout setting as shown in the device menu
+	AlarmCPM      int
+	AlarmDoseRate float64 // µSv/h
+	AlarmType     int     // 0 alarm on CPM, 1 alarm on dose rate
+	DisplayMode   int     // unit of the idle screen: 0 CPM, 1 µSv/h, 2 mR/h, 3 CPS
+	SaveDataType  int     // history logging: 0 off, 1 every second, 2 every minute, 3 every hour
+	PowerSaving   int
+	BatteryType   int // 0 rechargeable, 1 non-rechargeable
+	Baudrate      int // index into the model's baud rate table
+	Calibration   [3]CalibrationPoint
+
+	raw    []byte
+	layout map[string]fieldLayout
+}
+
+func (c *Config) fields() map[string]interface{} {
+	return map[string]interface{}{
+		"power":             &c.Power,
+		"alarm":             &c.Alarm,
+		"speaker":           &c.Speaker,
+		"vibration":         &c.Vibration,
+		"wifi":              &c.WiFi,
+		"backlight":         &c.Backlight,
+		"alarm_cpm":         &c.AlarmCPM,
+		"alarm_usvh":        &c.AlarmDoseRate,
+		"alarm_type":        &c.AlarmType,
+		"display_mode":      &c.DisplayMode,
+		"save_data_type":    &c.SaveDataType,
+		"power_saving":      &c.PowerSaving,
+		"battery_type":      &c.BatteryType,
+		"baudrate":          &c.Baudrate,
+		"calibration0_cpm":  &c.Calibration[0].CPM,
+		"calibration0_usvh": &c.Calibration[0].USvH,
+		"calibration1_cpm":  &c.Calibration[1].CPM,
+		"calibration1_usvh": &c.Calibration[1].USvH,
+		"calibration2_cpm":  &c.Calibration[2].CPM,
+		"calibration2_usvh": &c.Calibration[2].USvH,
+	}
+}
+
+// CalibrationCurve returns the calibration points for Tube.Curve. It fails
+// if the points do not describe a rising dose rate for rising count rates,
+// as after a factory reset clearing them.
+func (c *Config) CalibrationCurve() ([]CalibrationPoint, error) {
+	curve := append([]CalibrationPoint(nil), c.Calibration[:]...)
+	sort.Slice(curve, func(i, j int) bool { return curve[i].CPM < curve[j].CPM })
+	prev := CalibrationPoint{}
+	for _, p := range curve {
+		if p.CPM <= prev.CPM || !(p.USvH > prev.USvH) || math.IsInf(p.USvH, 0) {
+			return nil, fmt.Errorf("gqgmc: invalid calibration points %v", c.Calibration)
+		}
+		prev = p
+	}
+	return curve, nil
+}
+
+var displayUnits = []string{"cpm", "usvh", "mrh", "cps"}
+
+// DisplayUnit returns the unit shown on the idle screen, "cpm", "usvh",
+// "mrh" or "cps", or "unknown" for values this package does not know.
+func (c *Config) DisplayUnit() string {
+	if c.DisplayMode < 0 || c.DisplayMode >= len(displayUnits) {
+		return "unknown"
+	}
+	return displayUnits[c.DisplayMode]
+}
+
+var historyModes = []string{"off", "every_second", "every_minute", "every_hour", "every_second_above_threshold", "every_minute_above_threshold"}
+
+// HistoryMode describes how the device logs its history, e.g.
+// "every_minute". The threshold modes only exist on the GMC-500 and GMC-600.
+func (c *Config) HistoryMode() string {
+	if c.SaveDataType < 0 || c.SaveDataType >= len(historyModes) {
+		return "unknown"
+	}
+	return historyModes[c.SaveDataType]
+}
+
+// ParseConfig decodes a 256 or 512 byte configuration block.
+func ParseConfig(raw []byte) (*Config, error) {
+	c := &Config{raw: append([]byte(nil), raw...)}
+	switch len(raw) {
+	case 256:
+		c.layout = layout256
+	case 512:
+		c.layout = layout512
+	default:
+		return nil, fmt.Errorf("gqgmc: unknown config size %d", len(raw))
+	}
+	fields := c.fields()
+	for name, l := range c.layout {
+		b := raw[l.offset : l.offset+l.enc.size()]
+		switch p := fields[name].(type) {
+		case *bool:
+			*p = (b[0] != 0) != (l.enc == encBoolInverted)
+		case *int:
+			if l.enc == encUint16 {
+				*p = int(binary.BigEndian.Uint16(b))
+			} else {
+				*p = int(b[0])
+			}
+		case *float64:
+			if l.enc == encFloatBE {
+				*p = float64(math.Float32frombits(binary.BigEndian.Uint32(b)))
+			} else {
+				*p = float64(math.Float32frombits(binary.LittleEndian.Uint32(b)))
+			}
+		}
+	}
+	return c, nil
+}
+
+// Bytes encodes the configuration into a block of the original size.
+func (c *Config) Bytes() []byte {
+	raw := append([]byte(nil), c.raw...)
+	fields := c.fields()
+	for name, l := range c.layout {
+		b := raw[l.offset : l.offset+l.enc.size()]
+		switch p := fields[name].(type) {
+		case *bool:
+			b[0] = 0
+			if *p != (l.enc == encBoolInverted) {
+				b[0] = 1
+			}
+		case *int:
+			if l.enc == encUint16 {
+				binary.BigEndian.PutUint16(b, uint16(*p))
+			} else {
+				b[0] = byte(*p)
+			}
+		case *float64:
+			bits := math.Float32bits(float32(*p))
+			if l.enc == encFloatBE {
+				binary.BigEndian.PutUint32(b, bits)
+			} else {
+				binary.LittleEndian.PutUint32(b, bits)
+			}
+		}
+	}
+	return raw
+}
+
+// Names returns the names of the fields present in this layout, ordered by
+// their offset.
+func (c *Config) Names() []string {
+	var names []string
+	for name := range c.layout {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return c.layout[names[i]].offset < c.layout[names[j]].offset
+	})
+	return names
+}
+
+// Has reports whether the layout contains the named field.
+func (c *Config) Has(name string) bool {
+	_, ok := c.layout[name]
+	return ok
+}
+
+// Get returns the named field formatted as a string.
+func (c *Config) Get(name string) (string, error) {
+	if !c.Has(name) {
+		return "", fmt.Errorf("%s: %w", name, ErrUnsupported)
+	}
+	switch p := c.fields()[name].(type) {
+	case *bool:
+		if *p {
+			return "on", nil
+		}
+		return "off", nil
+	case *int:
+		return strconv.Itoa(*p), nil
+	case *float64:
+		return strconv.FormatFloat(*p, 'g', -1, 64), nil
+	}
+	panic("unreachable")
+}
+
+// Set parses value and assigns it to the named field. Booleans accept on/off
+// as well as anything strconv.ParseBool understands.
+func (c *Config) Set(name, value string) error {
+	if !c.Has(name) {
+		return fmt.Errorf("%s: %w", name, ErrUnsupported)
+	}
+	l := c.layout[name]
+	switch p := c.fields()[name].(type) {
+	case *bool:
+		switch value {
+		case "on":
+			*p = true
+		case "off":
+			*p = false
+		default:
+			v, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			*p = v
+		}
+	case *int:
+		max := 0xFF
+		if l.enc == encUint16 {
+			max = 0xFFFF
+		}
+		v, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		if v < 0 || v > max {
+			return fmt.Errorf("%s: %d out of range 0..%d", name, v, max)
+		}
+		*p = v
+	case *float64:
+		v, err := strconv.ParseFloat(value, 32)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		*p = v
+	}
+	return nil
+}
+
+// Config reads and decodes the device configuration.
+func (d *Device) Config() (*Config, error) {
+	raw, err := d.RawConfig()
+	if err != nil {
+		return nil, err
+	}
+	return ParseConfig(raw)
+}
+
+// WriteConfig writes c back to the device.
+func (d *Device) WriteConfig(c *Config) error {
+	return d.WriteRawConfig(c.Bytes())
+}
+
+// UpdateConfig reads the configuration, applies f and writes it back if f
+// changed anything.
+func (d *Device) UpdateConfig(f func(c *Config) error) error {
+	c, err := d.Config()
+	if err != nil {
+		return err
+	}
+	before := c.Bytes()
+	if err := f(c); err != nil {
+		return err
+	}
+	if string(c.Bytes()) == string(before) {
+		return nil
+	}
+	return d.WriteConfig(c)
+}
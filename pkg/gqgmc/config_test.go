@@ -0,0 +1,200 @@
+package gqgmc_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/mwuertinger/gq-gmc/pkg/gqgmc"
+)
+
+// configBlock returns a block of size with the bytes not covered by a field
+// set to a pattern, so that the tests notice fields at wrong offsets and
+// bytes lost when encoding.
+func configBlock(size int, order binary.AppendByteOrder, set map[int][]byte) []byte {
+	raw := make([]byte, size)
+	for i := range raw {
+		raw[i] = byte(i*7 + 3)
+	}
+	f32 := func(v float32) []byte { return order.AppendUint32(nil, math.Float32bits(v)) }
+	fields := map[int][]byte{
+		0:  {0}, // power on, stored inverted
+		1:  {1}, // alarm
+		2:  {0}, // speaker
+		4:  {3}, // backlight
+		6:  {0x01, 0x2C},
+		8:  {0x00, 0x64},
+		10: f32(0.65),
+		14: {0x03, 0xE8},
+		16: f32(6.5),
+		20: {0x27, 0x10},
+		22: f32(65),
+		26: {1}, // display mode µSv/h
+		27: f32(0.5),
+		31: {1},
+		32: {2},
+		44: {1},
+		56: {1},
+		57: {0},
+	}
+	for off, b := range set {
+		fields[off] = b
+	}
+	for off, b := range fields {
+		copy(raw[off:], b)
+	}
+	return raw
+}
+
+func TestParseConfig(t *testing.T) {
+	common := gqgmc.Config{
+		Power:         true,
+		Alarm:         true,
+		Speaker:       false,
+		Backlight:     3,
+		AlarmCPM:      300,
+		AlarmDoseRate: 0.5,
+		AlarmType:     1,
+		DisplayMode:   1,
+		SaveDataType:  2,
+		PowerSaving:   1,
+		BatteryType:   1,
+		Baudrate:      0,
+		Calibration: [3]gqgmc.CalibrationPoint{
+			{CPM: 100, USvH: float64(float32(0.65))},
+			{CPM: 1000, USvH: 6.5},
+			{CPM: 10000, USvH: 65},
+		},
+	}
+	with512 := common
+	with512.Vibration = true
+	with512.WiFi = true
+	tests := []struct {
+		name      string
+		raw       []byte
+		want      gqgmc.Config
+		has, lack []string
+	}{
+		{
+			name: "256 bytes",
+			raw:  configBlock(256, binary.LittleEndian, map[int][]byte{58: {1}}),
+			want: common,
+			lack: []string{"vibration", "wifi"},
+		},
+		{
+			name: "512 bytes",
+			raw:  configBlock(512, binary.BigEndian, map[int][]byte{58: {1}, 230: {1}}),
+			want: with512,
+			has:  []string{"vibration", "wifi"},
+		},
+	}
+	for _, tt := range tests {
+		c, err := gqgmc.ParseConfig(tt.raw)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		got := *c
+		if !reflect.DeepEqual(exported(got), exported(tt.want)) {
+			t.Errorf("%s: ParseConfig() =\n%+v, want\n%+v", tt.name, exported(got), exported(tt.want))
+		}
+		if u, m := c.DisplayUnit(), c.HistoryMode(); u != "usvh" || m != "every_minute" {
+			t.Errorf("%s: DisplayUnit(), HistoryMode() = %s, %s", tt.name, u, m)
+		}
+		if b := c.Bytes(); !bytes.Equal(b, tt.raw) {
+			t.Errorf("%s: Bytes() does not round-trip, first difference at %d", tt.name, firstDiff(b, tt.raw))
+		}
+		for _, name := range tt.has {
+			if !c.Has(name) {
+				t.Errorf("%s: Has(%q) = false", tt.name, name)
+			}
+		}
+		for _, name := range tt.lack {
+			if c.Has(name) {
+				t.Errorf("%s: Has(%q) = true", tt.name, name)
+			}
+		}
+	}
+}
+
+// exported returns the fields of c without the raw block and layout.
+func exported(c gqgmc.Config) map[string]interface{} {
+	m := map[string]interface{}{}
+	v := reflect.ValueOf(c)
+	for i := 0; i < v.NumField(); i++ {
+		if f := v.Type().Field(i); f.IsExported() {
+			m[f.Name] = v.Field(i).Interface()
+		}
+	}
+	return m
+}
+
+func firstDiff(a, b []byte) int {
+	for i := range a {
+		if i >= len(b) || a[i] != b[i] {
+			return i
+		}
+	}
+	return len(a)
+}
+
+func TestConfigSet(t *testing.T) {
+	tests := []struct {
+		size         int
+		name, value  string
+		offset       int
+		want         []byte
+		wantRejected bool
+	}{
+		{256, "speaker", "on", 2, []byte{1}, false},
+		{256, "power", "off", 0, []byte{1}, false},
+		{256, "alarm_cpm", "1000", 6, []byte{0x03, 0xE8}, false},
+		{256, "alarm_usvh", "2.5", 27, []byte{0x00, 0x00, 0x20, 0x40}, false},
+		{512, "alarm_usvh", "2.5", 27, []byte{0x40, 0x20, 0x00, 0x00}, false},
+		{512, "vibration", "false", 58, []byte{0}, false},
+		{512, "display_mode", "3", 26, []byte{3}, false},
+		{256, "vibration", "on", 0, nil, true},
+		{256, "backlight", "256", 0, nil, true},
+		{256, "alarm_cpm", "-1", 0, nil, true},
+		{256, "speaker", "maybe", 0, nil, true},
+	}
+	for _, tt := range tests {
+		order := binary.AppendByteOrder(binary.LittleEndian)
+		if tt.size == 512 {
+			order = binary.BigEndian
+		}
+		raw := configBlock(tt.size, order, map[int][]byte{58: {1}, 230: {1}})
+		c, err := gqgmc.ParseConfig(raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = c.Set(tt.name, tt.value)
+		if tt.wantRejected {
+			if err == nil {
+				t.Errorf("%d: Set(%s, %s) succeeded", tt.size, tt.name, tt.value)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%d: Set(%s, %s): %v", tt.size, tt.name, tt.value, err)
+			continue
+		}
+		want := append([]byte(nil), raw...)
+		copy(want[tt.offset:], tt.want)
+		if got := c.Bytes(); !bytes.Equal(got, want) {
+			t.Errorf("%d: Set(%s, %s) changed byte %d", tt.size, tt.name, tt.value, firstDiff(got, want))
+		}
+		if v, err := c.Get(tt.name); err != nil || (v != tt.value && !(v == "off" && tt.value == "false")) {
+			t.Errorf("%d: Get(%s) = %s, %v after setting %s", tt.size, tt.name, v, err, tt.value)
+		}
+	}
+}
+
+func TestParseConfigSize(t *testing.T) {
+	for _, size := range []int{0, 255, 257, 1024} {
+		if _, err := gqgmc.ParseConfig(make([]byte, size)); err == nil {
+			t.Errorf("ParseConfig() of %d bytes succeeded", size)
+		}
+	}
+}
@@ -0,0 +1,343 @@
+package gqgmc
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrTimeout is returned when the device does not deliver a complete response
+// before the underlying port's read timeout expires.
+var ErrTimeout = errors.New("gqgmc: timeout waiting for response")
+
+// ErrCorrupt is returned when a response fails validation, e.g. because of
+// a flaky USB connection.
+var ErrCorrupt = errors.New("gqgmc: corrupted response")
+
+// maxAttempts is how often a command is tried when its response times out or
+// is corrupted.
+const maxAttempts = 3
+
+// retryDelay is the pause before the second attempt of a command, doubled
+// for each further one, giving a device that is busy time to recover.
+const retryDelay = 100 * time.Millisecond
+
+// ack is the byte most write commands answer with on success.
+const ack = 0xAA
+
+// Device is a GMC Geiger counter attached to a serial port. It is safe for
+// concurrent use; commands are serialized on the port.
+type Device struct {
+	mu        sync.Mutex
+	rw        io.ReadWriter
+	version   string
+	quirks    Quirks
+	heartbeat bool
+	skip      int            // heartbeat frames left to discard
+	held      []byte         // frame read by EnableHeartbeat, returned first
+	maxCPS    int            // plausibility limit of heartbeat frames, 0 disables
+	last      int            // count of the previous heartbeat frame
+	repeats   int            // further frames with the same count in a row
+	reserved  int            // frames with the reserved bits set, see TakeReserved
+	clockLoc  *time.Location // time zone of the device clock
+
+	// timeout is how long to wait for the first byte of a response,
+	// interByte how long to wait for each further byte. Zero timeout
+	// treats the first read returning no data as a timeout.
+	timeout, interByte time.Duration
+}
+
+// New returns a Device communicating over rw. rw must return from Read with
+// n == 0 after a read timeout, like github.com/tarm/serial does.
+func New(rw io.ReadWriter) *Device {
+	return &Device{rw: rw, clockLoc: time.Local, maxCPS: DefaultMaxCPS}
+}
+
+// SetTimeouts sets how long to wait for the first byte of a response and for
+// every further byte of a multi-byte response. A zero interByte uses timeout
+// for both. The read timeout of the port then only determines how often the
+// deadlines are checked, so it should be much shorter than both. A zero
+// timeout restores the default of relying on the port's read timeout alone.
+func (d *Device) SetTimeouts(timeout, interByte time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if interByte <= 0 {
+		interByte = timeout
+	}
+	d.timeout, d.interByte = timeout, interByte
+}
+
+// Version returns the model and firmware string reported by <GETVER>>, e.g.
+// "GMC-320Re 4.19". The result is cached after the first successful call.
+func (d *Device) Version() (string, error) {
+	var v string
+	err := d.exec(func() (err error) {
+		v, err = d.versionLocked()
+		return err
+	})
+	return v, err
+}
+
+func (d *Device) versionLocked() (string, error) {
+	if d.version != "" {
+		return d.version, nil
+	}
+	if err := d.send("GETVER"); err != nil {
+		return "", err
+	}
+	// Version strings are 14 bytes, except for the "+" models which report
+	// one extra character (e.g. "GMC-500+Re 1.18").
+	buf, err := d.read(14)
+	if err != nil {
+		return "", err
+	}
+	if strings.Contains(string(buf), "+") {
+		extra, err := d.read(1)
+		if err != nil {
+			return "", err
+		}
+		buf = append(buf, extra...)
+	}
+	for _, c := range buf {
+		if c < 0x20 || c > 0x7E {
+			return "", fmt.Errorf("%w: version %q", ErrCorrupt, buf)
+		}
+	}
+	d.version = strings.TrimSpace(string(buf))
+	d.quirks = LookupQuirks(d.version)
+	return d.version, nil
+}
+
+// Model returns the model part of the version string, e.g. "GMC-320".
+func (d *Device) Model() (string, error) {
+	v, err := d.Version()
+	if err != nil {
+		return "", err
+	}
+	return modelFromVersion(v), nil
+}
+
+func modelFromVersion(v string) string {
+	if i := strings.Index(v, "Re"); i > 0 {
+		return strings.TrimSuffix(v[:i], "+")
+	}
+	return v
+}
+
+// Serial returns the serial number reported by <GETSERIAL>> as 14 hex
+// digits.
+func (d *Device) Serial() (string, error) {
+	var serial string
+	err := d.exec(func() error {
+		if err := d.sendSupported("GETSERIAL"); err != nil {
+			return err
+		}
+		buf, err := d.read(7)
+		if err != nil {
+			return err
+		}
+		serial = fmt.Sprintf("%X", buf)
+		return nil
+	})
+	return serial, err
+}
+
+// SendCommand issues <cmd>> and returns its response of expectedLen bytes,
+// none if expectedLen is zero. It waits up to timeout for the first byte of
+// the response, zero uses the timeout set with SetTimeouts. It is meant for
+// commands the Device has no method for and, like them, is serialized with
+// heartbeat mode and retried on timeouts, so cmd must be safe to repeat.
+func (d *Device) SendCommand(cmd string, expectedLen int, timeout time.Duration) ([]byte, error) {
+	var resp []byte
+	err := d.exec(func() error {
+		if err := d.sendSupported(cmd); err != nil {
+			return err
+		}
+		if expectedLen == 0 {
+			return nil
+		}
+		if timeout > 0 {
+			defer func(t time.Duration) { d.timeout = t }(d.timeout)
+			d.timeout = timeout
+		}
+		var err error
+		resp, err = d.read(expectedLen)
+		return err
+	})
+	return resp, err
+}
+
+// RawConfig reads the raw configuration block using <GETCFG>>.
+func (d *Device) RawConfig() ([]byte, error) {
+	var cfg []byte
+	err := d.exec(func() (err error) {
+		cfg, err = d.configLocked()
+		return err
+	})
+	return cfg, err
+}
+
+// configLocked reads the configuration twice. It has no checksum, so
+// comparing two copies is the only way to detect corrupted transfers.
+func (d *Device) configLocked() ([]byte, error) {
+	if _, err := d.versionLocked(); err != nil {
+		return nil, err
+	}
+	var copies [2][]byte
+	for i := range copies {
+		if err := d.send("GETCFG"); err != nil {
+			return nil, err
+		}
+		var err error
+		if copies[i], err = d.read(d.quirks.ConfigSize); err != nil {
+			return nil, err
+		}
+	}
+	if !bytes.Equal(copies[0], copies[1]) {
+		return nil, fmt.Errorf("%w: config differs between reads", ErrCorrupt)
+	}
+	return copies[0], nil
+}
+
+// WriteRawConfig replaces the device configuration with cfg. The
+// configuration is erased with <ECFG>>, rewritten byte by byte with <WCFG>>
+// and finally activated with <CFGUPDATE>>.
+func (d *Device) WriteRawConfig(cfg []byte) error {
+	return d.exec(func() error {
+		if _, err := d.versionLocked(); err != nil {
+			return err
+		}
+		size := d.quirks.ConfigSize
+		if len(cfg) != size {
+			return fmt.Errorf("gqgmc: config has %d bytes, device expects %d", len(cfg), size)
+		}
+		if err := d.sendAck("ECFG"); err != nil {
+			return err
+		}
+		for addr, b := range cfg {
+			var cmd []byte
+			if size > 256 {
+				cmd = []byte{'W', 'C', 'F', 'G', byte(addr >> 8), byte(addr), b}
+			} else {
+				cmd = []byte{'W', 'C', 'F', 'G', byte(addr), b}
+			}
+			if err := d.sendAck(string(cmd)); err != nil {
+				return fmt.Errorf("write config byte %d: %w", addr, err)
+			}
+		}
+		return d.sendAck("CFGUPDATE")
+	})
+}
+
+// exec runs f with exclusive access to the port. If heartbeat mode is active
+// it is suspended while f runs so that the response is not interleaved with
+// count frames. f is retried if it fails with ErrTimeout or ErrCorrupt, so
+// it must be safe to repeat.
+func (d *Device) exec(f func() error) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.heartbeat {
+		return d.retry(f)
+	}
+	if err := d.send("HEARTBEAT0"); err != nil {
+		return err
+	}
+	d.drain()
+	err := d.retry(f)
+	if resumeErr := d.send("HEARTBEAT1"); err == nil {
+		err = resumeErr
+	}
+	return err
+}
+
+// retry runs f up to maxAttempts times, backing off from retryDelay and
+// discarding the remains of the previous response in between. The remains of
+// the last attempt are discarded as well, so that a late response is not
+// taken for that of the next command.
+func (d *Device) retry(f func() error) error {
+	var err error
+	for i, delay := 0, retryDelay; i < maxAttempts; i, delay = i+1, 2*delay {
+		if i > 0 {
+			time.Sleep(delay)
+			d.drain()
+		}
+		err = f()
+		if !errors.Is(err, ErrTimeout) && !errors.Is(err, ErrCorrupt) {
+			return err
+		}
+	}
+	d.drain()
+	return err
+}
+
+// send writes a command framed as <cmd>>.
+func (d *Device) send(cmd string) error {
+	_, err := fmt.Fprintf(d.rw, "<%s>>", cmd)
+	return err
+}
+
+// sendSupported is like send but first consults the firmware quirks.
+func (d *Device) sendSupported(cmd string) error {
+	if _, err := d.versionLocked(); err != nil {
+		return err
+	}
+	if d.quirks.unsupported(cmd) {
+		return ErrUnsupported
+	}
+	return d.send(cmd)
+}
+
+// sendAck writes a command and waits for the 0xAA acknowledgement.
+func (d *Device) sendAck(cmd string) error {
+	if err := d.send(cmd); err != nil {
+		return err
+	}
+	buf, err := d.read(1)
+	if err != nil {
+		return err
+	}
+	if buf[0] != ack {
+		return fmt.Errorf("gqgmc: unexpected response %#x", buf[0])
+	}
+	return nil
+}
+
+// drain discards pending input until the port is quiet. It gives up after
+// maxDrain bytes so a device that never stops talking cannot block forever.
+func (d *Device) drain() {
+	const maxDrain = 1024
+	var buf [64]byte
+	for total := 0; total < maxDrain; {
+		n, err := d.rw.Read(buf[:])
+		if err != nil || n == 0 {
+			return
+		}
+		total += n
+	}
+}
+
+// read reads exactly n bytes. Without timeouts set, a read returning no data
+// is treated as a timeout.
+func (d *Device) read(n int) ([]byte, error) {
+	buf := make([]byte, n)
+	deadline := time.Now().Add(d.timeout)
+	for off := 0; off < n; {
+		m, err := d.rw.Read(buf[off:])
+		if err != nil {
+			return nil, err
+		}
+		if m == 0 {
+			if d.timeout <= 0 || time.Now().After(deadline) {
+				return nil, ErrTimeout
+			}
+			continue
+		}
+		off += m
+		deadline = time.Now().Add(d.interByte)
+	}
+	return buf, nil
+}
@@ -0,0 +1,262 @@
+package gqgmc_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mwuertinger/gq-gmc/pkg/gqgmc"
+	"github.com/mwuertinger/gq-gmc/pkg/gqgmc/gqgmctest"
+)
+
+// versions cover the 2 byte and the 4 byte protocol and firmware discarding
+// the first heartbeat frame.
+var versions = []string{"GMC-320Re 4.26", "GMC-320Re 4.19", "GMC-500+Re 2.24"}
+
+// open returns a Device connected to an emulator of the version.
+func open(t *testing.T, version string) (*gqgmc.Device, *gqgmctest.Emulator) {
+	t.Helper()
+	emu := gqgmctest.New(version)
+	port, err := emu.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { port.Close() })
+	dev := gqgmc.New(port)
+	dev.SetTimeouts(time.Second, 0)
+	return dev, emu
+}
+
+// readCounts reads n heartbeat frames, failing on errors.
+func readCounts(t *testing.T, dev *gqgmc.Device, n int) []int {
+	t.Helper()
+	var counts []int
+	for i := 0; i < n; i++ {
+		c, err := dev.ReadCounts()
+		if err != nil {
+			t.Fatalf("frame %d: %v", i, err)
+		}
+		counts = append(counts, c)
+	}
+	return counts
+}
+
+func equal(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestCommands(t *testing.T) {
+	for _, version := range versions {
+		t.Run(version, func(t *testing.T) {
+			dev, emu := open(t, version)
+			emu.SetCPM(70000)
+			if v, err := dev.Version(); err != nil || v != version {
+				t.Errorf("Version() = %q, %v, want %q", v, err, version)
+			}
+			if s, err := dev.Serial(); err != nil || s != "F4881A00421703" {
+				t.Errorf("Serial() = %q, %v", s, err)
+			}
+			want := 70000
+			if !gqgmc.LookupQuirks(version).CPM32 {
+				want = 70000 & 0xFFFF
+			}
+			if cpm, err := dev.CPM(); err != nil || cpm != want {
+				t.Errorf("CPM() = %d, %v, want %d", cpm, err, want)
+			}
+		})
+	}
+}
+
+func TestRetryLostResponse(t *testing.T) {
+	dev, emu := open(t, versions[0])
+	dev.SetTimeouts(100*time.Millisecond, 0)
+	emu.Drop("GETSERIAL", 1)
+	if _, err := dev.Serial(); err != nil {
+		t.Fatal(err)
+	}
+	emu.Drop("GETSERIAL", 3)
+	if _, err := dev.Serial(); !errors.Is(err, gqgmc.ErrTimeout) {
+		t.Errorf("Serial() with all responses lost = %v, want ErrTimeout", err)
+	}
+}
+
+func TestConfigRoundTrip(t *testing.T) {
+	dev, emu := open(t, versions[0])
+	cfg := make([]byte, 256)
+	for i := range cfg {
+		// '<' and '>' among the arguments of <WCFG>>
+		cfg[i] = byte(i)
+	}
+	if err := dev.WriteRawConfig(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if got := emu.Config(); !bytes.Equal(got, cfg) {
+		t.Fatalf("config written differs")
+	}
+	got, err := dev.RawConfig()
+	if err != nil || !bytes.Equal(got, cfg) {
+		t.Errorf("RawConfig() = %x, %v", got, err)
+	}
+}
+
+func TestHeartbeat(t *testing.T) {
+	for _, version := range versions {
+		t.Run(version, func(t *testing.T) {
+			dev, emu := open(t, version)
+			emu.Send(3, 5, 0, 1000)
+			if err := dev.EnableHeartbeat(); err != nil {
+				t.Fatal(err)
+			}
+			// the stale frame of the 4.19 firmware is discarded
+			if got := readCounts(t, dev, 4); !equal(got, []int{3, 5, 0, 1000}) {
+				t.Errorf("counts = %v", got)
+			}
+			if _, err := dev.ReadCounts(); !errors.Is(err, gqgmc.ErrTimeout) {
+				t.Errorf("ReadCounts() without frames = %v, want ErrTimeout", err)
+			}
+			if err := dev.DisableHeartbeat(); err != nil {
+				t.Fatal(err)
+			}
+			if emu.Heartbeat() {
+				t.Error("heartbeat still on")
+			}
+		})
+	}
+}
+
+func TestCommandDuringHeartbeat(t *testing.T) {
+	dev, emu := open(t, versions[0])
+	emu.Send(1, 2)
+	if err := dev.EnableHeartbeat(); err != nil {
+		t.Fatal(err)
+	}
+	readCounts(t, dev, 1)
+	if _, err := dev.Serial(); err != nil {
+		t.Fatal(err)
+	}
+	cmds := emu.Commands()
+	if got := cmds[len(cmds)-3:]; got[0] != "HEARTBEAT0" || got[1] != "GETSERIAL" || got[2] != "HEARTBEAT1" {
+		t.Errorf("commands = %v, want the command within HEARTBEAT0 and HEARTBEAT1", got)
+	}
+	if !emu.Heartbeat() {
+		t.Error("heartbeat not resumed")
+	}
+	if got := readCounts(t, dev, 1); got[0] != 2 {
+		t.Errorf("count after the command = %d, want 2", got[0])
+	}
+}
+
+func TestHeartbeatRecovery(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		script func(emu *gqgmctest.Emulator)
+		err    []error // of the frame after the first
+		want   []int   // counts read afterwards
+		resync bool
+	}{
+		{
+			// ff 00|01 00|02 ...: 16128 counts once the reserved bits are masked
+			name:   "stray byte",
+			script: func(emu *gqgmctest.Emulator) { emu.Inject([]byte{0xFF}); emu.Send(1, 2, 3) },
+			err:    []error{gqgmc.ErrCorrupt, gqgmc.ErrResynchronized},
+			want:   []int{2, 3},
+			resync: true,
+		},
+		{
+			// 05 00|02: 1280 counts
+			name:   "implausible count",
+			script: func(emu *gqgmctest.Emulator) { emu.Inject([]byte{0x05}); emu.Send(2, 3, 4) },
+			err:    []error{gqgmc.ErrCorrupt, gqgmc.ErrResynchronized},
+			want:   []int{3, 4},
+			resync: true,
+		},
+		{
+			name: "incomplete frame",
+			script: func(emu *gqgmctest.Emulator) {
+				emu.Inject([]byte{0x00})
+				emu.Pause(500 * time.Millisecond)
+				emu.Send(2, 3)
+			},
+			err:  []error{gqgmc.ErrCorrupt},
+			want: []int{2, 3},
+		},
+		{
+			name:   "all count bits set",
+			script: func(emu *gqgmctest.Emulator) { emu.Send(0x3FFF, 2, 3) },
+			err:    []error{gqgmc.ErrInvalid},
+			want:   []int{2, 3},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dev, emu := open(t, versions[0])
+			emu.Send(7)
+			tc.script(emu)
+			if err := dev.EnableHeartbeat(); err != nil {
+				t.Fatal(err)
+			}
+			readCounts(t, dev, 1)
+			before := len(emu.Commands())
+			_, err := dev.ReadCounts()
+			for _, want := range tc.err {
+				if !errors.Is(err, want) {
+					t.Errorf("ReadCounts() = %v, want %v", err, want)
+				}
+			}
+			if resynced := len(emu.Commands()) > before; resynced != tc.resync {
+				t.Errorf("resynchronized = %v, want %v", resynced, tc.resync)
+			}
+			if got := readCounts(t, dev, len(tc.want)); !equal(got, tc.want) {
+				t.Errorf("counts afterwards = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestReservedBits(t *testing.T) {
+	dev, emu := open(t, versions[0])
+	emu.Send(7)
+	emu.Inject([]byte{0x80, 0x05})
+	emu.Inject([]byte{0x40, 0x06})
+	emu.Send(2)
+	if err := dev.EnableHeartbeat(); err != nil {
+		t.Fatal(err)
+	}
+	readCounts(t, dev, 1)
+	before := len(emu.Commands())
+	if got := readCounts(t, dev, 3); !equal(got, []int{5, 6, 2}) {
+		t.Errorf("counts = %v, want [5 6 2]", got)
+	}
+	if n := dev.TakeReserved(); n != 2 {
+		t.Errorf("TakeReserved() = %d, want 2", n)
+	}
+	if n := dev.TakeReserved(); n != 0 {
+		t.Errorf("TakeReserved() again = %d, want 0", n)
+	}
+	if len(emu.Commands()) > before {
+		t.Error("resynchronized on the reserved bits")
+	}
+}
+
+func TestStuckCounts(t *testing.T) {
+	dev, emu := open(t, versions[0])
+	for i := 0; i < 40; i++ {
+		emu.Send(4)
+	}
+	if err := dev.EnableHeartbeat(); err != nil {
+		t.Fatal(err)
+	}
+	// the 30th repeat of the first count is taken for a wedged device
+	readCounts(t, dev, 30)
+	if _, err := dev.ReadCounts(); !errors.Is(err, gqgmc.ErrInvalid) {
+		t.Errorf("ReadCounts() of a stuck count = %v, want ErrInvalid", err)
+	}
+}
@@ -0,0 +1,28 @@
+// Package gqgmc implements the serial protocol spoken by GQ Electronics GMC
+// Geiger counters (GQ-RFC1201 and its GMC-500/600 successor GQ-RFC1801).
+//
+// It is the driver of the gq-gmc collector and has no dependencies besides
+// the standard library, so other programs can embed it. The port is opened
+// by the caller, e.g. with github.com/tarm/serial:
+//
+//	s, err := serial.OpenPort(&serial.Config{Name: "/dev/ttyUSB0", Baud: 115200, ReadTimeout: 100 * time.Millisecond})
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	dev := gqgmc.New(s)
+//	dev.SetTimeouts(2*time.Second, 0)
+//	if err := dev.EnableHeartbeat(); err != nil {
+//		log.Fatal(err)
+//	}
+//	for {
+//		n, err := dev.ReadCounts()
+//		if err != nil {
+//			log.Print(err)
+//			continue
+//		}
+//		fmt.Println("counts in the last second:", n)
+//	}
+//
+// Commands like Voltage or Config may be issued while heartbeat mode is on;
+// the Device suspends it for the duration of the command.
+package gqgmc
@@ -0,0 +1,381 @@
+// Package gqgmctest provides a scripted GMC counter for testing code that
+// talks to one without the hardware, in the spirit of net/http/httptest.
+//
+//	emu := gqgmctest.New("GMC-320Re 4.26")
+//	port, _ := emu.Open()
+//	dev := gqgmc.New(port)
+//	dev.SetTimeouts(time.Second, 0)
+//	emu.Send(3, 5, 0)
+//	dev.EnableHeartbeat()
+//	n, _ := dev.ReadCounts() // 3
+//
+// The Emulator answers the commands of the protocol with the widths of the
+// firmware of its version string and streams the heartbeat frames the test
+// queued, so the test decides what arrives on the wire and when.
+package gqgmctest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/mwuertinger/gq-gmc/pkg/gqgmc"
+)
+
+// ReadTimeout is how long Read blocks when there is nothing to send,
+// standing in for the read timeout of a serial port.
+const ReadTimeout = 10 * time.Millisecond
+
+// ErrUnplugged is returned by the reads and writes of a port that was
+// unplugged, see Emulator.Unplug, or closed.
+var ErrUnplugged = errors.New("gqgmctest: device unplugged")
+
+// DefaultSerial is the <GETSERIAL>> response of a new Emulator.
+var DefaultSerial = []byte{0xF4, 0x88, 0x1A, 0x00, 0x42, 0x17, 0x03}
+
+// StaleCount is the count of the frames firmware with HeartbeatSkip sends
+// first after heartbeat mode is enabled, the counts accumulated since the
+// previous session.
+const StaleCount = 777
+
+// Emulator is a scripted counter. It is safe for concurrent use.
+type Emulator struct {
+	mu        sync.Mutex
+	version   string
+	quirks    gqgmc.Quirks
+	serial    []byte
+	cpm       int
+	config    []byte
+	handlers  map[string]func(cmd []byte) []byte
+	drops     map[string]int
+	commands  []string
+	heartbeat bool
+	frames    []chunk // queued for heartbeat mode, oldest first
+	resume    time.Time
+	in        []byte // start of a command not complete yet
+	out       []byte // response or frame being read
+	port      *port  // open port, nil while closed or unplugged
+	unplugged bool
+}
+
+// chunk is bytes sent in heartbeat mode, only after a pause if one is set.
+type chunk struct {
+	data  []byte
+	pause time.Duration
+}
+
+// New returns an Emulator reporting version to <GETVER>>, e.g.
+// "GMC-500+Re 2.24". Its configuration has the size of the firmware and is
+// zeroed.
+func New(version string) *Emulator {
+	q := gqgmc.LookupQuirks(version)
+	return &Emulator{
+		version:  version,
+		quirks:   q,
+		serial:   DefaultSerial,
+		config:   make([]byte, q.ConfigSize),
+		handlers: map[string]func([]byte) []byte{},
+		drops:    map[string]int{},
+	}
+}
+
+// Open connects to the counter, like opening its serial port. The previous
+// port stops working, and Open fails while the counter is unplugged. The
+// state of the counter, e.g. heartbeat mode, is kept across ports.
+func (e *Emulator) Open() (io.ReadWriteCloser, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.unplugged {
+		return nil, ErrUnplugged
+	}
+	e.port = &port{e: e}
+	e.in, e.out = nil, nil
+	return e.port, nil
+}
+
+// Unplug makes the reads and writes of the open port fail with ErrUnplugged
+// and Open fail until Plug is called.
+func (e *Emulator) Unplug() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.unplugged = true
+	e.port = nil
+}
+
+// Plug lets Open connect again.
+func (e *Emulator) Plug() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.unplugged = false
+}
+
+// Send queues heartbeat frames with the counts, which are sent back to back
+// as soon as the previous frame was read while heartbeat mode is on.
+func (e *Emulator) Send(counts ...int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, n := range counts {
+		e.frames = append(e.frames, chunk{data: e.frame(n)})
+	}
+}
+
+// Inject queues raw bytes in between the frames, e.g. a stray byte that
+// misaligns the stream.
+func (e *Emulator) Inject(b []byte) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.frames = append(e.frames, chunk{data: append([]byte(nil), b...)})
+}
+
+// Pause delays the next queued frame or bytes by d.
+func (e *Emulator) Pause(d time.Duration) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.frames = append(e.frames, chunk{pause: d})
+}
+
+// Pending returns the number of queued frames, injections and pauses not
+// sent yet.
+func (e *Emulator) Pending() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return len(e.frames)
+}
+
+// SetCPM sets the <GETCPM>> response.
+func (e *Emulator) SetCPM(cpm int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.cpm = cpm
+}
+
+// SetConfig replaces the configuration read with <GETCFG>>.
+func (e *Emulator) SetConfig(cfg []byte) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.config = append([]byte(nil), cfg...)
+}
+
+// Config returns the configuration as written with <WCFG>>.
+func (e *Emulator) Config() []byte {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]byte(nil), e.config...)
+}
+
+// Handle answers the command, e.g. "GETVOLT", with the response of f, which
+// gets the command as sent without the framing. It replaces the built-in
+// one; a nil response sends nothing. f runs with the Emulator locked and must
+// not call its methods.
+func (e *Emulator) Handle(cmd string, f func(cmd []byte) []byte) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.handlers[cmd] = f
+}
+
+// Drop leaves the next n times the command is received unanswered, as if
+// the response was lost, commands changing state still take effect.
+func (e *Emulator) Drop(cmd string, n int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.drops[cmd] += n
+}
+
+// Commands returns the names of the commands received, oldest first.
+func (e *Emulator) Commands() []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]string(nil), e.commands...)
+}
+
+// Heartbeat reports whether heartbeat mode is on.
+func (e *Emulator) Heartbeat() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.heartbeat
+}
+
+// frame returns a heartbeat frame of the firmware with n counts.
+func (e *Emulator) frame(n int) []byte {
+	if e.quirks.Heartbeat32 {
+		return binary.BigEndian.AppendUint32(nil, uint32(n))
+	}
+	return binary.BigEndian.AppendUint16(nil, uint16(n))
+}
+
+// receive parses the commands in b, which may end within one.
+func (e *Emulator) receive(b []byte) {
+	e.in = append(e.in, b...)
+	for {
+		start := bytes.IndexByte(e.in, '<')
+		if start < 0 {
+			e.in = e.in[:0]
+			return
+		}
+		e.in = e.in[start:]
+		end := e.commandEnd()
+		if end < 0 {
+			return
+		}
+		e.execute(e.in[1 : end-2])
+		e.in = e.in[end:]
+	}
+}
+
+// commandEnd returns the length of the command at the start of e.in
+// including the framing, -1 if it is not complete. <WCFG>> has binary
+// arguments that may contain '>', so it is delimited by its length.
+func (e *Emulator) commandEnd() int {
+	if bytes.HasPrefix(e.in, []byte("<WCFG")) {
+		n := len("<WCFG") + 2 + len(">>")
+		if e.quirks.ConfigSize > 256 {
+			n++
+		}
+		if len(e.in) < n {
+			return -1
+		}
+		return n
+	}
+	if i := bytes.Index(e.in, []byte(">>")); i >= 0 {
+		return i + 2
+	}
+	return -1
+}
+
+func (e *Emulator) execute(cmd []byte) {
+	name := string(cmd)
+	if bytes.HasPrefix(cmd, []byte("WCFG")) {
+		name = "WCFG"
+	}
+	e.commands = append(e.commands, name)
+	resp := e.respond(name, cmd)
+	if e.drops[name] > 0 {
+		e.drops[name]--
+		return
+	}
+	e.out = append(e.out, resp...)
+}
+
+// respond carries out the command and returns its response.
+func (e *Emulator) respond(name string, cmd []byte) []byte {
+	if f, ok := e.handlers[name]; ok {
+		return f(cmd)
+	}
+	switch name {
+	case "GETVER":
+		return []byte(e.version)
+	case "GETSERIAL":
+		return e.serial
+	case "GETCPM":
+		if e.quirks.CPM32 {
+			return binary.BigEndian.AppendUint32(nil, uint32(e.cpm))
+		}
+		return binary.BigEndian.AppendUint16(nil, uint16(e.cpm))
+	case "GETCFG":
+		return append([]byte(nil), e.config...)
+	case "ECFG":
+		for i := range e.config {
+			e.config[i] = 0xFF
+		}
+		return []byte{0xAA}
+	case "WCFG":
+		args := cmd[len("WCFG"):]
+		addr := int(args[0])
+		if len(args) == 3 {
+			addr = addr<<8 | int(args[1])
+		}
+		if addr < len(e.config) {
+			e.config[addr] = args[len(args)-1]
+		}
+		return []byte{0xAA}
+	case "CFGUPDATE":
+		return []byte{0xAA}
+	case "HEARTBEAT1":
+		if !e.heartbeat {
+			e.heartbeat = true
+			// accumulated counts of the firmware sending them first
+			stale := make([]chunk, e.quirks.HeartbeatSkip)
+			for i := range stale {
+				stale[i] = chunk{data: e.frame(StaleCount)}
+			}
+			e.frames = append(stale, e.frames...)
+		}
+	case "HEARTBEAT0":
+		e.heartbeat = false
+		// the rest of a frame in flight is not sent any more
+		e.out = nil
+	}
+	return nil
+}
+
+// next moves the next queued frame to the output if due.
+func (e *Emulator) next() {
+	for len(e.out) == 0 && e.heartbeat && len(e.frames) > 0 {
+		c := &e.frames[0]
+		if c.pause > 0 {
+			if e.resume.IsZero() {
+				e.resume = time.Now().Add(c.pause)
+			}
+			if time.Now().Before(e.resume) {
+				return
+			}
+			e.resume = time.Time{}
+		}
+		e.out = c.data
+		e.frames = e.frames[1:]
+	}
+}
+
+// port is a connection to the Emulator. Its Read returns no data after
+// ReadTimeout, like github.com/tarm/serial does.
+type port struct {
+	e *Emulator
+}
+
+func (p *port) Read(b []byte) (int, error) {
+	deadline := time.Now().Add(ReadTimeout)
+	for {
+		n, err := p.read(b)
+		if n > 0 || err != nil || !time.Now().Before(deadline) {
+			return n, err
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func (p *port) read(b []byte) (int, error) {
+	e := p.e
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.port != p {
+		return 0, ErrUnplugged
+	}
+	e.next()
+	n := copy(b, e.out)
+	e.out = e.out[n:]
+	return n, nil
+}
+
+func (p *port) Write(b []byte) (int, error) {
+	e := p.e
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.port != p {
+		return 0, ErrUnplugged
+	}
+	e.receive(b)
+	return len(b), nil
+}
+
+func (p *port) Close() error {
+	e := p.e
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.port == p {
+		e.port = nil
+	}
+	return nil
+}
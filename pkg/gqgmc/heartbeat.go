@@ -0,0 +1,289 @@
+package gqgmc
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// heartbeatMask and heartbeatMask32 select the count bits of a 2 and 4 byte
+// heartbeat frame; the two most significant bits are reserved.
+const (
+	heartbeatMask   = 0x3FFF
+	heartbeatMask32 = 0x3FFFFFFF
+)
+
+// heartbeatFrameGap bounds the time between the bytes of a heartbeat frame,
+// which the device sends at once, once per second.
+const heartbeatFrameGap = 300 * time.Millisecond
+
+// DefaultMaxCPS is the plausibility limit of heartbeat frames, far above
+// what the tubes of the supported models count before they saturate.
+const DefaultMaxCPS = 1000
+
+// SetMaxCPS sets the plausibility limit of the heartbeat frames. Larger
+// values are taken for a misaligned stream, see ReadCounts. Zero disables
+// the check.
+func (d *Device) SetMaxCPS(n int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.maxCPS = n
+}
+
+// ErrNoHeartbeat is returned by EnableHeartbeat when the device does not
+// start sending heartbeat frames.
+var ErrNoHeartbeat = errors.New("gqgmc: device does not send heartbeat frames")
+
+// EnableHeartbeat switches the device into heartbeat mode, in which it
+// reports the number of counts every second (<HEARTBEAT1>>). The command
+// has no response, so it is only taken as successful once the first frame
+// arrived; it is repeated with backoff while none does. The first frame is
+// kept for ReadCounts.
+func (d *Device) EnableHeartbeat() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, err := d.versionLocked(); err != nil {
+		return err
+	}
+	d.heartbeat = true
+	d.skip = d.quirks.HeartbeatSkip
+	d.held = nil
+	d.repeats = 0
+	for i, delay := 0, retryDelay; i < maxAttempts; i, delay = i+1, 2*delay {
+		if i > 0 {
+			time.Sleep(delay)
+			d.drain()
+		}
+		if err := d.send("HEARTBEAT1"); err != nil {
+			return err
+		}
+		buf, err := d.readFrame(d.frameSize())
+		if err == nil {
+			d.held = buf
+			return nil
+		}
+		if !errors.Is(err, ErrTimeout) && !errors.Is(err, ErrCorrupt) {
+			return err
+		}
+	}
+	return fmt.Errorf("%w after %d attempts", ErrNoHeartbeat, maxAttempts)
+}
+
+// frameSize returns the size of a heartbeat frame of the firmware.
+func (d *Device) frameSize() int {
+	if d.quirks.Heartbeat32 {
+		return 4
+	}
+	return 2
+}
+
+// DisableHeartbeat leaves heartbeat mode (<HEARTBEAT0>>). A lost command
+// would leave the device streaming, so it is repeated until no frame has
+// arrived for the read timeout.
+func (d *Device) DisableHeartbeat() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.heartbeat = false
+	d.held = nil
+	for i := 0; i < maxAttempts; i++ {
+		if err := d.send("HEARTBEAT0"); err != nil {
+			return err
+		}
+		d.drain()
+		_, err := d.read(1)
+		if errors.Is(err, ErrTimeout) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return errors.New("gqgmc: device keeps sending heartbeat frames")
+}
+
+// stuckFrames is how often heartbeat frames may repeat the previous count
+// before the device is taken for wedged. Poisson noise makes a run this long
+// practically impossible at any count rate above zero.
+const stuckFrames = 30
+
+// ErrInvalid is wrapped by the errors of ReadCounts for frames that cannot
+// be a count: with all count bits set, which wedged firmware sends in
+// floods, or repeating the previous nonzero count more than 30 times, see
+// stuckFrames.
+var ErrInvalid = errors.New("gqgmc: invalid heartbeat count")
+
+// ErrResynchronized is wrapped by the errors of ReadCounts after which
+// heartbeat mode was restarted to realign the stream.
+var ErrResynchronized = errors.New("resynchronized")
+
+// ReadCounts waits for the next heartbeat frame and returns the number of
+// counts it reports. It returns ErrTimeout if no frame arrived within the
+// port's read timeout, after sending <HEARTBEAT1>> again in case the device
+// missed it, e.g. when resuming heartbeat mode after a command. The frame
+// size follows the quirks of the firmware detected by EnableHeartbeat.
+//
+// A lost byte would misalign all following frames. The bytes of a frame
+// arrive together, so a frame that is not complete after heartbeatFrameGap
+// is dropped with ErrCorrupt, which realigns the stream with the next frame.
+// A count above the plausibility limit also returns ErrCorrupt, after
+// heartbeat mode was switched off and on again to start over. The other
+// invalid counts are dropped with ErrInvalid without touching the stream.
+// The reserved bits are masked off, a frame with any of them set is still
+// returned and counted for TakeReserved.
+func (d *Device) ReadCounts() (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	size := d.frameSize()
+	for {
+		buf := d.held
+		d.held = nil
+		if buf == nil {
+			var err error
+			if buf, err = d.readFrame(size); err != nil {
+				if errors.Is(err, ErrTimeout) && d.heartbeat {
+					if serr := d.send("HEARTBEAT1"); serr != nil {
+						return 0, serr
+					}
+				}
+				return 0, err
+			}
+		}
+		if d.skip > 0 {
+			d.skip--
+			continue
+		}
+		raw, mask := uint32(0), uint32(heartbeatMask)
+		if size == 4 {
+			raw, mask = binary.BigEndian.Uint32(buf), heartbeatMask32
+		} else {
+			raw = uint32(binary.BigEndian.Uint16(buf))
+		}
+		if raw&mask == mask {
+			return 0, fmt.Errorf("%w: all count bits set", ErrInvalid)
+		}
+		if raw&^mask != 0 {
+			d.reserved++
+		}
+		n := int(raw & mask)
+		if d.maxCPS > 0 && n > d.maxCPS {
+			return 0, d.resync(fmt.Errorf("%w: implausible heartbeat count %d", ErrCorrupt, n))
+		}
+		if n != 0 && n == d.last {
+			d.repeats++
+		} else {
+			d.last, d.repeats = n, 0
+		}
+		if d.repeats >= stuckFrames {
+			return 0, fmt.Errorf("%w: count %d repeated %d times", ErrInvalid, n, d.repeats)
+		}
+		return n, nil
+	}
+}
+
+// TakeReserved returns the number of heartbeat frames read with the reserved
+// bits set since the last call. ReadCounts returns their counts masked.
+func (d *Device) TakeReserved() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	n := d.reserved
+	d.reserved = 0
+	return n
+}
+
+// readFrame reads a heartbeat frame, failing with ErrCorrupt if its bytes do
+// not arrive together.
+func (d *Device) readFrame(size int) ([]byte, error) {
+	buf, err := d.read(1)
+	if err != nil {
+		return nil, err
+	}
+	deadline := time.Now().Add(heartbeatFrameGap)
+	for len(buf) < size {
+		var b [4]byte
+		m, err := d.rw.Read(b[:size-len(buf)])
+		if err != nil {
+			return nil, err
+		}
+		buf = append(buf, b[:m]...)
+		if m == 0 && time.Now().After(deadline) {
+			return nil, fmt.Errorf("%w: incomplete heartbeat frame %x", ErrCorrupt, buf)
+		}
+	}
+	return buf, nil
+}
+
+// resync switches heartbeat mode off and on again, discarding the pending
+// input in between, and returns err.
+func (d *Device) resync(err error) error {
+	if serr := d.send("HEARTBEAT0"); serr != nil {
+		return serr
+	}
+	d.drain()
+	if serr := d.send("HEARTBEAT1"); serr != nil {
+		return serr
+	}
+	d.skip = d.quirks.HeartbeatSkip
+	return fmt.Errorf("%w, %w", err, ErrResynchronized)
+}
+
+// CPM returns the counts of the last minute as computed by the device
+// (<GETCPM>>). It is an alternative to heartbeat mode for firmware that
+// does not stream reliably.
+func (d *Device) CPM() (int, error) {
+	var cpm int
+	err := d.exec(func() error {
+		if err := d.sendSupported("GETCPM"); err != nil {
+			return err
+		}
+		// GQ-RFC1801 widened the response to 32 bits
+		if d.quirks.CPM32 {
+			buf, err := d.read(4)
+			if err != nil {
+				return err
+			}
+			cpm = int(binary.BigEndian.Uint32(buf))
+			return nil
+		}
+		buf, err := d.read(2)
+		if err != nil {
+			return err
+		}
+		cpm = int(binary.BigEndian.Uint16(buf))
+		return nil
+	})
+	return cpm, err
+}
+
+// DualCPM returns the counts of the last minute of both tubes of a dual
+// tube counter like the GMC-500+: the sensitive tube for low dose rates
+// (<GETCPML>>) and the tube for high dose rates (<GETCPMH>>). It returns
+// ErrUnsupported on counters with a single tube.
+func (d *Device) DualCPM() (low, high int, err error) {
+	err = d.exec(func() error {
+		if _, err := d.versionLocked(); err != nil {
+			return err
+		}
+		if !d.quirks.DualTube {
+			return ErrUnsupported
+		}
+		if low, err = d.cpm32("GETCPML"); err != nil {
+			return err
+		}
+		high, err = d.cpm32("GETCPMH")
+		return err
+	})
+	return low, high, err
+}
+
+// cpm32 issues a command answering with a 4 byte count.
+func (d *Device) cpm32(cmd string) (int, error) {
+	if err := d.sendSupported(cmd); err != nil {
+		return 0, err
+	}
+	buf, err := d.read(4)
+	if err != nil {
+		return 0, err
+	}
+	return int(binary.BigEndian.Uint32(buf)), nil
+}
@@ -0,0 +1,242 @@
+package gqgmc
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// MaxHistoryChunk is the largest number of bytes a single <SPIR>> request
+// may read.
+const MaxHistoryChunk = 4096
+
+// ReadHistory reads n bytes of the history flash starting at addr using
+// <SPIR[A2][A1][A0][L1][L0]>>. n must not exceed MaxHistoryChunk.
+func (d *Device) ReadHistory(addr, n int) ([]byte, error) {
+	if n <= 0 || n > MaxHistoryChunk {
+		return nil, fmt.Errorf("gqgmc: history read of %d bytes, must be 1 to %d", n, MaxHistoryChunk)
+	}
+	var buf []byte
+	err := d.exec(func() error {
+		if _, err := d.versionLocked(); err != nil {
+			return err
+		}
+		if addr < 0 || addr+n > d.quirks.HistorySize {
+			return fmt.Errorf("gqgmc: history read %#x+%d outside of %d byte flash", addr, n, d.quirks.HistorySize)
+		}
+		cmd := []byte{'S', 'P', 'I', 'R', byte(addr >> 16), byte(addr >> 8), byte(addr), byte(n >> 8), byte(n)}
+		if err := d.send(string(cmd)); err != nil {
+			return err
+		}
+		var err error
+		buf, err = d.read(n)
+		return err
+	})
+	return buf, err
+}
+
+// DownloadHistory reads the whole history flash. progress, if not nil, is
+// called after every chunk with the bytes read so far and the flash size.
+// Heartbeat mode is resumed between the chunks.
+func (d *Device) DownloadHistory(progress func(done, total int)) ([]byte, error) {
+	q, err := d.Quirks()
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, 0, q.HistorySize)
+	for addr := 0; addr < q.HistorySize; addr += MaxHistoryChunk {
+		chunk, err := d.ReadHistory(addr, min(MaxHistoryChunk, q.HistorySize-addr))
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, chunk...)
+		if progress != nil {
+			progress(len(data), q.HistorySize)
+		}
+	}
+	return data, nil
+}
+
+// HistoryRecord is a value logged to the history flash.
+type HistoryRecord struct {
+	Time   time.Time     // start of the period
+	Period time.Duration // a second, a minute or an hour
+	Status ClockStatus   // of the timestamp the record is counted from
+	Counts int           // counts during the period
+	Note   string        // location text logged before the record, if any
+}
+
+// CPM returns the counts of the record normalized to a minute.
+func (r HistoryRecord) CPM() float64 {
+	return float64(r.Counts) * float64(time.Minute) / float64(r.Period)
+}
+
+// historyPeriods is the time covered by a value for the save data types of
+// the timestamp records, see Config.HistoryMode.
+var historyPeriods = []time.Duration{0, time.Second, time.Minute, time.Hour, time.Second, time.Minute}
+
+// HistoryEntryKind tells what a HistoryEntry was decoded from.
+type HistoryEntryKind int
+
+const (
+	HistoryCount     HistoryEntryKind = iota // a count value
+	HistoryTimestamp                         // a timestamp record, which also sets the save data type
+	HistoryNote                              // a location text
+)
+
+func (k HistoryEntryKind) String() string {
+	switch k {
+	case HistoryTimestamp:
+		return "timestamp"
+	case HistoryNote:
+		return "note"
+	}
+	return "count"
+}
+
+// HistoryEntry is a record of the history flash in the order of the flash.
+type HistoryEntry struct {
+	Offset int // in the flash
+	Kind   HistoryEntryKind
+	// Time is that of a timestamp or the start of the period of a count,
+	// zero if no valid timestamp precedes it.
+	Time time.Time
+	// Period is that of the counts following a timestamp and of a count,
+	// zero while logging is off or the timestamp is invalid.
+	Period time.Duration
+	Mode   string      // of a timestamp, see Config.HistoryMode
+	Status ClockStatus // of the timestamp the entry is counted from
+	Counts int         // of a count
+	Note   string      // of a note and of the counts after it
+}
+
+// ParseHistory decodes the history flash into the records that can be
+// placed in time. The flash is a ring buffer, so the records are returned
+// in order of time rather than of address. See DecodeHistory for the
+// format.
+func ParseHistory(data []byte, loc *time.Location) []HistoryRecord {
+	var records []HistoryRecord
+	for _, e := range DecodeHistory(data, loc) {
+		if e.Kind == HistoryCount && e.Period > 0 {
+			records = append(records, HistoryRecord{Time: e.Time, Period: e.Period, Status: e.Status, Counts: e.Counts, Note: e.Note})
+		}
+	}
+	return sortHistory(records)
+}
+
+// DecodeHistory decodes the history flash. It consists of single byte count
+// values, interspersed with records introduced by 55 AA:
+//
+//	55 AA 00 YY MM DD HH MI SS 55 AA TT   timestamp and save data type
+//	55 AA 01 HI LO                        two byte value
+//	55 AA 02 LL <text>                    location text of LL bytes
+//	55 AA 03 B2 B1 B0                     three byte value
+//	55 AA 04 B3 B2 B1 B0                  four byte value
+//
+// Unused flash reads as FF and is skipped. Timestamps are device clock
+// readings in loc. Counts before the first valid timestamp cannot be placed
+// in time, nor can counts after unused flash, which the device follows with
+// a new timestamp; they are returned with a zero Time and Period.
+func DecodeHistory(data []byte, loc *time.Location) []HistoryEntry {
+	var (
+		entries []HistoryEntry
+		t       time.Time
+		status  ClockStatus
+		period  time.Duration // zero while no valid timestamp applies
+		note    string
+	)
+	for i := 0; i < len(data); {
+		value := -1
+		var rec []byte
+		n := 0 // length of the record at i
+		if data[i] == 0x55 && i+2 < len(data) && data[i+1] == 0xAA {
+			rec = data[i+2:]
+			n = historyRecordLen(rec)
+		}
+		offset := i
+		switch {
+		case n == 0 && data[i] == 0xFF:
+			period = 0
+			i++
+		case n == 0:
+			// includes records cut short, which are plain counts
+			value = int(data[i])
+			i++
+		case rec[0] == 0x00:
+			var prev time.Time
+			if period > 0 {
+				prev = t
+			}
+			t, status, period = historyTimestamp(rec[1:7], rec[9], loc, prev)
+			e := HistoryEntry{Offset: offset, Kind: HistoryTimestamp, Time: t, Period: period, Mode: "unknown", Status: status}
+			if int(rec[9]) < len(historyModes) {
+				e.Mode = historyModes[rec[9]]
+			}
+			entries = append(entries, e)
+		case rec[0] == 0x01:
+			value = int(binary.BigEndian.Uint16(rec[1:3]))
+		case rec[0] == 0x02:
+			note = string(rec[2 : 2+int(rec[1])])
+			entries = append(entries, HistoryEntry{Offset: offset, Kind: HistoryNote, Note: note})
+		case rec[0] == 0x03:
+			value = int(rec[1])<<16 | int(rec[2])<<8 | int(rec[3])
+		case rec[0] == 0x04:
+			value = int(binary.BigEndian.Uint32(rec[1:5]))
+		}
+		i += n
+		if value < 0 {
+			continue
+		}
+		e := HistoryEntry{Offset: offset, Kind: HistoryCount, Counts: value, Note: note}
+		if period > 0 {
+			e.Time, e.Period, e.Status = t, period, status
+			t = t.Add(period)
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+// historyRecordLen returns the length of the record following 55 AA,
+// including the marker, or 0 if rec is not a complete record.
+func historyRecordLen(rec []byte) int {
+	n := 0
+	switch rec[0] {
+	case 0x00:
+		if len(rec) >= 10 && rec[7] == 0x55 && rec[8] == 0xAA {
+			n = 10
+		}
+	case 0x01:
+		n = 3
+	case 0x02:
+		if len(rec) >= 2 {
+			n = 2 + int(rec[1])
+		}
+	case 0x03:
+		n = 4
+	case 0x04:
+		n = 5
+	}
+	if n == 0 || n > len(rec) {
+		return 0
+	}
+	return 2 + n
+}
+
+// historyTimestamp decodes the YY MM DD HH MI SS bytes and the save data
+// type of a timestamp record, prev being the end of the counts before it, see
+// ClockTimeAfter. period is zero if the timestamp is invalid or logging is
+// off.
+func historyTimestamp(b []byte, dataType byte, loc *time.Location, prev time.Time) (t time.Time, status ClockStatus, period time.Duration) {
+	if b[1] < 1 || b[1] > 12 || b[2] < 1 || b[2] > 31 || b[3] > 23 || b[4] > 59 || b[5] > 59 || int(dataType) >= len(historyPeriods) {
+		return time.Time{}, ClockExact, 0
+	}
+	t, status = ClockTimeAfter(2000+int(b[0]), time.Month(b[1]), int(b[2]), int(b[3]), int(b[4]), int(b[5]), loc, prev)
+	return t, status, historyPeriods[dataType]
+}
+
+func sortHistory(records []HistoryRecord) []HistoryRecord {
+	sort.SliceStable(records, func(i, j int) bool { return records[i].Time.Before(records[j].Time) })
+	return records
+}
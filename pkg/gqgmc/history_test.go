@@ -0,0 +1,142 @@
+package gqgmc_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mwuertinger/gq-gmc/pkg/gqgmc"
+)
+
+// timestamp returns a timestamp record of June 2024 with the save data type.
+func timestamp(day, hour, min byte, dataType byte) []byte {
+	return []byte{0x55, 0xAA, 0x00, 24, 6, day, hour, min, 0, 0x55, 0xAA, dataType}
+}
+
+func june(day, hour, min int) time.Time {
+	return time.Date(2024, time.June, day, hour, min, 0, 0, time.UTC)
+}
+
+func join(parts ...[]byte) []byte {
+	var b []byte
+	for _, p := range parts {
+		b = append(b, p...)
+	}
+	return b
+}
+
+func TestDecodeHistory(t *testing.T) {
+	ts := timestamp(3, 12, 0, 2)
+	stamp := gqgmc.HistoryEntry{Offset: 0, Kind: gqgmc.HistoryTimestamp, Time: june(3, 12, 0), Period: time.Minute, Mode: "every_minute"}
+	count := func(offset, counts int, at time.Time) gqgmc.HistoryEntry {
+		e := gqgmc.HistoryEntry{Offset: offset, Kind: gqgmc.HistoryCount, Counts: counts, Time: at}
+		if !at.IsZero() {
+			e.Period = time.Minute
+		}
+		return e
+	}
+	// a timestamp record without its second marker is read as counts
+	broken := []byte{0x55, 0xAA, 0x00, 24, 6, 3, 13, 0, 0, 0x00, 0x00, 2}
+	counts := func(b []byte) []gqgmc.HistoryEntry {
+		entries := []gqgmc.HistoryEntry{stamp}
+		for i, v := range b {
+			entries = append(entries, count(12+i, int(v), june(3, 12, i)))
+		}
+		return entries
+	}
+	tests := []struct {
+		name string
+		data []byte
+		want []gqgmc.HistoryEntry
+	}{
+		{"one byte values", join(ts, []byte{5, 0, 254}), []gqgmc.HistoryEntry{stamp, count(12, 5, june(3, 12, 0)), count(13, 0, june(3, 12, 1)), count(14, 254, june(3, 12, 2))}},
+		{"two byte value", join(ts, []byte{0x55, 0xAA, 0x01, 0x01, 0x2C, 7}), []gqgmc.HistoryEntry{stamp, count(12, 300, june(3, 12, 0)), count(17, 7, june(3, 12, 1))}},
+		{"three byte value", join(ts, []byte{0x55, 0xAA, 0x03, 0x01, 0x00, 0x02}), []gqgmc.HistoryEntry{stamp, count(12, 65538, june(3, 12, 0))}},
+		{"four byte value", join(ts, []byte{0x55, 0xAA, 0x04, 0x01, 0x00, 0x00, 0x00}), []gqgmc.HistoryEntry{stamp, count(12, 1<<24, june(3, 12, 0))}},
+		{"location text", join(ts, []byte{0x55, 0xAA, 0x02, 4, 'h', 'o', 'm', 'e', 3}), []gqgmc.HistoryEntry{
+			stamp,
+			{Offset: 12, Kind: gqgmc.HistoryNote, Note: "home"},
+			{Offset: 20, Kind: gqgmc.HistoryCount, Counts: 3, Time: june(3, 12, 0), Period: time.Minute, Note: "home"},
+		}},
+		{"every hour", join(timestamp(3, 12, 0, 3), []byte{40, 41}), []gqgmc.HistoryEntry{
+			{Kind: gqgmc.HistoryTimestamp, Time: june(3, 12, 0), Period: time.Hour, Mode: "every_hour"},
+			{Offset: 12, Kind: gqgmc.HistoryCount, Counts: 40, Time: june(3, 12, 0), Period: time.Hour},
+			{Offset: 13, Kind: gqgmc.HistoryCount, Counts: 41, Time: june(3, 13, 0), Period: time.Hour},
+		}},
+		{"counts before the first timestamp", join([]byte{4, 5}, ts, []byte{6}), []gqgmc.HistoryEntry{
+			count(0, 4, time.Time{}), count(1, 5, time.Time{}),
+			{Offset: 2, Kind: gqgmc.HistoryTimestamp, Time: june(3, 12, 0), Period: time.Minute, Mode: "every_minute"},
+			count(14, 6, june(3, 12, 0)),
+		}},
+		{"unused flash", join(ts, []byte{1, 0xFF, 0xFF, 2}, timestamp(3, 12, 10, 2), []byte{3}), []gqgmc.HistoryEntry{
+			stamp, count(12, 1, june(3, 12, 0)),
+			// the device follows unused flash with a timestamp
+			count(15, 2, time.Time{}),
+			{Offset: 16, Kind: gqgmc.HistoryTimestamp, Time: june(3, 12, 10), Period: time.Minute, Mode: "every_minute"},
+			count(28, 3, june(3, 12, 10)),
+		}},
+		{"invalid timestamp", join([]byte{0x55, 0xAA, 0x00, 24, 13, 3, 12, 0, 0, 0x55, 0xAA, 2}, []byte{1}), []gqgmc.HistoryEntry{
+			{Kind: gqgmc.HistoryTimestamp, Mode: "every_minute"}, count(12, 1, time.Time{}),
+		}},
+		{"logging off", join(timestamp(3, 12, 0, 0), []byte{1}), []gqgmc.HistoryEntry{
+			{Kind: gqgmc.HistoryTimestamp, Time: june(3, 12, 0), Mode: "off"}, count(12, 1, time.Time{}),
+		}},
+		{"unknown save data type", join(timestamp(3, 12, 0, 9), []byte{1}), []gqgmc.HistoryEntry{
+			{Kind: gqgmc.HistoryTimestamp, Mode: "unknown"}, count(12, 1, time.Time{}),
+		}},
+		// a record cut short by the end of the data is read as counts
+		{"record cut short", join(ts, []byte{0x55, 0xAA, 0x01, 0x02}), []gqgmc.HistoryEntry{
+			stamp, count(12, 0x55, june(3, 12, 0)), count(13, 0xAA, june(3, 12, 1)), count(14, 1, june(3, 12, 2)), count(15, 2, june(3, 12, 3)),
+		}},
+		{"timestamp without its second marker", join(ts, broken), counts(broken)},
+		{"marker alone", join(ts, []byte{0x55, 0x01}), []gqgmc.HistoryEntry{stamp, count(12, 0x55, june(3, 12, 0)), count(13, 1, june(3, 12, 1))}},
+		{"empty", nil, nil},
+	}
+	for _, tt := range tests {
+		got := gqgmc.DecodeHistory(tt.data, time.UTC)
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: DecodeHistory() = %d entries, want %d: %+v", tt.name, len(got), len(tt.want), got)
+			continue
+		}
+		for i, w := range tt.want {
+			g := got[i]
+			if g.Offset != w.Offset || g.Kind != w.Kind || !g.Time.Equal(w.Time) || g.Period != w.Period || g.Mode != w.Mode || g.Status != w.Status || g.Counts != w.Counts || g.Note != w.Note {
+				t.Errorf("%s: entry %d = %+v, want %+v", tt.name, i, g, w)
+			}
+		}
+	}
+}
+
+// TestParseHistoryWrapped reads a flash whose ring buffer wrapped around:
+// the newest records are at the start, followed by the sector erased ahead
+// of them and the rest of the oldest records.
+func TestParseHistoryWrapped(t *testing.T) {
+	data := join(
+		timestamp(10, 0, 0, 2), []byte{1, 2},
+		[]byte{0xFF, 0xFF, 0xFF, 0xFF},
+		// the oldest records, whose timestamp was overwritten
+		[]byte{9, 9},
+		timestamp(3, 0, 0, 1), []byte{3, 4},
+		[]byte{0x55, 0xAA, 0x02, 2, 'o', 'k'},
+		timestamp(3, 6, 0, 3), []byte{120},
+	)
+	want := []gqgmc.HistoryRecord{
+		{Time: june(3, 0, 0), Period: time.Second, Counts: 3},
+		{Time: june(3, 0, 0).Add(time.Second), Period: time.Second, Counts: 4},
+		{Time: june(3, 6, 0), Period: time.Hour, Counts: 120, Note: "ok"},
+		{Time: june(10, 0, 0), Period: time.Minute, Counts: 1},
+		{Time: june(10, 0, 1), Period: time.Minute, Counts: 2},
+	}
+	wantCPM := []float64{180, 240, 2, 1, 2}
+	got := gqgmc.ParseHistory(data, time.UTC)
+	if len(got) != len(want) {
+		t.Fatalf("ParseHistory() = %+v, want %+v", got, want)
+	}
+	for i, w := range want {
+		if g := got[i]; !g.Time.Equal(w.Time) || g.Period != w.Period || g.Status != w.Status || g.Counts != w.Counts || g.Note != w.Note {
+			t.Errorf("record %d = %+v, want %+v", i, g, w)
+		}
+		if cpm := got[i].CPM(); cpm != wantCPM[i] {
+			t.Errorf("record %d: CPM() = %v, want %v", i, cpm, wantCPM[i])
+		}
+	}
+}
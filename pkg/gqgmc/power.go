@@ -0,0 +1,114 @@
+package gqgmc
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Battery voltages used to infer the power source. A single Li-ion cell never
+// exceeds externalPowerVoltage on its own, so higher readings mean the device
+// is fed from USB or a wall adapter.
+const externalPowerVoltage = 4.3
+
+// PowerStatus describes how the device is powered.
+type PowerStatus struct {
+	Voltage  float64 // battery voltage in volts
+	External bool    // running on USB or wall adapter power
+	Charging bool    // external power is charging the battery
+}
+
+// Source returns "external" or "battery".
+func (p PowerStatus) Source() string {
+	if p.External {
+		return "external"
+	}
+	return "battery"
+}
+
+// Voltage returns the battery voltage reported by <GETVOLT>>.
+func (d *Device) Voltage() (float64, error) {
+	var v float64
+	err := d.exec(func() (err error) {
+		v, err = d.voltageLocked()
+		return err
+	})
+	return v, err
+}
+
+func (d *Device) voltageLocked() (float64, error) {
+	if err := d.sendSupported("GETVOLT"); err != nil {
+		return 0, err
+	}
+	// GMC-500 and GMC-600 answer in ASCII, e.g. "4.2v\x00", older models with
+	// a single byte holding tenths of a volt.
+	if d.quirks.VoltageASCII {
+		buf, err := d.read(5)
+		if err != nil {
+			return 0, err
+		}
+		v, err := strconv.ParseFloat(strings.TrimRight(string(buf), "vV\x00 "), 64)
+		if err != nil {
+			return 0, fmt.Errorf("%w: voltage %q", ErrCorrupt, buf)
+		}
+		return v, nil
+	}
+	buf, err := d.read(1)
+	if err != nil {
+		return 0, err
+	}
+	return float64(buf[0]) / 10, nil
+}
+
+// PowerStatus combines the battery voltage with the configured battery type
+// to determine the power source. Whether the battery is actually being
+// charged cannot be read from the device; it is assumed whenever a
+// rechargeable battery is on external power.
+func (d *Device) PowerStatus() (PowerStatus, error) {
+	var p PowerStatus
+	err := d.exec(func() error {
+		v, err := d.voltageLocked()
+		if err != nil {
+			return err
+		}
+		raw, err := d.configLocked()
+		if err != nil {
+			return err
+		}
+		cfg, err := ParseConfig(raw)
+		if err != nil {
+			return err
+		}
+		p = PowerStatus{Voltage: v, External: v >= externalPowerVoltage}
+		p.Charging = p.External && cfg.BatteryType == 0
+		return nil
+	})
+	return p, err
+}
+
+// PowerOff switches the device off (<POWEROFF>>). It keeps listening on the
+// USB port, so PowerOn can switch it on again.
+func (d *Device) PowerOff() error {
+	return d.powerCommand("POWEROFF")
+}
+
+// PowerOn switches a device on that was switched off (<POWERON>>).
+func (d *Device) PowerOn() error {
+	return d.powerCommand("POWERON")
+}
+
+// Reboot restarts the device (<REBOOT>>). Heartbeat mode ends with it.
+func (d *Device) Reboot() error {
+	return d.powerCommand("REBOOT")
+}
+
+// powerCommand sends a command without a response. A device that is off does
+// not answer <GETVER>>, so the quirks are not consulted, and heartbeat mode
+// is left alone as the command ends it anyway.
+func (d *Device) powerCommand(cmd string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.heartbeat = false
+	d.held = nil
+	return d.send(cmd)
+}
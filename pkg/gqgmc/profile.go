@@ -0,0 +1,128 @@
+package gqgmc
+
+import (
+	"strings"
+	"time"
+)
+
+// Tube describes the Geiger-Müller tube of a model.
+type Tube struct {
+	Name string
+
+	// Factor converts counts per minute to µSv/h.
+	Factor float64
+
+	// Curve, if set, converts counts per minute to µSv/h instead of
+	// Factor by interpolating linearly between its points, which are
+	// sorted by CPM. Above the last point the last segment is extended.
+	Curve []CalibrationPoint
+
+	// DeadTime is the time after each pulse during which the tube cannot
+	// detect another one. Zero disables dead time correction.
+	DeadTime time.Duration
+}
+
+// Tubes used by GMC counters, and by counters modified with another tube.
+var (
+	TubeM4011 = Tube{Name: "M4011", Factor: 0.00625}
+
+	// The LND-7317 pancake tube is far more sensitive than the M4011:
+	// 58 cps per mR/h for Cs-137 according to the LND datasheet, i.e. about
+	// 348 CPM per µSv/h. Its dead time is 40 µs.
+	TubeLND7317 = Tube{Name: "LND-7317", Factor: 1 / 348.0, DeadTime: 40 * time.Microsecond}
+
+	// The small SI-3BG is the high dose rate tube of the GMC-500+, which
+	// GQ calibrates at 0.194 µSv/h per CPM.
+	TubeSI3BG = Tube{Name: "SI-3BG", Factor: 0.194}
+
+	// The J305 of many DIY kits registers about 123 CPM per µSv/h.
+	TubeJ305 = Tube{Name: "J305", Factor: 1 / 123.0}
+
+	// The SBM-20 registers about 175 CPM per µSv/h for Cs-137.
+	TubeSBM20 = Tube{Name: "SBM-20", Factor: 1 / 175.0}
+)
+
+// Tubes lists the known tubes for LookupTube.
+var Tubes = []Tube{TubeM4011, TubeLND7317, TubeSI3BG, TubeJ305, TubeSBM20}
+
+// LookupTube returns the known tube of the given name, ignoring case.
+func LookupTube(name string) (Tube, bool) {
+	for _, t := range Tubes {
+		if strings.EqualFold(t.Name, name) {
+			return t, true
+		}
+	}
+	return Tube{}, false
+}
+
+// Profile holds the default measurement parameters of a model.
+type Profile struct {
+	Tube Tube
+
+	// HighTube is the tube for high dose rates of a dual tube model, read
+	// with DualCPM. Its Name is empty on models with a single tube.
+	HighTube Tube
+}
+
+// profileTable is keyed by prefixes of the <GETVER>> string like quirkTable.
+// Models not listed use the M4011.
+var profileTable = map[string]Profile{
+	"GMC-280":  {Tube: TubeM4011},
+	"GMC-300":  {Tube: TubeM4011},
+	"GMC-320":  {Tube: TubeM4011},
+	"GMC-500":  {Tube: TubeM4011},
+	"GMC-500+": {Tube: TubeM4011, HighTube: TubeSI3BG},
+	"GMC-600":  {Tube: TubeLND7317},
+}
+
+var defaultProfile = Profile{Tube: TubeM4011}
+
+// LookupProfile returns the profile for a <GETVER>> string.
+func LookupProfile(version string) Profile {
+	return lookupPrefix(profileTable, version, defaultProfile)
+}
+
+// CorrectDeadTime returns the count rate the tube would have measured without
+// dead time losses.
+func (t Tube) CorrectDeadTime(cpm float64) float64 {
+	if t.DeadTime <= 0 {
+		return cpm
+	}
+	cps := cpm / 60
+	loss := cps * t.DeadTime.Seconds()
+	if loss >= 1 {
+		// The tube is saturated, the true rate cannot be recovered.
+		return cpm
+	}
+	return cps / (1 - loss) * 60
+}
+
+// DoseRate converts a count rate to µSv/h, including dead time correction.
+func (t Tube) DoseRate(cpm float64) float64 {
+	cpm = t.CorrectDeadTime(cpm)
+	if len(t.Curve) == 0 {
+		return cpm * t.Factor
+	}
+	// the curve starts at the origin
+	prev := CalibrationPoint{}
+	for i, p := range t.Curve {
+		if cpm <= float64(p.CPM) || i == len(t.Curve)-1 {
+			slope := (p.USvH - prev.USvH) / float64(p.CPM-prev.CPM)
+			return prev.USvH + (cpm-float64(prev.CPM))*slope
+		}
+		prev = p
+	}
+	return 0
+}
+
+// lookupPrefix returns the entry with the longest key that is a prefix of
+// version, or def if there is none.
+func lookupPrefix[T any](table map[string]T, version string, def T) T {
+	best, v := -1, def
+	for prefix, entry := range table {
+		if strings.HasPrefix(version, prefix) && len(prefix) > best {
+			best, v = len(prefix), entry
+		}
+	}
+	return v
+}
@@ -0,0 +1,79 @@
+package gqgmc_test
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/mwuertinger/gq-gmc/pkg/gqgmc"
+)
+
+func TestTubeDoseRate(t *testing.T) {
+	curve := gqgmc.Tube{Curve: []gqgmc.CalibrationPoint{{CPM: 100, USvH: 0.5}, {CPM: 1000, USvH: 6.5}, {CPM: 10000, USvH: 80}}}
+	tests := []struct {
+		name string
+		tube gqgmc.Tube
+		cpm  float64
+		want float64
+	}{
+		{"factor", gqgmc.TubeM4011, 160, 1},
+		{"factor at zero", gqgmc.TubeM4011, 0, 0},
+		{"curve at zero", curve, 0, 0},
+		// the first segment starts at the origin
+		{"below the first point", curve, 50, 0.25},
+		{"at the first point", curve, 100, 0.5},
+		{"between points", curve, 400, 2.5},
+		{"at a middle point", curve, 1000, 6.5},
+		{"last segment", curve, 5500, 43.25},
+		{"at the last point", curve, 10000, 80},
+		// the last segment is extended
+		{"above the last point", curve, 12000, 96.33333333333333},
+		{"single point", gqgmc.Tube{Curve: []gqgmc.CalibrationPoint{{CPM: 200, USvH: 1.5}}}, 400, 3},
+		{"dead time", gqgmc.Tube{Factor: 0.01, DeadTime: 100 * time.Microsecond}, 60000, 600 / 0.9},
+		// saturated, no correction
+		{"dead time saturated", gqgmc.Tube{Factor: 0.01, DeadTime: time.Millisecond}, 60000, 600},
+		// 900 cps lose 9% to the dead time
+		{"dead time with curve", gqgmc.Tube{Curve: curve.Curve, DeadTime: 100 * time.Microsecond}, 54000, 80 + (54000/0.91-10000)*73.5/9000},
+	}
+	for _, tt := range tests {
+		if got := tt.tube.DoseRate(tt.cpm); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("%s: DoseRate(%v) = %v, want %v", tt.name, tt.cpm, got, tt.want)
+		}
+	}
+}
+
+func TestConfigCalibrationCurve(t *testing.T) {
+	tests := []struct {
+		name    string
+		points  [3]gqgmc.CalibrationPoint
+		want    []gqgmc.CalibrationPoint
+		wantErr bool
+	}{
+		{"sorted", [3]gqgmc.CalibrationPoint{{CPM: 100, USvH: 0.65}, {CPM: 1000, USvH: 6.5}, {CPM: 10000, USvH: 65}}, []gqgmc.CalibrationPoint{{CPM: 100, USvH: 0.65}, {CPM: 1000, USvH: 6.5}, {CPM: 10000, USvH: 65}}, false},
+		{"unsorted", [3]gqgmc.CalibrationPoint{{CPM: 10000, USvH: 65}, {CPM: 100, USvH: 0.65}, {CPM: 1000, USvH: 6.5}}, []gqgmc.CalibrationPoint{{CPM: 100, USvH: 0.65}, {CPM: 1000, USvH: 6.5}, {CPM: 10000, USvH: 65}}, false},
+		{"unset", [3]gqgmc.CalibrationPoint{}, nil, true},
+		{"same CPM", [3]gqgmc.CalibrationPoint{{CPM: 100, USvH: 0.65}, {CPM: 100, USvH: 0.7}, {CPM: 10000, USvH: 65}}, nil, true},
+		{"dose rate falling", [3]gqgmc.CalibrationPoint{{CPM: 100, USvH: 0.65}, {CPM: 1000, USvH: 0.5}, {CPM: 10000, USvH: 65}}, nil, true},
+		{"dose rate zero", [3]gqgmc.CalibrationPoint{{CPM: 100, USvH: 0}, {CPM: 1000, USvH: 6.5}, {CPM: 10000, USvH: 65}}, nil, true},
+		{"dose rate NaN", [3]gqgmc.CalibrationPoint{{CPM: 100, USvH: 0.65}, {CPM: 1000, USvH: math.NaN()}, {CPM: 10000, USvH: 65}}, nil, true},
+		{"dose rate infinite", [3]gqgmc.CalibrationPoint{{CPM: 100, USvH: 0.65}, {CPM: 1000, USvH: 6.5}, {CPM: 10000, USvH: math.Inf(1)}}, nil, true},
+	}
+	for _, tt := range tests {
+		c := gqgmc.Config{Calibration: tt.points}
+		got, err := c.CalibrationCurve()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: CalibrationCurve() = %v, %v, want error %v", tt.name, got, err, tt.wantErr)
+			continue
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: CalibrationCurve() = %v, want %v", tt.name, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%s: CalibrationCurve() = %v, want %v", tt.name, got, tt.want)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package gqgmc
+
+// Quirks describes how a firmware deviates from the documented protocol.
+type Quirks struct {
+	ConfigSize   int  // size of the <GETCFG>> block
+	VoltageASCII bool // <GETVOLT>> answers with 5 ASCII bytes instead of one binary byte
+	HistorySize  int  // size of the history flash read with <SPIR>>
+	CPM32        bool // <GETCPM>> answers with 4 bytes instead of 2
+	Heartbeat32  bool // heartbeat frames are 4 bytes instead of 2
+	DualTube     bool // a second tube is read with <GETCPML>> and <GETCPMH>>
+
+	// Unsupported lists commands the firmware lacks or implements so badly
+	// that issuing them does more harm than good. They fail with
+	// ErrUnsupported without touching the port.
+	Unsupported []string
+
+	// HeartbeatSkip is the number of frames to discard after enabling
+	// heartbeat mode; some firmware reports the counts accumulated since the
+	// last heartbeat session in the first frame.
+	HeartbeatSkip int
+}
+
+func (q *Quirks) unsupported(cmd string) bool {
+	for _, c := range q.Unsupported {
+		if c == cmd {
+			return true
+		}
+	}
+	return false
+}
+
+// quirkTable is keyed by prefixes of the <GETVER>> string. The longest
+// matching prefix wins, so point releases can override their model family.
+var quirkTable = map[string]Quirks{
+	"GMC-280": {ConfigSize: 256, HistorySize: 0x10000, Unsupported: []string{"GETTEMP", "GETGYRO"}},
+	"GMC-300": {ConfigSize: 256, HistorySize: 0x10000, Unsupported: []string{"GETTEMP", "GETGYRO"}},
+	"GMC-320": {ConfigSize: 256, HistorySize: 0x10000},
+	// Firmware 2.x has neither the thermometer nor the gyro.
+	"GMC-320Re 2.":   {ConfigSize: 256, HistorySize: 0x10000, Unsupported: []string{"GETTEMP", "GETGYRO"}},
+	"GMC-320Re 4.19": {ConfigSize: 256, HistorySize: 0x10000, HeartbeatSkip: 1},
+	"GMC-500":        {ConfigSize: 512, HistorySize: 0x100000, VoltageASCII: true, CPM32: true, Heartbeat32: true},
+	"GMC-500+":       {ConfigSize: 512, HistorySize: 0x100000, VoltageASCII: true, CPM32: true, Heartbeat32: true, DualTube: true},
+	// The thermometer of the first GMC-500+ releases reports a constant value.
+	"GMC-500+Re 1.": {ConfigSize: 512, HistorySize: 0x100000, VoltageASCII: true, CPM32: true, Heartbeat32: true, DualTube: true, Unsupported: []string{"GETTEMP"}},
+	"GMC-600":       {ConfigSize: 512, HistorySize: 0x100000, VoltageASCII: true, CPM32: true, Heartbeat32: true, Unsupported: []string{"GETGYRO"}},
+}
+
+// defaultQuirks applies to unknown firmware and assumes the GQ-RFC1201
+// protocol as documented.
+var defaultQuirks = Quirks{ConfigSize: 256, HistorySize: 0x10000}
+
+// LookupQuirks returns the quirks for a <GETVER>> string.
+func LookupQuirks(version string) Quirks {
+	return lookupPrefix(quirkTable, version, defaultQuirks)
+}
+
+// Quirks returns the quirks applied to the connected firmware.
+func (d *Device) Quirks() (Quirks, error) {
+	var q Quirks
+	err := d.exec(func() error {
+		if _, err := d.versionLocked(); err != nil {
+			return err
+		}
+		q = d.quirks
+		return nil
+	})
+	return q, err
+}
@@ -0,0 +1,71 @@
+package gqgmc
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// ErrUnsupported is returned for features the connected model lacks.
+var ErrUnsupported = errors.New("gqgmc: not supported by this model")
+
+// SetSpeaker switches the click sound on or off.
+func (d *Device) SetSpeaker(on bool) error {
+	return d.UpdateConfig(func(c *Config) error {
+		c.Speaker = on
+		return nil
+	})
+}
+
+// SetBacklight sets the backlight timeout to setting of the backlight menu
+// of the device, counted from 0 for off.
+func (d *Device) SetBacklight(setting int) error {
+	if setting < 0 || setting > math.MaxUint8 {
+		return fmt.Errorf("gqgmc: backlight setting %d out of range", setting)
+	}
+	return d.UpdateConfig(func(c *Config) error {
+		c.Backlight = setting
+		return nil
+	})
+}
+
+// SetQuiet prepares the device for quiet operation at night by switching the
+// speaker and the backlight off in a single configuration write. Leaving
+// quiet operation switches the speaker on again; the previous backlight
+// setting is not known, so it has to be restored with SetBacklight.
+func (d *Device) SetQuiet(quiet bool) error {
+	return d.UpdateConfig(func(c *Config) error {
+		c.Speaker = !quiet
+		if quiet {
+			c.Backlight = 0
+		}
+		return nil
+	})
+}
+
+// SetVibration switches the vibration motor on or off. Only the GMC-500 and
+// GMC-600 families have one; other models return ErrUnsupported.
+func (d *Device) SetVibration(on bool) error {
+	return d.UpdateConfig(func(c *Config) error {
+		if !c.Has("vibration") {
+			return ErrUnsupported
+		}
+		c.Vibration = on
+		return nil
+	})
+}
+
+// Keys are numbered from 0 to 3 like the commands <KEY0>> to <KEY3>> that
+// emulate pressing them.
+const Keys = 4
+
+// PressKey emulates a press of key n (<KEY0>> to <KEY3>>), the keys S1 to
+// S4 from left to right, e.g. to navigate the menu remotely.
+func (d *Device) PressKey(n int) error {
+	if n < 0 || n >= Keys {
+		return fmt.Errorf("gqgmc: key %d out of range 0 to %d", n, Keys-1)
+	}
+	return d.exec(func() error {
+		return d.sendSupported(fmt.Sprintf("KEY%d", n))
+	})
+}
@@ -0,0 +1,173 @@
+package gqgmc
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+// Gyro is the raw orientation reported by <GETGYRO>>.
+type Gyro struct {
+	X, Y, Z int16
+}
+
+// Telemetry is a snapshot of the device health. Readings the model does not
+// support are left nil.
+type Telemetry struct {
+	Version     string
+	Voltage     *float64
+	Temperature *float64 // degrees Celsius
+	Gyro        *Gyro
+	Clock       *time.Time
+	ClockDrift  time.Duration // device clock minus host clock
+}
+
+// Telemetry queries all health readings while heartbeat mode is suspended
+// only once.
+func (d *Device) Telemetry() (Telemetry, error) {
+	var t Telemetry
+	err := d.exec(func() error {
+		var err error
+		t.Version, err = d.versionLocked()
+		if err != nil {
+			return err
+		}
+		if v, err := d.voltageLocked(); err == nil {
+			t.Voltage = &v
+		} else {
+			d.drain()
+		}
+		if v, err := d.temperatureLocked(); err == nil {
+			t.Temperature = &v
+		} else {
+			d.drain()
+		}
+		if g, err := d.gyroLocked(); err == nil {
+			t.Gyro = &g
+		} else {
+			d.drain()
+		}
+		if c, err := d.dateTimeLocked(); err == nil {
+			t.Clock = &c
+			t.ClockDrift = time.Until(c).Round(time.Second)
+		} else {
+			d.drain()
+		}
+		return nil
+	})
+	return t, err
+}
+
+// Temperature returns the temperature in degrees Celsius (<GETTEMP>>).
+func (d *Device) Temperature() (float64, error) {
+	var v float64
+	err := d.exec(func() (err error) {
+		v, err = d.temperatureLocked()
+		return err
+	})
+	return v, err
+}
+
+func (d *Device) temperatureLocked() (float64, error) {
+	if err := d.sendSupported("GETTEMP"); err != nil {
+		return 0, err
+	}
+	// integer part, decimal part, sign (0 = positive), 0xAA
+	buf, err := d.read(4)
+	if err != nil {
+		return 0, err
+	}
+	if buf[3] != ack || buf[1] > 9 {
+		return 0, fmt.Errorf("%w: temperature %x", ErrCorrupt, buf)
+	}
+	v := float64(buf[0]) + float64(buf[1])/10
+	if buf[2] != 0 {
+		v = -v
+	}
+	return v, nil
+}
+
+// Gyro returns the orientation (<GETGYRO>>).
+func (d *Device) Gyro() (Gyro, error) {
+	var g Gyro
+	err := d.exec(func() (err error) {
+		g, err = d.gyroLocked()
+		return err
+	})
+	return g, err
+}
+
+func (d *Device) gyroLocked() (Gyro, error) {
+	if err := d.sendSupported("GETGYRO"); err != nil {
+		return Gyro{}, err
+	}
+	buf, err := d.read(7)
+	if err != nil {
+		return Gyro{}, err
+	}
+	if buf[6] != ack {
+		return Gyro{}, fmt.Errorf("%w: gyro data %x", ErrCorrupt, buf)
+	}
+	return Gyro{
+		X: int16(binary.BigEndian.Uint16(buf[0:])),
+		Y: int16(binary.BigEndian.Uint16(buf[2:])),
+		Z: int16(binary.BigEndian.Uint16(buf[4:])),
+	}, nil
+}
+
+// DateTime returns the time of the device's real time clock
+// (<GETDATETIME>>). The clock has no notion of time zones, its reading is
+// interpreted in the location set with SetClockLocation.
+func (d *Device) DateTime() (time.Time, error) {
+	var t time.Time
+	err := d.exec(func() (err error) {
+		t, err = d.dateTimeLocked()
+		return err
+	})
+	return t, err
+}
+
+func (d *Device) dateTimeLocked() (time.Time, error) {
+	if err := d.sendSupported("GETDATETIME"); err != nil {
+		return time.Time{}, err
+	}
+	// YY MM DD HH MM SS 0xAA
+	buf, err := d.read(7)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if buf[6] != ack || buf[1] < 1 || buf[1] > 12 || buf[2] < 1 || buf[2] > 31 ||
+		buf[3] > 23 || buf[4] > 59 || buf[5] > 59 {
+		return time.Time{}, fmt.Errorf("%w: date %x", ErrCorrupt, buf)
+	}
+	t, _ := ClockTime(2000+int(buf[0]), time.Month(buf[1]), int(buf[2]),
+		int(buf[3]), int(buf[4]), int(buf[5]), d.clockLoc)
+	return t, nil
+}
+
+// SetClockLocation sets the time zone the device clock is kept in. It
+// defaults to the local time zone of the host.
+func (d *Device) SetClockLocation(loc *time.Location) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.clockLoc = loc
+}
+
+// ClockLocation returns the time zone of the device clock.
+func (d *Device) ClockLocation() *time.Location {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.clockLoc
+}
+
+// SetDateTime sets the device clock to t, converted to the time zone of the
+// device clock (<SETDATETIME[YYMMDDHHMMSS]>>).
+func (d *Device) SetDateTime(t time.Time) error {
+	return d.exec(func() error {
+		t := t.In(d.clockLoc)
+		cmd := []byte("SETDATETIME")
+		cmd = append(cmd, byte(t.Year()-2000), byte(t.Month()), byte(t.Day()),
+			byte(t.Hour()), byte(t.Minute()), byte(t.Second()))
+		return d.sendAck(string(cmd))
+	})
+}
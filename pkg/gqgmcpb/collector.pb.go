@@ -0,0 +1,1109 @@
+// The gRPC API of gq-gmc serve, enabled with -grpcListen.
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.34.2
+// 	protoc        (unknown)
+// source: collector.proto
+
+package gqgmcpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type WatchReadingsRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+}
+
+func (x *WatchReadingsRequest) Reset() {
+	*x = WatchReadingsRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_collector_proto_msgTypes[0]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *WatchReadingsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WatchReadingsRequest) ProtoMessage() {}
+
+func (x *WatchReadingsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_collector_proto_msgTypes[0]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WatchReadingsRequest.ProtoReflect.Descriptor instead.
+func (*WatchReadingsRequest) Descriptor() ([]byte, []int) {
+	return file_collector_proto_rawDescGZIP(), []int{0}
+}
+
+// Reading is the result of an interval.
+type Reading struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Time                *timestamppb.Timestamp `protobuf:"bytes,1,opt,name=time,proto3" json:"time,omitempty"` // end of the interval
+	Cpm                 int32                  `protobuf:"varint,2,opt,name=cpm,proto3" json:"cpm,omitempty"`
+	DoseRate            float64                `protobuf:"fixed64,3,opt,name=dose_rate,json=doseRate,proto3" json:"dose_rate,omitempty"`                   // µSv/h
+	CumulativeDose      float64                `protobuf:"fixed64,4,opt,name=cumulative_dose,json=cumulativeDose,proto3" json:"cumulative_dose,omitempty"` // µSv since cumulative_dose_since
+	CumulativeDoseSince *timestamppb.Timestamp `protobuf:"bytes,5,opt,name=cumulative_dose_since,json=cumulativeDoseSince,proto3" json:"cumulative_dose_since,omitempty"`
+	Device              string                 `protobuf:"bytes,6,opt,name=device,proto3" json:"device,omitempty"`                       // version string, e.g. GMC-320Re 4.19
+	CpmRise             float64                `protobuf:"fixed64,7,opt,name=cpm_rise,json=cpmRise,proto3" json:"cpm_rise,omitempty"`    // rise of the smoothed count rate in %/min
+	CpmSigma            float64                `protobuf:"fixed64,8,opt,name=cpm_sigma,json=cpmSigma,proto3" json:"cpm_sigma,omitempty"` // standard deviations above the baseline
+	BatteryVoltage      *float64               `protobuf:"fixed64,9,opt,name=battery_voltage,json=batteryVoltage,proto3,oneof" json:"battery_voltage,omitempty"`
+	PowerSource         string                 `protobuf:"bytes,10,opt,name=power_source,json=powerSource,proto3" json:"power_source,omitempty"` // battery or external, empty if unknown
+}
+
+func (x *Reading) Reset() {
+	*x = Reading{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_collector_proto_msgTypes[1]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Reading) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Reading) ProtoMessage() {}
+
+func (x *Reading) ProtoReflect() protoreflect.Message {
+	mi := &file_collector_proto_msgTypes[1]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Reading.ProtoReflect.Descriptor instead.
+func (*Reading) Descriptor() ([]byte, []int) {
+	return file_collector_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *Reading) GetTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Time
+	}
+	return nil
+}
+
+func (x *Reading) GetCpm() int32 {
+	if x != nil {
+		return x.Cpm
+	}
+	return 0
+}
+
+func (x *Reading) GetDoseRate() float64 {
+	if x != nil {
+		return x.DoseRate
+	}
+	return 0
+}
+
+func (x *Reading) GetCumulativeDose() float64 {
+	if x != nil {
+		return x.CumulativeDose
+	}
+	return 0
+}
+
+func (x *Reading) GetCumulativeDoseSince() *timestamppb.Timestamp {
+	if x != nil {
+		return x.CumulativeDoseSince
+	}
+	return nil
+}
+
+func (x *Reading) GetDevice() string {
+	if x != nil {
+		return x.Device
+	}
+	return ""
+}
+
+func (x *Reading) GetCpmRise() float64 {
+	if x != nil {
+		return x.CpmRise
+	}
+	return 0
+}
+
+func (x *Reading) GetCpmSigma() float64 {
+	if x != nil {
+		return x.CpmSigma
+	}
+	return 0
+}
+
+func (x *Reading) GetBatteryVoltage() float64 {
+	if x != nil && x.BatteryVoltage != nil {
+		return *x.BatteryVoltage
+	}
+	return 0
+}
+
+func (x *Reading) GetPowerSource() string {
+	if x != nil {
+		return x.PowerSource
+	}
+	return ""
+}
+
+type GetDeviceInfoRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+}
+
+func (x *GetDeviceInfoRequest) Reset() {
+	*x = GetDeviceInfoRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_collector_proto_msgTypes[2]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *GetDeviceInfoRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetDeviceInfoRequest) ProtoMessage() {}
+
+func (x *GetDeviceInfoRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_collector_proto_msgTypes[2]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetDeviceInfoRequest.ProtoReflect.Descriptor instead.
+func (*GetDeviceInfoRequest) Descriptor() ([]byte, []int) {
+	return file_collector_proto_rawDescGZIP(), []int{2}
+}
+
+type DeviceInfo struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Version string `protobuf:"bytes,1,opt,name=version,proto3" json:"version,omitempty"` // e.g. GMC-320Re 4.19
+	Model   string `protobuf:"bytes,2,opt,name=model,proto3" json:"model,omitempty"`     // e.g. GMC-320, empty if unknown
+	Serial  string `protobuf:"bytes,3,opt,name=serial,proto3" json:"serial,omitempty"`   // 14 hex digits, empty if unknown
+	// unset if the configuration could not be read
+	Settings *DeviceSettings `protobuf:"bytes,4,opt,name=settings,proto3" json:"settings,omitempty"`
+}
+
+func (x *DeviceInfo) Reset() {
+	*x = DeviceInfo{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_collector_proto_msgTypes[3]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *DeviceInfo) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DeviceInfo) ProtoMessage() {}
+
+func (x *DeviceInfo) ProtoReflect() protoreflect.Message {
+	mi := &file_collector_proto_msgTypes[3]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DeviceInfo.ProtoReflect.Descriptor instead.
+func (*DeviceInfo) Descriptor() ([]byte, []int) {
+	return file_collector_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *DeviceInfo) GetVersion() string {
+	if x != nil {
+		return x.Version
+	}
+	return ""
+}
+
+func (x *DeviceInfo) GetModel() string {
+	if x != nil {
+		return x.Model
+	}
+	return ""
+}
+
+func (x *DeviceInfo) GetSerial() string {
+	if x != nil {
+		return x.Serial
+	}
+	return ""
+}
+
+func (x *DeviceInfo) GetSettings() *DeviceSettings {
+	if x != nil {
+		return x.Settings
+	}
+	return nil
+}
+
+// DeviceSettings are the settings of the device configuration that decide
+// how the counter measures and alerts on site.
+type DeviceSettings struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	ReadAt        *timestamppb.Timestamp `protobuf:"bytes,1,opt,name=read_at,json=readAt,proto3" json:"read_at,omitempty"`
+	Speaker       bool                   `protobuf:"varint,2,opt,name=speaker,proto3" json:"speaker,omitempty"`
+	Vibration     *bool                  `protobuf:"varint,3,opt,name=vibration,proto3,oneof" json:"vibration,omitempty"` // GMC-500 and GMC-600 only
+	Alarm         bool                   `protobuf:"varint,4,opt,name=alarm,proto3" json:"alarm,omitempty"`
+	AlarmOn       string                 `protobuf:"bytes,5,opt,name=alarm_on,json=alarmOn,proto3" json:"alarm_on,omitempty"` // cpm or dose_rate
+	AlarmCpm      int32                  `protobuf:"varint,6,opt,name=alarm_cpm,json=alarmCpm,proto3" json:"alarm_cpm,omitempty"`
+	AlarmDoseRate float64                `protobuf:"fixed64,7,opt,name=alarm_dose_rate,json=alarmDoseRate,proto3" json:"alarm_dose_rate,omitempty"` // µSv/h
+	HistoryMode   string                 `protobuf:"bytes,8,opt,name=history_mode,json=historyMode,proto3" json:"history_mode,omitempty"`           // e.g. every_minute
+	DisplayUnit   string                 `protobuf:"bytes,9,opt,name=display_unit,json=displayUnit,proto3" json:"display_unit,omitempty"`           // cpm, usvh, mrh or cps
+	PowerSaving   int32                  `protobuf:"varint,10,opt,name=power_saving,json=powerSaving,proto3" json:"power_saving,omitempty"`
+	Backlight     int32                  `protobuf:"varint,11,opt,name=backlight,proto3" json:"backlight,omitempty"` // setting as numbered in the device menu
+}
+
+func (x *DeviceSettings) Reset() {
+	*x = DeviceSettings{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_collector_proto_msgTypes[4]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *DeviceSettings) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DeviceSettings) ProtoMessage() {}
+
+func (x *DeviceSettings) ProtoReflect() protoreflect.Message {
+	mi := &file_collector_proto_msgTypes[4]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DeviceSettings.ProtoReflect.Descriptor instead.
+func (*DeviceSettings) Descriptor() ([]byte, []int) {
+	return file_collector_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *DeviceSettings) GetReadAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.ReadAt
+	}
+	return nil
+}
+
+func (x *DeviceSettings) GetSpeaker() bool {
+	if x != nil {
+		return x.Speaker
+	}
+	return false
+}
+
+func (x *DeviceSettings) GetVibration() bool {
+	if x != nil && x.Vibration != nil {
+		return *x.Vibration
+	}
+	return false
+}
+
+func (x *DeviceSettings) GetAlarm() bool {
+	if x != nil {
+		return x.Alarm
+	}
+	return false
+}
+
+func (x *DeviceSettings) GetAlarmOn() string {
+	if x != nil {
+		return x.AlarmOn
+	}
+	return ""
+}
+
+func (x *DeviceSettings) GetAlarmCpm() int32 {
+	if x != nil {
+		return x.AlarmCpm
+	}
+	return 0
+}
+
+func (x *DeviceSettings) GetAlarmDoseRate() float64 {
+	if x != nil {
+		return x.AlarmDoseRate
+	}
+	return 0
+}
+
+func (x *DeviceSettings) GetHistoryMode() string {
+	if x != nil {
+		return x.HistoryMode
+	}
+	return ""
+}
+
+func (x *DeviceSettings) GetDisplayUnit() string {
+	if x != nil {
+		return x.DisplayUnit
+	}
+	return ""
+}
+
+func (x *DeviceSettings) GetPowerSaving() int32 {
+	if x != nil {
+		return x.PowerSaving
+	}
+	return 0
+}
+
+func (x *DeviceSettings) GetBacklight() int32 {
+	if x != nil {
+		return x.Backlight
+	}
+	return 0
+}
+
+type PressKeyRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Key int32 `protobuf:"varint,1,opt,name=key,proto3" json:"key,omitempty"` // 0 to 3, the keys S1 to S4 from left to right
+}
+
+func (x *PressKeyRequest) Reset() {
+	*x = PressKeyRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_collector_proto_msgTypes[5]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *PressKeyRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PressKeyRequest) ProtoMessage() {}
+
+func (x *PressKeyRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_collector_proto_msgTypes[5]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PressKeyRequest.ProtoReflect.Descriptor instead.
+func (*PressKeyRequest) Descriptor() ([]byte, []int) {
+	return file_collector_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *PressKeyRequest) GetKey() int32 {
+	if x != nil {
+		return x.Key
+	}
+	return 0
+}
+
+type PressKeyResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+}
+
+func (x *PressKeyResponse) Reset() {
+	*x = PressKeyResponse{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_collector_proto_msgTypes[6]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *PressKeyResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PressKeyResponse) ProtoMessage() {}
+
+func (x *PressKeyResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_collector_proto_msgTypes[6]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PressKeyResponse.ProtoReflect.Descriptor instead.
+func (*PressKeyResponse) Descriptor() ([]byte, []int) {
+	return file_collector_proto_rawDescGZIP(), []int{6}
+}
+
+type SyncClockRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+}
+
+func (x *SyncClockRequest) Reset() {
+	*x = SyncClockRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_collector_proto_msgTypes[7]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *SyncClockRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SyncClockRequest) ProtoMessage() {}
+
+func (x *SyncClockRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_collector_proto_msgTypes[7]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SyncClockRequest.ProtoReflect.Descriptor instead.
+func (*SyncClockRequest) Descriptor() ([]byte, []int) {
+	return file_collector_proto_rawDescGZIP(), []int{7}
+}
+
+type SyncClockResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+}
+
+func (x *SyncClockResponse) Reset() {
+	*x = SyncClockResponse{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_collector_proto_msgTypes[8]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *SyncClockResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SyncClockResponse) ProtoMessage() {}
+
+func (x *SyncClockResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_collector_proto_msgTypes[8]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SyncClockResponse.ProtoReflect.Descriptor instead.
+func (*SyncClockResponse) Descriptor() ([]byte, []int) {
+	return file_collector_proto_rawDescGZIP(), []int{8}
+}
+
+type SetCollectionRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Enabled bool `protobuf:"varint,1,opt,name=enabled,proto3" json:"enabled,omitempty"` // false pauses the collection
+}
+
+func (x *SetCollectionRequest) Reset() {
+	*x = SetCollectionRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_collector_proto_msgTypes[9]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *SetCollectionRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SetCollectionRequest) ProtoMessage() {}
+
+func (x *SetCollectionRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_collector_proto_msgTypes[9]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SetCollectionRequest.ProtoReflect.Descriptor instead.
+func (*SetCollectionRequest) Descriptor() ([]byte, []int) {
+	return file_collector_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *SetCollectionRequest) GetEnabled() bool {
+	if x != nil {
+		return x.Enabled
+	}
+	return false
+}
+
+type SetCollectionResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+}
+
+func (x *SetCollectionResponse) Reset() {
+	*x = SetCollectionResponse{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_collector_proto_msgTypes[10]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *SetCollectionResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SetCollectionResponse) ProtoMessage() {}
+
+func (x *SetCollectionResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_collector_proto_msgTypes[10]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SetCollectionResponse.ProtoReflect.Descriptor instead.
+func (*SetCollectionResponse) Descriptor() ([]byte, []int) {
+	return file_collector_proto_rawDescGZIP(), []int{10}
+}
+
+type SetHeartbeatRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Enabled bool `protobuf:"varint,1,opt,name=enabled,proto3" json:"enabled,omitempty"` // false stops the counter from streaming
+}
+
+func (x *SetHeartbeatRequest) Reset() {
+	*x = SetHeartbeatRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_collector_proto_msgTypes[11]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *SetHeartbeatRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SetHeartbeatRequest) ProtoMessage() {}
+
+func (x *SetHeartbeatRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_collector_proto_msgTypes[11]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SetHeartbeatRequest.ProtoReflect.Descriptor instead.
+func (*SetHeartbeatRequest) Descriptor() ([]byte, []int) {
+	return file_collector_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *SetHeartbeatRequest) GetEnabled() bool {
+	if x != nil {
+		return x.Enabled
+	}
+	return false
+}
+
+type SetHeartbeatResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+}
+
+func (x *SetHeartbeatResponse) Reset() {
+	*x = SetHeartbeatResponse{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_collector_proto_msgTypes[12]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *SetHeartbeatResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SetHeartbeatResponse) ProtoMessage() {}
+
+func (x *SetHeartbeatResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_collector_proto_msgTypes[12]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SetHeartbeatResponse.ProtoReflect.Descriptor instead.
+func (*SetHeartbeatResponse) Descriptor() ([]byte, []int) {
+	return file_collector_proto_rawDescGZIP(), []int{12}
+}
+
+var File_collector_proto protoreflect.FileDescriptor
+
+var file_collector_proto_rawDesc = []byte{
+	0x0a, 0x0f, 0x63, 0x6f, 0x6c, 0x6c, 0x65, 0x63, 0x74, 0x6f, 0x72, 0x2e, 0x70, 0x72, 0x6f, 0x74,
+	0x6f, 0x12, 0x08, 0x67, 0x71, 0x67, 0x6d, 0x63, 0x2e, 0x76, 0x31, 0x1a, 0x1f, 0x67, 0x6f, 0x6f,
+	0x67, 0x6c, 0x65, 0x2f, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2f, 0x74, 0x69, 0x6d,
+	0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x22, 0x16, 0x0a, 0x14,
+	0x57, 0x61, 0x74, 0x63, 0x68, 0x52, 0x65, 0x61, 0x64, 0x69, 0x6e, 0x67, 0x73, 0x52, 0x65, 0x71,
+	0x75, 0x65, 0x73, 0x74, 0x22, 0x96, 0x03, 0x0a, 0x07, 0x52, 0x65, 0x61, 0x64, 0x69, 0x6e, 0x67,
+	0x12, 0x2e, 0x0a, 0x04, 0x74, 0x69, 0x6d, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1a,
+	0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66,
+	0x2e, 0x54, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x52, 0x04, 0x74, 0x69, 0x6d, 0x65,
+	0x12, 0x10, 0x0a, 0x03, 0x63, 0x70, 0x6d, 0x18, 0x02, 0x20, 0x01, 0x28, 0x05, 0x52, 0x03, 0x63,
+	0x70, 0x6d, 0x12, 0x1b, 0x0a, 0x09, 0x64, 0x6f, 0x73, 0x65, 0x5f, 0x72, 0x61, 0x74, 0x65, 0x18,
+	0x03, 0x20, 0x01, 0x28, 0x01, 0x52, 0x08, 0x64, 0x6f, 0x73, 0x65, 0x52, 0x61, 0x74, 0x65, 0x12,
+	0x27, 0x0a, 0x0f, 0x63, 0x75, 0x6d, 0x75, 0x6c, 0x61, 0x74, 0x69, 0x76, 0x65, 0x5f, 0x64, 0x6f,
+	0x73, 0x65, 0x18, 0x04, 0x20, 0x01, 0x28, 0x01, 0x52, 0x0e, 0x63, 0x75, 0x6d, 0x75, 0x6c, 0x61,
+	0x74, 0x69, 0x76, 0x65, 0x44, 0x6f, 0x73, 0x65, 0x12, 0x4e, 0x0a, 0x15, 0x63, 0x75, 0x6d, 0x75,
+	0x6c, 0x61, 0x74, 0x69, 0x76, 0x65, 0x5f, 0x64, 0x6f, 0x73, 0x65, 0x5f, 0x73, 0x69, 0x6e, 0x63,
+	0x65, 0x18, 0x05, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1a, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65,
+	0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x54, 0x69, 0x6d, 0x65, 0x73, 0x74,
+	0x61, 0x6d, 0x70, 0x52, 0x13, 0x63, 0x75, 0x6d, 0x75, 0x6c, 0x61, 0x74, 0x69, 0x76, 0x65, 0x44,
+	0x6f, 0x73, 0x65, 0x53, 0x69, 0x6e, 0x63, 0x65, 0x12, 0x16, 0x0a, 0x06, 0x64, 0x65, 0x76, 0x69,
+	0x63, 0x65, 0x18, 0x06, 0x20, 0x01, 0x28, 0x09, 0x52, 0x06, 0x64, 0x65, 0x76, 0x69, 0x63, 0x65,
+	0x12, 0x19, 0x0a, 0x08, 0x63, 0x70, 0x6d, 0x5f, 0x72, 0x69, 0x73, 0x65, 0x18, 0x07, 0x20, 0x01,
+	0x28, 0x01, 0x52, 0x07, 0x63, 0x70, 0x6d, 0x52, 0x69, 0x73, 0x65, 0x12, 0x1b, 0x0a, 0x09, 0x63,
+	0x70, 0x6d, 0x5f, 0x73, 0x69, 0x67, 0x6d, 0x61, 0x18, 0x08, 0x20, 0x01, 0x28, 0x01, 0x52, 0x08,
+	0x63, 0x70, 0x6d, 0x53, 0x69, 0x67, 0x6d, 0x61, 0x12, 0x2c, 0x0a, 0x0f, 0x62, 0x61, 0x74, 0x74,
+	0x65, 0x72, 0x79, 0x5f, 0x76, 0x6f, 0x6c, 0x74, 0x61, 0x67, 0x65, 0x18, 0x09, 0x20, 0x01, 0x28,
+	0x01, 0x48, 0x00, 0x52, 0x0e, 0x62, 0x61, 0x74, 0x74, 0x65, 0x72, 0x79, 0x56, 0x6f, 0x6c, 0x74,
+	0x61, 0x67, 0x65, 0x88, 0x01, 0x01, 0x12, 0x21, 0x0a, 0x0c, 0x70, 0x6f, 0x77, 0x65, 0x72, 0x5f,
+	0x73, 0x6f, 0x75, 0x72, 0x63, 0x65, 0x18, 0x0a, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0b, 0x70, 0x6f,
+	0x77, 0x65, 0x72, 0x53, 0x6f, 0x75, 0x72, 0x63, 0x65, 0x42, 0x12, 0x0a, 0x10, 0x5f, 0x62, 0x61,
+	0x74, 0x74, 0x65, 0x72, 0x79, 0x5f, 0x76, 0x6f, 0x6c, 0x74, 0x61, 0x67, 0x65, 0x22, 0x16, 0x0a,
+	0x14, 0x47, 0x65, 0x74, 0x44, 0x65, 0x76, 0x69, 0x63, 0x65, 0x49, 0x6e, 0x66, 0x6f, 0x52, 0x65,
+	0x71, 0x75, 0x65, 0x73, 0x74, 0x22, 0x8a, 0x01, 0x0a, 0x0a, 0x44, 0x65, 0x76, 0x69, 0x63, 0x65,
+	0x49, 0x6e, 0x66, 0x6f, 0x12, 0x18, 0x0a, 0x07, 0x76, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x18,
+	0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x07, 0x76, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x12, 0x14,
+	0x0a, 0x05, 0x6d, 0x6f, 0x64, 0x65, 0x6c, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x6d,
+	0x6f, 0x64, 0x65, 0x6c, 0x12, 0x16, 0x0a, 0x06, 0x73, 0x65, 0x72, 0x69, 0x61, 0x6c, 0x18, 0x03,
+	0x20, 0x01, 0x28, 0x09, 0x52, 0x06, 0x73, 0x65, 0x72, 0x69, 0x61, 0x6c, 0x12, 0x34, 0x0a, 0x08,
+	0x73, 0x65, 0x74, 0x74, 0x69, 0x6e, 0x67, 0x73, 0x18, 0x04, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x18,
+	0x2e, 0x67, 0x71, 0x67, 0x6d, 0x63, 0x2e, 0x76, 0x31, 0x2e, 0x44, 0x65, 0x76, 0x69, 0x63, 0x65,
+	0x53, 0x65, 0x74, 0x74, 0x69, 0x6e, 0x67, 0x73, 0x52, 0x08, 0x73, 0x65, 0x74, 0x74, 0x69, 0x6e,
+	0x67, 0x73, 0x22, 0x8d, 0x03, 0x0a, 0x0e, 0x44, 0x65, 0x76, 0x69, 0x63, 0x65, 0x53, 0x65, 0x74,
+	0x74, 0x69, 0x6e, 0x67, 0x73, 0x12, 0x33, 0x0a, 0x07, 0x72, 0x65, 0x61, 0x64, 0x5f, 0x61, 0x74,
+	0x18, 0x01, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1a, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e,
+	0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x54, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61,
+	0x6d, 0x70, 0x52, 0x06, 0x72, 0x65, 0x61, 0x64, 0x41, 0x74, 0x12, 0x18, 0x0a, 0x07, 0x73, 0x70,
+	0x65, 0x61, 0x6b, 0x65, 0x72, 0x18, 0x02, 0x20, 0x01, 0x28, 0x08, 0x52, 0x07, 0x73, 0x70, 0x65,
+	0x61, 0x6b, 0x65, 0x72, 0x12, 0x21, 0x0a, 0x09, 0x76, 0x69, 0x62, 0x72, 0x61, 0x74, 0x69, 0x6f,
+	0x6e, 0x18, 0x03, 0x20, 0x01, 0x28, 0x08, 0x48, 0x00, 0x52, 0x09, 0x76, 0x69, 0x62, 0x72, 0x61,
+	0x74, 0x69, 0x6f, 0x6e, 0x88, 0x01, 0x01, 0x12, 0x14, 0x0a, 0x05, 0x61, 0x6c, 0x61, 0x72, 0x6d,
+	0x18, 0x04, 0x20, 0x01, 0x28, 0x08, 0x52, 0x05, 0x61, 0x6c, 0x61, 0x72, 0x6d, 0x12, 0x19, 0x0a,
+	0x08, 0x61, 0x6c, 0x61, 0x72, 0x6d, 0x5f, 0x6f, 0x6e, 0x18, 0x05, 0x20, 0x01, 0x28, 0x09, 0x52,
+	0x07, 0x61, 0x6c, 0x61, 0x72, 0x6d, 0x4f, 0x6e, 0x12, 0x1b, 0x0a, 0x09, 0x61, 0x6c, 0x61, 0x72,
+	0x6d, 0x5f, 0x63, 0x70, 0x6d, 0x18, 0x06, 0x20, 0x01, 0x28, 0x05, 0x52, 0x08, 0x61, 0x6c, 0x61,
+	0x72, 0x6d, 0x43, 0x70, 0x6d, 0x12, 0x26, 0x0a, 0x0f, 0x61, 0x6c, 0x61, 0x72, 0x6d, 0x5f, 0x64,
+	0x6f, 0x73, 0x65, 0x5f, 0x72, 0x61, 0x74, 0x65, 0x18, 0x07, 0x20, 0x01, 0x28, 0x01, 0x52, 0x0d,
+	0x61, 0x6c, 0x61, 0x72, 0x6d, 0x44, 0x6f, 0x73, 0x65, 0x52, 0x61, 0x74, 0x65, 0x12, 0x21, 0x0a,
+	0x0c, 0x68, 0x69, 0x73, 0x74, 0x6f, 0x72, 0x79, 0x5f, 0x6d, 0x6f, 0x64, 0x65, 0x18, 0x08, 0x20,
+	0x01, 0x28, 0x09, 0x52, 0x0b, 0x68, 0x69, 0x73, 0x74, 0x6f, 0x72, 0x79, 0x4d, 0x6f, 0x64, 0x65,
+	0x12, 0x21, 0x0a, 0x0c, 0x64, 0x69, 0x73, 0x70, 0x6c, 0x61, 0x79, 0x5f, 0x75, 0x6e, 0x69, 0x74,
+	0x18, 0x09, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0b, 0x64, 0x69, 0x73, 0x70, 0x6c, 0x61, 0x79, 0x55,
+	0x6e, 0x69, 0x74, 0x12, 0x21, 0x0a, 0x0c, 0x70, 0x6f, 0x77, 0x65, 0x72, 0x5f, 0x73, 0x61, 0x76,
+	0x69, 0x6e, 0x67, 0x18, 0x0a, 0x20, 0x01, 0x28, 0x05, 0x52, 0x0b, 0x70, 0x6f, 0x77, 0x65, 0x72,
+	0x53, 0x61, 0x76, 0x69, 0x6e, 0x67, 0x12, 0x1c, 0x0a, 0x09, 0x62, 0x61, 0x63, 0x6b, 0x6c, 0x69,
+	0x67, 0x68, 0x74, 0x18, 0x0b, 0x20, 0x01, 0x28, 0x05, 0x52, 0x09, 0x62, 0x61, 0x63, 0x6b, 0x6c,
+	0x69, 0x67, 0x68, 0x74, 0x42, 0x0c, 0x0a, 0x0a, 0x5f, 0x76, 0x69, 0x62, 0x72, 0x61, 0x74, 0x69,
+	0x6f, 0x6e, 0x22, 0x23, 0x0a, 0x0f, 0x50, 0x72, 0x65, 0x73, 0x73, 0x4b, 0x65, 0x79, 0x52, 0x65,
+	0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x10, 0x0a, 0x03, 0x6b, 0x65, 0x79, 0x18, 0x01, 0x20, 0x01,
+	0x28, 0x05, 0x52, 0x03, 0x6b, 0x65, 0x79, 0x22, 0x12, 0x0a, 0x10, 0x50, 0x72, 0x65, 0x73, 0x73,
+	0x4b, 0x65, 0x79, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x22, 0x12, 0x0a, 0x10, 0x53,
+	0x79, 0x6e, 0x63, 0x43, 0x6c, 0x6f, 0x63, 0x6b, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x22,
+	0x13, 0x0a, 0x11, 0x53, 0x79, 0x6e, 0x63, 0x43, 0x6c, 0x6f, 0x63, 0x6b, 0x52, 0x65, 0x73, 0x70,
+	0x6f, 0x6e, 0x73, 0x65, 0x22, 0x30, 0x0a, 0x14, 0x53, 0x65, 0x74, 0x43, 0x6f, 0x6c, 0x6c, 0x65,
+	0x63, 0x74, 0x69, 0x6f, 0x6e, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x18, 0x0a, 0x07,
+	0x65, 0x6e, 0x61, 0x62, 0x6c, 0x65, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x08, 0x52, 0x07, 0x65,
+	0x6e, 0x61, 0x62, 0x6c, 0x65, 0x64, 0x22, 0x17, 0x0a, 0x15, 0x53, 0x65, 0x74, 0x43, 0x6f, 0x6c,
+	0x6c, 0x65, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x22,
+	0x2f, 0x0a, 0x13, 0x53, 0x65, 0x74, 0x48, 0x65, 0x61, 0x72, 0x74, 0x62, 0x65, 0x61, 0x74, 0x52,
+	0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x18, 0x0a, 0x07, 0x65, 0x6e, 0x61, 0x62, 0x6c, 0x65,
+	0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x08, 0x52, 0x07, 0x65, 0x6e, 0x61, 0x62, 0x6c, 0x65, 0x64,
+	0x22, 0x16, 0x0a, 0x14, 0x53, 0x65, 0x74, 0x48, 0x65, 0x61, 0x72, 0x74, 0x62, 0x65, 0x61, 0x74,
+	0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x32, 0xc2, 0x03, 0x0a, 0x09, 0x43, 0x6f, 0x6c,
+	0x6c, 0x65, 0x63, 0x74, 0x6f, 0x72, 0x12, 0x44, 0x0a, 0x0d, 0x57, 0x61, 0x74, 0x63, 0x68, 0x52,
+	0x65, 0x61, 0x64, 0x69, 0x6e, 0x67, 0x73, 0x12, 0x1e, 0x2e, 0x67, 0x71, 0x67, 0x6d, 0x63, 0x2e,
+	0x76, 0x31, 0x2e, 0x57, 0x61, 0x74, 0x63, 0x68, 0x52, 0x65, 0x61, 0x64, 0x69, 0x6e, 0x67, 0x73,
+	0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x11, 0x2e, 0x67, 0x71, 0x67, 0x6d, 0x63, 0x2e,
+	0x76, 0x31, 0x2e, 0x52, 0x65, 0x61, 0x64, 0x69, 0x6e, 0x67, 0x30, 0x01, 0x12, 0x45, 0x0a, 0x0d,
+	0x47, 0x65, 0x74, 0x44, 0x65, 0x76, 0x69, 0x63, 0x65, 0x49, 0x6e, 0x66, 0x6f, 0x12, 0x1e, 0x2e,
+	0x67, 0x71, 0x67, 0x6d, 0x63, 0x2e, 0x76, 0x31, 0x2e, 0x47, 0x65, 0x74, 0x44, 0x65, 0x76, 0x69,
+	0x63, 0x65, 0x49, 0x6e, 0x66, 0x6f, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x14, 0x2e,
+	0x67, 0x71, 0x67, 0x6d, 0x63, 0x2e, 0x76, 0x31, 0x2e, 0x44, 0x65, 0x76, 0x69, 0x63, 0x65, 0x49,
+	0x6e, 0x66, 0x6f, 0x12, 0x41, 0x0a, 0x08, 0x50, 0x72, 0x65, 0x73, 0x73, 0x4b, 0x65, 0x79, 0x12,
+	0x19, 0x2e, 0x67, 0x71, 0x67, 0x6d, 0x63, 0x2e, 0x76, 0x31, 0x2e, 0x50, 0x72, 0x65, 0x73, 0x73,
+	0x4b, 0x65, 0x79, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x1a, 0x2e, 0x67, 0x71, 0x67,
+	0x6d, 0x63, 0x2e, 0x76, 0x31, 0x2e, 0x50, 0x72, 0x65, 0x73, 0x73, 0x4b, 0x65, 0x79, 0x52, 0x65,
+	0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x44, 0x0a, 0x09, 0x53, 0x79, 0x6e, 0x63, 0x43, 0x6c,
+	0x6f, 0x63, 0x6b, 0x12, 0x1a, 0x2e, 0x67, 0x71, 0x67, 0x6d, 0x63, 0x2e, 0x76, 0x31, 0x2e, 0x53,
+	0x79, 0x6e, 0x63, 0x43, 0x6c, 0x6f, 0x63, 0x6b, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a,
+	0x1b, 0x2e, 0x67, 0x71, 0x67, 0x6d, 0x63, 0x2e, 0x76, 0x31, 0x2e, 0x53, 0x79, 0x6e, 0x63, 0x43,
+	0x6c, 0x6f, 0x63, 0x6b, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x50, 0x0a, 0x0d,
+	0x53, 0x65, 0x74, 0x43, 0x6f, 0x6c, 0x6c, 0x65, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x12, 0x1e, 0x2e,
+	0x67, 0x71, 0x67, 0x6d, 0x63, 0x2e, 0x76, 0x31, 0x2e, 0x53, 0x65, 0x74, 0x43, 0x6f, 0x6c, 0x6c,
+	0x65, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x1f, 0x2e,
+	0x67, 0x71, 0x67, 0x6d, 0x63, 0x2e, 0x76, 0x31, 0x2e, 0x53, 0x65, 0x74, 0x43, 0x6f, 0x6c, 0x6c,
+	0x65, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x4d,
+	0x0a, 0x0c, 0x53, 0x65, 0x74, 0x48, 0x65, 0x61, 0x72, 0x74, 0x62, 0x65, 0x61, 0x74, 0x12, 0x1d,
+	0x2e, 0x67, 0x71, 0x67, 0x6d, 0x63, 0x2e, 0x76, 0x31, 0x2e, 0x53, 0x65, 0x74, 0x48, 0x65, 0x61,
+	0x72, 0x74, 0x62, 0x65, 0x61, 0x74, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x1e, 0x2e,
+	0x67, 0x71, 0x67, 0x6d, 0x63, 0x2e, 0x76, 0x31, 0x2e, 0x53, 0x65, 0x74, 0x48, 0x65, 0x61, 0x72,
+	0x74, 0x62, 0x65, 0x61, 0x74, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x42, 0x2b, 0x5a,
+	0x29, 0x67, 0x69, 0x74, 0x68, 0x75, 0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x6d, 0x77, 0x75, 0x65,
+	0x72, 0x74, 0x69, 0x6e, 0x67, 0x65, 0x72, 0x2f, 0x67, 0x71, 0x2d, 0x67, 0x6d, 0x63, 0x2f, 0x70,
+	0x6b, 0x67, 0x2f, 0x67, 0x71, 0x67, 0x6d, 0x63, 0x70, 0x62, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74,
+	0x6f, 0x33,
+}
+
+var (
+	file_collector_proto_rawDescOnce sync.Once
+	file_collector_proto_rawDescData = file_collector_proto_rawDesc
+)
+
+func file_collector_proto_rawDescGZIP() []byte {
+	file_collector_proto_rawDescOnce.Do(func() {
+		file_collector_proto_rawDescData = protoimpl.X.CompressGZIP(file_collector_proto_rawDescData)
+	})
+	return file_collector_proto_rawDescData
+}
+
+var file_collector_proto_msgTypes = make([]protoimpl.MessageInfo, 13)
+var file_collector_proto_goTypes = []any{
+	(*WatchReadingsRequest)(nil),  // 0: gqgmc.v1.WatchReadingsRequest
+	(*Reading)(nil),               // 1: gqgmc.v1.Reading
+	(*GetDeviceInfoRequest)(nil),  // 2: gqgmc.v1.GetDeviceInfoRequest
+	(*DeviceInfo)(nil),            // 3: gqgmc.v1.DeviceInfo
+	(*DeviceSettings)(nil),        // 4: gqgmc.v1.DeviceSettings
+	(*PressKeyRequest)(nil),       // 5: gqgmc.v1.PressKeyRequest
+	(*PressKeyResponse)(nil),      // 6: gqgmc.v1.PressKeyResponse
+	(*SyncClockRequest)(nil),      // 7: gqgmc.v1.SyncClockRequest
+	(*SyncClockResponse)(nil),     // 8: gqgmc.v1.SyncClockResponse
+	(*SetCollectionRequest)(nil),  // 9: gqgmc.v1.SetCollectionRequest
+	(*SetCollectionResponse)(nil), // 10: gqgmc.v1.SetCollectionResponse
+	(*SetHeartbeatRequest)(nil),   // 11: gqgmc.v1.SetHeartbeatRequest
+	(*SetHeartbeatResponse)(nil),  // 12: gqgmc.v1.SetHeartbeatResponse
+	(*timestamppb.Timestamp)(nil), // 13: google.protobuf.Timestamp
+}
+var file_collector_proto_depIdxs = []int32{
+	13, // 0: gqgmc.v1.Reading.time:type_name -> google.protobuf.Timestamp
+	13, // 1: gqgmc.v1.Reading.cumulative_dose_since:type_name -> google.protobuf.Timestamp
+	4,  // 2: gqgmc.v1.DeviceInfo.settings:type_name -> gqgmc.v1.DeviceSettings
+	13, // 3: gqgmc.v1.DeviceSettings.read_at:type_name -> google.protobuf.Timestamp
+	0,  // 4: gqgmc.v1.Collector.WatchReadings:input_type -> gqgmc.v1.WatchReadingsRequest
+	2,  // 5: gqgmc.v1.Collector.GetDeviceInfo:input_type -> gqgmc.v1.GetDeviceInfoRequest
+	5,  // 6: gqgmc.v1.Collector.PressKey:input_type -> gqgmc.v1.PressKeyRequest
+	7,  // 7: gqgmc.v1.Collector.SyncClock:input_type -> gqgmc.v1.SyncClockRequest
+	9,  // 8: gqgmc.v1.Collector.SetCollection:input_type -> gqgmc.v1.SetCollectionRequest
+	11, // 9: gqgmc.v1.Collector.SetHeartbeat:input_type -> gqgmc.v1.SetHeartbeatRequest
+	1,  // 10: gqgmc.v1.Collector.WatchReadings:output_type -> gqgmc.v1.Reading
+	3,  // 11: gqgmc.v1.Collector.GetDeviceInfo:output_type -> gqgmc.v1.DeviceInfo
+	6,  // 12: gqgmc.v1.Collector.PressKey:output_type -> gqgmc.v1.PressKeyResponse
+	8,  // 13: gqgmc.v1.Collector.SyncClock:output_type -> gqgmc.v1.SyncClockResponse
+	10, // 14: gqgmc.v1.Collector.SetCollection:output_type -> gqgmc.v1.SetCollectionResponse
+	12, // 15: gqgmc.v1.Collector.SetHeartbeat:output_type -> gqgmc.v1.SetHeartbeatResponse
+	10, // [10:16] is the sub-list for method output_type
+	4,  // [4:10] is the sub-list for method input_type
+	4,  // [4:4] is the sub-list for extension type_name
+	4,  // [4:4] is the sub-list for extension extendee
+	0,  // [0:4] is the sub-list for field type_name
+}
+
+func init() { file_collector_proto_init() }
+func file_collector_proto_init() {
+	if File_collector_proto != nil {
+		return
+	}
+	if !protoimpl.UnsafeEnabled {
+		file_collector_proto_msgTypes[0].Exporter = func(v any, i int) any {
+			switch v := v.(*WatchReadingsRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_collector_proto_msgTypes[1].Exporter = func(v any, i int) any {
+			switch v := v.(*Reading); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_collector_proto_msgTypes[2].Exporter = func(v any, i int) any {
+			switch v := v.(*GetDeviceInfoRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_collector_proto_msgTypes[3].Exporter = func(v any, i int) any {
+			switch v := v.(*DeviceInfo); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_collector_proto_msgTypes[4].Exporter = func(v any, i int) any {
+			switch v := v.(*DeviceSettings); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_collector_proto_msgTypes[5].Exporter = func(v any, i int) any {
+			switch v := v.(*PressKeyRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_collector_proto_msgTypes[6].Exporter = func(v any, i int) any {
+			switch v := v.(*PressKeyResponse); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_collector_proto_msgTypes[7].Exporter = func(v any, i int) any {
+			switch v := v.(*SyncClockRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_collector_proto_msgTypes[8].Exporter = func(v any, i int) any {
+			switch v := v.(*SyncClockResponse); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_collector_proto_msgTypes[9].Exporter = func(v any, i int) any {
+			switch v := v.(*SetCollectionRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_collector_proto_msgTypes[10].Exporter = func(v any, i int) any {
+			switch v := v.(*SetCollectionResponse); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_collector_proto_msgTypes[11].Exporter = func(v any, i int) any {
+			switch v := v.(*SetHeartbeatRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_collector_proto_msgTypes[12].Exporter = func(v any, i int) any {
+			switch v := v.(*SetHeartbeatResponse); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+	}
+	file_collector_proto_msgTypes[1].OneofWrappers = []any{}
+	file_collector_proto_msgTypes[4].OneofWrappers = []any{}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: file_collector_proto_rawDesc,
+			NumEnums:      0,
+			NumMessages:   13,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_collector_proto_goTypes,
+		DependencyIndexes: file_collector_proto_depIdxs,
+		MessageInfos:      file_collector_proto_msgTypes,
+	}.Build()
+	File_collector_proto = out.File
+	file_collector_proto_rawDesc = nil
+	file_collector_proto_goTypes = nil
+	file_collector_proto_depIdxs = nil
+}
@@ -0,0 +1,353 @@
+// The gRPC API of gq-gmc serve, enabled with -grpcListen.
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: collector.proto
+
+package gqgmcpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Collector_WatchReadings_FullMethodName = "/gqgmc.v1.Collector/WatchReadings"
+	Collector_GetDeviceInfo_FullMethodName = "/gqgmc.v1.Collector/GetDeviceInfo"
+	Collector_PressKey_FullMethodName      = "/gqgmc.v1.Collector/PressKey"
+	Collector_SyncClock_FullMethodName     = "/gqgmc.v1.Collector/SyncClock"
+	Collector_SetCollection_FullMethodName = "/gqgmc.v1.Collector/SetCollection"
+	Collector_SetHeartbeat_FullMethodName  = "/gqgmc.v1.Collector/SetHeartbeat"
+)
+
+// CollectorClient is the client API for Collector service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// Collector reads a counter. The control RPCs require the -apiToken of the
+// collector as bearer token in the authorization metadata. They fail with
+// UNAUTHENTICATED for a wrong token, with PERMISSION_DENIED if the collector
+// has no token, and with UNAVAILABLE for errors of the counter. Unless the
+// collector serves TLS with -grpcTLSCert, they are only accepted from
+// loopback clients, which keeps the token off the network in plaintext.
+type CollectorClient interface {
+	// WatchReadings streams the readings, starting with the latest one, and
+	// then one per interval until the call is cancelled.
+	WatchReadings(ctx context.Context, in *WatchReadingsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Reading], error)
+	// GetDeviceInfo returns the counter and the settings of its configuration.
+	GetDeviceInfo(ctx context.Context, in *GetDeviceInfoRequest, opts ...grpc.CallOption) (*DeviceInfo, error)
+	// PressKey presses a key of the counter.
+	PressKey(ctx context.Context, in *PressKeyRequest, opts ...grpc.CallOption) (*PressKeyResponse, error)
+	// SyncClock sets the clock of the counter to the time of the collector.
+	SyncClock(ctx context.Context, in *SyncClockRequest, opts ...grpc.CallOption) (*SyncClockResponse, error)
+	// SetCollection pauses or resumes the collection of the readings, like
+	// gq-gmc ctl pause and resume. The heartbeat of the counter keeps
+	// streaming while paused, so that a lost counter is still noticed.
+	SetCollection(ctx context.Context, in *SetCollectionRequest, opts ...grpc.CallOption) (*SetCollectionResponse, error)
+	// SetHeartbeat switches the heartbeat of the counter on or off, like
+	// gq-gmc ctl heartbeat. The collection is paused while it is off. It
+	// fails with UNAVAILABLE if the counter is polled, see -mode.
+	SetHeartbeat(ctx context.Context, in *SetHeartbeatRequest, opts ...grpc.CallOption) (*SetHeartbeatResponse, error)
+}
+
+type collectorClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewCollectorClient(cc grpc.ClientConnInterface) CollectorClient {
+	return &collectorClient{cc}
+}
+
+func (c *collectorClient) WatchReadings(ctx context.Context, in *WatchReadingsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Reading], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Collector_ServiceDesc.Streams[0], Collector_WatchReadings_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[WatchReadingsRequest, Reading]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Collector_WatchReadingsClient = grpc.ServerStreamingClient[Reading]
+
+func (c *collectorClient) GetDeviceInfo(ctx context.Context, in *GetDeviceInfoRequest, opts ...grpc.CallOption) (*DeviceInfo, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(DeviceInfo)
+	err := c.cc.Invoke(ctx, Collector_GetDeviceInfo_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *collectorClient) PressKey(ctx context.Context, in *PressKeyRequest, opts ...grpc.CallOption) (*PressKeyResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(PressKeyResponse)
+	err := c.cc.Invoke(ctx, Collector_PressKey_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *collectorClient) SyncClock(ctx context.Context, in *SyncClockRequest, opts ...grpc.CallOption) (*SyncClockResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(SyncClockResponse)
+	err := c.cc.Invoke(ctx, Collector_SyncClock_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *collectorClient) SetCollection(ctx context.Context, in *SetCollectionRequest, opts ...grpc.CallOption) (*SetCollectionResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(SetCollectionResponse)
+	err := c.cc.Invoke(ctx, Collector_SetCollection_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *collectorClient) SetHeartbeat(ctx context.Context, in *SetHeartbeatRequest, opts ...grpc.CallOption) (*SetHeartbeatResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(SetHeartbeatResponse)
+	err := c.cc.Invoke(ctx, Collector_SetHeartbeat_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// CollectorServer is the server API for Collector service.
+// All implementations must embed UnimplementedCollectorServer
+// for forward compatibility.
+//
+// Collector reads a counter. The control RPCs require the -apiToken of the
+// collector as bearer token in the authorization metadata. They fail with
+// UNAUTHENTICATED for a wrong token, with PERMISSION_DENIED if the collector
+// has no token, and with UNAVAILABLE for errors of the counter. Unless the
+// collector serves TLS with -grpcTLSCert, they are only accepted from
+// loopback clients, which keeps the token off the network in plaintext.
+type CollectorServer interface {
+	// WatchReadings streams the readings, starting with the latest one, and
+	// then one per interval until the call is cancelled.
+	WatchReadings(*WatchReadingsRequest, grpc.ServerStreamingServer[Reading]) error
+	// GetDeviceInfo returns the counter and the settings of its configuration.
+	GetDeviceInfo(context.Context, *GetDeviceInfoRequest) (*DeviceInfo, error)
+	// PressKey presses a key of the counter.
+	PressKey(context.Context, *PressKeyRequest) (*PressKeyResponse, error)
+	// SyncClock sets the clock of the counter to the time of the collector.
+	SyncClock(context.Context, *SyncClockRequest) (*SyncClockResponse, error)
+	// SetCollection pauses or resumes the collection of the readings, like
+	// gq-gmc ctl pause and resume. The heartbeat of the counter keeps
+	// streaming while paused, so that a lost counter is still noticed.
+	SetCollection(context.Context, *SetCollectionRequest) (*SetCollectionResponse, error)
+	// SetHeartbeat switches the heartbeat of the counter on or off, like
+	// gq-gmc ctl heartbeat. The collection is paused while it is off. It
+	// fails with UNAVAILABLE if the counter is polled, see -mode.
+	SetHeartbeat(context.Context, *SetHeartbeatRequest) (*SetHeartbeatResponse, error)
+	mustEmbedUnimplementedCollectorServer()
+}
+
+// UnimplementedCollectorServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedCollectorServer struct{}
+
+func (UnimplementedCollectorServer) WatchReadings(*WatchReadingsRequest, grpc.ServerStreamingServer[Reading]) error {
+	return status.Errorf(codes.Unimplemented, "method WatchReadings not implemented")
+}
+func (UnimplementedCollectorServer) GetDeviceInfo(context.Context, *GetDeviceInfoRequest) (*DeviceInfo, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetDeviceInfo not implemented")
+}
+func (UnimplementedCollectorServer) PressKey(context.Context, *PressKeyRequest) (*PressKeyResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method PressKey not implemented")
+}
+func (UnimplementedCollectorServer) SyncClock(context.Context, *SyncClockRequest) (*SyncClockResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SyncClock not implemented")
+}
+func (UnimplementedCollectorServer) SetCollection(context.Context, *SetCollectionRequest) (*SetCollectionResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetCollection not implemented")
+}
+func (UnimplementedCollectorServer) SetHeartbeat(context.Context, *SetHeartbeatRequest) (*SetHeartbeatResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetHeartbeat not implemented")
+}
+func (UnimplementedCollectorServer) mustEmbedUnimplementedCollectorServer() {}
+func (UnimplementedCollectorServer) testEmbeddedByValue()                   {}
+
+// UnsafeCollectorServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to CollectorServer will
+// result in compilation errors.
+type UnsafeCollectorServer interface {
+	mustEmbedUnimplementedCollectorServer()
+}
+
+func RegisterCollectorServer(s grpc.ServiceRegistrar, srv CollectorServer) {
+	// If the following call pancis, it indicates UnimplementedCollectorServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Collector_ServiceDesc, srv)
+}
+
+func _Collector_WatchReadings_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchReadingsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(CollectorServer).WatchReadings(m, &grpc.GenericServerStream[WatchReadingsRequest, Reading]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Collector_WatchReadingsServer = grpc.ServerStreamingServer[Reading]
+
+func _Collector_GetDeviceInfo_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetDeviceInfoRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CollectorServer).GetDeviceInfo(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Collector_GetDeviceInfo_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CollectorServer).GetDeviceInfo(ctx, req.(*GetDeviceInfoRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Collector_PressKey_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PressKeyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CollectorServer).PressKey(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Collector_PressKey_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CollectorServer).PressKey(ctx, req.(*PressKeyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Collector_SyncClock_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SyncClockRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CollectorServer).SyncClock(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Collector_SyncClock_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CollectorServer).SyncClock(ctx, req.(*SyncClockRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Collector_SetCollection_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetCollectionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CollectorServer).SetCollection(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Collector_SetCollection_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CollectorServer).SetCollection(ctx, req.(*SetCollectionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Collector_SetHeartbeat_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetHeartbeatRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CollectorServer).SetHeartbeat(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Collector_SetHeartbeat_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CollectorServer).SetHeartbeat(ctx, req.(*SetHeartbeatRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Collector_ServiceDesc is the grpc.ServiceDesc for Collector service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Collector_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "gqgmc.v1.Collector",
+	HandlerType: (*CollectorServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetDeviceInfo",
+			Handler:    _Collector_GetDeviceInfo_Handler,
+		},
+		{
+			MethodName: "PressKey",
+			Handler:    _Collector_PressKey_Handler,
+		},
+		{
+			MethodName: "SyncClock",
+			Handler:    _Collector_SyncClock_Handler,
+		},
+		{
+			MethodName: "SetCollection",
+			Handler:    _Collector_SetCollection_Handler,
+		},
+		{
+			MethodName: "SetHeartbeat",
+			Handler:    _Collector_SetHeartbeat_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchReadings",
+			Handler:       _Collector_WatchReadings_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "collector.proto",
+}
@@ -0,0 +1,6 @@
+// Package gqgmcpb is the gRPC API of the collector, generated from
+// collector.proto with protoc-gen-go and protoc-gen-go-grpc, for clients of
+// gq-gmc serve -grpcListen.
+package gqgmcpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative collector.proto
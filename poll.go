@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/mwuertinger/gq-gmc/pkg/gqgmc"
+)
+
+// pollCounts issues <GETCPM>> every interval until the port is closed,
+// calling reconnect when it is lost. The main loop expects counts per second
+// as in heartbeat mode, so the rate is spread over the seconds since the
+// previous poll, carrying fractional counts over. Seconds covered by a
+// failed poll count as missed frames.
+func pollCounts(dev *gqgmc.Device, interval time.Duration, counts *countRing, missed *atomic.Int64, gaps *gapTracker, reconnect func() bool) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	last := time.Now()
+	var fraction float64
+	for range t.C {
+		cpm, err := dev.CPM()
+		now := time.Now()
+		seconds := int(now.Sub(last).Round(time.Second) / time.Second)
+		last = now
+		if errors.Is(err, errPortLost) {
+			missed.Add(int64(seconds))
+			gaps.fail(gapPortLost)
+			if !reconnect() {
+				return
+			}
+			last = time.Now()
+			continue
+		}
+		if err != nil {
+			missed.Add(int64(seconds))
+			gaps.fail(gapReadError)
+			slog.Warn("poll", "err", err)
+			continue
+		}
+		gaps.reading()
+		for i := 0; i < seconds; i++ {
+			fraction += float64(cpm) / 60
+			n := int(fraction)
+			fraction -= float64(n)
+			counts.push(n)
+		}
+	}
+}
+
+// heartbeatStall is the number of consecutive heartbeat timeouts that is
+// logged as a persistent failure, as ReadCounts keeps re-enabling heartbeat
+// mode in between.
+const heartbeatStall = 30
+
+// defaultStallTimeout is the default of -stallTimeout.
+const defaultStallTimeout = 2 * time.Minute
+
+// stallWatchdog follows the heartbeat frames of the reader. It logs when they
+// have stopped for heartbeatStall reads and when they resume. Some firmware
+// silently stops streaming after a USB hiccup and only recovers from a new
+// connection, so after timeout without a frame the port is reopened.
+type stallWatchdog struct {
+	logger  *slog.Logger
+	port    *reconnectingPort
+	timeout time.Duration // 0 never reopens the port
+
+	last     time.Time // of the last frame or reconnect
+	timeouts int
+}
+
+func newStallWatchdog(logger *slog.Logger, port *reconnectingPort, timeout time.Duration) *stallWatchdog {
+	return &stallWatchdog{logger: logger, port: port, timeout: timeout, last: time.Now()}
+}
+
+func (w *stallWatchdog) timedOut() {
+	if w.timeouts++; w.timeouts == heartbeatStall {
+		w.logger.Error("no heartbeat frames", "timeouts", w.timeouts)
+	}
+	if w.timeout > 0 && time.Since(w.last) >= w.timeout {
+		w.logger.Error("heartbeat stalled, reopening the port", "since", w.last.Format(time.RFC3339))
+		w.port.reset(fmt.Errorf("no heartbeat frame for %s", w.timeout))
+		w.last = time.Now()
+	}
+}
+
+func (w *stallWatchdog) frame() {
+	if w.timeouts >= heartbeatStall {
+		w.logger.Info("heartbeat frames resumed", "timeouts", w.timeouts)
+	}
+	w.timeouts = 0
+	w.last = time.Now()
+}
+
+// reconnected restarts the timeout once the port was reopened.
+func (w *stallWatchdog) reconnected() {
+	w.last = time.Now()
+}
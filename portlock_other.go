@@ -0,0 +1,99 @@
+//go:build !windows
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// lockDir holds the UUCP lock files of the serial ports, which minicom,
+// screen and the like check before opening a port.
+const lockDir = "/var/lock"
+
+// lockPort takes an advisory lock on the serial port, so that a second
+// instance or another program does not interleave its commands with ours.
+// The port is flocked, which other instances and most serial libraries
+// honour, and a LCK..<tty> file is created where lockDir is writable. It
+// fails if another process holds either. The returned func releases the
+// locks.
+func lockPort(name string) (func(), error) {
+	f, err := os.OpenFile(name, os.O_RDWR|syscall.O_NOCTTY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		f.Close()
+		if err == syscall.EWOULDBLOCK {
+			return nil, fmt.Errorf("%s is in use by another process", name)
+		}
+		return nil, fmt.Errorf("lock %s: %w", name, err)
+	}
+	removeLockFile, err := createLockFile(name)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return func() {
+		removeLockFile()
+		f.Close()
+	}, nil
+}
+
+// createLockFile creates the UUCP lock file of the port holding our PID,
+// removing a stale one left by a process that is gone. The lock file is
+// named after the device node, so /dev/serial/by-id links are resolved. It
+// is skipped if lockDir is missing or not writable, as the lock is only a
+// courtesy to the programs checking it.
+func createLockFile(name string) (func(), error) {
+	if resolved, err := filepath.EvalSymlinks(name); err == nil {
+		name = resolved
+	}
+	path := filepath.Join(lockDir, "LCK.."+filepath.Base(name))
+	for attempt := 0; attempt < 2; attempt++ {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+		if errors.Is(err, fs.ErrExist) {
+			pid, alive := lockOwner(path)
+			if alive {
+				return nil, fmt.Errorf("%s is in use by process %d (%s)", name, pid, path)
+			}
+			os.Remove(path)
+			continue
+		}
+		if err != nil {
+			return func() {}, nil
+		}
+		_, err = fmt.Fprintf(f, "%10d\n", os.Getpid())
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			os.Remove(path)
+			return nil, fmt.Errorf("lock file: %w", err)
+		}
+		return func() { os.Remove(path) }, nil
+	}
+	return nil, fmt.Errorf("%s is locked by %s", name, path)
+}
+
+// lockOwner returns the PID in the lock file and whether that process is
+// still running. A lock file that cannot be parsed counts as stale.
+func lockOwner(path string) (int, bool) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		// removed meanwhile
+		return 0, false
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil || pid <= 0 {
+		return 0, false
+	}
+	err = syscall.Kill(pid, 0)
+	return pid, err == nil || err == syscall.EPERM
+}
@@ -0,0 +1,7 @@
+package main
+
+// lockPort does nothing, Windows opens serial ports exclusively: a port in
+// use by another program fails to open with "Access is denied".
+func lockPort(name string) (func(), error) {
+	return func() {}, nil
+}
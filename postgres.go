@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// postgresTimeout bounds each statement, so that an unreachable server does
+// not hold up the main loop for long.
+const postgresTimeout = 10 * time.Second
+
+// postgresSchema creates the table of the readings, in the layout of
+// sqliteSchema. %[1]s is the quoted table name, %[2]s the quoted index name.
+const postgresSchema = `
+CREATE TABLE IF NOT EXISTS %[1]s (
+	time      TIMESTAMPTZ      NOT NULL,
+	cpm       INTEGER          NOT NULL,
+	dose_rate DOUBLE PRECISION NOT NULL,
+	tags      JSONB            NOT NULL DEFAULT '{}'
+);
+CREATE INDEX IF NOT EXISTS %[2]s ON %[1]s (time DESC);
+`
+
+// postgresStore writes the reading of each interval into a PostgreSQL
+// table, for installations running Postgres rather than InfluxDB. With the
+// TimescaleDB extension installed in the database, the table is made a
+// hypertable; retention is then left to its policies.
+type postgresStore struct {
+	db    *sql.DB
+	table string // quoted
+}
+
+func newPostgresStore(dsn, table string) (*postgresStore, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, err
+	}
+	s := &postgresStore{db: db, table: pq.QuoteIdentifier(table)}
+	if err := s.createSchema(table); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *postgresStore) createSchema(table string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), postgresTimeout)
+	defer cancel()
+	if _, err := s.db.ExecContext(ctx, fmt.Sprintf(postgresSchema, s.table, pq.QuoteIdentifier(table+"_time"))); err != nil {
+		return err
+	}
+	var timescale bool
+	if err := s.db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM pg_extension WHERE extname = 'timescaledb')`).Scan(&timescale); err != nil {
+		return err
+	}
+	if !timescale {
+		return nil
+	}
+	if _, err := s.db.ExecContext(ctx, `SELECT create_hypertable($1::regclass, 'time', if_not_exists => TRUE, migrate_data => TRUE)`, s.table); err != nil {
+		return fmt.Errorf("create hypertable: %w", err)
+	}
+	slog.Info("postgres: readings in a TimescaleDB hypertable", "table", table)
+	return nil
+}
+
+func (s *postgresStore) write(r reading) error {
+	b, err := json.Marshal(r.Tags)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), postgresTimeout)
+	defer cancel()
+	_, err = s.db.ExecContext(ctx, `INSERT INTO `+s.table+` (time, cpm, dose_rate, tags) VALUES ($1, $2, $3, $4)`, r.Time, r.CPM, r.DoseRate, string(b))
+	return err
+}
+
+func (s *postgresStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// prometheusHandler exports the latest reading and the health of the
+// collector in the Prometheus text format. info labels the gqgmc_device_info
+// metric.
+func prometheusHandler(state *liveState, info map[string]string, metrics *selfMetrics) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		snap := state.get()
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		writeGauge(w, "gqgmc_device_info", "Device metadata, the value is always 1.", info, 1)
+		metrics.writePrometheus(w)
+		// no samples before the first interval ended
+		if snap.Time.IsZero() {
+			return
+		}
+		writeGauge(w, "gqgmc_cpm", "Counts per minute averaged over the last interval.", nil, float64(snap.CPM))
+		writeGauge(w, "gqgmc_dose_rate_usv_h", "Dose rate in µSv/h averaged over the last interval.", nil, snap.DoseRate)
+		writeGauge(w, "gqgmc_cumulative_dose_usv", "Dose in µSv accumulated since the collector started counting.", nil, snap.CumulativeDose)
+		writeGauge(w, "gqgmc_last_reading_timestamp_seconds", "End of the last interval as Unix time.", nil, float64(snap.Time.UnixNano())/1e9)
+		if p := snap.Power; p != nil {
+			writeGauge(w, "gqgmc_battery_voltage_volts", "Battery voltage.", nil, p.Voltage)
+			external := 0.0
+			if p.External {
+				external = 1
+			}
+			writeGauge(w, "gqgmc_external_power", "1 if the device runs on external power.", nil, external)
+		}
+	})
+}
+
+func writeGauge(w io.Writer, name, help string, labels map[string]string, value float64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s%s %s\n", name, help, name, name, formatLabels(labels), strconv.FormatFloat(value, 'g', -1, 64))
+}
+
+func writeCounter(w io.Writer, name, help string, value float64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n%s %s\n", name, help, name, name, strconv.FormatFloat(value, 'g', -1, 64))
+}
+
+// formatLabels returns labels as {k="v",...} in key order.
+func formatLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	escape := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	var b strings.Builder
+	for i, k := range keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, `%s="%s"`, k, escape.Replace(labels[k]))
+	}
+	return "{" + b.String() + "}"
+}
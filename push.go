@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Message size limits of the chat services, longer text is cut.
+const (
+	telegramMaxText = 4096
+	pushoverMaxText = 1024
+)
+
+// pushText formats a report as a chat message. Only plain text reports fit
+// a message, of others like the HTML weekly report just the subject is sent.
+func pushText(subject, contentType, body string, max int) string {
+	if contentType != "text/plain" {
+		return subject
+	}
+	return truncateText(subject+"\n\n"+body, max)
+}
+
+// truncateText cuts s to at most max bytes, marking that it was cut.
+func truncateText(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	return strings.ToValidUTF8(s[:max-3], "") + "..."
+}
+
+// telegramNotifier sends alert events and reports as messages of a
+// Telegram bot to a chat.
+type telegramNotifier struct {
+	token  string
+	chatID string
+}
+
+func (t *telegramNotifier) notify(ev alertEvent) error {
+	return t.send(ev.String())
+}
+
+func (t *telegramNotifier) report(subject, contentType, body string) error {
+	return t.send(pushText(subject, contentType, body, telegramMaxText))
+}
+
+func (t *telegramNotifier) send(text string) error {
+	body, err := json.Marshal(map[string]string{"chat_id": t.chatID, "text": text})
+	if err != nil {
+		return err
+	}
+	resp, err := notifyClient.Post("https://api.telegram.org/bot"+t.token+"/sendMessage", "application/json", bytes.NewReader(body))
+	if err != nil {
+		// the error quotes the URL, which holds the token
+		return fmt.Errorf("telegram: %v", strings.ReplaceAll(err.Error(), t.token, "***"))
+	}
+	return checkPushResponse("telegram", resp)
+}
+
+// pushoverNotifier sends alert events and reports as Pushover
+// notifications. Critical alerts are sent with high priority.
+type pushoverNotifier struct {
+	token string // of the application
+	user  string // user or group key
+}
+
+func (p *pushoverNotifier) notify(ev alertEvent) error {
+	priority := "0"
+	if ev.Severity == "critical" && ev.State == "firing" {
+		priority = "1"
+	}
+	return p.send(fmt.Sprintf("[gq-gmc] %s %s %s", ev.Rule, ev.Severity, ev.State), ev.String(), priority)
+}
+
+func (p *pushoverNotifier) report(subject, contentType, body string) error {
+	message := subject
+	if contentType == "text/plain" {
+		message = truncateText(body, pushoverMaxText)
+	}
+	return p.send(subject, message, "0")
+}
+
+func (p *pushoverNotifier) send(title, message, priority string) error {
+	form := url.Values{
+		"token":    {p.token},
+		"user":     {p.user},
+		"title":    {title},
+		"message":  {message},
+		"priority": {priority},
+	}
+	resp, err := notifyClient.PostForm("https://api.pushover.net/1/messages.json", form)
+	if err != nil {
+		return fmt.Errorf("pushover: %v", err)
+	}
+	return checkPushResponse("pushover", resp)
+}
+
+func checkPushResponse(service string, resp *http.Response) error {
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s: %s: %s", service, resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// radmonURL is the submission endpoint of radmon.org.
+const radmonURL = "https://radmon.org/radmon.php"
+
+// radmonMinInterval is the shortest submission interval radmon.org allows.
+const radmonMinInterval = time.Minute
+
+// radmonUploader submits the mean count rate to radmon.org with the
+// station's user name and data sending password.
+type radmonUploader struct {
+	user, password string
+}
+
+func (r *radmonUploader) upload(u upload) error {
+	q := url.Values{
+		"function": {"submit"},
+		"user":     {r.user},
+		"password": {r.password},
+		"value":    {strconv.FormatFloat(u.meanCPM, 'f', 1, 64)},
+		"unit":     {"CPM"},
+		"datetime": {u.snap.Time.UTC().Format("2006-01-02 15:04:05")},
+	}
+	resp, err := uploadClient.Get(radmonURL + "?" + q.Encode())
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s", resp.Status)
+	}
+	// rejected submissions are reported as text with status 200
+	if msg := strings.TrimSpace(string(body)); strings.Contains(strings.ToLower(msg), "incorrect") {
+		return fmt.Errorf("%s", msg)
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	influxdb "github.com/influxdata/influxdb1-client/v2"
+	"github.com/mwuertinger/gq-gmc/pkg/gqgmc"
+)
+
+// gmcmapReply is what gmcmap.com answers to a successful upload, the
+// firmware shows it on the display.
+const gmcmapReply = "OK.ERR0"
+
+// gmcmapUpload is a reading a WiFi model sent to log2.asp.
+type gmcmapUpload struct {
+	counterID string   // GID
+	cpm       int      // CPM
+	acpm      *float64 // ACPM, the average since power-on
+	doseRate  *float64 // uSV, in µSv/h by the calibration of the counter
+}
+
+// parseGMCMapUpload parses the query of an upload.
+func parseGMCMapUpload(q url.Values) (gmcmapUpload, error) {
+	u := gmcmapUpload{counterID: q.Get("GID")}
+	if u.counterID == "" {
+		return u, fmt.Errorf("GID missing")
+	}
+	var err error
+	if u.cpm, err = strconv.Atoi(q.Get("CPM")); err != nil || u.cpm < 0 {
+		return u, fmt.Errorf("CPM %q is not a count", q.Get("CPM"))
+	}
+	for _, f := range []struct {
+		name string
+		v    **float64
+	}{{"ACPM", &u.acpm}, {"uSV", &u.doseRate}} {
+		if s := q.Get(f.name); s != "" {
+			v, err := strconv.ParseFloat(s, 64)
+			if err != nil {
+				return u, fmt.Errorf("%s %q is not a number", f.name, s)
+			}
+			*f.v = &v
+		}
+	}
+	return u, nil
+}
+
+// gmcmapReceiver takes the uploads of WiFi models pointed at it instead of
+// gmcmap.com and writes them like the readings of the collector, tagged
+// with the counter ID. With forward the uploads are passed on to gmcmap.com,
+// so the counter stays on the map.
+type gmcmapReceiver struct {
+	client      influxdb.Client
+	database    string
+	measurement string
+	tags        map[string]string
+	tube        gqgmc.Tube // for uploads without uSV
+	forward     bool
+}
+
+func (rc *gmcmapReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u, err := parseGMCMapUpload(r.URL.Query())
+	if err != nil {
+		slog.Warn("receive: bad upload", "remote", r.RemoteAddr, "err", err)
+		http.Error(w, "ERR1 "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	doseRate := rc.tube.DoseRate(float64(u.cpm))
+	if u.doseRate != nil {
+		doseRate = *u.doseRate
+	}
+	fields := map[string]interface{}{
+		"geiger_counter_cpm":       u.cpm,
+		"geiger_counter_dose_rate": doseRate,
+	}
+	if u.acpm != nil {
+		fields["geiger_counter_acpm"] = *u.acpm
+	}
+	tags := mergeTags(rc.tags, map[string]string{"gmcmap_counter": u.counterID})
+	slog.Info("reading", "counter", u.counterID, "remote", r.RemoteAddr, "cpm", u.cpm, "dose_rate", doseRate)
+	if err := sendToInflux(rc.client, rc.database, rc.measurement, tags, fields, time.Now()); err != nil {
+		slog.Error("influx write", "counter", u.counterID, "err", err)
+		http.Error(w, "ERR2 storage failed", http.StatusServiceUnavailable)
+		return
+	}
+	if rc.forward {
+		go func(q string) {
+			if err := forwardGMCMapUpload(q); err != nil {
+				slog.Warn("receive: forward to gmcmap.com", "counter", u.counterID, "err", err)
+			}
+		}(r.URL.RawQuery)
+	}
+	fmt.Fprint(w, gmcmapReply)
+}
+
+// forwardGMCMapUpload passes the query of an upload on to gmcmap.com.
+func forwardGMCMapUpload(rawQuery string) error {
+	resp, err := uploadClient.Get(gmcmapURL + "?" + rawQuery)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s", resp.Status)
+	}
+	return nil
+}
+
+// receiveCommand implements "gq-gmc receive", which logs WiFi models without
+// a serial connection. Their server is set to the host running it and the
+// URL to log2.asp.
+func receiveCommand(args []string) {
+	fs := flag.NewFlagSet("receive", flag.ExitOnError)
+	influx := influxFlags(fs)
+	tagValues := tagFlag(fs)
+	configPath := fs.String("config", "", "YAML config file providing the tags")
+	listen := fs.String("listen", ":80", "TCP address the uploads are received on, the firmware sends them to port 80")
+	model := fs.String("model", "", "Device model selecting the tube for the dose rate of uploads without uSV, e.g. GMC-320+")
+	forward := fs.Bool("forward", false, "Also pass the uploads on to gmcmap.com, which must not resolve to this host")
+	parseFlags(fs, args)
+
+	tags := readingTags(&fileConfig{}, tagValues)
+	if *configPath != "" {
+		cfg, err := loadConfig(*configPath)
+		if err != nil {
+			log.Fatalf("config: %v", err)
+		}
+		tags = readingTags(cfg, tagValues)
+	}
+	client, err := influx.client()
+	if err != nil {
+		log.Fatalf("influx: %v", err)
+	}
+	rc := &gmcmapReceiver{
+		client:      client,
+		database:    influx.database,
+		measurement: influx.measurement,
+		tags:        tags,
+		tube:        gqgmc.LookupProfile(*model).Tube,
+		forward:     *forward,
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/log2.asp", rc)
+	slog.Info("receiving gmcmap uploads", "listen", *listen, "forward", *forward)
+	log.Fatalf("receive: %v", http.ListenAndServe(*listen, mux))
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// errPortLost is returned by reads and writes while the serial port is
+// closed after a failure, e.g. because the USB cable was unplugged.
+var errPortLost = errors.New("serial port lost")
+
+// Bounds of the exponential backoff between attempts to reopen a lost port.
+const (
+	reconnectMinDelay = time.Second
+	reconnectMaxDelay = time.Minute
+)
+
+// reconnectingPort reads and writes through the port returned by open. The
+// first failing read or write closes the port, and from then on reads and
+// writes fail with errPortLost until reconnect has reopened it. Read
+// timeouts are not failures: they return no data and no error.
+type reconnectingPort struct {
+	open func() (io.ReadWriteCloser, error)
+
+	mu     sync.Mutex
+	rwc    io.ReadWriteCloser // nil while the port is lost
+	closed bool
+}
+
+// newReconnectingPort opens the port once, failing if that does not work.
+func newReconnectingPort(open func() (io.ReadWriteCloser, error)) (*reconnectingPort, error) {
+	rwc, err := open()
+	if err != nil {
+		return nil, err
+	}
+	return &reconnectingPort{open: open, rwc: rwc}, nil
+}
+
+func (r *reconnectingPort) port() io.ReadWriteCloser {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.rwc
+}
+
+// fail closes rwc after it failed with err, unless it was replaced already.
+func (r *reconnectingPort) fail(rwc io.ReadWriteCloser, err error) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.rwc == rwc {
+		slog.Error("serial port lost", "err", err)
+		rwc.Close()
+		r.rwc = nil
+	}
+	return fmt.Errorf("%w: %v", errPortLost, err)
+}
+
+func (r *reconnectingPort) Read(p []byte) (int, error) {
+	rwc := r.port()
+	if rwc == nil {
+		return 0, errPortLost
+	}
+	n, err := rwc.Read(p)
+	if err != nil {
+		return n, r.fail(rwc, err)
+	}
+	return n, nil
+}
+
+func (r *reconnectingPort) Write(p []byte) (int, error) {
+	rwc := r.port()
+	if rwc == nil {
+		return 0, errPortLost
+	}
+	n, err := rwc.Write(p)
+	if err != nil {
+		return n, r.fail(rwc, err)
+	}
+	return n, nil
+}
+
+// reset closes the port as if it had failed with err, so that it is
+// reopened by the next reconnect.
+func (r *reconnectingPort) reset(err error) {
+	if rwc := r.port(); rwc != nil {
+		r.fail(rwc, err)
+	}
+}
+
+// connected reports whether the port is open.
+func (r *reconnectingPort) connected() bool {
+	return r.port() != nil
+}
+
+// reconnect reopens the port if it was lost, waiting with exponential
+// backoff between the attempts. It returns false once the port is closed.
+func (r *reconnectingPort) reconnect() bool {
+	for delay := reconnectMinDelay; ; delay = min(2*delay, reconnectMaxDelay) {
+		r.mu.Lock()
+		closed, lost := r.closed, r.rwc == nil
+		r.mu.Unlock()
+		if closed {
+			return false
+		}
+		if !lost {
+			return true
+		}
+		time.Sleep(delay)
+		rwc, err := r.open()
+		if err != nil {
+			slog.Warn("reopen serial port", "err", err)
+			continue
+		}
+		r.mu.Lock()
+		if r.closed {
+			r.mu.Unlock()
+			rwc.Close()
+			return false
+		}
+		r.rwc = rwc
+		r.mu.Unlock()
+		slog.Info("serial port reopened")
+		return true
+	}
+}
+
+// Close closes the port and stops reconnect.
+func (r *reconnectingPort) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.closed = true
+	if r.rwc == nil {
+		return nil
+	}
+	err := r.rwc.Close()
+	r.rwc = nil
+	return err
+}
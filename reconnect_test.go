@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mwuertinger/gq-gmc/pkg/gqgmc"
+	"github.com/mwuertinger/gq-gmc/pkg/gqgmc/gqgmctest"
+)
+
+func TestReconnectingPort(t *testing.T) {
+	emu := gqgmctest.New("GMC-320Re 4.26")
+	r, err := newReconnectingPort(emu.Open)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	dev := gqgmc.New(r)
+	dev.SetTimeouts(time.Second, 0)
+	emu.Send(1)
+	if err := dev.EnableHeartbeat(); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := dev.ReadCounts(); err != nil || n != 1 {
+		t.Fatalf("ReadCounts() = %d, %v", n, err)
+	}
+
+	emu.Unplug()
+	if _, err := dev.ReadCounts(); !errors.Is(err, errPortLost) {
+		t.Fatalf("ReadCounts() after unplugging = %v, want errPortLost", err)
+	}
+	if r.connected() {
+		t.Fatal("still connected after unplugging")
+	}
+	if _, err := dev.ReadCounts(); !errors.Is(err, errPortLost) {
+		t.Fatalf("ReadCounts() while unplugged = %v, want errPortLost", err)
+	}
+
+	emu.Plug()
+	if !r.reconnect() {
+		t.Fatal("reconnect() = false")
+	}
+	// the counter kept streaming while unplugged
+	emu.Send(2)
+	if n, err := dev.ReadCounts(); err != nil || n != 2 {
+		t.Fatalf("ReadCounts() after reconnecting = %d, %v", n, err)
+	}
+}
+
+func TestReconnectingPortClosed(t *testing.T) {
+	emu := gqgmctest.New("GMC-320Re 4.26")
+	r, err := newReconnectingPort(emu.Open)
+	if err != nil {
+		t.Fatal(err)
+	}
+	emu.Unplug()
+	r.reset(errors.New("test"))
+	done := make(chan bool)
+	go func() { done <- r.reconnect() }()
+	r.Close()
+	select {
+	case ok := <-done:
+		if ok {
+			t.Error("reconnect() = true after Close")
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("reconnect() did not return after Close")
+	}
+}
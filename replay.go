@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// replay stands in for a device by playing back the communication logged
+// with -logRawCommunication. The heartbeat frames are sent at their recorded
+// pace, sped up by speed, while heartbeat mode is enabled; every other
+// command is answered with the response recorded for its next occurrence,
+// the last one repeating once they are used up. As heartbeat mode is
+// suspended for commands in the recording as well, the frames continue
+// where they left off.
+//
+// The replay ends, closing done, when the recorded frames or, in a
+// recording of poll mode, the <GETCPM>> responses are used up.
+type replay struct {
+	frames    []traceRecord
+	responses map[string][][]byte // by command, in recorded order
+	answered  map[string]int
+	speed     float64 // 0 replays as fast as possible
+
+	heartbeat bool
+	next      int       // index of the next frame
+	recorded  time.Time // recording time of the last frame sent
+	sent      time.Time // when it was sent
+	pending   []byte
+
+	done     chan struct{}
+	doneOnce sync.Once
+}
+
+// loadReplay reads the trace lines of a log written in the text or JSON
+// format. A log of several counters is played back for the first one.
+func loadReplay(path string, speed float64) (*replay, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := &replay{responses: map[string][][]byte{}, answered: map[string]int{}, speed: speed, done: make(chan struct{})}
+	var device, cmd string
+	var heartbeat bool
+	sc := bufio.NewScanner(f)
+	sc.Buffer(nil, 1<<20)
+	for line := 1; sc.Scan(); line++ {
+		attrs := parseLogLine(sc.Text())
+		msg := attrs["msg"]
+		if msg != "trace: read" && msg != "trace: wrote" {
+			continue
+		}
+		if device == "" {
+			device = attrs["device"]
+		} else if attrs["device"] != device {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339Nano, attrs["time"])
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		data, err := hex.DecodeString(attrs["data"])
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		if msg == "trace: wrote" {
+			cmd = string(data)
+			switch cmd {
+			case "<HEARTBEAT1>>":
+				if !heartbeat && r.recorded.IsZero() {
+					r.recorded = t
+				}
+				heartbeat = true
+			case "<HEARTBEAT0>>":
+				heartbeat = false
+			default:
+				r.responses[cmd] = append(r.responses[cmd], nil)
+			}
+			continue
+		}
+		switch {
+		case heartbeat:
+			r.frames = append(r.frames, traceRecord{time: t, data: data})
+		case r.responses[cmd] != nil:
+			last := len(r.responses[cmd]) - 1
+			r.responses[cmd][last] = append(r.responses[cmd][last], data...)
+		}
+		// anything else was drained after leaving heartbeat mode
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(r.frames) == 0 && len(r.responses) == 0 {
+		return nil, fmt.Errorf("%s: no raw communication found, record it with -logRawCommunication", path)
+	}
+	return r, nil
+}
+
+// parseLogLine returns the attributes of a line written by the text or the
+// JSON handler of slog. Nested attributes are not supported.
+func parseLogLine(line string) map[string]string {
+	attrs := map[string]string{}
+	if strings.HasPrefix(line, "{") {
+		var m map[string]interface{}
+		if json.Unmarshal([]byte(line), &m) != nil {
+			return attrs
+		}
+		for k, v := range m {
+			attrs[k] = fmt.Sprint(v)
+		}
+		return attrs
+	}
+	for line != "" {
+		key, rest, ok := strings.Cut(line, "=")
+		if !ok {
+			break
+		}
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			quoted, err := strconv.QuotedPrefix(rest)
+			if err != nil {
+				break
+			}
+			value, _ = strconv.Unquote(quoted)
+			rest = rest[len(quoted):]
+		} else {
+			value, rest, _ = strings.Cut(rest, " ")
+		}
+		attrs[key] = value
+		line = strings.TrimLeft(rest, " ")
+	}
+	return attrs
+}
+
+// finish ends the replay.
+func (r *replay) finish() {
+	r.doneOnce.Do(func() { close(r.done) })
+}
+
+func (r *replay) Read(p []byte) (int, error) {
+	if len(r.pending) == 0 && r.heartbeat && r.next < len(r.frames) {
+		f := r.frames[r.next]
+		var wait time.Duration
+		if r.speed > 0 {
+			wait = time.Until(r.sent.Add(time.Duration(float64(f.time.Sub(r.recorded)) / r.speed)))
+		}
+		if wait > simulatedTimeout {
+			time.Sleep(simulatedTimeout)
+			return 0, nil
+		}
+		time.Sleep(wait)
+		r.pending = f.data
+		r.recorded, r.sent = f.time, time.Now()
+		r.next++
+	}
+	if len(r.pending) == 0 {
+		if r.heartbeat && r.next == len(r.frames) {
+			r.finish()
+		}
+		time.Sleep(simulatedTimeout)
+		return 0, nil
+	}
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
+
+func (r *replay) Write(p []byte) (int, error) {
+	switch cmd := string(p); cmd {
+	case "<HEARTBEAT1>>":
+		if !r.heartbeat {
+			r.heartbeat = true
+			r.sent = time.Now()
+		}
+	case "<HEARTBEAT0>>":
+		r.heartbeat = false
+		r.pending = nil
+	default:
+		responses := r.responses[cmd]
+		if len(responses) == 0 {
+			// not in the recording, the command times out
+			r.pending = nil
+			break
+		}
+		i := r.answered[cmd]
+		if i == len(responses) {
+			if cmd == "<GETCPM>>" && len(r.frames) == 0 {
+				r.finish()
+			}
+			i--
+		} else {
+			r.answered[cmd]++
+		}
+		r.pending = responses[i]
+	}
+	return len(p), nil
+}
+
+func (r *replay) Close() error {
+	return nil
+}
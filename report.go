@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/mwuertinger/gq-gmc/pkg/gqgmc"
+)
+
+// reporter is a notifier that can also deliver reports.
+type reporter interface {
+	report(subject, contentType, body string) error
+}
+
+// dailyStats summarizes the readings since start for the daily report.
+type dailyStats struct {
+	start     time.Time
+	intervals int
+	sum       float64 // dose rate in µSv/h
+	min, max  float64
+	doseStart float64 // cumulative dose at start
+	telemetry *gqgmc.Telemetry
+}
+
+func newDailyStats(start time.Time, cumulativeDose float64) *dailyStats {
+	return &dailyStats{start: start, doseStart: cumulativeDose}
+}
+
+// state returns the statistics for the state file. The telemetry is not
+// kept since it is refreshed by the next housekeeping run.
+func (d *dailyStats) state() *dailyState {
+	return &dailyState{Start: d.start, Intervals: d.intervals, Sum: d.sum, Min: d.min, Max: d.max, DoseStart: d.doseStart}
+}
+
+func restoreDailyStats(s *dailyState) *dailyStats {
+	return &dailyStats{start: s.Start, intervals: s.Intervals, sum: s.Sum, min: s.Min, max: s.Max, doseStart: s.DoseStart}
+}
+
+func (d *dailyStats) add(doseRate float64) {
+	if d.intervals == 0 || doseRate < d.min {
+		d.min = doseRate
+	}
+	if d.intervals == 0 || doseRate > d.max {
+		d.max = doseRate
+	}
+	d.sum += doseRate
+	d.intervals++
+}
+
+// format renders the report as plain text. events are the alert events of
+// the period, truncated if some were dropped.
+func (d *dailyStats) format(snap snapshot, events []alertEvent, truncated bool) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Summary for %s to %s\n", d.start.Format("2006-01-02 15:04"), time.Now().Format("2006-01-02 15:04"))
+	fmt.Fprintf(&b, "Device: %s\n\n", snap.Version)
+	if d.intervals == 0 {
+		b.WriteString("No readings.\n")
+	} else {
+		fmt.Fprintf(&b, "Dose rate: avg %.3f, min %.3f, max %.3f µSv/h (%d intervals)\n", d.sum/float64(d.intervals), d.min, d.max, d.intervals)
+		fmt.Fprintf(&b, "Dose: %.3f µSv, %.3f µSv since %s\n", snap.CumulativeDose-d.doseStart, snap.CumulativeDose, snap.DoseSince.Format("2006-01-02"))
+	}
+
+	b.WriteString("\nAlerts:\n")
+	if truncated {
+		b.WriteString("  (earlier events were dropped)\n")
+	} else if len(events) == 0 {
+		b.WriteString("  none\n")
+	}
+	for _, ev := range events {
+		fmt.Fprintf(&b, "  %s %s\n", ev.Time.Format("15:04:05"), ev)
+	}
+
+	b.WriteString("\nDevice health:\n")
+	if snap.Power != nil {
+		fmt.Fprintf(&b, "  power: %s, %.2f V\n", snap.Power.Source(), snap.Power.Voltage)
+	}
+	if t := d.telemetry; t != nil {
+		if t.Temperature != nil {
+			fmt.Fprintf(&b, "  temperature: %.1f °C\n", *t.Temperature)
+		}
+		if t.Clock != nil {
+			fmt.Fprintf(&b, "  clock drift: %s\n", t.ClockDrift.Round(time.Second))
+		}
+	} else if snap.Power == nil {
+		b.WriteString("  no telemetry\n")
+	}
+	return b.String()
+}
+
+// sendReport delivers a report to all reporters in the background.
+func sendReport(reporters []reporter, subject, contentType, body string) {
+	for _, r := range reporters {
+		go func(r reporter) {
+			if err := r.report(subject, contentType, body); err != nil {
+				slog.Error("report", "subject", subject, "err", err)
+			}
+		}(r)
+	}
+}
+
+// nextTimeOfDay returns the next time after now at which the wall clock
+// shows tod.
+func nextTimeOfDay(now time.Time, tod time.Duration) time.Time {
+	h, m := int(tod/time.Hour), int(tod%time.Hour/time.Minute)
+	t := time.Date(now.Year(), now.Month(), now.Day(), h, m, 0, 0, now.Location())
+	if !t.After(now) {
+		t = time.Date(now.Year(), now.Month(), now.Day()+1, h, m, 0, 0, now.Location())
+	}
+	return t
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// countRingSize is the number of heartbeat samples the reader keeps while
+// the main loop is busy, an hour at one sample per second.
+const countRingSize = 3600
+
+// countRing passes the heartbeat samples from the reader to the main loop.
+// Push never blocks, so that a main loop held up by a stalled write cannot
+// stop the reader and overflow the serial buffers; when the ring is full
+// the oldest sample is overwritten and counted as dropped instead. The
+// samples keep the time they were read at.
+type countRing struct {
+	mu      sync.Mutex
+	buf     []countSample
+	head, n int // oldest sample and number of samples
+	closed  bool
+	dropped int64 // overwritten samples, see takeDropped
+
+	// ready is signalled when samples were pushed or the ring was closed.
+	ready chan struct{}
+}
+
+func newCountRing(size int) *countRing {
+	return &countRing{buf: make([]countSample, size), ready: make(chan struct{}, 1)}
+}
+
+// push adds the counts of a sample read now.
+func (r *countRing) push(counts int) {
+	r.mu.Lock()
+	s := countSample{Time: time.Now(), CPS: counts}
+	if r.n == len(r.buf) {
+		r.buf[r.head] = s
+		r.head = (r.head + 1) % len(r.buf)
+		r.dropped++
+	} else {
+		r.buf[(r.head+r.n)%len(r.buf)] = s
+		r.n++
+	}
+	r.mu.Unlock()
+	r.signal()
+}
+
+// close tells the main loop that the reader stopped, after it took the
+// remaining samples.
+func (r *countRing) close() {
+	r.mu.Lock()
+	r.closed = true
+	r.mu.Unlock()
+	r.signal()
+}
+
+func (r *countRing) signal() {
+	select {
+	case r.ready <- struct{}{}:
+	default:
+	}
+}
+
+// drain appends the samples to dst, oldest first, and empties the ring. It
+// reports false if the ring was closed, these are then the last samples.
+func (r *countRing) drain(dst []countSample) ([]countSample, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := 0; i < r.n; i++ {
+		dst = append(dst, r.buf[(r.head+i)%len(r.buf)])
+	}
+	r.head, r.n = 0, 0
+	return dst, !r.closed
+}
+
+// takeDropped returns the number of samples overwritten since the previous
+// call.
+func (r *countRing) takeDropped() int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := r.dropped
+	r.dropped = 0
+	return n
+}
+
+// backlog returns the number of samples waiting and the size of the ring.
+func (r *countRing) backlog() (int, int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.n, len(r.buf)
+}
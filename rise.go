@@ -0,0 +1,46 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+// riseTracker smooths the count rate with an exponential moving average and
+// derives how fast the smoothed rate changed over the last window, in
+// percent per minute.
+type riseTracker struct {
+	window   time.Duration
+	smoothed float64
+	history  []riseSample // smoothed values of the last window, oldest first
+}
+
+type riseSample struct {
+	time  time.Time
+	value float64
+}
+
+// add records the count rate of an interval ending at t and returns the
+// current rate of rise. It returns 0 until a full window has been observed.
+func (r *riseTracker) add(t time.Time, cpm float64) float64 {
+	if len(r.history) == 0 {
+		r.smoothed = cpm
+	} else {
+		// the time constant of half a window filters out Poisson noise
+		// while still reacting within the window
+		dt := t.Sub(r.history[len(r.history)-1].time)
+		alpha := 1 - math.Exp(-dt.Seconds()/(r.window.Seconds()/2))
+		r.smoothed += alpha * (cpm - r.smoothed)
+	}
+	r.history = append(r.history, riseSample{t, r.smoothed})
+
+	// keep the newest sample that is at least a window old as base
+	for len(r.history) > 1 && t.Sub(r.history[1].time) >= r.window {
+		r.history = r.history[1:]
+	}
+	base := r.history[0]
+	elapsed := t.Sub(base.time)
+	if elapsed < r.window {
+		return 0
+	}
+	return (r.smoothed - base.value) / math.Max(base.value, 1) * 100 / elapsed.Minutes()
+}
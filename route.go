@@ -0,0 +1,51 @@
+package main
+
+import (
+	"path/filepath"
+
+	influxdb "github.com/influxdata/influxdb1-client/v2"
+)
+
+// routeConfig sends the readings of the devices it matches to their own
+// database and MQTT topic with extra tags, so that one collector host can
+// serve independent tenants, e.g.
+//
+//	routes:
+//	  - device: /dev/serial/by-id/usb-1a86_*
+//	    database: tenant_a
+//	    mqtt_topic: tenant-a/gq-gmc
+//	    tags: {project: a}
+type routeConfig struct {
+	Device    string            `yaml:"device"` // pattern of the serial port, see filepath.Match
+	Database  string            `yaml:"database"`
+	MQTTTopic string            `yaml:"mqtt_topic"`
+	Tags      map[string]string `yaml:"tags"`
+}
+
+// route returns the first route matching the serial port device, or an
+// empty one.
+func (c *fileConfig) route(device string) routeConfig {
+	for _, r := range c.Routes {
+		if ok, _ := filepath.Match(r.Device, device); ok {
+			return r
+		}
+	}
+	return routeConfig{}
+}
+
+// routedClient writes to and queries the database of a route instead of the
+// default one.
+type routedClient struct {
+	influxdb.Client
+	database string
+}
+
+func (c *routedClient) Write(bp influxdb.BatchPoints) error {
+	bp.SetDatabase(c.database)
+	return c.Client.Write(bp)
+}
+
+func (c *routedClient) Query(q influxdb.Query) (*influxdb.Response, error) {
+	q.Database = c.database
+	return c.Client.Query(q)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"time"
+)
+
+// safecastURL is the measurements endpoint of the Safecast API.
+const safecastURL = "https://api.safecast.org/measurements.json"
+
+// safecastUploader posts the mean count rate to the Safecast API as a
+// measurement of a registered device at a fixed location.
+type safecastUploader struct {
+	apiKey              string
+	deviceID            int
+	latitude, longitude float64
+}
+
+// safecastMeasurement is a measurement in the format of the Safecast API.
+type safecastMeasurement struct {
+	Latitude   float64   `json:"latitude"`
+	Longitude  float64   `json:"longitude"`
+	Value      float64   `json:"value"`
+	Unit       string    `json:"unit"`
+	DeviceID   int       `json:"device_id,omitempty"`
+	CapturedAt time.Time `json:"captured_at"`
+}
+
+func (s *safecastUploader) upload(u upload) error {
+	body, err := json.Marshal(struct {
+		Measurement safecastMeasurement `json:"measurement"`
+	}{safecastMeasurement{
+		Latitude:   s.latitude,
+		Longitude:  s.longitude,
+		Value:      u.meanCPM,
+		Unit:       "cpm",
+		DeviceID:   s.deviceID,
+		CapturedAt: u.snap.Time.UTC(),
+	}})
+	if err != nil {
+		return err
+	}
+	resp, err := uploadClient.Post(safecastURL+"?"+url.Values{"api_key": {s.apiKey}}.Encode(), "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
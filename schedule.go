@@ -0,0 +1,225 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronSpec is a parsed schedule: either five cron fields (minute, hour, day
+// of month, month, day of week) or "@every <duration>".
+type cronSpec struct {
+	every                         time.Duration
+	minute, hour, dom, month, dow uint64 // bit sets of the allowed values
+	domAny, dowAny                bool
+}
+
+var cronAliases = map[string]string{
+	"@hourly":  "0 * * * *",
+	"@daily":   "0 0 * * *",
+	"@weekly":  "0 0 * * 0",
+	"@monthly": "0 0 1 * *",
+}
+
+// parseCron parses a cron expression like "30 3 * * mon-fri" or
+// "@every 10m". Fields support *, lists, ranges and steps.
+func parseCron(s string) (*cronSpec, error) {
+	s = strings.TrimSpace(s)
+	if d, ok := strings.CutPrefix(s, "@every "); ok {
+		every, err := time.ParseDuration(strings.TrimSpace(d))
+		if err != nil {
+			return nil, err
+		}
+		if every < time.Minute {
+			return nil, fmt.Errorf("%q: interval must be at least 1m", s)
+		}
+		return &cronSpec{every: every}, nil
+	}
+	if alias, ok := cronAliases[s]; ok {
+		s = alias
+	}
+	fields := strings.Fields(s)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("%q: expected 5 fields", s)
+	}
+	var c cronSpec
+	var err error
+	if c.minute, err = parseCronField(fields[0], 0, 59, nil); err != nil {
+		return nil, fmt.Errorf("%q: minute: %w", s, err)
+	}
+	if c.hour, err = parseCronField(fields[1], 0, 23, nil); err != nil {
+		return nil, fmt.Errorf("%q: hour: %w", s, err)
+	}
+	if c.dom, err = parseCronField(fields[2], 1, 31, nil); err != nil {
+		return nil, fmt.Errorf("%q: day of month: %w", s, err)
+	}
+	if c.month, err = parseCronField(fields[3], 1, 12, nil); err != nil {
+		return nil, fmt.Errorf("%q: month: %w", s, err)
+	}
+	if c.dow, err = parseCronField(fields[4], 0, 7, weekdays); err != nil {
+		return nil, fmt.Errorf("%q: day of week: %w", s, err)
+	}
+	// 7 is Sunday as well
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1
+	}
+	c.domAny, c.dowAny = fields[2] == "*", fields[4] == "*"
+	return &c, nil
+}
+
+func parseCronField(f string, min, max int, names map[string]time.Weekday) (uint64, error) {
+	var bits uint64
+	value := func(s string) (int, error) {
+		if d, ok := names[strings.ToLower(s)]; ok {
+			return int(d), nil
+		}
+		v, err := strconv.Atoi(s)
+		if err != nil || v < min || v > max {
+			return 0, fmt.Errorf("%q out of range %d-%d", s, min, max)
+		}
+		return v, nil
+	}
+	for _, part := range strings.Split(f, ",") {
+		rng, stepStr, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepStr); err != nil || step < 1 {
+				return 0, fmt.Errorf("bad step %q", stepStr)
+			}
+		}
+		lo, hi := min, max
+		if rng != "*" {
+			from, to, isRange := strings.Cut(rng, "-")
+			var err error
+			if lo, err = value(from); err != nil {
+				return 0, err
+			}
+			hi = lo
+			if isRange {
+				if hi, err = value(to); err != nil {
+					return 0, err
+				}
+			} else if hasStep {
+				hi = max
+			}
+			if hi < lo {
+				return 0, fmt.Errorf("empty range %q", rng)
+			}
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << v
+		}
+	}
+	return bits, nil
+}
+
+// allHours is the hour field of "*".
+const allHours = 1<<24 - 1
+
+// next returns the first time after t the schedule fires. Cron expressions
+// are evaluated on the local wall clock, like cron does across daylight saving
+// time transitions: times skipped when the clock jumps forward fire right
+// after the jump, and times repeated when it jumps back fire once, unless the
+// hour field is "*".
+func (c *cronSpec) next(t time.Time) time.Time {
+	if c.every > 0 {
+		return t.Add(c.every)
+	}
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	// every valid expression fires within a few years
+	for limit := t.AddDate(5, 0, 0); t.Before(limit); t = t.Add(time.Minute) {
+		_, offset := t.Zone()
+		if _, prev := t.Add(-time.Minute).Zone(); offset > prev {
+			wall := wallClock(t)
+			for w := wall.Add(-time.Duration(offset-prev) * time.Second); w.Before(wall); w = w.Add(time.Minute) {
+				if c.matches(w) {
+					return t
+				}
+			}
+		}
+		if _, before := t.Add(-12 * time.Hour).Zone(); before > offset && c.hour != allHours {
+			if wallClock(t.Add(-time.Duration(before-offset) * time.Second)).Equal(wallClock(t)) {
+				continue // second pass through the repeated hour
+			}
+		}
+		if c.month&(1<<int(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location()).Add(-time.Minute)
+			continue
+		}
+		if !c.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location()).Add(-time.Minute)
+			continue
+		}
+		if c.hour&(1<<t.Hour()) == 0 || c.minute&(1<<t.Minute()) == 0 {
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+// matches reports whether the schedule fires at the wall clock time w.
+func (c *cronSpec) matches(w time.Time) bool {
+	return c.month&(1<<int(w.Month())) != 0 && c.dayMatches(w) && c.hour&(1<<w.Hour()) != 0 && c.minute&(1<<w.Minute()) != 0
+}
+
+// wallClock returns the wall clock time of t as UTC, so that readings of
+// different zone offsets compare.
+func wallClock(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, time.UTC)
+}
+
+// dayMatches follows cron in firing on either day field if both are
+// restricted.
+func (c *cronSpec) dayMatches(t time.Time) bool {
+	dom := c.dom&(1<<t.Day()) != 0
+	dow := c.dow&(1<<int(t.Weekday())) != 0
+	switch {
+	case c.domAny && c.dowAny:
+		return true
+	case c.domAny:
+		return dow
+	case c.dowAny:
+		return dom
+	}
+	return dom || dow
+}
+
+// scheduledTask runs a maintenance task of the main loop on a schedule.
+type scheduledTask struct {
+	task string
+	spec *cronSpec
+}
+
+// scheduleTasks are the tasks that can be scheduled.
+var scheduleTasks = map[string]bool{
+	"sync-clock":    true,
+	"power":         true,
+	"housekeeping":  true,
+	"daily-report":  true,
+	"weekly-report": true,
+	"flush":         true,
+	"history":       true,
+}
+
+// startScheduler sends the name of each task on the returned channel when
+// it is due. The main loop runs the tasks one at a time, so they never
+// compete for the serial port.
+func startScheduler(tasks []scheduledTask) <-chan string {
+	ch := make(chan string)
+	for _, st := range tasks {
+		go func(st scheduledTask) {
+			for {
+				at := st.spec.next(time.Now())
+				if at.IsZero() {
+					return
+				}
+				time.Sleep(time.Until(at))
+				ch <- st.task
+			}
+		}(st)
+	}
+	return ch
+}
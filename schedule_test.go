@@ -0,0 +1,89 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCron(t *testing.T) {
+	tests := []struct {
+		spec    string
+		wantErr bool
+	}{
+		{"30 3 * * mon-fri", false},
+		{"*/15 * * * *", false},
+		{"0 8-18/2 1,15 * *", false},
+		{"0 0 * 1-3 sun", false},
+		{"@daily", false},
+		{"@every 10m", false},
+		{"@every 30s", true},
+		{"@every ten", true},
+		{"* * * *", true},
+		{"60 * * * *", true},
+		{"* 24 * * *", true},
+		{"* * 0 * *", true},
+		{"* * * 13 *", true},
+		{"* * * * 8", true},
+		{"* * * * moo", true},
+		{"*/0 * * * *", true},
+		{"5-1 * * * *", true},
+	}
+	for _, tt := range tests {
+		_, err := parseCron(tt.spec)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseCron(%q) = %v, want error %v", tt.spec, err, tt.wantErr)
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	loc, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip("no time zone database:", err)
+	}
+	at := func(month time.Month, day, hour, min int) time.Time {
+		return time.Date(2024, month, day, hour, min, 0, 0, loc)
+	}
+	// the first pass through 02:30 on October 27, which time.Date leaves open
+	firstPass := time.Date(2024, time.October, 27, 0, 30, 0, 0, time.UTC).In(loc)
+	// 2024-06-03 is a Monday
+	tests := []struct {
+		spec string
+		from time.Time
+		want []time.Time
+	}{
+		{"30 3 * * *", at(time.June, 3, 3, 30), []time.Time{at(time.June, 4, 3, 30), at(time.June, 5, 3, 30)}},
+		{"*/20 * * * *", at(time.June, 3, 10, 5), []time.Time{at(time.June, 3, 10, 20), at(time.June, 3, 10, 40), at(time.June, 3, 11, 0)}},
+		{"10/20 9 * * *", at(time.June, 3, 0, 0), []time.Time{at(time.June, 3, 9, 10), at(time.June, 3, 9, 30), at(time.June, 3, 9, 50), at(time.June, 4, 9, 10)}},
+		{"0 8-12/2 * * *", at(time.June, 3, 9, 0), []time.Time{at(time.June, 3, 10, 0), at(time.June, 3, 12, 0), at(time.June, 4, 8, 0)}},
+		{"0 9 * * mon-wed", at(time.June, 5, 12, 0), []time.Time{at(time.June, 10, 9, 0), at(time.June, 11, 9, 0)}},
+		{"0 9 * * 5-7", at(time.June, 3, 0, 0), []time.Time{at(time.June, 7, 9, 0), at(time.June, 8, 9, 0), at(time.June, 9, 9, 0), at(time.June, 14, 9, 0)}},
+		{"0 0 1,15 * *", at(time.June, 3, 0, 0), []time.Time{at(time.June, 15, 0, 0), at(time.July, 1, 0, 0)}},
+		// both day fields restricted: either matches
+		{"0 0 13 * fri", at(time.September, 1, 0, 0), []time.Time{at(time.September, 6, 0, 0), at(time.September, 13, 0, 0), at(time.September, 20, 0, 0)}},
+		{"0 0 29 2 *", at(time.March, 1, 0, 0), []time.Time{time.Date(2028, time.February, 29, 0, 0, 0, 0, loc)}},
+		{"0 0 1 1,7 *", at(time.March, 1, 0, 0), []time.Time{at(time.July, 1, 0, 0), time.Date(2025, time.January, 1, 0, 0, 0, 0, loc)}},
+		{"@weekly", at(time.June, 3, 0, 0), []time.Time{at(time.June, 9, 0, 0), at(time.June, 16, 0, 0)}},
+		{"@every 90m", at(time.June, 3, 0, 7), []time.Time{at(time.June, 3, 1, 37), at(time.June, 3, 3, 7)}},
+		{"0 0 31 2 *", at(time.June, 3, 0, 0), []time.Time{{}}},
+		// 02:30 does not exist on March 31 and happens twice on October 27
+		{"30 2 * * *", at(time.March, 30, 12, 0), []time.Time{at(time.March, 31, 3, 0), at(time.April, 1, 2, 30)}},
+		{"30 2 * * *", at(time.October, 26, 12, 0), []time.Time{firstPass, at(time.October, 28, 2, 30)}},
+		{"30 * * * *", at(time.October, 27, 1, 0), []time.Time{at(time.October, 27, 1, 30), firstPass, firstPass.Add(time.Hour), at(time.October, 27, 3, 30)}},
+	}
+	for _, tt := range tests {
+		c, err := parseCron(tt.spec)
+		if err != nil {
+			t.Fatalf("parseCron(%q): %v", tt.spec, err)
+		}
+		from := tt.from
+		for i, want := range tt.want {
+			got := c.next(from)
+			if !got.Equal(want) {
+				t.Errorf("%q: firing %d after %v = %v, want %v", tt.spec, i+1, tt.from, got, want)
+				break
+			}
+			from = got
+		}
+	}
+}
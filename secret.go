@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// secretEnv matches a secret referring to an environment variable.
+var secretEnv = regexp.MustCompile(`^\$\{([A-Za-z_][A-Za-z0-9_]*)\}$`)
+
+// resolveSecret returns a credential given either as value or as the path of
+// a file holding it, so that configs can be shared while the secrets are
+// mounted separately. A value of the form ${NAME} is read from the
+// environment variable NAME.
+func resolveSecret(value, file string) (string, error) {
+	if file != "" {
+		if value != "" {
+			return "", fmt.Errorf("either the secret or its file may be given, not both")
+		}
+		b, err := os.ReadFile(file)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(b), "\r\n"), nil
+	}
+	if m := secretEnv.FindStringSubmatch(value); m != nil {
+		v, ok := os.LookupEnv(m[1])
+		if !ok {
+			return "", fmt.Errorf("environment variable %s is not set", m[1])
+		}
+		return v, nil
+	}
+	return value, nil
+}
+
+// resolveSecrets replaces the secrets of the config by their values.
+func (c *fileConfig) resolveSecrets() error {
+	var err error
+	if c.APIToken, err = resolveSecret(c.APIToken, c.APITokenFile); err != nil {
+		return fmt.Errorf("api_token: %w", err)
+	}
+	c.APITokenFile = ""
+	if c.Influx.Token, err = resolveSecret(c.Influx.Token, c.Influx.TokenFile); err != nil {
+		return fmt.Errorf("influx: token: %w", err)
+	}
+	c.Influx.TokenFile = ""
+	if c.Influx.Password, err = resolveSecret(c.Influx.Password, c.Influx.PasswordFile); err != nil {
+		return fmt.Errorf("influx: password: %w", err)
+	}
+	c.Influx.PasswordFile = ""
+	if c.MQTT.Password, err = resolveSecret(c.MQTT.Password, c.MQTT.PasswordFile); err != nil {
+		return fmt.Errorf("mqtt: password: %w", err)
+	}
+	c.MQTT.PasswordFile = ""
+	if c.Radmon.Password, err = resolveSecret(c.Radmon.Password, c.Radmon.PasswordFile); err != nil {
+		return fmt.Errorf("radmon: password: %w", err)
+	}
+	c.Radmon.PasswordFile = ""
+	if c.Safecast.APIKey, err = resolveSecret(c.Safecast.APIKey, c.Safecast.APIKeyFile); err != nil {
+		return fmt.Errorf("safecast: api_key: %w", err)
+	}
+	c.Safecast.APIKeyFile = ""
+	for _, name := range notifierNames(c) {
+		nc := c.Alerts.Notifiers[name]
+		if e := nc.Email; e != nil {
+			if e.Password, err = resolveSecret(e.Password, e.PasswordFile); err != nil {
+				return fmt.Errorf("notifier %s: email: password: %w", name, err)
+			}
+			e.PasswordFile = ""
+		}
+		if t := nc.Telegram; t != nil {
+			if t.Token, err = resolveSecret(t.Token, t.TokenFile); err != nil {
+				return fmt.Errorf("notifier %s: telegram: token: %w", name, err)
+			}
+			t.TokenFile = ""
+		}
+		if p := nc.Pushover; p != nil {
+			if p.Token, err = resolveSecret(p.Token, p.TokenFile); err != nil {
+				return fmt.Errorf("notifier %s: pushover: token: %w", name, err)
+			}
+			p.TokenFile = ""
+		}
+	}
+	return nil
+}
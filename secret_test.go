@@ -0,0 +1,47 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolveSecret(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "token")
+	if err := os.WriteFile(file, []byte("from-file\r\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	ref := filepath.Join(dir, "ref")
+	if err := os.WriteFile(ref, []byte("${GQGMC_TEST_SECRET}\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GQGMC_TEST_SECRET", "from-env")
+	t.Setenv("GQGMC_TEST_EMPTY", "")
+
+	tests := []struct {
+		name, value, file string
+		want              string
+		wantErr           bool
+	}{
+		{"plain", "hunter2", "", "hunter2", false},
+		{"empty", "", "", "", false},
+		{"env", "${GQGMC_TEST_SECRET}", "", "from-env", false},
+		{"empty env", "${GQGMC_TEST_EMPTY}", "", "", false},
+		{"unset env", "${GQGMC_TEST_UNSET}", "", "", true},
+		{"not a whole reference", "x${GQGMC_TEST_SECRET}", "", "x${GQGMC_TEST_SECRET}", false},
+		{"no braces", "$GQGMC_TEST_SECRET", "", "$GQGMC_TEST_SECRET", false},
+		{"file", "", file, "from-file", false},
+		// the file is read as is, without expanding a reference in it
+		{"reference in file", "", ref, "${GQGMC_TEST_SECRET}", false},
+		{"value and file", "hunter2", file, "", true},
+		{"env and file", "${GQGMC_TEST_SECRET}", file, "", true},
+		{"missing file", "", filepath.Join(dir, "missing"), "", true},
+	}
+	for _, tt := range tests {
+		got, err := resolveSecret(tt.value, tt.file)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("%s: resolveSecret(%q, %q) = %q, %v, want %q, error %v", tt.name, tt.value, tt.file, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
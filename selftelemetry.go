@@ -0,0 +1,86 @@
+package main
+
+import (
+	"io"
+	"sync/atomic"
+	"time"
+
+	influxdb "github.com/influxdata/influxdb1-client/v2"
+)
+
+// selfMetrics is the health of the collector itself, as opposed to that
+// of the device. The read errors are those of the first counter.
+type selfMetrics struct {
+	readErrors     atomic.Int64
+	resyncs        atomic.Int64 // heartbeat stream realignments
+	rejected       atomic.Int64 // heartbeat frames with invalid counts or reserved bits
+	overflows      atomic.Int64 // samples dropped as the main loop was behind
+	influxWrites   atomic.Int64 // writes reaching the server, including retries of the buffer
+	influxFailures atomic.Int64
+	influxLatency  atomic.Int64 // of the last write, in nanoseconds
+
+	// missed counts the lost heartbeat frames until the main loop moves
+	// them to the persisted counters, saved is what it moved so far.
+	missed, saved atomic.Int64
+
+	buffer *bufferedClient // nil without -bufferSize
+}
+
+// dropped returns the number of heartbeat frames lost since the start.
+func (m *selfMetrics) dropped() int64 {
+	return m.saved.Load() + m.missed.Load()
+}
+
+// backlog returns the number of points buffered after failed writes.
+func (m *selfMetrics) backlog() int {
+	if m.buffer == nil {
+		return 0
+	}
+	return m.buffer.backlog()
+}
+
+// fields returns the metrics as the fields of the collector measurement.
+func (m *selfMetrics) fields() map[string]interface{} {
+	return map[string]interface{}{
+		"read_errors":            m.readErrors.Load(),
+		"resyncs":                m.resyncs.Load(),
+		"rejected_samples":       m.rejected.Load(),
+		"overflowed_samples":     m.overflows.Load(),
+		"dropped_counts":         m.dropped(),
+		"influx_writes":          m.influxWrites.Load(),
+		"influx_failures":        m.influxFailures.Load(),
+		"influx_latency_seconds": time.Duration(m.influxLatency.Load()).Seconds(),
+		"buffer_backlog":         m.backlog(),
+	}
+}
+
+// writePrometheus writes the metrics in the Prometheus text format.
+func (m *selfMetrics) writePrometheus(w io.Writer) {
+	writeCounter(w, "gqgmc_collector_read_errors_total", "Failed reads of heartbeat frames.", float64(m.readErrors.Load()))
+	writeCounter(w, "gqgmc_collector_resyncs_total", "Realignments of the heartbeat stream.", float64(m.resyncs.Load()))
+	writeCounter(w, "gqgmc_collector_rejected_samples_total", "Heartbeat frames dropped for invalid counts, like floods of 0x3FFF or a stuck value, or read with the reserved bits masked.", float64(m.rejected.Load()))
+	writeCounter(w, "gqgmc_collector_overflowed_samples_total", "Heartbeat samples dropped as the main loop fell behind the reader.", float64(m.overflows.Load()))
+	writeCounter(w, "gqgmc_collector_dropped_counts_total", "Heartbeat frames lost to timeouts, read errors, port failures and a main loop behind the reader.", float64(m.dropped()))
+	writeCounter(w, "gqgmc_collector_influx_writes_total", "Writes to InfluxDB, including retries of buffered points.", float64(m.influxWrites.Load()))
+	writeCounter(w, "gqgmc_collector_influx_failures_total", "Failed writes to InfluxDB.", float64(m.influxFailures.Load()))
+	writeGauge(w, "gqgmc_collector_influx_latency_seconds", "Duration of the last write to InfluxDB.", nil, time.Duration(m.influxLatency.Load()).Seconds())
+	writeGauge(w, "gqgmc_collector_buffer_backlog_points", "Points of failed writes waiting to be written again.", nil, float64(m.backlog()))
+}
+
+// meteredClient records the latency and the failures of the writes to the
+// server in metrics.
+type meteredClient struct {
+	influxdb.Client
+	metrics *selfMetrics
+}
+
+func (c *meteredClient) Write(bp influxdb.BatchPoints) error {
+	start := time.Now()
+	err := c.Client.Write(bp)
+	c.metrics.influxLatency.Store(int64(time.Since(start)))
+	c.metrics.influxWrites.Add(1)
+	if err != nil {
+		c.metrics.influxFailures.Add(1)
+	}
+	return err
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mwuertinger/gq-gmc/pkg/gqgmc/gqgmctest"
+	"golang.org/x/sys/unix"
+)
+
+// servePTY connects the emulator to the master side of a new pseudo
+// terminal and returns the name of its slave side, which stands in for the
+// serial port of the counter.
+func servePTY(t *testing.T, emu *gqgmctest.Emulator) string {
+	t.Helper()
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		t.Skip("no pseudo terminals:", err)
+	}
+	fd := int(master.Fd())
+	if err := unix.IoctlSetPointerInt(fd, unix.TIOCSPTLCK, 0); err != nil {
+		t.Fatal(err)
+	}
+	n, err := unix.IoctlGetUint32(fd, unix.TIOCGPTN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := fmt.Sprintf("/dev/pts/%d", n)
+	// held open so that the master does not see a hangup while the port is
+	// closed between the probes, and raw like the port the collector opens
+	slave, err := os.OpenFile(name, os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tio, err := unix.IoctlGetTermios(int(slave.Fd()), unix.TCGETS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tio.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP | unix.INLCR | unix.IGNCR | unix.ICRNL | unix.IXON
+	tio.Oflag &^= unix.OPOST
+	tio.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+	if err := unix.IoctlSetTermios(int(slave.Fd()), unix.TCSETS, tio); err != nil {
+		t.Fatal(err)
+	}
+
+	port, err := emu.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		buf := make([]byte, 256)
+		for {
+			n, err := master.Read(buf)
+			if err != nil {
+				return
+			}
+			port.Write(buf[:n])
+		}
+	}()
+	go func() {
+		buf := make([]byte, 256)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			n, _ := port.Read(buf)
+			if n > 0 {
+				master.Write(buf[:n])
+			}
+		}
+	}()
+	t.Cleanup(func() {
+		close(done)
+		port.Close()
+		master.Close()
+		slave.Close()
+	})
+	return name
+}
+
+// TestSerialPort runs the protocol through the serial port code of the
+// collector, which the in-process emulator bypasses.
+func TestSerialPort(t *testing.T) {
+	emu := gqgmctest.New("GMC-320Re 4.26")
+	p := &portConfig{device: servePTY(t, emu), readTimeout: time.Second}
+	s, err := p.open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if p.baud != probeBauds[0] {
+		t.Errorf("baud = %d, want %d", p.baud, probeBauds[0])
+	}
+	dev := p.newDevice(s)
+	if v, err := dev.Version(); err != nil || v != "GMC-320Re 4.26" {
+		t.Fatalf("Version() = %q, %v", v, err)
+	}
+	emu.Send(3, 0, 5)
+	if err := dev.EnableHeartbeat(); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []int{3, 0, 5} {
+		if n, err := dev.ReadCounts(); err != nil || n != want {
+			t.Fatalf("ReadCounts() = %d, %v, want %d", n, err, want)
+		}
+	}
+	if _, err := dev.Serial(); err != nil {
+		t.Errorf("Serial() during heartbeat: %v", err)
+	}
+}
+
+func TestSerialPortLocked(t *testing.T) {
+	emu := gqgmctest.New("GMC-320Re 4.26")
+	p := &portConfig{device: servePTY(t, emu), baud: 115200}
+	s, err := p.open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.open(); err == nil || !strings.Contains(err.Error(), "in use") {
+		t.Errorf("second open = %v, want the port in use", err)
+	}
+	s.Close()
+	s, err = p.open()
+	if err != nil {
+		t.Fatalf("open after closing: %v", err)
+	}
+	s.Close()
+}
+
+// TestSerialPortPause has the counter pause for several read timeouts of the
+// port, which github.com/tarm/serial reports as io.EOF on Linux.
+func TestSerialPortPause(t *testing.T) {
+	emu := gqgmctest.New("GMC-320Re 4.26")
+	p := &portConfig{device: servePTY(t, emu), baud: 115200, readTimeout: time.Second}
+	s, err := p.open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	buf := make([]byte, 1)
+	if n, err := s.Read(buf); n != 0 || err != nil {
+		t.Fatalf("Read() of the idle port = %d, %v, want a timeout", n, err)
+	}
+	dev := p.newDevice(s)
+	emu.Send(1)
+	emu.Pause(3 * portPollInterval)
+	emu.Send(2)
+	if err := dev.EnableHeartbeat(); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []int{1, 2} {
+		if n, err := dev.ReadCounts(); err != nil || n != want {
+			t.Fatalf("ReadCounts() = %d, %v, want %d", n, err, want)
+		}
+	}
+	emu.Handle("GETVER", func([]byte) []byte {
+		time.Sleep(3 * portPollInterval)
+		return []byte("GMC-320Re 4.26")
+	})
+	if err := dev.DisableHeartbeat(); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := dev.Version(); err != nil || v != "GMC-320Re 4.26" {
+		t.Errorf("slow Version() = %q, %v", v, err)
+	}
+}
@@ -0,0 +1,20 @@
+//go:build !windows
+
+package main
+
+import (
+	"log"
+	"os"
+)
+
+// isService is false outside Windows, where the collector runs under
+// systemd or a similar supervisor, see gq-gmc.service.
+func isService() bool { return false }
+
+func runService([]string) {}
+
+func forwardServiceStop(chan<- os.Signal) {}
+
+func serviceCommand([]string) {
+	log.Fatal("service: only on Windows, use gq-gmc.service with systemd")
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// serviceName is the name the collector is installed under as a Windows
+// service.
+const serviceName = "gq-gmc"
+
+// serviceStopped is closed when the service control manager stops the
+// collector, see forwardServiceStop.
+var (
+	serviceStopped  = make(chan struct{})
+	serviceStopOnce sync.Once
+)
+
+// isService reports whether the process was started by the service control
+// manager.
+func isService() bool {
+	ok, err := svc.IsWindowsService()
+	return err == nil && ok
+}
+
+// runService runs serve with args under the service control manager. A
+// service has no console, so the log goes to gq-gmc.log in the program data
+// directory.
+func runService(args []string) {
+	dir := filepath.Join(os.Getenv("ProgramData"), serviceName)
+	if err := os.MkdirAll(dir, 0755); err == nil {
+		if f, err := os.OpenFile(filepath.Join(dir, serviceName+".log"), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644); err == nil {
+			os.Stderr = f
+			setupLogging("text")
+		}
+	}
+	if err := svc.Run(serviceName, &windowsService{args: args}); err != nil {
+		log.Fatalf("service: %v", err)
+	}
+}
+
+type windowsService struct {
+	args []string
+}
+
+func (s *windowsService) Execute(_ []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.StartPending}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		serveCommand(s.args)
+	}()
+	status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+	for {
+		select {
+		case <-done:
+			return false, 0
+		case r := <-requests:
+			switch r.Cmd {
+			case svc.Interrogate:
+				status <- r.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				status <- svc.Status{State: svc.StopPending}
+				serviceStopOnce.Do(func() { close(serviceStopped) })
+				<-done
+				return false, 0
+			}
+		}
+	}
+}
+
+// forwardServiceStop delivers a stop of the service to sigChan as SIGTERM,
+// so that serve shuts down as on Ctrl-C.
+func forwardServiceStop(sigChan chan<- os.Signal) {
+	go func() {
+		<-serviceStopped
+		sigChan <- syscall.SIGTERM
+	}()
+}
+
+// serviceCommand implements "gq-gmc service", which installs the collector
+// as a Windows service starting with the system.
+func serviceCommand(args []string) {
+	fs := flag.NewFlagSet("service", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), `Usage: %s service install [-- serve flags]
+       %[1]s service uninstall
+
+Installs the collector as the Windows service %s, started with the system
+and restarted when it fails, or removes it. The serve flags are stored with
+the service, paths among them must be absolute, e.g.
+
+  %[1]s service install -- -config C:\ProgramData\gq-gmc\gq-gmc.yaml
+
+The log goes to %%ProgramData%%\gq-gmc\gq-gmc.log.
+`, os.Args[0], serviceName)
+	}
+	parseFlags(fs, args)
+	if fs.NArg() < 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	var err error
+	switch fs.Arg(0) {
+	case "install":
+		serveArgs := fs.Args()[1:]
+		if len(serveArgs) > 0 && serveArgs[0] == "--" {
+			serveArgs = serveArgs[1:]
+		}
+		err = installService(serveArgs)
+	case "uninstall":
+		err = uninstallService()
+	default:
+		fs.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatalf("service: %v", err)
+	}
+}
+
+func installService(args []string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+	if s, err := m.OpenService(serviceName); err == nil {
+		s.Close()
+		return fmt.Errorf("%s is installed already", serviceName)
+	}
+	s, err := m.CreateService(serviceName, exe, mgr.Config{
+		DisplayName: "gq-gmc Geiger counter collector",
+		Description: "Collects the readings of a GQ GMC Geiger counter.",
+		StartType:   mgr.StartAutomatic,
+	}, args...)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	// like Restart=always of the systemd unit
+	if err := s.SetRecoveryActions([]mgr.RecoveryAction{{Type: mgr.ServiceRestart, Delay: 10 * time.Second}}, 24*60*60); err != nil {
+		slog.Warn("service: recovery actions", "err", err)
+	}
+	slog.Info("service installed", "name", serviceName, "exe", exe, "args", args)
+	return s.Start()
+}
+
+func uninstallService() error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+	s, err := m.OpenService(serviceName)
+	if err != nil {
+		return fmt.Errorf("%s is not installed: %w", serviceName, err)
+	}
+	defer s.Close()
+	if _, err := s.Control(svc.Stop); err != nil && !errors.Is(err, windows.ERROR_SERVICE_NOT_ACTIVE) {
+		slog.Warn("service: stop", "err", err)
+	}
+	if err := s.Delete(); err != nil {
+		return err
+	}
+	slog.Info("service uninstalled", "name", serviceName)
+	return nil
+}
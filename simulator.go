@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/mwuertinger/gq-gmc/pkg/gqgmc"
+	"gopkg.in/yaml.v3"
+)
+
+// defaultSimulatedVersion is the <GETVER>> string the simulator reports by
+// default.
+const defaultSimulatedVersion = "GMC-320Re 4.19"
+
+// simulatedSerial is the <GETSERIAL>> response of the simulator.
+var simulatedSerial = []byte{0xF4, 0x88, 0x1A, 0x00, 0x42, 0x17, 0x03}
+
+// simulatedTimeout is how long the simulator blocks in Read when it has
+// nothing to send, standing in for the port's read timeout.
+const simulatedTimeout = 100 * time.Millisecond
+
+// defaultBackgroundCPM is the count rate of the simulator without a scenario.
+const defaultBackgroundCPM = 20
+
+// poissonNormalLimit is the mean above which poisson approximates the
+// distribution by a normal one.
+const poissonNormalLimit = 30
+
+// scenario is a sequence of steps the simulated count rate follows, loaded
+// from the -simulate file:
+//
+//	steps:
+//	  - {duration: 10m, usvh: 0.1}
+//	  - {duration: 2m, usvh: 5}
+//	  - {duration: 5m, usvh: 0.1, ramp: true}
+//	repeat: true
+//
+// The level of the last step holds once the scenario has ended, unless it is
+// repeated.
+type scenario struct {
+	Steps  []scenarioStep `yaml:"steps"`
+	Repeat bool           `yaml:"repeat"`
+}
+
+// scenarioStep sets the count rate for a period of time. The level is given
+// either in CPM or in µSv/h. With ramp the rate changes linearly from the
+// previous level to this one over the duration of the step.
+type scenarioStep struct {
+	Duration duration `yaml:"duration"`
+	CPM      *float64 `yaml:"cpm"`
+	USvH     *float64 `yaml:"usvh"`
+	Ramp     bool     `yaml:"ramp"`
+}
+
+func loadScenario(path string) (*scenario, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var sc scenario
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	if err := dec.Decode(&sc); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(sc.Steps) == 0 {
+		return nil, fmt.Errorf("%s: no steps", path)
+	}
+	var total time.Duration
+	for i, st := range sc.Steps {
+		if (st.CPM == nil) == (st.USvH == nil) {
+			return nil, fmt.Errorf("%s: step %d: exactly one of cpm and usvh required", path, i+1)
+		}
+		if st.Duration < 0 {
+			return nil, fmt.Errorf("%s: step %d: negative duration", path, i+1)
+		}
+		total += time.Duration(st.Duration)
+	}
+	if sc.Repeat && total == 0 {
+		return nil, fmt.Errorf("%s: repeated scenario has no duration", path)
+	}
+	return &sc, nil
+}
+
+// level returns the count rate of the step in CPM for tube t.
+func (s scenarioStep) level(t gqgmc.Tube) float64 {
+	if s.CPM != nil {
+		return *s.CPM
+	}
+	return *s.USvH / t.Factor
+}
+
+// cpm returns the count rate at offset d from the start of the scenario.
+func (sc *scenario) cpm(d time.Duration, t gqgmc.Tube) float64 {
+	var total time.Duration
+	for _, st := range sc.Steps {
+		total += time.Duration(st.Duration)
+	}
+	if sc.Repeat {
+		d %= total
+	}
+	prev := sc.Steps[0].level(t)
+	for _, st := range sc.Steps {
+		cur, length := st.level(t), time.Duration(st.Duration)
+		if d < length {
+			if !st.Ramp {
+				return cur
+			}
+			return prev + (cur-prev)*float64(d)/float64(length)
+		}
+		d -= length
+		prev = cur
+	}
+	return prev
+}
+
+// simulator stands in for a device when no port is configured. It answers
+// <GETVER>>, <GETSERIAL>> and <GETCPM>> and sends a heartbeat frame every second while
+// heartbeat mode is enabled. The counts are Poisson-distributed around the
+// rate of the scenario, like the decays a real tube registers.
+//
+// The responses have the widths of the firmware of the version string, so
+// the protocol variants detected from <GETVER>> can be tried out without the
+// model at hand. A dual tube model also answers <GETCPML>> and <GETCPMH>>,
+// the tube for high dose rates counting as much less as it is less
+// sensitive.
+type simulator struct {
+	scenario  *scenario
+	version   string
+	quirks    gqgmc.Quirks
+	tube      gqgmc.Tube
+	highShare float64 // of the counts registered by the high dose rate tube
+	start     time.Time
+	rng       *rand.Rand
+	heartbeat bool
+	next      time.Time // when the next heartbeat frame is due
+	pending   []byte
+}
+
+// newSimulator returns a simulator of the firmware version following sc. A
+// nil scenario produces a constant background rate of cpm.
+func newSimulator(sc *scenario, cpm float64, version string) *simulator {
+	if sc == nil {
+		sc = &scenario{Steps: []scenarioStep{{CPM: &cpm}}}
+	}
+	profile := gqgmc.LookupProfile(version)
+	s := &simulator{
+		scenario: sc,
+		version:  version,
+		quirks:   gqgmc.LookupQuirks(version),
+		tube:     profile.Tube,
+		start:    time.Now(),
+		rng:      rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	if profile.HighTube.Factor > 0 {
+		s.highShare = profile.Tube.Factor / profile.HighTube.Factor
+	}
+	return s
+}
+
+// poisson draws from the Poisson distribution with the given mean, using
+// Knuth's method for small means.
+func (s *simulator) poisson(mean float64) float64 {
+	if mean <= 0 {
+		return 0
+	}
+	if mean > poissonNormalLimit {
+		return math.Max(0, math.Round(mean+math.Sqrt(mean)*s.rng.NormFloat64()))
+	}
+	limit, p, k := math.Exp(-mean), 1.0, 0.0
+	for {
+		p *= s.rng.Float64()
+		if p <= limit {
+			return k
+		}
+		k++
+	}
+}
+
+func (s *simulator) Read(p []byte) (int, error) {
+	if len(s.pending) == 0 && s.heartbeat {
+		wait := time.Until(s.next)
+		if wait > simulatedTimeout {
+			time.Sleep(simulatedTimeout)
+			return 0, nil
+		}
+		time.Sleep(wait)
+		s.pending = s.frame(s.next)
+		s.next = s.next.Add(time.Second)
+	}
+	if len(s.pending) == 0 {
+		time.Sleep(simulatedTimeout)
+		return 0, nil
+	}
+	n := copy(p, s.pending)
+	s.pending = s.pending[n:]
+	return n, nil
+}
+
+// frame returns the heartbeat frame for the second ending at t.
+func (s *simulator) frame(t time.Time) []byte {
+	mean := s.scenario.cpm(t.Sub(s.start), s.tube) / 60
+	if s.quirks.Heartbeat32 {
+		return binary.BigEndian.AppendUint32(nil, uint32(min(s.poisson(mean), 0x3FFFFFFF)))
+	}
+	return binary.BigEndian.AppendUint16(nil, uint16(min(s.poisson(mean), 0x3FFF)))
+}
+
+// cpm returns a <GETCPM>> response for the given share of the count rate.
+func (s *simulator) cpm(share float64, wide bool) []byte {
+	cpm := s.poisson(s.scenario.cpm(time.Since(s.start), s.tube) * share)
+	if wide {
+		return binary.BigEndian.AppendUint32(nil, uint32(min(cpm, math.MaxUint32)))
+	}
+	return binary.BigEndian.AppendUint16(nil, uint16(min(cpm, 0xFFFF)))
+}
+
+func (s *simulator) Write(p []byte) (int, error) {
+	switch string(p) {
+	case "<GETVER>>":
+		s.pending = []byte(s.version)
+	case "<GETSERIAL>>":
+		s.pending = append([]byte(nil), simulatedSerial...)
+	case "<GETCPM>>":
+		s.pending = s.cpm(1, s.quirks.CPM32)
+	case "<GETCPML>>", "<GETCPMH>>":
+		if !s.quirks.DualTube {
+			break
+		}
+		share := 1.0
+		if string(p) == "<GETCPMH>>" {
+			share = s.highShare
+		}
+		s.pending = s.cpm(share, true)
+	case "<HEARTBEAT1>>":
+		if !s.heartbeat {
+			s.heartbeat = true
+			s.next = time.Now().Add(time.Second)
+		}
+	case "<HEARTBEAT0>>":
+		s.heartbeat = false
+		s.pending = nil
+	}
+	return len(p), nil
+}
+
+func (s *simulator) Close() error {
+	return nil
+}
+
+// checkSimulatedVersion validates a <GETVER>> string for the simulator: it
+// has the 14 bytes of the protocol, one more for the "+" models.
+func checkSimulatedVersion(v string) error {
+	size := 14
+	if strings.Contains(v, "+") {
+		size = 15
+	}
+	if len(v) != size || !strings.HasPrefix(v, "GMC-") {
+		return fmt.Errorf("%q is not a version string like %q", v, defaultSimulatedVersion)
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+// ewma is an exponentially weighted moving average with time constant tau,
+// like the averaging behind the display of the counters. The values may
+// arrive at any interval; a value tau after the previous one gets a weight
+// of 63%.
+type ewma struct {
+	tau   time.Duration
+	value float64
+	last  time.Time // of the previous value, zero before the first
+}
+
+// add records the value v at t and returns the average.
+func (e *ewma) add(t time.Time, v float64) float64 {
+	if e.last.IsZero() {
+		e.value = v
+	} else {
+		alpha := 1 - math.Exp(-t.Sub(e.last).Seconds()/e.tau.Seconds())
+		e.value += alpha * (v - e.value)
+	}
+	e.last = t
+	return e.value
+}
+
+// addSmoothedDoseRate adds the smoothed dose rate to the fields if the
+// smoothing is enabled.
+func addSmoothedDoseRate(fields map[string]interface{}, smoothed *ewma, doseRate float64) {
+	if smoothed != nil {
+		fields["geiger_counter_dose_rate_smoothed"] = smoothed.add(time.Now(), doseRate)
+	}
+}
@@ -0,0 +1,416 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// snmpBaseOID is NET-SNMP's playpen subtree, which is reserved for local
+// use. GQ-GMC-MIB.txt describes the objects below it.
+const snmpBaseOID = "1.3.6.1.4.1.8072.9999.9999.1"
+
+// BER tags used by SNMPv1/v2c.
+const (
+	berInteger     = 0x02
+	berOctetString = 0x04
+	berNull        = 0x05
+	berOID         = 0x06
+	berSequence    = 0x30
+	berGauge32     = 0x42
+	berCounter64   = 0x46
+
+	pduGet      = 0xA0
+	pduGetNext  = 0xA1
+	pduResponse = 0xA2
+	pduGetBulk  = 0xA5
+
+	snmpNoSuchObject = 0x80
+	snmpEndOfMib     = 0x82
+
+	snmpErrNoSuchName = 2 // SNMPv1 only
+)
+
+// snmpObject is a scalar of the private MIB.
+type snmpObject struct {
+	oid   []int
+	value func(s snapshot) (tag byte, value []byte)
+}
+
+// snmpAgent answers SNMPv1 and SNMPv2c read requests for the current
+// readings.
+type snmpAgent struct {
+	community string
+	state     *liveState
+	objects   []snmpObject // sorted by oid
+}
+
+func newSNMPAgent(community string, state *liveState) *snmpAgent {
+	base := parseOID(snmpBaseOID)
+	scalar := func(n int) []int {
+		return append(append([]int(nil), base...), n, 0)
+	}
+	return &snmpAgent{
+		community: community,
+		state:     state,
+		objects: []snmpObject{
+			{scalar(1), func(s snapshot) (byte, []byte) {
+				return berGauge32, berUint(uint64(s.CPM))
+			}},
+			{scalar(2), func(s snapshot) (byte, []byte) {
+				// nSv/h, SNMP has no floating point type
+				return berGauge32, berUint(uint64(math.Round(s.DoseRate * 1000)))
+			}},
+			{scalar(3), func(s snapshot) (byte, []byte) {
+				return berCounter64, berUint(uint64(math.Round(s.CumulativeDose * 1000)))
+			}},
+			{scalar(4), func(s snapshot) (byte, []byte) {
+				return berOctetString, []byte(s.Version)
+			}},
+			{scalar(5), func(s snapshot) (byte, []byte) {
+				if s.Power == nil {
+					return berGauge32, berUint(0)
+				}
+				return berGauge32, berUint(uint64(math.Round(s.Power.Voltage * 1000)))
+			}},
+			{scalar(6), func(s snapshot) (byte, []byte) {
+				switch {
+				case s.Power == nil:
+					return berInteger, berInt(0)
+				case s.Power.External:
+					return berInteger, berInt(2)
+				}
+				return berInteger, berInt(1)
+			}},
+			{scalar(7), func(s snapshot) (byte, []byte) {
+				if s.Time.IsZero() {
+					return berGauge32, berUint(0)
+				}
+				return berGauge32, berUint(uint64(time.Since(s.Time).Seconds()))
+			}},
+		},
+	}
+}
+
+// serve answers requests on conn until it is closed.
+func (a *snmpAgent) serve(conn net.PacketConn) {
+	buf := make([]byte, 65535)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				slog.Error("snmp", "err", err)
+			}
+			return
+		}
+		resp, err := a.handle(buf[:n])
+		if err != nil {
+			slog.Debug("snmp: bad request", "from", addr.String(), "err", err)
+			continue
+		}
+		if resp != nil {
+			conn.WriteTo(resp, addr)
+		}
+	}
+}
+
+// handle decodes a request and returns the encoded response. Requests with a
+// wrong community are dropped silently, as mandated by the RFCs.
+func (a *snmpAgent) handle(msg []byte) ([]byte, error) {
+	tag, body, _, err := berRead(msg)
+	if err != nil || tag != berSequence {
+		return nil, errors.New("malformed message")
+	}
+	_, v, body, err := berRead(body)
+	if err != nil {
+		return nil, err
+	}
+	version := berToInt(v)
+	if version != 0 && version != 1 {
+		return nil, fmt.Errorf("unsupported version %d", version)
+	}
+	_, community, body, err := berRead(body)
+	if err != nil {
+		return nil, err
+	}
+	if string(community) != a.community {
+		return nil, nil
+	}
+	pduType, pdu, _, err := berRead(body)
+	if err != nil {
+		return nil, err
+	}
+	_, reqID, pdu, err := berRead(pdu)
+	if err != nil {
+		return nil, err
+	}
+	_, p1, pdu, err := berRead(pdu)
+	if err != nil {
+		return nil, err
+	}
+	_, p2, pdu, err := berRead(pdu)
+	if err != nil {
+		return nil, err
+	}
+	_, vbList, _, err := berRead(pdu)
+	if err != nil {
+		return nil, err
+	}
+	var oids [][]int
+	for len(vbList) > 0 {
+		var vb []byte
+		_, vb, vbList, err = berRead(vbList)
+		if err != nil {
+			return nil, err
+		}
+		_, oid, _, err := berRead(vb)
+		if err != nil {
+			return nil, err
+		}
+		oids = append(oids, decodeOID(oid))
+	}
+
+	// SNMPv1 knows neither Counter64 nor the exceptions of SNMPv2: a
+	// missing object fails the whole request with noSuchName, and the
+	// response repeats the requested varbinds with NULL values (RFC 1157,
+	// RFC 3584 section 4.2.2).
+	snap := a.state.get()
+	var errStatus, errIndex int
+	var varbinds []byte
+	switch pduType {
+	case pduGet:
+		for i, oid := range oids {
+			obj := a.find(oid)
+			if obj != nil && version == 0 && obj.v2Only(snap) {
+				obj = nil
+			}
+			if obj == nil {
+				if version == 0 {
+					errStatus, errIndex = snmpErrNoSuchName, i+1
+					break
+				}
+				varbinds = append(varbinds, berVarbind(oid, snmpNoSuchObject, nil)...)
+				continue
+			}
+			t, v := obj.value(snap)
+			varbinds = append(varbinds, berVarbind(oid, t, v)...)
+		}
+	case pduGetNext:
+		for i, oid := range oids {
+			obj := a.next(oid)
+			for version == 0 && obj != nil && obj.v2Only(snap) {
+				obj = a.next(obj.oid)
+			}
+			if obj == nil {
+				if version == 0 {
+					errStatus, errIndex = snmpErrNoSuchName, i+1
+					break
+				}
+				varbinds = append(varbinds, berVarbind(oid, snmpEndOfMib, nil)...)
+				continue
+			}
+			t, v := obj.value(snap)
+			varbinds = append(varbinds, berVarbind(obj.oid, t, v)...)
+		}
+	case pduGetBulk:
+		if version == 0 {
+			return nil, errors.New("GetBulk is not part of SNMPv1")
+		}
+		// p1 is non-repeaters, p2 max-repetitions
+		nonRepeaters, maxRep := int(berToInt(p1)), int(berToInt(p2))
+		for i, oid := range oids {
+			reps := maxRep
+			if i < nonRepeaters {
+				reps = 1
+			}
+			for r := 0; r < reps; r++ {
+				obj := a.next(oid)
+				if obj == nil {
+					varbinds = append(varbinds, berVarbind(oid, snmpEndOfMib, nil)...)
+					break
+				}
+				t, v := obj.value(snap)
+				varbinds = append(varbinds, berVarbind(obj.oid, t, v)...)
+				oid = obj.oid
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unsupported PDU type %#x", pduType)
+	}
+
+	if errStatus != 0 {
+		varbinds = nil
+		for _, oid := range oids {
+			varbinds = append(varbinds, berVarbind(oid, berNull, nil)...)
+		}
+	}
+	resp := berTLV(berInteger, reqID)
+	resp = append(resp, berTLV(berInteger, berInt(int64(errStatus)))...)
+	resp = append(resp, berTLV(berInteger, berInt(int64(errIndex)))...)
+	resp = append(resp, berTLV(berSequence, varbinds)...)
+	out := berTLV(berInteger, berInt(version))
+	out = append(out, berTLV(berOctetString, community)...)
+	out = append(out, berTLV(pduResponse, resp)...)
+	return berTLV(berSequence, out), nil
+}
+
+// v2Only tells whether the value of o has a type SNMPv1 lacks.
+func (o *snmpObject) v2Only(s snapshot) bool {
+	tag, _ := o.value(s)
+	return tag == berCounter64
+}
+
+func (a *snmpAgent) find(oid []int) *snmpObject {
+	for i := range a.objects {
+		if compareOID(a.objects[i].oid, oid) == 0 {
+			return &a.objects[i]
+		}
+	}
+	return nil
+}
+
+func (a *snmpAgent) next(oid []int) *snmpObject {
+	for i := range a.objects {
+		if compareOID(a.objects[i].oid, oid) > 0 {
+			return &a.objects[i]
+		}
+	}
+	return nil
+}
+
+func parseOID(s string) []int {
+	var oid []int
+	for _, part := range strings.Split(strings.TrimPrefix(s, "."), ".") {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			panic("invalid OID " + s)
+		}
+		oid = append(oid, n)
+	}
+	return oid
+}
+
+func compareOID(a, b []int) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			if a[i] < b[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	return len(a) - len(b)
+}
+
+// berRead splits the first TLV off b.
+func berRead(b []byte) (tag byte, value, rest []byte, err error) {
+	if len(b) < 2 {
+		return 0, nil, nil, errors.New("truncated BER")
+	}
+	tag, l, off := b[0], int(b[1]), 2
+	if l&0x80 != 0 {
+		n := l & 0x7F
+		if n == 0 || n > 3 || len(b) < 2+n {
+			return 0, nil, nil, errors.New("invalid BER length")
+		}
+		l = 0
+		for _, c := range b[2 : 2+n] {
+			l = l<<8 | int(c)
+		}
+		off += n
+	}
+	if len(b) < off+l {
+		return 0, nil, nil, errors.New("truncated BER")
+	}
+	return tag, b[off : off+l], b[off+l:], nil
+}
+
+func berTLV(tag byte, value []byte) []byte {
+	out := []byte{tag}
+	switch l := len(value); {
+	case l < 0x80:
+		out = append(out, byte(l))
+	case l <= 0xFF:
+		out = append(out, 0x81, byte(l))
+	default:
+		out = append(out, 0x82, byte(l>>8), byte(l))
+	}
+	return append(out, value...)
+}
+
+func berVarbind(oid []int, tag byte, value []byte) []byte {
+	vb := berTLV(berOID, encodeOID(oid))
+	vb = append(vb, berTLV(tag, value)...)
+	return berTLV(berSequence, vb)
+}
+
+func berToInt(b []byte) int64 {
+	var v int64
+	for i, c := range b {
+		if i == 0 && c&0x80 != 0 {
+			v = -1
+		}
+		v = v<<8 | int64(c)
+	}
+	return v
+}
+
+func berInt(v int64) []byte {
+	b := []byte{byte(v)}
+	for v > 127 || v < -128 {
+		v >>= 8
+		b = append([]byte{byte(v)}, b...)
+	}
+	return b
+}
+
+// berUint encodes an unsigned value, adding a leading zero byte where the
+// most significant bit would otherwise mark it as negative.
+func berUint(v uint64) []byte {
+	b := []byte{byte(v)}
+	for v > 0xFF {
+		v >>= 8
+		b = append([]byte{byte(v)}, b...)
+	}
+	if b[0]&0x80 != 0 {
+		b = append([]byte{0}, b...)
+	}
+	return b
+}
+
+func encodeOID(oid []int) []byte {
+	if len(oid) < 2 {
+		return []byte{0}
+	}
+	out := []byte{byte(oid[0]*40 + oid[1])}
+	for _, n := range oid[2:] {
+		var enc []byte
+		enc = append(enc, byte(n&0x7F))
+		for n >>= 7; n > 0; n >>= 7 {
+			enc = append([]byte{byte(n&0x7F | 0x80)}, enc...)
+		}
+		out = append(out, enc...)
+	}
+	return out
+}
+
+func decodeOID(b []byte) []int {
+	if len(b) == 0 {
+		return nil
+	}
+	oid := []int{int(b[0]) / 40, int(b[0]) % 40}
+	n := 0
+	for _, c := range b[1:] {
+		n = n<<7 | int(c&0x7F)
+		if c&0x80 == 0 {
+			oid = append(oid, n)
+			n = 0
+		}
+	}
+	return oid
+}
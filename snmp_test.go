@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestBERIntegers(t *testing.T) {
+	ints := []struct {
+		v    int64
+		want []byte
+	}{
+		{0, []byte{0x00}},
+		{127, []byte{0x7F}},
+		{128, []byte{0x00, 0x80}},
+		{256, []byte{0x01, 0x00}},
+		{-1, []byte{0xFF}},
+		{-128, []byte{0x80}},
+		{-129, []byte{0xFF, 0x7F}},
+		{math.MaxInt32, []byte{0x7F, 0xFF, 0xFF, 0xFF}},
+	}
+	for _, tt := range ints {
+		if got := berInt(tt.v); !bytes.Equal(got, tt.want) {
+			t.Errorf("berInt(%d) = % x, want % x", tt.v, got, tt.want)
+		}
+		if got := berToInt(tt.want); got != tt.v {
+			t.Errorf("berToInt(% x) = %d, want %d", tt.want, got, tt.v)
+		}
+	}
+	uints := []struct {
+		v    uint64
+		want []byte
+	}{
+		{0, []byte{0x00}},
+		{0x7F, []byte{0x7F}},
+		{0xFF, []byte{0x00, 0xFF}},
+		{1 << 32, []byte{0x01, 0x00, 0x00, 0x00, 0x00}},
+		{math.MaxUint64, []byte{0x00, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}},
+	}
+	for _, tt := range uints {
+		if got := berUint(tt.v); !bytes.Equal(got, tt.want) {
+			t.Errorf("berUint(%d) = % x, want % x", tt.v, got, tt.want)
+		}
+	}
+}
+
+func TestBEROID(t *testing.T) {
+	tests := []struct {
+		oid  string
+		want []byte
+	}{
+		{"1.3.6.1.2.1.1.1.0", []byte{0x2B, 0x06, 0x01, 0x02, 0x01, 0x01, 0x01, 0x00}},
+		{snmpBaseOID, []byte{0x2B, 0x06, 0x01, 0x04, 0x01, 0xBF, 0x08, 0xCE, 0x0F, 0xCE, 0x0F, 0x01}},
+		{"1.3.128.16384", []byte{0x2B, 0x81, 0x00, 0x81, 0x80, 0x00}},
+	}
+	for _, tt := range tests {
+		oid := parseOID(tt.oid)
+		got := encodeOID(oid)
+		if !bytes.Equal(got, tt.want) {
+			t.Errorf("encodeOID(%s) = % x, want % x", tt.oid, got, tt.want)
+		}
+		if back := decodeOID(got); !reflect.DeepEqual(back, oid) {
+			t.Errorf("decodeOID(% x) = %v, want %v", got, back, oid)
+		}
+	}
+}
+
+func TestBERLength(t *testing.T) {
+	for _, n := range []int{0, 1, 127, 128, 255, 256, 1000} {
+		value := bytes.Repeat([]byte{0xAB}, n)
+		enc := append(berTLV(berOctetString, value), 0x05, 0x00)
+		tag, got, rest, err := berRead(enc)
+		if err != nil || tag != berOctetString || !bytes.Equal(got, value) || !bytes.Equal(rest, []byte{0x05, 0x00}) {
+			t.Errorf("berRead(berTLV(%d bytes)) = %#x, %d bytes, % x, %v", n, tag, len(got), rest, err)
+		}
+	}
+	for _, b := range [][]byte{
+		{},
+		{0x04},
+		{0x04, 0x02, 0x01},
+		{0x04, 0x80},
+		{0x04, 0x84, 0x00, 0x00, 0x00, 0x01, 0x00},
+		{0x04, 0x81},
+		{0x04, 0x82, 0x01, 0x00, 0x00},
+	} {
+		if _, _, _, err := berRead(b); err == nil {
+			t.Errorf("berRead(% x) succeeded", b)
+		}
+	}
+}
+
+// snmpRequest encodes a request for the oids with NULL values.
+func snmpRequest(version int64, community string, pdu byte, p1, p2 int64, oids ...string) []byte {
+	var vbs []byte
+	for _, oid := range oids {
+		vbs = append(vbs, berVarbind(parseOID(oid), berNull, nil)...)
+	}
+	body := berTLV(berInteger, berInt(4711))
+	body = append(body, berTLV(berInteger, berInt(p1))...)
+	body = append(body, berTLV(berInteger, berInt(p2))...)
+	body = append(body, berTLV(berSequence, vbs)...)
+	msg := berTLV(berInteger, berInt(version))
+	msg = append(msg, berTLV(berOctetString, []byte(community))...)
+	msg = append(msg, berTLV(pdu, body)...)
+	return berTLV(berSequence, msg)
+}
+
+// snmpResponse decodes a response into its error status and index and the
+// varbinds as "oid=tag:value".
+func snmpResponse(b []byte) (errStatus, errIndex int64, varbinds []string, err error) {
+	fields := func(b []byte, n int) (tags []byte, values [][]byte, err error) {
+		for i := 0; i < n; i++ {
+			var tag byte
+			var v []byte
+			if tag, v, b, err = berRead(b); err != nil {
+				return nil, nil, err
+			}
+			tags, values = append(tags, tag), append(values, v)
+		}
+		return tags, values, nil
+	}
+	_, msg, _, err := berRead(b)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	tags, m, err := fields(msg, 3)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	if tags[2] != pduResponse {
+		return 0, 0, nil, fmt.Errorf("PDU type %#x", tags[2])
+	}
+	_, p, err := fields(m[2], 4)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	if berToInt(p[0]) != 4711 {
+		return 0, 0, nil, fmt.Errorf("request ID %d", berToInt(p[0]))
+	}
+	for vbs := p[3]; len(vbs) > 0; {
+		var vb []byte
+		if _, vb, vbs, err = berRead(vbs); err != nil {
+			return 0, 0, nil, err
+		}
+		_, oid, rest, err := berRead(vb)
+		if err != nil {
+			return 0, 0, nil, err
+		}
+		tag, value, _, err := berRead(rest)
+		if err != nil {
+			return 0, 0, nil, err
+		}
+		s := fmt.Sprint(decodeOID(oid))
+		varbinds = append(varbinds, fmt.Sprintf("%s=%#x:%x", s[1:len(s)-1], tag, value))
+	}
+	return berToInt(p[1]), berToInt(p[2]), varbinds, nil
+}
+
+func TestSNMPAgent(t *testing.T) {
+	state := &liveState{}
+	state.update(func(s *snapshot) {
+		s.CPM = 200
+		s.DoseRate = 1.3
+		s.CumulativeDose = 5
+		s.Version = "GMC"
+	})
+	a := newSNMPAgent("public", state)
+	obj := func(n int) string { return fmt.Sprintf("%s.%d.0", snmpBaseOID, n) }
+	vb := func(n int, tag byte, value string) string {
+		return fmt.Sprintf("1 3 6 1 4 1 8072 9999 9999 1 %d 0=%#x:%s", n, tag, value)
+	}
+	unknown := snmpBaseOID + ".99.0"
+	tests := []struct {
+		name     string
+		req      []byte
+		dropped  bool
+		wantErr  bool
+		status   int64
+		index    int64
+		varbinds []string
+	}{
+		{name: "get", req: snmpRequest(1, "public", pduGet, 0, 0, obj(1), obj(2)), varbinds: []string{vb(1, berGauge32, "00c8"), vb(2, berGauge32, "0514")}},
+		{name: "get v1", req: snmpRequest(0, "public", pduGet, 0, 0, obj(1), obj(4)), varbinds: []string{vb(1, berGauge32, "00c8"), vb(4, berOctetString, "474d43")}},
+		{name: "get Counter64", req: snmpRequest(1, "public", pduGet, 0, 0, obj(3)), varbinds: []string{vb(3, berCounter64, "1388")}},
+		{name: "get unknown", req: snmpRequest(1, "public", pduGet, 0, 0, obj(1), unknown), varbinds: []string{vb(1, berGauge32, "00c8"), "1 3 6 1 4 1 8072 9999 9999 1 99 0=0x80:"}},
+		// SNMPv1 echoes the request with NULL values
+		{name: "get unknown v1", req: snmpRequest(0, "public", pduGet, 0, 0, obj(1), unknown), status: snmpErrNoSuchName, index: 2, varbinds: []string{vb(1, berNull, ""), "1 3 6 1 4 1 8072 9999 9999 1 99 0=0x5:"}},
+		{name: "get Counter64 v1", req: snmpRequest(0, "public", pduGet, 0, 0, obj(3), obj(1)), status: snmpErrNoSuchName, index: 1, varbinds: []string{vb(3, berNull, ""), vb(1, berNull, "")}},
+		{name: "getnext", req: snmpRequest(1, "public", pduGetNext, 0, 0, snmpBaseOID, obj(2)), varbinds: []string{vb(1, berGauge32, "00c8"), vb(3, berCounter64, "1388")}},
+		{name: "getnext v1 skips Counter64", req: snmpRequest(0, "public", pduGetNext, 0, 0, obj(2)), varbinds: []string{vb(4, berOctetString, "474d43")}},
+		{name: "getnext at the end", req: snmpRequest(1, "public", pduGetNext, 0, 0, obj(7)), varbinds: []string{vb(7, snmpEndOfMib, "")}},
+		{name: "getnext at the end v1", req: snmpRequest(0, "public", pduGetNext, 0, 0, obj(1), obj(7)), status: snmpErrNoSuchName, index: 2, varbinds: []string{vb(1, berNull, ""), vb(7, berNull, "")}},
+		{name: "getbulk", req: snmpRequest(1, "public", pduGetBulk, 1, 2, obj(1), obj(5)), varbinds: []string{vb(2, berGauge32, "0514"), vb(6, berInteger, "00"), vb(7, berGauge32, "00")}},
+		{name: "getbulk past the end", req: snmpRequest(1, "public", pduGetBulk, 0, 3, obj(6)), varbinds: []string{vb(7, berGauge32, "00"), vb(7, snmpEndOfMib, "")}},
+		{name: "getbulk v1", req: snmpRequest(0, "public", pduGetBulk, 0, 2, obj(1)), wantErr: true},
+		{name: "wrong community", req: snmpRequest(1, "private", pduGet, 0, 0, obj(1)), dropped: true},
+		{name: "SNMPv3", req: snmpRequest(3, "public", pduGet, 0, 0, obj(1)), wantErr: true},
+		{name: "set", req: snmpRequest(1, "public", 0xA3, 0, 0, obj(1)), wantErr: true},
+		{name: "truncated", req: snmpRequest(1, "public", pduGet, 0, 0, obj(1))[:20], wantErr: true},
+	}
+	for _, tt := range tests {
+		resp, err := a.handle(tt.req)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: handle() = %v, want error %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if tt.wantErr {
+			continue
+		}
+		if tt.dropped != (resp == nil) {
+			t.Errorf("%s: handle() = % x, want dropped %v", tt.name, resp, tt.dropped)
+			continue
+		}
+		if tt.dropped {
+			continue
+		}
+		status, index, varbinds, err := snmpResponse(resp)
+		if err != nil {
+			t.Errorf("%s: malformed response: %v", tt.name, err)
+			continue
+		}
+		if status != tt.status || index != tt.index || !reflect.DeepEqual(varbinds, tt.varbinds) {
+			t.Errorf("%s: response = %d, %d, %q, want %d, %d, %q", tt.name, status, index, varbinds, tt.status, tt.index, tt.varbinds)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// sqlitePruneInterval is how often sqliteStore deletes the readings past the
+// retention.
+const sqlitePruneInterval = time.Hour
+
+// sqliteSchema creates the table of the readings. time is in Unix
+// milliseconds, tags holds a JSON object.
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS readings (
+	time      INTEGER NOT NULL,
+	cpm       INTEGER NOT NULL,
+	dose_rate REAL    NOT NULL,
+	tags      TEXT    NOT NULL DEFAULT '{}'
+);
+CREATE INDEX IF NOT EXISTS readings_time ON readings (time);
+`
+
+// sqliteStore writes the reading of each interval into a local SQLite
+// database, for installations without InfluxDB. Readings older than
+// retention are pruned, zero keeps them forever.
+type sqliteStore struct {
+	db        *sql.DB
+	retention time.Duration
+	pruned    time.Time
+}
+
+func newSQLiteStore(path string, retention time.Duration) (*sqliteStore, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	// a single connection serializes the writers, SQLite locks the file anyway
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &sqliteStore{db: db, retention: retention}, nil
+}
+
+func (s *sqliteStore) write(r reading) error {
+	b, err := json.Marshal(r.Tags)
+	if err != nil {
+		return err
+	}
+	if _, err := s.db.Exec(`INSERT INTO readings (time, cpm, dose_rate, tags) VALUES (?, ?, ?, ?)`, r.Time.UnixMilli(), r.CPM, r.DoseRate, string(b)); err != nil {
+		return err
+	}
+	if s.retention > 0 && time.Since(s.pruned) >= sqlitePruneInterval {
+		if _, err := s.db.Exec(`DELETE FROM readings WHERE time < ?`, r.Time.Add(-s.retention).UnixMilli()); err != nil {
+			return err
+		}
+		s.pruned = time.Now()
+	}
+	return nil
+}
+
+func (s *sqliteStore) Close() error {
+	return s.db.Close()
+}
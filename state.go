@@ -0,0 +1,129 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/mwuertinger/gq-gmc/pkg/gqgmc"
+)
+
+// snapshot is the latest state of the collector as exposed to the query
+// interfaces.
+type snapshot struct {
+	Time           time.Time // end of the last interval
+	CPM            int
+	DoseRate       float64   // µSv/h
+	CumulativeDose float64   // µSv since DoseSince
+	DoseSince      time.Time // startup, or the first run with the state file
+	CPMRise        float64   // rise of the smoothed count rate in %/min
+	CPMSigma       float64   // standard deviations the last window lies above the baseline, see anomalyTracker
+	Version        string
+	Power          *gqgmc.PowerStatus
+	Settings       *deviceSettings // of the device configuration, nil if unreadable
+}
+
+// liveState guards the snapshot shared between the main loop and the
+// servers answering queries.
+type liveState struct {
+	mu     sync.Mutex
+	snap   snapshot
+	subs   map[chan snapshot]struct{}
+	closed bool
+}
+
+func (s *liveState) get() snapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.snap
+}
+
+func (s *liveState) update(f func(snap *snapshot)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f(&s.snap)
+	for ch := range s.subs {
+		// Subscribers only care about the latest state, so a slow one
+		// misses intermediate updates rather than blocking the main loop.
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- s.snap
+	}
+}
+
+// subscribe returns a channel receiving the snapshot after every update and
+// a function to cancel the subscription. The channel is closed, after the
+// last snapshot, when the state is closed.
+func (s *liveState) subscribe() (<-chan snapshot, func()) {
+	ch := make(chan snapshot, 1)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		close(ch)
+		return ch, func() {}
+	}
+	if s.subs == nil {
+		s.subs = map[chan snapshot]struct{}{}
+	}
+	s.subs[ch] = struct{}{}
+	return ch, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		delete(s.subs, ch)
+	}
+}
+
+// close ends the subscriptions when the collector stops.
+func (s *liveState) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for ch := range s.subs {
+		close(ch)
+	}
+	s.subs = nil
+	s.closed = true
+}
+
+// metrics returns the numeric values of the snapshot by name.
+func (s snapshot) metrics() map[string]float64 {
+	m := map[string]float64{
+		"cpm":             float64(s.CPM),
+		"dose_rate":       s.DoseRate,
+		"cumulative_dose": s.CumulativeDose,
+		"cpm_rise":        s.CPMRise,
+		"cpm_sigma":       s.CPMSigma,
+	}
+	if s.Power != nil {
+		m["battery_voltage"] = s.Power.Voltage
+		m["external_power"] = 0
+		if s.Power.External {
+			m["external_power"] = 1
+		}
+	}
+	if !s.Time.IsZero() {
+		m["age_seconds"] = time.Since(s.Time).Seconds()
+	}
+	return m
+}
+
+// collectorMetrics are computed by the main loop from its own state rather
+// than from the snapshot. They are evaluated by the alert engine every few
+// seconds.
+var collectorMetrics = []string{"sample_age_seconds", "sink_failure_seconds"}
+
+// housekeepingMetrics are evaluated after every housekeeping run. See
+// telemetryMetrics for how they are derived.
+var housekeepingMetrics = []string{"battery_voltage", "temperature", "clock_drift_seconds"}
+
+// knownMetric reports whether name is one of the values returned by
+// metrics, a collector or a housekeeping metric.
+func knownMetric(name string) bool {
+	for _, m := range append(collectorMetrics, housekeepingMetrics...) {
+		if m == name {
+			return true
+		}
+	}
+	_, ok := snapshot{Time: time.Now(), Power: &gqgmc.PowerStatus{}}.metrics()[name]
+	return ok
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// tcpWriteTimeout bounds a write to a serial bridge whose connection hangs.
+const tcpWriteTimeout = 5 * time.Second
+
+// isBridge reports whether the device is a TCP serial bridge rather than a
+// local serial port.
+func isBridge(device string) bool {
+	return strings.HasPrefix(device, "tcp://") || strings.HasPrefix(device, "rfc2217://")
+}
+
+// openBridge connects to a serial bridge like ser2net or an ESP module. At
+// tcp://host:port the bridge passes the bytes through unchanged and the
+// baud is configured there; at rfc2217://host:port it speaks Telnet with the
+// COM port option of RFC 2217, which also sets the baud.
+func openBridge(device string, baud int) (io.ReadWriteCloser, error) {
+	scheme, addr, _ := strings.Cut(device, "://")
+	conn, err := net.DialTimeout("tcp", addr, tcpWriteTimeout)
+	if err != nil {
+		return nil, err
+	}
+	port := &tcpPort{Conn: conn}
+	if scheme == "tcp" {
+		return port, nil
+	}
+	t := &telnetPort{tcpPort: port}
+	if err := t.setBaud(baud); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return t, nil
+}
+
+// tcpPort behaves like the serial port: a read returns no data and no error
+// once portPollInterval has passed without any.
+type tcpPort struct {
+	net.Conn
+}
+
+func (t *tcpPort) Read(p []byte) (int, error) {
+	t.SetReadDeadline(time.Now().Add(portPollInterval))
+	n, err := t.Conn.Read(p)
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		return n, nil
+	}
+	return n, err
+}
+
+func (t *tcpPort) Write(p []byte) (int, error) {
+	t.SetWriteDeadline(time.Now().Add(tcpWriteTimeout))
+	return t.Conn.Write(p)
+}
+
+// Telnet commands and the RFC 2217 COM port option.
+const (
+	telnetSE   = 240
+	telnetSB   = 250
+	telnetWILL = 251
+	telnetWONT = 252
+	telnetDO   = 253
+	telnetDONT = 254
+	telnetIAC  = 255
+
+	comPortOption   = 44
+	comSetBaud      = 1
+	comSetDataSize  = 2
+	comSetParity    = 3
+	comSetStopSize  = 4
+	comParityNone   = 1
+	comStopSizeOne  = 1
+	comDataSizeByte = 8
+)
+
+// Telnet parser states of telnetPort.
+const (
+	telnetData = iota
+	telnetCommand
+	telnetOption // after WILL, WONT, DO or DONT
+	telnetSub
+	telnetSubIAC
+)
+
+// telnetPort strips the Telnet commands from the data the bridge sends and
+// escapes the 0xFF bytes written to the counter. Options the bridge offers
+// are refused, except for the COM port option.
+type telnetPort struct {
+	*tcpPort
+	state int
+	verb  byte
+}
+
+// setBaud configures the serial line of the bridge as <baud> 8N1.
+func (t *telnetPort) setBaud(baud int) error {
+	var b bytes.Buffer
+	b.Write([]byte{telnetIAC, telnetWILL, comPortOption})
+	sub := func(cmd byte, value []byte) {
+		b.Write([]byte{telnetIAC, telnetSB, comPortOption, cmd})
+		b.Write(bytes.ReplaceAll(value, []byte{telnetIAC}, []byte{telnetIAC, telnetIAC}))
+		b.Write([]byte{telnetIAC, telnetSE})
+	}
+	sub(comSetBaud, binary.BigEndian.AppendUint32(nil, uint32(baud)))
+	sub(comSetDataSize, []byte{comDataSizeByte})
+	sub(comSetParity, []byte{comParityNone})
+	sub(comSetStopSize, []byte{comStopSizeOne})
+	_, err := t.tcpPort.Write(b.Bytes())
+	return err
+}
+
+func (t *telnetPort) Read(p []byte) (int, error) {
+	n, err := t.tcpPort.Read(p)
+	// the data is moved down in place, it never grows
+	out := p[:0]
+	var reply []byte
+	for _, c := range p[:n] {
+		switch t.state {
+		case telnetData:
+			if c == telnetIAC {
+				t.state = telnetCommand
+			} else {
+				out = append(out, c)
+			}
+		case telnetCommand:
+			switch c {
+			case telnetIAC:
+				out = append(out, c)
+				t.state = telnetData
+			case telnetWILL, telnetWONT, telnetDO, telnetDONT:
+				t.verb = c
+				t.state = telnetOption
+			case telnetSB:
+				t.state = telnetSub
+			default:
+				t.state = telnetData
+			}
+		case telnetOption:
+			if c != comPortOption {
+				switch t.verb {
+				case telnetDO:
+					reply = append(reply, telnetIAC, telnetWONT, c)
+				case telnetWILL:
+					reply = append(reply, telnetIAC, telnetDONT, c)
+				}
+			}
+			t.state = telnetData
+		case telnetSub:
+			// the replies to the COM port settings are of no interest
+			if c == telnetIAC {
+				t.state = telnetSubIAC
+			}
+		case telnetSubIAC:
+			t.state = telnetSub
+			if c == telnetSE {
+				t.state = telnetData
+			}
+		}
+	}
+	if len(reply) > 0 {
+		if _, werr := t.tcpPort.Write(reply); werr != nil && err == nil {
+			err = werr
+		}
+	}
+	return len(out), err
+}
+
+func (t *telnetPort) Write(p []byte) (int, error) {
+	if _, err := t.tcpPort.Write(bytes.ReplaceAll(p, []byte{telnetIAC}, []byte{telnetIAC, telnetIAC})); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// traceBacklog is the number of transfers kept for "dump".
+const traceBacklog = 256
+
+type traceRecord struct {
+	time  time.Time
+	write bool
+	data  []byte
+}
+
+// traceReadWriter records the communication with the device. The most recent
+// transfers are always kept in memory, logging them can be toggled at
+// runtime. The log lines can be played back with -replay.
+type traceReadWriter struct {
+	rw      io.ReadWriter
+	logger  *slog.Logger
+	logging atomic.Bool
+
+	mu      sync.Mutex
+	records []traceRecord // ring buffer, next is the oldest entry once full
+	next    int
+}
+
+func newTraceReadWriter(rw io.ReadWriter, logging bool, logger *slog.Logger) *traceReadWriter {
+	t := &traceReadWriter{rw: rw, logger: logger}
+	t.logging.Store(logging)
+	return t
+}
+
+func (t *traceReadWriter) Read(p []byte) (n int, err error) {
+	n, err = t.rw.Read(p)
+	t.record(false, p[:n])
+	return
+}
+
+func (t *traceReadWriter) Write(p []byte) (n int, err error) {
+	n, err = t.rw.Write(p)
+	t.record(true, p[:n])
+	return
+}
+
+func (t *traceReadWriter) record(write bool, data []byte) {
+	if len(data) == 0 {
+		return
+	}
+	if t.logging.Load() {
+		if write {
+			t.logger.Info("trace: wrote", "bytes", len(data), "data", fmt.Sprintf("%x", data))
+		} else {
+			t.logger.Info("trace: read", "bytes", len(data), "data", fmt.Sprintf("%x", data))
+		}
+	}
+	r := traceRecord{time: time.Now(), write: write, data: append([]byte(nil), data...)}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.records) < traceBacklog {
+		t.records = append(t.records, r)
+		return
+	}
+	t.records[t.next] = r
+	t.next = (t.next + 1) % traceBacklog
+}
+
+// dump formats the last n transfers, oldest first. Writes are marked with
+// ">", reads with "<".
+func (t *traceReadWriter) dump(n int) string {
+	t.mu.Lock()
+	records := append(append([]traceRecord(nil), t.records[t.next:]...), t.records[:t.next]...)
+	t.mu.Unlock()
+	if n < len(records) {
+		records = records[len(records)-n:]
+	}
+	var b strings.Builder
+	for _, r := range records {
+		dir := "<"
+		if r.write {
+			dir = ">"
+		}
+		fmt.Fprintf(&b, "%s %s % x\n", r.time.Format("15:04:05.000"), dir, r.data)
+	}
+	return b.String()
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	influxdb "github.com/influxdata/influxdb1-client/v2"
+)
+
+// trackPoint is a reading with the position of -gpsd.
+type trackPoint struct {
+	Time      time.Time
+	Latitude  float64
+	Longitude float64
+	Altitude  *float64
+	CPM       float64
+	DoseRate  float64
+}
+
+// queryTrack reads the readings with a position from start to end. The
+// position may have been stored as fields or, with -gpsTags, as tags.
+func queryTrack(client influxdb.Client, database, measurement string, tags map[string]string, start, end time.Time) ([]trackPoint, error) {
+	q := fmt.Sprintf(`SELECT "latitude", "longitude", "altitude", "geiger_counter_cpm", "geiger_counter_dose_rate" FROM %s WHERE time >= %ds AND time < %ds`,
+		quoteIdent(measurement), start.Unix(), end.Unix())
+	for k, v := range tags {
+		q += fmt.Sprintf(` AND %s = '%s'`, quoteIdent(k), strings.ReplaceAll(v, `'`, `\'`))
+	}
+	resp, err := client.Query(influxdb.NewQuery(q, database, "ms"))
+	if err != nil {
+		return nil, err
+	}
+	if err := resp.Error(); err != nil {
+		return nil, err
+	}
+	var points []trackPoint
+	for _, res := range resp.Results {
+		for _, row := range res.Series {
+			for _, v := range row.Values {
+				if len(v) != 6 || v[1] == nil || v[2] == nil || v[4] == nil {
+					continue // reading without a fix
+				}
+				p := trackPoint{
+					Time:      time.UnixMilli(int64(influxFloat(v[0]))),
+					Latitude:  influxFloat(v[1]),
+					Longitude: influxFloat(v[2]),
+					CPM:       influxFloat(v[4]),
+					DoseRate:  influxFloat(v[5]),
+				}
+				if v[3] != nil {
+					alt := influxFloat(v[3])
+					p.Altitude = &alt
+				}
+				points = append(points, p)
+			}
+		}
+	}
+	return points, nil
+}
+
+// writeGPX writes the points as a GPX 1.1 track, carrying the readings in
+// gqgmc:cpm and gqgmc:dose_rate extensions of each point.
+func writeGPX(w io.Writer, name string, points []trackPoint) error {
+	b := bufio.NewWriter(w)
+	fmt.Fprintf(b, `<?xml version="1.0" encoding="UTF-8"?>
+<gpx version="1.1" creator="gq-gmc" xmlns="http://www.topografix.com/GPX/1/1" xmlns:gqgmc="https://github.com/mwuertinger/gq-gmc">
+  <trk>
+    <name>%s</name>
+    <trkseg>
+`, name)
+	for _, p := range points {
+		fmt.Fprintf(b, "      <trkpt lat=\"%.7f\" lon=\"%.7f\">\n", p.Latitude, p.Longitude)
+		if p.Altitude != nil {
+			fmt.Fprintf(b, "        <ele>%.1f</ele>\n", *p.Altitude)
+		}
+		fmt.Fprintf(b, "        <time>%s</time>\n", p.Time.UTC().Format(time.RFC3339))
+		fmt.Fprintf(b, "        <extensions><gqgmc:cpm>%g</gqgmc:cpm><gqgmc:dose_rate>%g</gqgmc:dose_rate></extensions>\n", p.CPM, p.DoseRate)
+		b.WriteString("      </trkpt>\n")
+	}
+	b.WriteString("    </trkseg>\n  </trk>\n</gpx>\n")
+	return b.Flush()
+}
+
+// writeKML writes the points as a gx:Track, whose ExtendedData arrays carry
+// the readings so Google Earth shows them in the elevation profile.
+func writeKML(w io.Writer, name string, points []trackPoint) error {
+	b := bufio.NewWriter(w)
+	fmt.Fprintf(b, `<?xml version="1.0" encoding="UTF-8"?>
+<kml xmlns="http://www.opengis.net/kml/2.2" xmlns:gx="http://www.google.com/kml/ext/2.2">
+  <Document>
+    <name>%s</name>
+    <Schema id="reading">
+      <gx:SimpleArrayField name="cpm" type="float"><displayName>CPM</displayName></gx:SimpleArrayField>
+      <gx:SimpleArrayField name="dose_rate" type="float"><displayName>Dose rate (µSv/h)</displayName></gx:SimpleArrayField>
+    </Schema>
+    <Placemark>
+      <name>%[1]s</name>
+      <gx:Track>
+        <altitudeMode>clampToGround</altitudeMode>
+`, name)
+	for _, p := range points {
+		fmt.Fprintf(b, "        <when>%s</when>\n", p.Time.UTC().Format(time.RFC3339))
+	}
+	for _, p := range points {
+		var alt float64
+		if p.Altitude != nil {
+			alt = *p.Altitude
+		}
+		fmt.Fprintf(b, "        <gx:coord>%.7f %.7f %.1f</gx:coord>\n", p.Longitude, p.Latitude, alt)
+	}
+	b.WriteString("        <ExtendedData>\n          <SchemaData schemaUrl=\"#reading\">\n")
+	for _, f := range []struct {
+		name  string
+		value func(trackPoint) float64
+	}{
+		{"cpm", func(p trackPoint) float64 { return p.CPM }},
+		{"dose_rate", func(p trackPoint) float64 { return p.DoseRate }},
+	} {
+		fmt.Fprintf(b, "            <gx:SimpleArrayData name=\"%s\">\n", f.name)
+		for _, p := range points {
+			fmt.Fprintf(b, "              <gx:value>%g</gx:value>\n", f.value(p))
+		}
+		b.WriteString("            </gx:SimpleArrayData>\n")
+	}
+	b.WriteString("          </SchemaData>\n        </ExtendedData>\n      </gx:Track>\n    </Placemark>\n  </Document>\n</kml>\n")
+	return b.Flush()
+}
+
+// parseTrackTime parses -start and -end, given as RFC 3339 or as a date.
+func parseTrackTime(s string) (time.Time, error) {
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// trackCommand implements "gq-gmc track", which exports the readings of a
+// survey recorded with -gpsd as a GPX or KML track.
+func trackCommand(args []string) {
+	fs := flag.NewFlagSet("track", flag.ExitOnError)
+	influx := influxFlags(fs)
+	tagValues := tagFlags{}
+	fs.Var(tagValues, "tag", "Only export the readings with the tag key=value, may be repeated")
+	out := fs.String("out", "", "Output file, default stdout")
+	format := fs.String("format", "", "gpx or kml, default by the extension of -out or gpx")
+	startFlag := fs.String("start", "", "Start of the track as YYYY-MM-DD or RFC 3339, default 24 hours ago")
+	endFlag := fs.String("end", "", "End of the track as YYYY-MM-DD or RFC 3339, default now")
+	parseFlags(fs, args)
+
+	end := time.Now()
+	start := end.Add(-24 * time.Hour)
+	var err error
+	if *endFlag != "" {
+		if end, err = parseTrackTime(*endFlag); err != nil {
+			log.Fatalf("track: -end: %v", err)
+		}
+	}
+	if *startFlag != "" {
+		if start, err = parseTrackTime(*startFlag); err != nil {
+			log.Fatalf("track: -start: %v", err)
+		}
+	}
+	if *format == "" {
+		*format = "gpx"
+		if strings.EqualFold(filepath.Ext(*out), ".kml") {
+			*format = "kml"
+		}
+	}
+	write := writeGPX
+	switch *format {
+	case "gpx":
+	case "kml":
+		write = writeKML
+	default:
+		log.Fatalf("track: unknown format %q", *format)
+	}
+
+	client, err := influx.client()
+	if err != nil {
+		log.Fatalf("influx: %v", err)
+	}
+	points, err := queryTrack(client, influx.database, influx.measurement, tagValues, start, end)
+	if err != nil {
+		log.Fatalf("track: %v", err)
+	}
+	if len(points) == 0 {
+		log.Fatal("track: no readings with a position, record them with -gpsd")
+	}
+	name := fmt.Sprintf("gq-gmc survey %s", start.Format("2006-01-02 15:04"))
+	if *out == "" {
+		if err := write(os.Stdout, name, points); err != nil {
+			log.Fatalf("track: %v", err)
+		}
+		return
+	}
+	f, err := os.Create(*out)
+	if err != nil {
+		log.Fatalf("track: %v", err)
+	}
+	if err := write(f, name, points); err != nil {
+		log.Fatalf("track: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		log.Fatalf("track: %v", err)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mwuertinger/gq-gmc/pkg/gqgmc"
+)
+
+// doseUnit is a unit the dose rate is also written in, converted from µSv/h.
+// The conversions assume gamma radiation, for which 1 Sv is 1 Gy and 1 R
+// is about 10 mSv.
+type doseUnit struct {
+	field   string
+	perUSvH float64
+}
+
+var doseUnits = map[string]doseUnit{
+	"usvh": {"geiger_counter_dose_rate", 1},
+	"mrh":  {"geiger_counter_dose_rate_mr_h", 0.1},
+	"ngyh": {"geiger_counter_dose_rate_ngy_h", 1000},
+}
+
+// parseDoseUnits parses a comma-separated list of units like "mrh,ngyh".
+func parseDoseUnits(s string) ([]doseUnit, error) {
+	var units []doseUnit
+	for _, name := range strings.Split(s, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		u, ok := doseUnits[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown unit %q, known are usvh, mrh and ngyh", name)
+		}
+		units = append(units, u)
+	}
+	return units, nil
+}
+
+// addDoseRateFields adds the dose rate in the units to the fields.
+func addDoseRateFields(fields map[string]interface{}, doseRate float64, units []doseUnit) {
+	for _, u := range units {
+		fields[u.field] = doseRate * u.perUSvH
+	}
+}
+
+// addDualTubeFields adds the counts of both tubes of a dual tube model and,
+// if the tube for high dose rates is known, the dose rate it measures.
+func addDualTubeFields(fields map[string]interface{}, low, high int, highTube gqgmc.Tube) {
+	fields["geiger_counter_cpm_low"] = low
+	fields["geiger_counter_cpm_high"] = high
+	if highTube.Name != "" {
+		fields["geiger_counter_dose_rate_high"] = highTube.DoseRate(float64(high))
+	}
+}
+
+// parseTube returns the tube of the given name for -tube.
+func parseTube(name string) (gqgmc.Tube, error) {
+	t, ok := gqgmc.LookupTube(name)
+	if !ok {
+		names := make([]string, len(gqgmc.Tubes))
+		for i, t := range gqgmc.Tubes {
+			names[i] = t.Name
+		}
+		return gqgmc.Tube{}, fmt.Errorf("unknown tube %q, known are %s", name, strings.Join(names, ", "))
+	}
+	return t, nil
+}
+
+// parseCalibrationCurve parses a curve given as comma-separated CPM:µSv/h
+// points like "60:0.39,240:1.56,1000:6.8". The points are sorted by CPM.
+func parseCalibrationCurve(s string) ([]gqgmc.CalibrationPoint, error) {
+	var curve []gqgmc.CalibrationPoint
+	for _, p := range strings.Split(s, ",") {
+		cpmStr, usvhStr, ok := strings.Cut(strings.TrimSpace(p), ":")
+		if !ok {
+			return nil, fmt.Errorf("point %q is not CPM:µSv/h", p)
+		}
+		cpm, err := strconv.Atoi(cpmStr)
+		if err != nil || cpm <= 0 {
+			return nil, fmt.Errorf("point %q: CPM must be a positive integer", p)
+		}
+		usvh, err := strconv.ParseFloat(usvhStr, 64)
+		if err != nil || usvh < 0 {
+			return nil, fmt.Errorf("point %q: µSv/h must be a non-negative number", p)
+		}
+		curve = append(curve, gqgmc.CalibrationPoint{CPM: cpm, USvH: usvh})
+	}
+	sort.Slice(curve, func(i, j int) bool { return curve[i].CPM < curve[j].CPM })
+	for i := 1; i < len(curve); i++ {
+		if curve[i].CPM == curve[i-1].CPM {
+			return nil, fmt.Errorf("CPM %d is given twice", curve[i].CPM)
+		}
+	}
+	return curve, nil
+}
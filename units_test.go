@@ -0,0 +1,52 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/mwuertinger/gq-gmc/pkg/gqgmc"
+)
+
+func TestParseCalibrationCurve(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    []gqgmc.CalibrationPoint
+		wantErr bool
+	}{
+		{"60:0.39,240:1.56,1000:6.8", []gqgmc.CalibrationPoint{{CPM: 60, USvH: 0.39}, {CPM: 240, USvH: 1.56}, {CPM: 1000, USvH: 6.8}}, false},
+		{"1000:6.8, 60:0.39 ,240:1.56", []gqgmc.CalibrationPoint{{CPM: 60, USvH: 0.39}, {CPM: 240, USvH: 1.56}, {CPM: 1000, USvH: 6.8}}, false},
+		{"100:0", []gqgmc.CalibrationPoint{{CPM: 100, USvH: 0}}, false},
+		{"", nil, true},
+		{"60", nil, true},
+		{"60:", nil, true},
+		{"0:1", nil, true},
+		{"-60:1", nil, true},
+		{"6.5:1", nil, true},
+		{"60:-1", nil, true},
+		{"60:high", nil, true},
+		{"60:0.39,60:0.4", nil, true},
+		{"60:0.39,", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := parseCalibrationCurve(tt.s)
+		if (err != nil) != tt.wantErr || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseCalibrationCurve(%q) = %v, %v, want %v, error %v", tt.s, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestDoseRateUnits(t *testing.T) {
+	units, err := parseDoseUnits("mrh, ngyh,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields := map[string]interface{}{}
+	addDoseRateFields(fields, 0.25, units)
+	want := map[string]interface{}{"geiger_counter_dose_rate_mr_h": 0.025, "geiger_counter_dose_rate_ngy_h": 250.0}
+	if !reflect.DeepEqual(fields, want) {
+		t.Errorf("addDoseRateFields() = %v, want %v", fields, want)
+	}
+	if _, err := parseDoseUnits("mrh,rem"); err == nil {
+		t.Error("parseDoseUnits() accepted an unknown unit")
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var uploadClient = &http.Client{Timeout: 30 * time.Second}
+
+// upload is what an uploader submits: the last reading and the mean count
+// rate of the readings since the previous submission.
+type upload struct {
+	snap    snapshot
+	meanCPM float64
+}
+
+// uploader submits readings to a public radiation map.
+type uploader interface {
+	upload(u upload) error
+}
+
+// uploadOutput submits to u every interval if there were new readings since
+// the previous submission. Interval is the rate limit of the service, so
+// failed submissions are not retried early. The station is the first
+// counter, the readings of the further counters are not submitted.
+type uploadOutput struct {
+	name string
+	u    uploader
+	tick *time.Ticker
+	done chan struct{}
+
+	mu   sync.Mutex
+	snap snapshot // of the last reading
+	sum  float64
+	n    int
+}
+
+func newUploadOutput(name string, u uploader, interval time.Duration) *uploadOutput {
+	o := &uploadOutput{name: name, u: u, tick: time.NewTicker(interval), done: make(chan struct{})}
+	go o.run()
+	return o
+}
+
+func (o *uploadOutput) write(r reading) error {
+	if r.Counter != "" {
+		return nil
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.snap = r.State
+	o.sum += float64(r.CPM)
+	o.n++
+	return nil
+}
+
+func (o *uploadOutput) run() {
+	for {
+		select {
+		case <-o.done:
+			return
+		case <-o.tick.C:
+			o.mu.Lock()
+			n, up := o.n, upload{snap: o.snap, meanCPM: o.sum / float64(max(o.n, 1))}
+			o.sum, o.n = 0, 0
+			o.mu.Unlock()
+			if n == 0 {
+				continue
+			}
+			if err := o.u.upload(up); err != nil {
+				slog.Error("upload", "service", o.name, "err", err)
+			}
+		}
+	}
+}
+
+// Close stops the submissions.
+func (o *uploadOutput) Close() error {
+	o.tick.Stop()
+	close(o.done)
+	return nil
+}
+
+// uploaderConfig are the accounts on the radiation maps.
+type uploaderConfig struct {
+	gmcmapUserID, gmcmapCounterID       string
+	gmcmapInterval                      time.Duration
+	radmonUser                          string
+	radmonPassword, radmonPasswordFile  string
+	radmonInterval                      time.Duration
+	safecastAPIKey, safecastAPIKeyFile  string
+	safecastDeviceID                    int
+	safecastLatitude, safecastLongitude float64
+	safecastInterval                    time.Duration
+}
+
+func uploaderFlags(fs *flag.FlagSet) *uploaderConfig {
+	c := &uploaderConfig{}
+	fs.StringVar(&c.gmcmapUserID, "gmcmapUserID", "", "Account ID on gmcmap.com, readings are submitted to the map together with -gmcmapCounterID")
+	fs.StringVar(&c.gmcmapCounterID, "gmcmapCounterID", "", "Geiger counter ID on gmcmap.com")
+	fs.DurationVar(&c.gmcmapInterval, "gmcmapInterval", 5*time.Minute, "Interval of the gmcmap.com submissions")
+	fs.StringVar(&c.radmonUser, "radmonUser", "", "Station user name on radmon.org, readings are submitted when set")
+	fs.StringVar(&c.radmonPassword, "radmonPassword", "", "Data sending password of the radmon.org station")
+	fs.StringVar(&c.radmonPasswordFile, "radmonPasswordFile", "", "File holding the radmon.org password, instead of -radmonPassword")
+	fs.DurationVar(&c.radmonInterval, "radmonInterval", time.Minute, "Interval of the radmon.org submissions, at least 1m")
+	fs.StringVar(&c.safecastAPIKey, "safecastAPIKey", "", "Safecast API key, measurements are posted to the Safecast API when set")
+	fs.StringVar(&c.safecastAPIKeyFile, "safecastAPIKeyFile", "", "File holding the Safecast API key, instead of -safecastAPIKey")
+	fs.IntVar(&c.safecastDeviceID, "safecastDeviceID", 0, "Safecast device ID of the counter")
+	fs.Float64Var(&c.safecastLatitude, "safecastLatitude", 0, "Latitude of the counter for Safecast")
+	fs.Float64Var(&c.safecastLongitude, "safecastLongitude", 0, "Longitude of the counter for Safecast")
+	fs.DurationVar(&c.safecastInterval, "safecastInterval", 5*time.Minute, "Interval of the Safecast submissions")
+	return c
+}
+
+// override applies the gmcmap, radmon and safecast sections of the config
+// file, except for the settings given as flags.
+func (c *uploaderConfig) override(cfg *fileConfig, set map[string]bool) {
+	g := &cfg.GMCMap
+	if g.UserID != "" && !set["gmcmapUserID"] {
+		c.gmcmapUserID = g.UserID
+	}
+	if g.CounterID != "" && !set["gmcmapCounterID"] {
+		c.gmcmapCounterID = g.CounterID
+	}
+	if g.Interval != 0 && !set["gmcmapInterval"] {
+		c.gmcmapInterval = time.Duration(g.Interval)
+	}
+	r := &cfg.Radmon
+	if r.User != "" && !set["radmonUser"] {
+		c.radmonUser = r.User
+	}
+	if r.Password != "" && !set["radmonPassword"] && !set["radmonPasswordFile"] {
+		c.radmonPassword = r.Password
+	}
+	if r.Interval != 0 && !set["radmonInterval"] {
+		c.radmonInterval = time.Duration(r.Interval)
+	}
+	sf := &cfg.Safecast
+	if sf.APIKey != "" && !set["safecastAPIKey"] && !set["safecastAPIKeyFile"] {
+		c.safecastAPIKey = sf.APIKey
+	}
+	if sf.DeviceID != 0 && !set["safecastDeviceID"] {
+		c.safecastDeviceID = sf.DeviceID
+	}
+	if sf.Latitude != 0 && !set["safecastLatitude"] {
+		c.safecastLatitude = sf.Latitude
+	}
+	if sf.Longitude != 0 && !set["safecastLongitude"] {
+		c.safecastLongitude = sf.Longitude
+	}
+	if sf.Interval != 0 && !set["safecastInterval"] {
+		c.safecastInterval = time.Duration(sf.Interval)
+	}
+}
+
+// start passes the outputs of the configured maps to add, with dryRun only
+// logging the submissions.
+func (c *uploaderConfig) start(add func(name string, open func() (output, error)), dryRun bool) error {
+	var err error
+	if c.radmonPassword, err = resolveSecret(c.radmonPassword, c.radmonPasswordFile); err != nil {
+		return fmt.Errorf("radmon password: %w", err)
+	}
+	if c.safecastAPIKey, err = resolveSecret(c.safecastAPIKey, c.safecastAPIKeyFile); err != nil {
+		return fmt.Errorf("safecast api key: %w", err)
+	}
+	startUploader := func(name string, u uploader, interval time.Duration) {
+		if dryRun {
+			u = dryRunUploader{service: name}
+		}
+		add(name, func() (output, error) { return newUploadOutput(name, u, interval), nil })
+	}
+	if c.gmcmapUserID != "" || c.gmcmapCounterID != "" {
+		if c.gmcmapUserID == "" || c.gmcmapCounterID == "" {
+			return errors.New("gmcmap: -gmcmapUserID and -gmcmapCounterID are both required")
+		}
+		if c.gmcmapInterval < time.Minute {
+			return errors.New("-gmcmapInterval must be at least 1m")
+		}
+		startUploader("gmcmap", &gmcmapUploader{userID: c.gmcmapUserID, counterID: c.gmcmapCounterID}, c.gmcmapInterval)
+	}
+	if c.radmonUser != "" {
+		if c.radmonPassword == "" {
+			return errors.New("radmon: -radmonPassword is required")
+		}
+		if c.radmonInterval < radmonMinInterval {
+			return fmt.Errorf("-radmonInterval must be at least %v", radmonMinInterval)
+		}
+		startUploader("radmon", &radmonUploader{user: c.radmonUser, password: c.radmonPassword}, c.radmonInterval)
+	}
+	if c.safecastAPIKey != "" {
+		if c.safecastLatitude == 0 && c.safecastLongitude == 0 {
+			return errors.New("safecast: -safecastLatitude and -safecastLongitude are required")
+		}
+		if c.safecastInterval < time.Minute {
+			return errors.New("-safecastInterval must be at least 1m")
+		}
+		u := &safecastUploader{apiKey: c.safecastAPIKey, deviceID: c.safecastDeviceID, latitude: c.safecastLatitude, longitude: c.safecastLongitude}
+		startUploader("safecast", u, c.safecastInterval)
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"runtime/debug"
+)
+
+// Build metadata, set at link time:
+//
+//	go build -ldflags "-X main.version=1.2.0 -X main.commit=$(git rev-parse --short HEAD) -X main.buildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+//
+// Without them the VCS information embedded by the go tool is used, with the
+// commit time standing in for the build date.
+var (
+	version   = "dev"
+	commit    = ""
+	buildDate = ""
+)
+
+// buildInfo describes the running binary.
+type buildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	BuildDate string `json:"build_date,omitempty"`
+	GoVersion string `json:"go_version"`
+}
+
+func currentBuild() buildInfo {
+	b := buildInfo{Version: version, Commit: commit, BuildDate: buildDate, GoVersion: runtime.Version()}
+	if info, ok := debug.ReadBuildInfo(); ok && commit == "" {
+		dirty := false
+		for _, s := range info.Settings {
+			switch {
+			case s.Key == "vcs.revision":
+				b.Commit = s.Value
+				if len(b.Commit) > 12 {
+					b.Commit = b.Commit[:12]
+				}
+			case s.Key == "vcs.time" && b.BuildDate == "":
+				b.BuildDate = s.Value
+			case s.Key == "vcs.modified":
+				dirty = s.Value == "true"
+			}
+		}
+		if dirty && b.Commit != "" {
+			b.Commit += "-dirty"
+		}
+	}
+	return b
+}
+
+func (b buildInfo) String() string {
+	s := "gq-gmc " + b.Version
+	if b.Commit != "" {
+		s += " (" + b.Commit + ")"
+	}
+	if b.BuildDate != "" {
+		s += " built " + b.BuildDate
+	}
+	return s + " with " + b.GoVersion
+}
+
+// versionCommand implements "gq-gmc version".
+func versionCommand(args []string) {
+	if len(args) != 0 {
+		fmt.Fprintf(os.Stderr, "Usage: %s version\n", os.Args[0])
+		os.Exit(2)
+	}
+	fmt.Println(currentBuild())
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"html/template"
+	"log"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	influxdb "github.com/influxdata/influxdb1-client/v2"
+)
+
+// reportPoint is the hourly aggregate of the readings.
+type reportPoint struct {
+	Time     time.Time
+	DoseRate float64 // mean µSv/h
+	MaxRate  float64
+	CPM      float64 // mean
+}
+
+// weeklyReport holds the data rendered by weeklyTemplate.
+type weeklyReport struct {
+	Start, End time.Time
+	Device     string
+	Points     []reportPoint
+	Events     []alertEvent
+	Truncated  bool // earlier events were dropped
+	Generated  time.Time
+}
+
+// queryHourly reads the hourly aggregates from start to end from InfluxDB.
+func queryHourly(client influxdb.Client, database, measurement string, start, end time.Time) ([]reportPoint, error) {
+	q := fmt.Sprintf(`SELECT mean("geiger_counter_dose_rate"), max("geiger_counter_dose_rate"), mean("geiger_counter_cpm") FROM %s WHERE time >= %ds AND time < %ds GROUP BY time(1h)`,
+		quoteIdent(measurement), start.Unix(), end.Unix())
+	resp, err := client.Query(influxdb.NewQuery(q, database, "s"))
+	if err != nil {
+		return nil, err
+	}
+	if err := resp.Error(); err != nil {
+		return nil, err
+	}
+	var points []reportPoint
+	for _, res := range resp.Results {
+		for _, row := range res.Series {
+			for _, v := range row.Values {
+				if len(v) != 4 || v[1] == nil {
+					continue // hour without readings
+				}
+				points = append(points, reportPoint{
+					Time:     time.Unix(int64(influxFloat(v[0])), 0),
+					DoseRate: influxFloat(v[1]),
+					MaxRate:  influxFloat(v[2]),
+					CPM:      influxFloat(v[3]),
+				})
+			}
+		}
+	}
+	return points, nil
+}
+
+func influxFloat(v interface{}) float64 {
+	switch v := v.(type) {
+	case json.Number:
+		f, _ := v.Float64()
+		return f
+	case float64:
+		return v
+	case string: // a tag
+		f, _ := strconv.ParseFloat(v, 64)
+		return f
+	}
+	return 0
+}
+
+// Dose estimates the dose of the period from the hourly means.
+func (r *weeklyReport) Dose() float64 {
+	var d float64
+	for _, p := range r.Points {
+		d += p.DoseRate
+	}
+	return d
+}
+
+func (r *weeklyReport) Mean() float64 {
+	if len(r.Points) == 0 {
+		return 0
+	}
+	return r.Dose() / float64(len(r.Points))
+}
+
+func (r *weeklyReport) Max() float64 {
+	var m float64
+	for _, p := range r.Points {
+		if p.MaxRate > m {
+			m = p.MaxRate
+		}
+	}
+	return m
+}
+
+// Coverage is the share of hours with readings in percent.
+func (r *weeklyReport) Coverage() float64 {
+	return float64(len(r.Points)) / r.End.Sub(r.Start).Hours() * 100
+}
+
+// Chart renders the hourly mean and maximum dose rate as SVG polylines.
+func (r *weeklyReport) Chart() template.HTML {
+	const w, h = 800.0, 240.0
+	top := r.Max()
+	if top == 0 {
+		top = 1
+	}
+	span := r.End.Sub(r.Start).Seconds()
+	line := func(value func(p reportPoint) float64) string {
+		var pts []string
+		for _, p := range r.Points {
+			x := p.Time.Sub(r.Start).Seconds() / span * w
+			y := h - value(p)/top*h
+			pts = append(pts, fmt.Sprintf("%.1f,%.1f", x, y))
+		}
+		return strings.Join(pts, " ")
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg viewBox="-50 -10 %g %g" width="100%%" xmlns="http://www.w3.org/2000/svg">`, w+60, h+40)
+	fmt.Fprintf(&b, `<rect width="%g" height="%g" fill="none" stroke="#999"/>`, w, h)
+	for day := 0; day <= 7; day++ {
+		x := float64(day) / 7 * w
+		fmt.Fprintf(&b, `<line x1="%.1f" x2="%.1f" y1="0" y2="%g" stroke="#ddd"/>`, x, x, h)
+		if day < 7 {
+			fmt.Fprintf(&b, `<text x="%.1f" y="%g" font-size="12">%s</text>`, x+4, h+16, r.Start.AddDate(0, 0, day).Format("Mon 02"))
+		}
+	}
+	fmt.Fprintf(&b, `<text x="-45" y="10" font-size="12">%.3f</text><text x="-45" y="%g" font-size="12">0</text>`, top, h)
+	fmt.Fprintf(&b, `<polyline points="%s" fill="none" stroke="#f4a582"/>`, line(func(p reportPoint) float64 { return p.MaxRate }))
+	fmt.Fprintf(&b, `<polyline points="%s" fill="none" stroke="#2166ac" stroke-width="2"/>`, line(func(p reportPoint) float64 { return p.DoseRate }))
+	b.WriteString(`</svg>`)
+	return template.HTML(b.String())
+}
+
+var weeklyTemplate = template.Must(template.New("weekly").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Radiation report {{.Start.Format "2006-01-02"}} to {{.End.Format "2006-01-02"}}</title>
+<style>
+@page { size: A4; margin: 2cm; }
+body { font-family: sans-serif; max-width: 60em; margin: auto; }
+table { border-collapse: collapse; }
+td, th { border: 1px solid #999; padding: 0.2em 0.6em; text-align: left; }
+</style>
+</head>
+<body>
+<h1>Radiation report</h1>
+<p>{{.Start.Format "Mon 2006-01-02 15:04"}} to {{.End.Format "Mon 2006-01-02 15:04 MST"}}{{if .Device}}, device {{.Device}}{{end}}</p>
+<table>
+<tr><th>Mean dose rate</th><td>{{printf "%.3f" .Mean}} µSv/h</td></tr>
+<tr><th>Maximum dose rate</th><td>{{printf "%.3f" .Max}} µSv/h</td></tr>
+<tr><th>Dose</th><td>{{printf "%.2f" .Dose}} µSv</td></tr>
+<tr><th>Coverage</th><td>{{printf "%.0f" .Coverage}}% of hours with readings</td></tr>
+</table>
+<h2>Dose rate</h2>
+<p>Hourly mean (blue) and maximum (orange) in µSv/h.</p>
+{{.Chart}}
+<h2>Alerts</h2>
+{{if .Truncated}}<p>Earlier events of the week were dropped, as an alert changed state too often to keep them all.</p>
+{{end}}{{if .Events}}<table>
+<tr><th>Time</th><th>Rule</th><th>Severity</th><th>State</th><th>Value</th></tr>
+{{range .Events}}<tr><td>{{.Time.Format "2006-01-02 15:04"}}</td><td>{{.Rule}}</td><td>{{.Severity}}</td><td>{{.State}}{{if .Silenced}} (silenced){{end}}</td><td>{{.Metric}}={{.Value}}</td></tr>
+{{end}}</table>{{else}}<p>None.</p>{{end}}
+<p><small>Generated {{.Generated.Format "2006-01-02 15:04 MST"}} by gq-gmc.</small></p>
+</body>
+</html>
+`))
+
+// renderWeekly builds the HTML report for the week ending at end.
+func renderWeekly(client influxdb.Client, database, measurement string, end time.Time, device string, events []alertEvent, truncated bool) ([]byte, error) {
+	r := &weeklyReport{Start: end.AddDate(0, 0, -7), End: end, Device: device, Events: events, Truncated: truncated, Generated: time.Now()}
+	var err error
+	if r.Points, err = queryHourly(client, database, measurement, r.Start, r.End); err != nil {
+		return nil, err
+	}
+	var b bytes.Buffer
+	if err := weeklyTemplate.Execute(&b, r); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// writeWeekly renders the report and stores it in dir and/or sends it to the
+// reporters.
+func writeWeekly(client influxdb.Client, database, measurement string, end time.Time, device string, events []alertEvent, truncated bool, dir string, reporters []reporter) {
+	html, err := renderWeekly(client, database, measurement, end, device, events, truncated)
+	if err != nil {
+		slog.Error("weekly report", "err", err)
+		return
+	}
+	year, week := end.AddDate(0, 0, -1).ISOWeek()
+	if dir != "" {
+		path := filepath.Join(dir, fmt.Sprintf("report-%d-W%02d.html", year, week))
+		if err := writeFileAtomic(path, html, 0644); err != nil {
+			slog.Error("weekly report", "err", err)
+		} else {
+			slog.Info("weekly report written", "file", path)
+		}
+	}
+	sendReport(reporters, fmt.Sprintf("[gq-gmc] Weekly report %d-W%02d", year, week), "text/html", string(html))
+}
+
+// nextTimeOfWeek returns the next time after now that is on day and at which
+// the wall clock shows tod.
+func nextTimeOfWeek(now time.Time, day time.Weekday, tod time.Duration) time.Time {
+	t := nextTimeOfDay(now, tod)
+	for t.Weekday() != day {
+		t = nextTimeOfDay(t, tod)
+	}
+	return t
+}
+
+// reportCommand implements "gq-gmc report", which renders the weekly report
+// of the last seven days from InfluxDB.
+func reportCommand(args []string) {
+	fs := flag.NewFlagSet("report", flag.ExitOnError)
+	influx := influxFlags(fs)
+	out := fs.String("out", "", "Output file, default stdout")
+	endFlag := fs.String("end", "", "End of the reported week as YYYY-MM-DD, default now")
+	parseFlags(fs, args)
+
+	end := time.Now()
+	if *endFlag != "" {
+		var err error
+		if end, err = time.ParseInLocation("2006-01-02", *endFlag, time.Local); err != nil {
+			log.Fatalf("report: %v", err)
+		}
+	}
+	client, err := influx.client()
+	if err != nil {
+		log.Fatalf("influx: %v", err)
+	}
+	html, err := renderWeekly(client, influx.database, influx.measurement, end, "", nil, false)
+	if err != nil {
+		log.Fatalf("report: %v", err)
+	}
+	if *out == "" {
+		os.Stdout.Write(html)
+		return
+	}
+	if err := os.WriteFile(*out, html, 0644); err != nil {
+		log.Fatalf("report: %v", err)
+	}
+}
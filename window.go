@@ -0,0 +1,43 @@
+package main
+
+// cpmWindowSeconds is the length of the sliding window the count rate is
+// computed from when the reporting interval is shorter.
+const cpmWindowSeconds = 60
+
+// countWindow keeps the counts of the last heartbeat frames, one per second,
+// so that short reporting intervals still report a rate over a full minute
+// instead of a noisy rate over a few seconds.
+type countWindow struct {
+	counts []int // ring buffer
+	next   int
+	n      int // frames in the buffer
+	sum    int
+}
+
+func newCountWindow(size int) *countWindow {
+	return &countWindow{counts: make([]int, size)}
+}
+
+func (w *countWindow) add(count int) {
+	if w.n == len(w.counts) {
+		w.sum -= w.counts[w.next]
+	} else {
+		w.n++
+	}
+	w.counts[w.next] = count
+	w.sum += count
+	w.next = (w.next + 1) % len(w.counts)
+}
+
+// cpm returns the rate over the frames in the window, which are fewer than
+// its size for the first minute after a reset.
+func (w *countWindow) cpm() int {
+	if w.n == 0 {
+		return 0
+	}
+	return w.sum * 60 / w.n
+}
+
+func (w *countWindow) reset() {
+	w.next, w.n, w.sum = 0, 0, 0
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"log/slog"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/mwuertinger/gq-gmc/pkg/gqgmc"
+)
+
+func TestCountWindow(t *testing.T) {
+	tests := []struct {
+		name   string
+		size   int
+		counts []int
+		want   int
+	}{
+		{"empty", 60, nil, 0},
+		{"one frame", 60, []int{2}, 120},
+		{"partly filled", 60, []int{1, 0, 2}, 60},
+		{"full", 4, []int{1, 2, 3, 4}, 150},
+		{"wrapped", 4, []int{9, 9, 1, 2, 3, 4}, 150},
+		{"wrapped several times", 2, []int{5, 5, 5, 5, 5, 1, 0}, 30},
+		{"rounds down", 60, []int{1, 0, 0, 0, 0, 0, 0}, 8},
+	}
+	for _, tt := range tests {
+		w := newCountWindow(tt.size)
+		for _, c := range tt.counts {
+			w.add(c)
+		}
+		if got := w.cpm(); got != tt.want {
+			t.Errorf("%s: cpm() = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCountWindowReset(t *testing.T) {
+	w := newCountWindow(4)
+	for _, c := range []int{7, 7, 7, 7, 7} {
+		w.add(c)
+	}
+	w.reset()
+	if got := w.cpm(); got != 0 {
+		t.Errorf("cpm() after reset() = %d", got)
+	}
+	// the frames from before the reset no longer count, even once wrapped
+	for _, c := range []int{1, 2, 3, 4, 1} {
+		w.add(c)
+	}
+	if got := w.cpm(); got != 150 {
+		t.Errorf("cpm() after refilling = %d, want 150", got)
+	}
+}
+
+// TestReadingCPM checks that intervals under a minute report the rate of
+// the last minute, including the samples of the intervals before, and
+// longer ones that of the interval.
+func TestReadingCPM(t *testing.T) {
+	tests := []struct {
+		name     string
+		interval time.Duration
+		before   []int // samples of the intervals before
+		counts   []int
+		want     int
+	}{
+		{"a minute", time.Minute, nil, []int{1, 2, 3}, 120},
+		{"a minute after others", time.Minute, []int{5, 5, 5}, []int{1}, 60},
+		{"longer", 5 * time.Minute, []int{5}, []int{1, 2, 3}, 120},
+		{"under a minute", 10 * time.Second, nil, []int{1, 2, 3}, 120},
+		{"under a minute after others", 10 * time.Second, []int{5, 5, 5}, []int{1}, 240},
+		{"under a minute, window full", 10 * time.Second, repeat(5, 60), []int{0}, 295},
+	}
+	for _, tt := range tests {
+		p := &pipeline{logger: slog.Default(), opts: &readingConfig{}, profile: gqgmc.Profile{Tube: gqgmc.Tube{Factor: 0.01}}}
+		p.recent = newCountWindow(cpmWindowSeconds)
+		for _, c := range tt.before {
+			p.add(countSample{Time: time.Now(), CPS: c})
+		}
+		p.reset()
+		for _, c := range tt.counts {
+			p.add(countSample{Time: time.Now(), CPS: c})
+		}
+		r, ok := p.reading(tt.interval)
+		if !ok || r.CPM != tt.want {
+			t.Errorf("%s: reading(%s) = %d cpm, %v, want %d", tt.name, tt.interval, r.CPM, ok, tt.want)
+		}
+	}
+}
+
+func repeat(count, n int) []int {
+	counts := make([]int, n)
+	for i := range counts {
+		counts[i] = count
+	}
+	return counts
+}
+
+func TestCountRing(t *testing.T) {
+	tests := []struct {
+		name        string
+		size        int
+		pushes      []int
+		want        []int
+		wantDropped int64
+	}{
+		{"empty", 4, nil, nil, 0},
+		{"partly filled", 4, []int{1, 2}, []int{1, 2}, 0},
+		{"full", 4, []int{1, 2, 3, 4}, []int{1, 2, 3, 4}, 0},
+		{"wrapped", 4, []int{1, 2, 3, 4, 5, 6}, []int{3, 4, 5, 6}, 2},
+		{"wrapped several times", 3, []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}, []int{8, 9, 10}, 7},
+	}
+	for _, tt := range tests {
+		r := newCountRing(tt.size)
+		for _, c := range tt.pushes {
+			r.push(c)
+		}
+		if n, size := r.backlog(); n != len(tt.want) || size != tt.size {
+			t.Errorf("%s: backlog() = %d, %d, want %d, %d", tt.name, n, size, len(tt.want), tt.size)
+		}
+		samples, open := r.drain(nil)
+		var got []int
+		for _, s := range samples {
+			got = append(got, s.CPS)
+		}
+		if !reflect.DeepEqual(got, tt.want) || !open {
+			t.Errorf("%s: drain() = %v, %v, want %v, true", tt.name, got, open, tt.want)
+		}
+		if n := r.takeDropped(); n != tt.wantDropped {
+			t.Errorf("%s: takeDropped() = %d, want %d", tt.name, n, tt.wantDropped)
+		}
+		if n := r.takeDropped(); n != 0 {
+			t.Errorf("%s: takeDropped() again = %d", tt.name, n)
+		}
+	}
+}
+
+func TestCountRingAfterDrain(t *testing.T) {
+	r := newCountRing(3)
+	for _, c := range []int{1, 2, 3, 4} {
+		r.push(c)
+	}
+	r.drain(nil)
+	// the ring starts over after a drain, also when it had wrapped
+	for _, c := range []int{5, 6, 7, 8} {
+		r.push(c)
+	}
+	r.close()
+	select {
+	case <-r.ready:
+	default:
+		t.Error("ready not signalled")
+	}
+	samples, open := r.drain(nil)
+	var got []int
+	for _, s := range samples {
+		got = append(got, s.CPS)
+	}
+	if want := []int{6, 7, 8}; !reflect.DeepEqual(got, want) || open {
+		t.Errorf("drain() after close() = %v, %v, want %v, false", got, open, want)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// wsWriteTimeout drops WebSocket clients that stop reading.
+const wsWriteTimeout = 10 * time.Second
+
+// countSample is a heartbeat frame as streamed on /ws, in the JSON format of
+// the multicast datagrams.
+type countSample struct {
+	Time   time.Time `json:"time"`
+	CPS    int       `json:"cps"`
+	Device string    `json:"device"`
+}
+
+// countFeed fans the heartbeat counts out to the WebSocket clients. Like
+// liveState it never blocks the main loop: a client that falls behind
+// misses samples.
+type countFeed struct {
+	mu   sync.Mutex
+	subs map[chan countSample]struct{}
+}
+
+func (f *countFeed) publish(s countSample) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for ch := range f.subs {
+		select {
+		case ch <- s:
+		default:
+		}
+	}
+}
+
+func (f *countFeed) subscribe() (<-chan countSample, func()) {
+	ch := make(chan countSample, 16)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.subs == nil {
+		f.subs = map[chan countSample]struct{}{}
+	}
+	f.subs[ch] = struct{}{}
+	return ch, func() {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		delete(f.subs, ch)
+	}
+}
+
+// The stream is read-only, so pages from any origin may open it.
+var wsUpgrader = websocket.Upgrader{CheckOrigin: func(*http.Request) bool { return true }}
+
+// newWSHandler streams every heartbeat sample as a JSON message.
+func newWSHandler(feed *countFeed) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := wsUpgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return // the upgrader replied with an error
+		}
+		defer conn.Close()
+		samples, cancel := feed.subscribe()
+		defer cancel()
+
+		// the client sends nothing, reading just notices when it leaves
+		gone := make(chan struct{})
+		go func() {
+			defer close(gone)
+			for {
+				if _, _, err := conn.NextReader(); err != nil {
+					return
+				}
+			}
+		}()
+		for {
+			select {
+			case s := <-samples:
+				conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+				if err := conn.WriteJSON(s); err != nil {
+					slog.Debug("ws", "err", err)
+					return
+				}
+			case <-gone:
+				return
+			}
+		}
+	})
+}